@@ -1,5 +1,10 @@
 package acme
 
+import (
+	"encoding/asn1"
+	"encoding/json"
+)
+
 // acme.Resource values identify different types of ACME resources
 type Resource string
 
@@ -12,42 +17,79 @@ const (
 )
 
 const (
-	StatusPending    = "pending"
-	StatusInvalid    = "invalid"
-	StatusValid      = "valid"
-	StatusProcessing = "processing"
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusInvalid     = "invalid"
+	StatusValid       = "valid"
+	StatusProcessing  = "processing"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
 
 	IdentifierDNS = "dns"
+	IdentifierIP  = "ip"
 
-	ChallengeHTTP01   = "http-01"
-	ChallengeTLSSNI02 = "tls-sni-02"
-	ChallengeDNS01    = "dns-01"
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSSNI02  = "tls-sni-02"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
 
 	HTTP01BaseURL = ".well-known/acme-challenge/"
+
+	// ACMETLS1Protocol is the ALPN protocol name used for TLS-ALPN-01
+	ACMETLS1Protocol = "acme-tls/1"
+
+	// RevocationReasonKeyCompromise is the RFC 5280 CRLReason code of a
+	// certificate revoked because its key was compromised
+	RevocationReasonKeyCompromise = 1
 )
 
+// IDPeAcmeIdentifier is the OID of the acmeValidationV1 certificate extension
+// used by the TLS-ALPN-01 challenge
+var IDPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
 type Identifier struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
 }
 
 type Account struct {
-	Status             string   `json:"status"`
-	Contact            []string `json:"contact"`
-	ToSAgreed          bool     `json:"terms-of-service-agreed"`
-	Orders             string   `json:"orders"`
-	OnlyReturnExisting bool     `json:"only-return-existing"`
+	Status                 string          `json:"status"`
+	Contact                []string        `json:"contact"`
+	ToSAgreed              bool            `json:"terms-of-service-agreed"`
+	Orders                 string          `json:"orders"`
+	OnlyReturnExisting     bool            `json:"only-return-existing"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
+	// TermsOfServiceAgreed is the RFC 8555 name of ToSAgreed
+	TermsOfServiceAgreed bool `json:"termsOfServiceAgreed,omitempty"`
 }
 
-// An Order is created to request issuance for a CSR
+// AgreesToTermsOfService returns true if the account agrees to the terms of
+// service under either the RFC 8555 or the draft field name.
+func (a Account) AgreesToTermsOfService() bool {
+	return a.ToSAgreed || a.TermsOfServiceAgreed
+}
+
+// An Order is created to request issuance for a set of identifiers. Once all
+// of its authorizations are valid it is finalized with a CSR
 type Order struct {
-	Status         string   `json:"status"`
-	Expires        string   `json:"expires"`
-	CSR            string   `json:"csr"`
-	NotBefore      string   `json:"notBefore,omitempty"`
-	NotAfter       string   `json:"notAfter,omitempty"`
-	Authorizations []string `json:"authorizations"`
-	Certificate    string   `json:"certificate,omitempty"`
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	NotBefore      string       `json:"notBefore,omitempty"`
+	NotAfter       string       `json:"notAfter,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	// Profile is the name of the certificate profile selected by the order
+	// (draft-aaron-acme-profiles)
+	Profile string `json:"profile,omitempty"`
+	// Error is set when the order became invalid because authorizations failed
+	Error *ProblemDetails `json:"error,omitempty"`
+}
+
+// A FinalizeRequest is POSTed to an order's finalize URL to request issuance
+type FinalizeRequest struct {
+	CSR string `json:"csr"`
 }
 
 // An Authorization is created for each identifier in an order
@@ -56,6 +98,7 @@ type Authorization struct {
 	Identifier Identifier   `json:"identifier"`
 	Challenges []*Challenge `json:"challenges"`
 	Expires    string       `json:"expires"`
+	Wildcard   bool         `json:"wildcard,omitempty"`
 }
 
 // A Challenge is used to validate an Authorization
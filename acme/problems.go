@@ -11,17 +11,45 @@ const (
 	malformedErr           = errNS + "malformedRequest"
 	badNonceErr            = errNS + "badNonce"
 	agreementReqErr        = errNS + "agreementRequired"
+	userActionReqErr       = errNS + "userActionRequired"
 	connectionErr          = errNS + "connection"
 	unauthorizedErr        = errNS + "unauthorized"
 	invalidContactErr      = errNS + "invalidContact"
 	unsupportedContactErr  = errNS + "unsupportedContact"
 	accountDoesNotExistErr = errNS + "accountDoesNotExist"
+	externalAccountReqErr  = errNS + "externalAccountRequired"
+	rateLimitedErr         = errNS + "rateLimited"
+	badCSRErr              = errNS + "badCSR"
+	badPublicKeyErr        = errNS + "badPublicKey"
+	badSignatureAlgErr     = errNS + "badSignatureAlgorithm"
+	orderNotReadyErr       = errNS + "orderNotReady"
+	unsupportedIdentErr    = errNS + "unsupportedIdentifier"
+	rejectedIdentErr       = errNS + "rejectedIdentifier"
+	compoundErr            = errNS + "compound"
+	caaErr                 = errNS + "caa"
+	invalidProfileErr      = errNS + "invalidProfile"
+	badRevocationReasonErr = errNS + "badRevocationReason"
+	alreadyRevokedErr      = errNS + "alreadyRevoked"
 )
 
 type ProblemDetails struct {
-	Type       string `json:"type,omitempty"`
-	Detail     string `json:"detail,omitempty"`
-	HTTPStatus int    `json:"status,omitempty"`
+	Type        string              `json:"type,omitempty"`
+	Detail      string              `json:"detail,omitempty"`
+	HTTPStatus  int                 `json:"status,omitempty"`
+	Subproblems []SubProblemDetails `json:"subproblems,omitempty"`
+	// Algorithms lists the supported JWS algorithms of a badSignatureAlgorithm
+	// problem (RFC 8555 Section 6.2)
+	Algorithms []string `json:"algorithms,omitempty"`
+	// Header holds HTTP headers sent with the problem document, e.g. the Link
+	// to the terms of service of a userActionRequired problem
+	Header http.Header `json:"-"`
+}
+
+// SubProblemDetails is a problem for a single identifier within
+// a ProblemDetails that covers several identifiers (RFC 8555 Section 6.7.1)
+type SubProblemDetails struct {
+	ProblemDetails
+	Identifier Identifier `json:"identifier"`
 }
 
 func (pd *ProblemDetails) Error() string {
@@ -52,6 +80,27 @@ func MethodNotAllowed() *ProblemDetails {
 	}
 }
 
+// NotFoundProblem is the problem for requests of unknown resources. ACME has
+// no problem type for them, so like Boulder a malformed problem with a 404
+// status is used.
+func NotFoundProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       malformedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusNotFound,
+	}
+}
+
+// NotAcceptableProblem is the problem for requests accepting none of the
+// media types a resource can be returned as.
+func NotAcceptableProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       malformedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusNotAcceptable,
+	}
+}
+
 func BadNonceProblem(detail string) *ProblemDetails {
 	return &ProblemDetails{
 		Type:       badNonceErr,
@@ -76,6 +125,14 @@ func AgreementRequiredProblem(detail string) *ProblemDetails {
 	}
 }
 
+func UserActionRequiredProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       userActionReqErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
 func ConnectionProblem(detail string) *ProblemDetails {
 	return &ProblemDetails{
 		Type:       connectionErr,
@@ -115,3 +172,146 @@ func AccountDoesNotExistProblem(detail string) *ProblemDetails {
 		HTTPStatus: http.StatusBadRequest,
 	}
 }
+
+func ExternalAccountRequiredProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       externalAccountReqErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusUnauthorized,
+	}
+}
+
+func RateLimitedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       rateLimitedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+func BadCSRProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       badCSRErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func BadPublicKeyProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       badPublicKeyErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func BadSignatureAlgorithmProblem(detail string, algorithms []string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       badSignatureAlgErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+		Algorithms: algorithms,
+	}
+}
+
+func OrderNotReadyProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       orderNotReadyErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
+
+func UnsupportedIdentifierProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       unsupportedIdentErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+// RejectedIdentifierProblem is returned for an identifier the CA's policy
+// forbids issuance for (RFC 8555 Section 6.7)
+func RejectedIdentifierProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       rejectedIdentErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+// InvalidProfileProblem is returned for a newOrder request selecting a profile
+// the server doesn't have (draft-aaron-acme-profiles)
+func InvalidProfileProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       invalidProfileErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func BadRevocationReasonProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       badRevocationReasonErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func AlreadyRevokedProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       alreadyRevokedErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusBadRequest,
+	}
+}
+
+func ServiceUnavailableProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       serverInternalErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusServiceUnavailable,
+	}
+}
+
+func CompoundProblem(detail string, subproblems []SubProblemDetails) *ProblemDetails {
+	return &ProblemDetails{
+		Type:        compoundErr,
+		Detail:      detail,
+		HTTPStatus:  http.StatusForbidden,
+		Subproblems: subproblems,
+	}
+}
+
+// IdentifiersProblem returns the problem for the failures of several
+// identifiers, the subproblems, or the only subproblem's problem if there is
+// just one. As in the example of RFC 8555 Section 6.7.1 the problem has the
+// type and status shared by all of the subproblems, it is a compound problem if
+// they differ.
+func IdentifiersProblem(detail string, subproblems []SubProblemDetails) *ProblemDetails {
+	switch len(subproblems) {
+	case 0:
+		return nil
+	case 1:
+		prob := subproblems[0].ProblemDetails
+		return &prob
+	}
+	prob := CompoundProblem(detail, subproblems)
+	first := subproblems[0].ProblemDetails
+	for _, sub := range subproblems[1:] {
+		if sub.Type != first.Type || sub.HTTPStatus != first.HTTPStatus {
+			return prob
+		}
+	}
+	prob.Type = first.Type
+	prob.HTTPStatus = first.HTTPStatus
+	return prob
+}
+
+func CAAProblem(detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:       caaErr,
+		Detail:     detail,
+		HTTPStatus: http.StatusForbidden,
+	}
+}
@@ -1,21 +1,24 @@
 package ca
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"math"
 	"math/big"
+	"net"
+	"sync"
 	"time"
 
+	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/pebble/acme"
 	"github.com/letsencrypt/pebble/core"
 	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
 )
 
 const (
@@ -24,8 +27,38 @@ const (
 )
 
 type CAImpl struct {
-	log *log.Logger
-	db  *db.MemoryStore
+	log   *logging.Logger
+	clk   clock.Clock
+	db    *db.MemoryStore
+	hooks []IssuanceHook
+	// latency is the ordered list of rules used to delay issuance
+	latency []LatencyRule
+	// randomDelay is the range of the random delay added to every issuance
+	randomDelay core.DelayRange
+	// intermediateOpts override the extensions of new intermediate certificates
+	intermediateOpts IntermediateOptions
+	// signer is the remote signer certificates are issued by, or nil if the CA
+	// signs them with its own issuer keys
+	signer *RemoteSigner
+	// policies are the issuance policies orders are matched against in order.
+	// Their validity can be reloaded, so they are guarded by policiesLock.
+	policiesLock sync.RWMutex
+	policies     []*policy
+	// ctLogs are the URLs of the CT logs precertificates are submitted to. The
+	// SCTs they return are embedded in the certificates.
+	ctLogs []string
+	// serials is the pool of pre-generated serial numbers, nil if serials
+	// are generated on demand
+	serials chan *big.Int
+	// issuanceQueue holds the orders waiting for the issuance workers, nil if
+	// every order is issued in its own goroutine
+	issuanceQueue chan issuanceJob
+	// closed is closed by Close to stop filling the serial pool. closeLock
+	// keeps orders from being queued once the issuanceQueue is closed.
+	closed    chan struct{}
+	closeLock sync.RWMutex
+	// profiles are the certificate profiles orders can select, by name
+	profiles map[string]*Profile
 
 	root         *issuer
 	intermediate *issuer
@@ -47,19 +80,21 @@ func makeSerial() *big.Int {
 // makeKey and makeRootCert are adapted from MiniCA:
 // https://github.com/jsha/minica/blob/3a621c05b61fa1c24bcb42fbde4b261db504a74f/main.go
 
-// makeKey creates a new 2048 bit RSA private key
-func makeKey() (*rsa.PrivateKey, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
+// makeKey creates a new private key of the key type, which must be one of
+// keyTypes
+func makeKey(keyType string) (crypto.Signer, error) {
+	generate, ok := keyTypes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q", keyType)
 	}
-	return key, nil
+	return generate()
 }
 
 func (ca *CAImpl) makeRootCert(
 	subjectKey crypto.Signer,
 	subjCNPrefix string,
-	signer *issuer) (*core.Certificate, error) {
+	signer *issuer,
+	opts IntermediateOptions) (*core.Certificate, error) {
 
 	serial := makeSerial()
 	template := &x509.Certificate{
@@ -67,23 +102,31 @@ func (ca *CAImpl) makeRootCert(
 			CommonName: subjCNPrefix + hex.EncodeToString(serial.Bytes()[:3]),
 		},
 		SerialNumber: serial,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(30, 0, 0),
+		NotBefore:    ca.clk.Now(),
+		NotAfter:     ca.clk.Now().AddDate(30, 0, 0),
 
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
-		IsCA: true,
+		IsCA:                  true,
 	}
+	// Only intermediates have configurable extensions, roots have no options
+	opts.apply(template)
+	return ca.signCACert(template, subjectKey, signer)
+}
 
-	var signerKey crypto.Signer
+// signCACert signs the template of a CA certificate for subjectKey with the
+// signer, or with subjectKey itself if signer is nil, and stores it.
+func (ca *CAImpl) signCACert(
+	template *x509.Certificate,
+	subjectKey crypto.Signer,
+	signer *issuer) (*core.Certificate, error) {
+	parent, signerKey := template, subjectKey
 	if signer != nil && signer.key != nil {
-		signerKey = signer.key
-	} else {
-		signerKey = subjectKey
+		parent, signerKey = signer.cert.Cert, signer.key
 	}
 
-	der, err := x509.CreateCertificate(rand.Reader, template, template, subjectKey.Public(), signerKey)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, subjectKey.Public(), signerKey)
 	if err != nil {
 		return nil, err
 	}
@@ -109,14 +152,14 @@ func (ca *CAImpl) makeRootCert(
 	return newCert, nil
 }
 
-func (ca *CAImpl) newRootIssuer() error {
+func (ca *CAImpl) newRootIssuer(keyType string) error {
 	// Make a root private key
-	rk, err := makeKey()
+	rk, err := makeKey(keyType)
 	if err != nil {
 		return err
 	}
 	// Make a self-signed root certificate
-	rc, err := ca.makeRootCert(rk, rootCAPrefix, nil)
+	rc, err := ca.makeRootCert(rk, rootCAPrefix, nil, IntermediateOptions{})
 	if err != nil {
 		return err
 	}
@@ -129,59 +172,100 @@ func (ca *CAImpl) newRootIssuer() error {
 	return nil
 }
 
-func (ca *CAImpl) newIntermediateIssuer() error {
-	if ca.root == nil {
-		return fmt.Errorf("newIntermediateIssuer() called before newRootIssuer()")
+// makeIntermediateIssuer creates an intermediate issuer signed by parent, with
+// a key of keyType and its extensions overridden by opts.
+func (ca *CAImpl) makeIntermediateIssuer(parent *issuer, keyType string, opts IntermediateOptions) (*issuer, error) {
+	if parent == nil {
+		return nil, fmt.Errorf("makeIntermediateIssuer() called before newRootIssuer()")
 	}
 
 	// Make an intermediate private key
-	ik, err := makeKey()
+	ik, err := makeKey(keyType)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Make an intermediate certificate with the root issuer
-	ic, err := ca.makeRootCert(ik, intermediateCAPrefix, ca.root)
+	// Make an intermediate certificate with the parent issuer
+	ic, err := ca.makeRootCert(ik, intermediateCAPrefix, parent, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ca.intermediate = &issuer{
+	return &issuer{
 		key:  ik,
 		cert: ic,
-	}
-	ca.log.Printf("Generated new intermediate issuer with serial %s\n", ic.ID)
-	return nil
+	}, nil
 }
 
-func (ca *CAImpl) newCertificate(domains []string, key crypto.PublicKey) (*core.Certificate, error) {
+func (ca *CAImpl) newCertificate(
+	domains []string,
+	ips []net.IP,
+	emails []string,
+	key crypto.PublicKey,
+	extensions []pkix.Extension,
+	issuer *issuer,
+	notBefore time.Time,
+	notAfter time.Time,
+	validity time.Duration,
+	profile *Profile) (*core.Certificate, error) {
 	var cn string
 	if len(domains) > 0 {
 		cn = domains[0]
-	} else {
-		return nil, fmt.Errorf("must specify at least one domain name")
+	} else if len(ips) == 0 {
+		return nil, fmt.Errorf("must specify at least one domain name or IP address")
 	}
 
-	issuer := ca.intermediate
 	if issuer == nil || issuer.cert == nil {
 		return nil, fmt.Errorf("cannot sign certificate - nil issuer")
 	}
 
-	serial := makeSerial()
+	// A requested window takes precedence, the validity is counted from
+	// notBefore
+	requestedNotBefore, requestedNotAfter := notBefore, notAfter
+	if notBefore.IsZero() {
+		notBefore = ca.clk.Now()
+	}
+	if notAfter.IsZero() {
+		notAfter = notBefore.AddDate(5, 0, 0)
+		if validity > 0 {
+			notAfter = notBefore.Add(validity)
+		}
+	}
+
+	serial := ca.nextSerial()
 	template := &x509.Certificate{
-		DNSNames: domains,
+		DNSNames:       domains,
+		IPAddresses:    ips,
+		EmailAddresses: emails,
 		Subject: pkix.Name{
 			CommonName: cn,
 		},
 		SerialNumber: serial,
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().AddDate(5, 0, 0),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
 
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
-		IsCA: false,
+		IsCA:                  false,
+		ExtraExtensions:       extensions,
+	}
+	if profile != nil {
+		profile.apply(template)
+	}
+	var der []byte
+	var err error
+	if ca.signer != nil {
+		der, err = ca.remoteSign(domains, ips, emails, key, extensions, requestedNotBefore, requestedNotAfter,
+			validity, profile)
+	} else {
+		if len(ca.ctLogs) > 0 {
+			err = ca.embedSCTs(template, key, issuer)
+			if err != nil {
+				return nil, err
+			}
+		}
+		der, err = x509.CreateCertificate(rand.Reader, template, issuer.cert.Cert, key, issuer.key)
 	}
-	der, err := x509.CreateCertificate(rand.Reader, template, issuer.cert.Cert, key, issuer.key)
 	if err != nil {
 		return nil, err
 	}
@@ -204,55 +288,171 @@ func (ca *CAImpl) newCertificate(domains []string, key crypto.PublicKey) (*core.
 	return newCert, nil
 }
 
-func New(log *log.Logger, db *db.MemoryStore) *CAImpl {
+// Config holds the settings of a CA. The zero value issues certificates right
+// away from a new root and a single intermediate, without profiles, policies
+// or CT logs.
+type Config struct {
+	// Hooks are called for every issued certificate
+	Hooks []IssuanceHook
+	// Latency is the ordered list of rules used to delay issuance
+	Latency []LatencyRule
+	// RandomDelay is the range of the random delay added to every issuance
+	RandomDelay core.DelayRange
+	// Intermediate overrides the extensions of new intermediate certificates
+	Intermediate IntermediateOptions
+	// Hierarchy configures the key types and number of new intermediates
+	Hierarchy HierarchyOptions
+	// Policies are the issuance policies orders are matched against in order
+	Policies []IssuancePolicy
+	// CTLogs are the URLs of the CT logs precertificates are submitted to
+	CTLogs []string
+	// LoadTest configures the serial pool and the issuance workers
+	LoadTest LoadTestOptions
+	// Profiles are the certificate profiles orders can select. They must have
+	// been checked with CheckProfiles.
+	Profiles []Profile
+}
+
+// New creates a CA with a new issuer hierarchy.
+func New(log *logging.Logger, db *db.MemoryStore, clk clock.Clock, config Config) *CAImpl {
 	ca := &CAImpl{
-		log: log,
-		db:  db,
+		log:              log,
+		clk:              clk,
+		db:               db,
+		hooks:            config.Hooks,
+		latency:          config.Latency,
+		randomDelay:      config.RandomDelay,
+		intermediateOpts: config.Intermediate,
+		ctLogs:           config.CTLogs,
 	}
-	err := ca.newRootIssuer()
+	err := ca.newRootIssuer(config.Hierarchy.rootKeyType())
 	if err != nil {
 		panic(fmt.Sprintf("Error creating new root issuer: %s", err.Error()))
 	}
-	err = ca.newIntermediateIssuer()
+	err = ca.newIntermediateIssuers(config.Hierarchy)
 	if err != nil {
 		panic(fmt.Sprintf("Error creating new intermediate issuer: %s", err.Error()))
 	}
+	err = ca.newPolicyIssuers(config.Policies)
+	if err != nil {
+		panic(fmt.Sprintf("Error creating issuance policy issuers: %s", err.Error()))
+	}
+	ca.setProfiles(config.Profiles)
+	ca.startLoadTest(config.LoadTest)
 	return ca
 }
 
-func (ca *CAImpl) CompleteOrder(order *core.Order) {
-	// Update the order to reflect that we're now processing it
+// parseOrderTime returns the time of the RFC 3339 notBefore or notAfter of an
+// order, or the zero time if it is empty or invalid.
+func parseOrderTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// RootCert returns the certificate of the CA's root issuer.
+func (ca *CAImpl) RootCert() *core.Certificate {
+	return ca.root.cert
+}
+
+// CompleteOrder issues a certificate for a processing order and sets the
+// order to valid, or to invalid if issuance fails. It is logged with the
+// request ID of ctx, which is only used for logging.
+func (ca *CAImpl) CompleteOrder(ctx context.Context, order *core.Order) {
+	log := ca.log.WithContext(ctx)
+
+	// Wait for the configured issuance latency and random delay, if any,
+	// without holding the order lock so the order can still be polled while it
+	// is processing
+	order.RLock()
+	delay := ca.issuanceDelay(order) + ca.randomDelay.Random()
+	pol := ca.policyFor(order)
+	profile := ca.profiles[order.Profile]
+	order.RUnlock()
+	if delay > 0 {
+		log.Printf("Delaying issuance for order %s by %s\n", order.ID, delay)
+		ca.clk.Sleep(delay)
+	}
+
+	// Lock the order for writing, it is updated with the issuance result
+	defer ca.db.Changed()
 	order.Lock()
 	defer order.Unlock()
 
-	// Check the authorizations - this is done by the VA before calling
-	// CompleteOrder but we do it again for robustness sake.
+	// The WFE sets the order to processing when it is finalized
+	if order.Status != acme.StatusProcessing {
+		log.Printf("Error: Asked to complete order %s is not status processing, was status %s",
+			order.ID, order.Status)
+		return
+	}
+
+	// Check the authorizations - this is done by the WFE before finalizing the
+	// order but we do it again for robustness sake.
 	for _, authz := range order.AuthorizationObjects {
 		// Lock the authorization for reading
 		authz.RLock()
-		if authz.Status != acme.StatusValid {
+		authzStatus := authz.Status
+		authz.RUnlock()
+		if authzStatus != acme.StatusValid {
+			log.Printf("Error: order %s set INVALID: authz %s is status %s\n",
+				order.ID, authz.ID, authzStatus)
+			order.Status = acme.StatusInvalid
 			return
 		}
-		authz.RUnlock()
 	}
 
-	if order.Status != acme.StatusPending {
-		ca.log.Printf("Error: Asked to complete order %s is not status pending, was status %s",
-			order.ID, order.Status)
-		return
-	}
-
-	ca.log.Printf("Order %s is fully authorized. Ready to issue", order.ID)
-	order.Status = acme.StatusProcessing
+	log.Printf("Order %s is fully authorized. Ready to issue", order.ID)
 
 	csr := order.ParsedCSR
-	// issue a certificate for the csr
-	cert, err := ca.newCertificate(csr.DNSNames, csr.PublicKey)
+	// Issue a certificate for the CSR. The WFE's CSR policy has already
+	// rejected any email SANs or TLS Feature (OCSP Must-Staple) extension it
+	// doesn't permit, a requested TLS Feature extension is copied into the
+	// certificate.
+	var extensions []pkix.Extension
+	if ext := core.TLSFeatureExtension(csr); ext != nil {
+		extensions = append(extensions, *ext)
+	}
+	// An order matching an issuance policy uses its issuer and validity
+	issuer := ca.intermediate
+	var validity time.Duration
+	if pol != nil {
+		log.Printf("Issuing order %s with the policy for identifiers ending in %q\n",
+			order.ID, pol.IdentifierSuffix)
+		issuer = pol.issuer
+		validity = pol.Validity.Duration
+	}
+	// The profile selected by the order takes precedence over the policy
+	if profile != nil {
+		log.Printf("Issuing order %s with profile %q\n", order.ID, profile.Name)
+		if profile.Validity.Duration > 0 {
+			validity = profile.Validity.Duration
+		}
+	}
+	// The WFE checked the notBefore and notAfter of the order against its
+	// validity window
+	notBefore := parseOrderTime(order.NotBefore)
+	notAfter := parseOrderTime(order.NotAfter)
+	cert, err := ca.newCertificate(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.PublicKey,
+		extensions, issuer, notBefore, notAfter, validity, profile)
 	if err != nil {
-		ca.log.Printf("Error: unable to issue order: %s", err.Error())
+		log.Printf("Error: unable to issue order: %s", err.Error())
+		order.Status = acme.StatusInvalid
+		return
+	}
+	log.Printf("Issued certificate serial %s for order %s\n", cert.ID, order.ID)
+
+	// Send the issued chain to any configured issuance hooks. If a blocking hook
+	// fails the certificate is not given to the order and the order is invalid.
+	if err := ca.runIssuanceHooks(log, cert); err != nil {
+		log.Printf("Error: order %s set INVALID: %s\n", order.ID, err.Error())
+		order.Status = acme.StatusInvalid
 		return
 	}
-	ca.log.Printf("Issued certificate serial %s for order %s\n", cert.ID, order.ID)
 
 	// Update the order to valid status and store a cert ID for the wfe to use to
 	// render the certificate URL for the order
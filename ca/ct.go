@@ -0,0 +1,43 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/ct"
+)
+
+// embedSCTs issues a poisoned precertificate for template, submits it to each
+// of the configured CT logs and adds the returned SCTs to template, so that
+// the certificate issued from it has them embedded.
+func (ca *CAImpl) embedSCTs(template *x509.Certificate, key crypto.PublicKey, issuer *issuer) error {
+	precert := *template
+	precert.ExtraExtensions = append(
+		append([]pkix.Extension{}, template.ExtraExtensions...), ct.PoisonExtension())
+	der, err := x509.CreateCertificate(rand.Reader, &precert, issuer.cert.Cert, key, issuer.key)
+	if err != nil {
+		return fmt.Errorf("creating precertificate: %s", err.Error())
+	}
+
+	chain := [][]byte{der, issuer.cert.DER}
+	var scts []*ct.SCT
+	for _, logURL := range ca.ctLogs {
+		sct, err := ct.SubmitPrecert(logURL, chain)
+		if err != nil {
+			return fmt.Errorf("submitting precertificate to CT log %s: %s", logURL, err.Error())
+		}
+		scts = append(scts, sct)
+	}
+	ext, err := ct.SCTListExtension(scts)
+	if err != nil {
+		return err
+	}
+	// The SCT list takes the place of the poison so that the certificate's
+	// TBSCertificate matches the one the logs signed
+	template.ExtraExtensions = append(
+		append([]pkix.Extension{}, template.ExtraExtensions...), ext)
+	return nil
+}
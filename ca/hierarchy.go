@@ -0,0 +1,213 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+const (
+	// crossRootCAPrefix is the common name prefix of the root that
+	// cross-signs the first intermediate
+	crossRootCAPrefix = "Pebble Cross-Signing Root CA "
+	// maxIntermediates bounds the depth of the hierarchy
+	maxIntermediates = 8
+	// defaultKeyType is the key type of issuers without a configured one
+	defaultKeyType = "rsa2048"
+)
+
+// keyTypes are the key types issuers can have, by name
+var keyTypes = map[string]func() (crypto.Signer, error){
+	"rsa2048": func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) },
+	"rsa3072": func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 3072) },
+	"rsa4096": func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 4096) },
+	"ecdsa-p256": func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	},
+	"ecdsa-p384": func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	},
+}
+
+// HierarchyOptions describe the hierarchy of the CA's issuers. The zero value
+// is a root with a single intermediate, both with 2048 bit RSA keys.
+type HierarchyOptions struct {
+	// Intermediates is the number of intermediates between the root and the
+	// issued certificates, one when it is zero. The first is signed by the
+	// root and every other one by the intermediate before it. The last one
+	// issues the certificates and has the intermediate extensions.
+	Intermediates int
+	// CrossSign makes a second root sign the first intermediate too, so that
+	// the issued certificates also have an alternate chain to that root
+	CrossSign bool
+	// RootKeyType is the key type of the roots, a name from keyTypes
+	RootKeyType string
+	// IntermediateKeyTypes are the key types of the intermediates, from the
+	// one signed by the root down. Intermediates past the end of the list have
+	// its last key type.
+	IntermediateKeyTypes []string
+}
+
+// Validate returns an error if the number of intermediates is out of range or
+// a key type is unknown.
+func (h HierarchyOptions) Validate() error {
+	if h.Intermediates < 0 || h.Intermediates > maxIntermediates {
+		return fmt.Errorf("intermediates must be between 1 and %d, got %d", maxIntermediates, h.Intermediates)
+	}
+	for _, keyType := range append([]string{h.RootKeyType}, h.IntermediateKeyTypes...) {
+		if _, ok := keyTypes[keyType]; keyType != "" && !ok {
+			var names []string
+			for name := range keyTypes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown key type %q, must be one of %s", keyType, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// intermediates returns the number of intermediates of the hierarchy.
+func (h HierarchyOptions) intermediates() int {
+	if h.Intermediates == 0 {
+		return 1
+	}
+	return h.Intermediates
+}
+
+// rootKeyType returns the key type of the roots.
+func (h HierarchyOptions) rootKeyType() string {
+	if h.RootKeyType == "" {
+		return defaultKeyType
+	}
+	return h.RootKeyType
+}
+
+// intermediateKeyType returns the key type of the intermediate at depth i,
+// zero being the intermediate signed by the root.
+func (h HierarchyOptions) intermediateKeyType(i int) string {
+	if len(h.IntermediateKeyTypes) == 0 {
+		return defaultKeyType
+	}
+	if i >= len(h.IntermediateKeyTypes) {
+		i = len(h.IntermediateKeyTypes) - 1
+	}
+	if h.IntermediateKeyTypes[i] == "" {
+		return defaultKeyType
+	}
+	return h.IntermediateKeyTypes[i]
+}
+
+// keyTypeOf returns the name of the key type of key, or defaultKeyType if it
+// isn't one of keyTypes.
+func keyTypeOf(key crypto.Signer) string {
+	var keyType string
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		keyType = fmt.Sprintf("rsa%d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		keyType = fmt.Sprintf("ecdsa-p%d", pub.Curve.Params().BitSize)
+	}
+	if _, ok := keyTypes[keyType]; !ok {
+		return defaultKeyType
+	}
+	return keyType
+}
+
+// newIntermediateIssuers generates the intermediates of the hierarchy, and the
+// cross-signing root if it has one. The last intermediate becomes the issuer
+// of certificates.
+func (ca *CAImpl) newIntermediateIssuers(h HierarchyOptions) error {
+	parent := ca.root
+	n := h.intermediates()
+	for i := 0; i < n; i++ {
+		// Only the intermediate issuing certificates has the configured
+		// extensions
+		var opts IntermediateOptions
+		if i == n-1 {
+			opts = ca.intermediateOpts
+		}
+		intermediate, err := ca.makeIntermediateIssuer(parent, h.intermediateKeyType(i), opts)
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			ca.log.Printf("Generated new intermediate issuer with serial %s\n", intermediate.cert.ID)
+		} else {
+			ca.log.Printf("Generated new intermediate issuer with serial %s (%d of %d)\n",
+				intermediate.cert.ID, i+1, n)
+		}
+		if i == 0 && h.CrossSign {
+			if err := ca.crossSign(intermediate, h.rootKeyType()); err != nil {
+				return err
+			}
+		}
+		parent = intermediate
+	}
+	ca.intermediate = parent
+	return nil
+}
+
+// crossSign generates a second root and has it sign another certificate for
+// the subject and key of the intermediate, which becomes the intermediate's
+// CrossSigned certificate.
+func (ca *CAImpl) crossSign(intermediate *issuer, keyType string) error {
+	rk, err := makeKey(keyType)
+	if err != nil {
+		return err
+	}
+	rc, err := ca.makeRootCert(rk, crossRootCAPrefix, nil, IntermediateOptions{})
+	if err != nil {
+		return err
+	}
+	crossRoot := &issuer{key: rk, cert: rc}
+
+	ic := intermediate.cert.Cert
+	template := &x509.Certificate{
+		Subject:      ic.Subject,
+		SerialNumber: makeSerial(),
+		NotBefore:    ic.NotBefore,
+		NotAfter:     ic.NotAfter,
+		SubjectKeyId: ic.SubjectKeyId,
+
+		KeyUsage:              ic.KeyUsage,
+		ExtKeyUsage:           ic.ExtKeyUsage,
+		BasicConstraintsValid: ic.BasicConstraintsValid,
+		IsCA:                  ic.IsCA,
+		MaxPathLen:            ic.MaxPathLen,
+		MaxPathLenZero:        ic.MaxPathLenZero,
+	}
+	cross, err := ca.signCACert(template, intermediate.key, crossRoot)
+	if err != nil {
+		return err
+	}
+	intermediate.cert.CrossSigned = cross
+	ca.log.Printf("Generated new cross-signing root issuer with serial %s, cross-signed intermediate has serial %s\n",
+		rc.ID, cross.ID)
+	return nil
+}
+
+// AlternateRootCert returns the root of the alternate chain of the issued
+// certificates, or nil if the hierarchy isn't cross-signed.
+func (ca *CAImpl) AlternateRootCert() *core.Certificate {
+	if ca.intermediate == nil || ca.intermediate.cert == nil {
+		return nil
+	}
+	// The alternate chain of a certificate issued by the intermediate
+	root := core.Certificate{Issuer: ca.intermediate.cert}.AlternateChain()
+	if root == nil {
+		return nil
+	}
+	for root.Issuer != nil {
+		root = root.Issuer
+	}
+	return root
+}
@@ -0,0 +1,61 @@
+package ca
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// IssuanceHook describes an external URL that newly issued certificate chains
+// are POSTed to, for instance a CT test log or a certificate linting service.
+type IssuanceHook struct {
+	URL string
+	// If Blocking is true the hook must accept the chain (return a 2xx status)
+	// for the order to become valid. Failures of non-blocking hooks are only
+	// logged.
+	Blocking bool
+}
+
+// hookTimeout bounds how long the CA waits for an issuance hook to respond
+const hookTimeout = 10 * time.Second
+
+// postToHook POSTs the PEM certificate chain for cert to the hook URL and
+// returns an error if the request fails or the hook doesn't return 2xx.
+func postToHook(hook IssuanceHook, cert *core.Certificate) error {
+	client := &http.Client{Timeout: hookTimeout}
+	resp, err := client.Post(hook.URL, "application/pem-certificate-chain", bytes.NewReader(cert.Chain()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("hook returned status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// runIssuanceHooks sends cert to all of the configured issuance hooks. An error
+// is returned if any blocking hook failed.
+func (ca *CAImpl) runIssuanceHooks(log *logging.Logger, cert *core.Certificate) error {
+	var blockingErr error
+	for _, hook := range ca.hooks {
+		err := postToHook(hook, cert)
+		if err == nil {
+			log.Printf("Issuance hook %s accepted certificate serial %s\n", hook.URL, cert.ID)
+			continue
+		}
+		log.Printf("Error: issuance hook %s failed for certificate serial %s: %s\n",
+			hook.URL, cert.ID, err.Error())
+		if hook.Blocking && blockingErr == nil {
+			blockingErr = fmt.Errorf("blocking issuance hook %s failed: %s", hook.URL, err.Error())
+		}
+	}
+	return blockingErr
+}
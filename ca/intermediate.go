@@ -0,0 +1,89 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// IntermediateOptions override the BasicConstraints, KeyUsage and
+// ExtendedKeyUsage of the intermediate certificate. Any combination is
+// accepted, including ones that make the chain invalid, so that
+// chain-validation edge cases in TLS stacks can be reproduced. Unset fields
+// use the defaults of the root certificate.
+type IntermediateOptions struct {
+	// IsCA sets the BasicConstraints cA flag
+	IsCA *bool
+	// MaxPathLen sets the BasicConstraints pathLenConstraint. A value of -1
+	// omits the constraint.
+	MaxPathLen *int
+	// KeyUsage are names from keyUsages. An empty list omits the extension.
+	KeyUsage []string
+	// ExtKeyUsage are names from extKeyUsages. An empty list omits the
+	// extension.
+	ExtKeyUsage []string
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Validate returns an error if any of the key usage names are unknown or the
+// path length is less than -1.
+func (o IntermediateOptions) Validate() error {
+	if o.MaxPathLen != nil && *o.MaxPathLen < -1 {
+		return fmt.Errorf("maxPathLen must be -1 or greater, was %d", *o.MaxPathLen)
+	}
+	for _, name := range o.KeyUsage {
+		if _, ok := keyUsages[name]; !ok {
+			return fmt.Errorf("unknown keyUsage %q", name)
+		}
+	}
+	for _, name := range o.ExtKeyUsage {
+		if _, ok := extKeyUsages[name]; !ok {
+			return fmt.Errorf("unknown extKeyUsage %q", name)
+		}
+	}
+	return nil
+}
+
+// apply overrides the fields of the template with the configured options.
+// Validate must have been called first.
+func (o IntermediateOptions) apply(template *x509.Certificate) {
+	if o.IsCA != nil {
+		template.IsCA = *o.IsCA
+	}
+	if o.MaxPathLen != nil {
+		template.MaxPathLen = *o.MaxPathLen
+		template.MaxPathLenZero = *o.MaxPathLen == 0
+	}
+	if o.KeyUsage != nil {
+		template.KeyUsage = 0
+		for _, name := range o.KeyUsage {
+			template.KeyUsage |= keyUsages[name]
+		}
+	}
+	if o.ExtKeyUsage != nil {
+		template.ExtKeyUsage = nil
+		for _, name := range o.ExtKeyUsage {
+			template.ExtKeyUsage = append(template.ExtKeyUsage, extKeyUsages[name])
+		}
+	}
+}
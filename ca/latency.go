@@ -0,0 +1,34 @@
+package ca
+
+import (
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// LatencyRule adds an artificial delay before issuing certificates for orders
+// with an identifier ending in IdentifierSuffix. A rule with an empty suffix
+// matches every identifier.
+type LatencyRule struct {
+	IdentifierSuffix string
+	Delay            core.Duration
+}
+
+// issuanceDelay returns how long to wait before issuing a certificate for an
+// order. Each identifier uses the delay of the first rule it matches and the
+// order waits for the longest of them. The caller must hold the order lock.
+func (ca *CAImpl) issuanceDelay(order *core.Order) time.Duration {
+	var delay time.Duration
+	for _, ident := range order.Identifiers {
+		for _, rule := range ca.latency {
+			if strings.HasSuffix(ident.Value, rule.IdentifierSuffix) {
+				if rule.Delay.Duration > delay {
+					delay = rule.Delay.Duration
+				}
+				break
+			}
+		}
+	}
+	return delay
+}
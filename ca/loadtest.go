@@ -0,0 +1,133 @@
+package ca
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// issuanceQueuePerWorker is how many orders can wait for each issuance worker
+// before finalizations wait for room in the queue
+const issuanceQueuePerWorker = 64
+
+// LoadTestOptions tune the CA for high issuance rates when Pebble is the
+// backend of ACME client load tests. The zero value generates serials on
+// demand and issues every order in its own goroutine.
+type LoadTestOptions struct {
+	// SerialPoolSize is how many serial numbers are generated ahead of the
+	// issuances using them
+	SerialPoolSize int
+	// IssuanceWorkers is how many orders are issued at once. Orders finalized
+	// while every worker is busy wait in a queue.
+	IssuanceWorkers int
+}
+
+// Validate returns an error if a size is negative.
+func (o LoadTestOptions) Validate() error {
+	if o.SerialPoolSize < 0 {
+		return fmt.Errorf("serialPoolSize must not be negative, got %d", o.SerialPoolSize)
+	}
+	if o.IssuanceWorkers < 0 {
+		return fmt.Errorf("issuanceWorkers must not be negative, got %d", o.IssuanceWorkers)
+	}
+	return nil
+}
+
+// issuanceJob is an order queued for the issuance workers
+type issuanceJob struct {
+	ctx   context.Context
+	order *core.Order
+	done  func()
+}
+
+// startLoadTest starts filling the serial pool and the issuance workers of
+// opts, if it has any. They run until Close is called.
+func (ca *CAImpl) startLoadTest(opts LoadTestOptions) {
+	ca.closed = make(chan struct{})
+	if opts.SerialPoolSize > 0 {
+		ca.serials = make(chan *big.Int, opts.SerialPoolSize)
+		go func() {
+			for {
+				select {
+				case ca.serials <- makeSerial():
+				case <-ca.closed:
+					return
+				}
+			}
+		}()
+	}
+	if opts.IssuanceWorkers > 0 {
+		ca.issuanceQueue = make(chan issuanceJob, opts.IssuanceWorkers*issuanceQueuePerWorker)
+		for i := 0; i < opts.IssuanceWorkers; i++ {
+			go func() {
+				for job := range ca.issuanceQueue {
+					ca.CompleteOrder(job.ctx, job.order)
+					job.done()
+				}
+			}()
+		}
+	}
+	if opts.SerialPoolSize > 0 || opts.IssuanceWorkers > 0 {
+		ca.log.Printf("Load test mode: %d pre-generated serials, %d issuance workers\n",
+			opts.SerialPoolSize, opts.IssuanceWorkers)
+	}
+}
+
+// nextSerial returns a serial number for a new certificate, from the serial
+// pool if there is one. The serials left in the pool of a closed CA are used
+// up before serials are generated on demand again.
+func (ca *CAImpl) nextSerial() *big.Int {
+	if ca.serials == nil {
+		return makeSerial()
+	}
+	select {
+	case serial := <-ca.serials:
+		return serial
+	case <-ca.closed:
+		return makeSerial()
+	}
+}
+
+// IssueOrder completes the processing order in the background, see
+// CompleteOrder, and calls done once it is valid or invalid. Without issuance
+// workers the order is completed in its own goroutine, with them it is queued
+// and IssueOrder blocks while the queue is full. Orders of a closed CA are
+// completed in their own goroutines.
+func (ca *CAImpl) IssueOrder(ctx context.Context, order *core.Order, done func()) {
+	ca.closeLock.RLock()
+	defer ca.closeLock.RUnlock()
+	if ca.issuanceQueue == nil || ca.isClosed() {
+		go func() {
+			ca.CompleteOrder(ctx, order)
+			done()
+		}()
+		return
+	}
+	ca.issuanceQueue <- issuanceJob{ctx: ctx, order: order, done: done}
+}
+
+// isClosed returns true if Close has been called.
+func (ca *CAImpl) isClosed() bool {
+	select {
+	case <-ca.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops filling the serial pool, and the issuance workers once they
+// completed the queued orders. It can be called more than once.
+func (ca *CAImpl) Close() {
+	ca.closeLock.Lock()
+	defer ca.closeLock.Unlock()
+	if ca.isClosed() {
+		return
+	}
+	close(ca.closed)
+	if ca.issuanceQueue != nil {
+		close(ca.issuanceQueue)
+	}
+}
@@ -0,0 +1,50 @@
+package ca
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+func TestCloseStopsLoadTestGoroutines(t *testing.T) {
+	logger := logging.New(ioutil.Discard, logging.FormatText)
+	before := runtime.NumGoroutine()
+	ca := New(logger, db.NewMemoryStore(), clock.Default(), Config{
+		LoadTest: LoadTestOptions{SerialPoolSize: 4, IssuanceWorkers: 2},
+	})
+	ca.Close()
+	// Closing again is a no-op
+	ca.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines a second after Close, %d before New",
+				runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A closed CA still hands out serials and completes orders
+	for i := 0; i < 8; i++ {
+		if serial := ca.nextSerial(); serial == nil {
+			t.Fatalf("nextSerial() %d of a closed CA = nil", i+1)
+		}
+	}
+	done := make(chan struct{})
+	order := &core.Order{ID: "order", Order: acme.Order{Status: acme.StatusPending}}
+	ca.IssueOrder(context.Background(), order, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("IssueOrder() of a closed CA didn't complete the order")
+	}
+}
@@ -0,0 +1,123 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// IssuancePolicy changes how certificates are issued for orders whose
+// identifiers all end in IdentifierSuffix. A policy with an empty suffix
+// matches every order.
+type IssuancePolicy struct {
+	IdentifierSuffix string
+	// Validity is the validity period of the certificates. Zero uses the
+	// default of five years.
+	Validity core.Duration
+	// Intermediate, if not nil, gives the policy its own intermediate issuer
+	// signed by the root with these extensions. Otherwise certificates are
+	// issued by the default intermediate.
+	Intermediate *IntermediateOptions
+}
+
+// Validate returns an error if the policy has a negative validity or invalid
+// intermediate options.
+func (p IssuancePolicy) Validate() error {
+	if p.Validity.Duration < 0 {
+		return errors.New("validity must not be negative")
+	}
+	if p.Intermediate != nil {
+		if err := p.Intermediate.Validate(); err != nil {
+			return fmt.Errorf("intermediate: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// policy is an IssuancePolicy together with the issuer its certificates are
+// signed by.
+type policy struct {
+	IssuancePolicy
+	issuer *issuer
+}
+
+// newPolicyIssuers sets up the configured policies, generating an
+// intermediate issuer for each policy that has intermediate options. It must
+// be called after the root and default intermediate issuers exist.
+func (ca *CAImpl) newPolicyIssuers(policies []IssuancePolicy) error {
+	for _, p := range policies {
+		pol := &policy{
+			IssuancePolicy: p,
+			issuer:         ca.intermediate,
+		}
+		if p.Intermediate != nil {
+			intermediate, err := ca.makeIntermediateIssuer(ca.root, keyTypeOf(ca.intermediate.key), *p.Intermediate)
+			if err != nil {
+				return err
+			}
+			pol.issuer = intermediate
+			ca.log.Printf("Generated new intermediate issuer with serial %s for identifiers ending in %q\n",
+				intermediate.cert.ID, p.IdentifierSuffix)
+		}
+		ca.policies = append(ca.policies, pol)
+	}
+	return nil
+}
+
+// policyFor returns the first policy that matches all of the order's
+// identifiers, or nil if the defaults apply. The caller must hold the order
+// lock.
+func (ca *CAImpl) policyFor(order *core.Order) *policy {
+	ca.policiesLock.RLock()
+	defer ca.policiesLock.RUnlock()
+	for _, pol := range ca.policies {
+		matches := true
+		for _, ident := range order.Identifiers {
+			if !strings.HasSuffix(ident.Value, pol.IdentifierSuffix) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return pol
+		}
+	}
+	return nil
+}
+
+// ReloadPolicies changes the validity of the CA's issuance policies to that of
+// policies, which must otherwise be the policies the CA was created with.
+// Adding, removing or reordering policies and changing their identifier
+// suffixes or intermediates requires new issuers and so a new CA.
+func (ca *CAImpl) ReloadPolicies(policies []IssuancePolicy) error {
+	ca.policiesLock.Lock()
+	defer ca.policiesLock.Unlock()
+	if len(policies) != len(ca.policies) {
+		return fmt.Errorf("the CA has %d issuance policies, got %d", len(ca.policies), len(policies))
+	}
+	for i, p := range policies {
+		current := ca.policies[i].IssuancePolicy
+		if p.IdentifierSuffix != current.IdentifierSuffix ||
+			!reflect.DeepEqual(p.Intermediate, current.Intermediate) {
+			return fmt.Errorf("only the validity of issuance policy %d (identifiers ending in %q) can be changed",
+				i, current.IdentifierSuffix)
+		}
+	}
+	// Orders being issued keep the policy they were matched with
+	reloaded := make([]*policy, len(policies))
+	for i, p := range policies {
+		reloaded[i] = &policy{
+			IssuancePolicy: p,
+			issuer:         ca.policies[i].issuer,
+		}
+		if p.Validity != ca.policies[i].Validity {
+			ca.log.Printf("Changed validity of certificates for identifiers ending in %q from %s to %s\n",
+				p.IdentifierSuffix, ca.policies[i].Validity.Duration, p.Validity.Duration)
+		}
+	}
+	ca.policies = reloaded
+	return nil
+}
@@ -0,0 +1,167 @@
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Profile is a certificate profile (draft-aaron-acme-profiles) that newOrder
+// requests select by name. The profiles are advertised in the directory meta
+// and change how the certificates of the orders selecting them are issued.
+// Unset fields keep the parameters certificates are issued with otherwise.
+type Profile struct {
+	Name string
+	// Description is the human readable description advertised for the
+	// profile in the directory
+	Description string
+	// Validity is the validity period of the certificates. It takes precedence
+	// over the validity of an issuance policy matching the order.
+	Validity core.Duration
+	// KeyUsage and ExtKeyUsage are names from keyUsages and extKeyUsages. An
+	// empty list omits the extension.
+	KeyUsage    []string
+	ExtKeyUsage []string
+	// OmitCommonName leaves the subject common name of the certificates empty
+	OmitCommonName bool
+	// Extensions are added to the certificates, replacing the extensions with
+	// the same OIDs that would otherwise be included
+	Extensions []ExtensionOptions
+}
+
+// ExtensionOptions is an X.509 extension added to certificates. The value is
+// the hex encoding of the DER extnValue, e.g. "0500" for an ASN.1 NULL.
+type ExtensionOptions struct {
+	OID      string
+	Critical bool
+	Value    string
+}
+
+// parse returns the extension, or an error if its OID or value are invalid.
+func (e ExtensionOptions) parse() (pkix.Extension, error) {
+	var oid asn1.ObjectIdentifier
+	for _, arc := range strings.Split(e.OID, ".") {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return pkix.Extension{}, fmt.Errorf("invalid OID %q", e.OID)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return pkix.Extension{}, fmt.Errorf("invalid OID %q", e.OID)
+	}
+	value, err := hex.DecodeString(e.Value)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("extension %s has an invalid hex value: %s", e.OID, err.Error())
+	}
+	return pkix.Extension{Id: oid, Critical: e.Critical, Value: value}, nil
+}
+
+// Validate returns an error if the profile has no name, a negative validity,
+// an unknown key usage or an invalid extension.
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return errors.New("name must not be empty")
+	}
+	if p.Validity.Duration < 0 {
+		return fmt.Errorf("profile %q: validity must not be negative", p.Name)
+	}
+	for _, name := range p.KeyUsage {
+		if _, ok := keyUsages[name]; !ok {
+			return fmt.Errorf("profile %q: unknown keyUsage %q", p.Name, name)
+		}
+	}
+	for _, name := range p.ExtKeyUsage {
+		if _, ok := extKeyUsages[name]; !ok {
+			return fmt.Errorf("profile %q: unknown extKeyUsage %q", p.Name, name)
+		}
+	}
+	for _, ext := range p.Extensions {
+		if _, err := ext.parse(); err != nil {
+			return fmt.Errorf("profile %q: %s", p.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// CheckProfiles validates each of the profiles and returns an error if
+// several have the same name.
+func CheckProfiles(profiles []Profile) error {
+	seen := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("profile %q is configured twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return nil
+}
+
+// apply overrides the fields of a certificate template with the profile's.
+// Validate must have been called first.
+func (p *Profile) apply(template *x509.Certificate) {
+	if p.OmitCommonName {
+		template.Subject.CommonName = ""
+	}
+	if p.KeyUsage != nil {
+		template.KeyUsage = 0
+		for _, name := range p.KeyUsage {
+			template.KeyUsage |= keyUsages[name]
+		}
+	}
+	if p.ExtKeyUsage != nil {
+		template.ExtKeyUsage = nil
+		for _, name := range p.ExtKeyUsage {
+			template.ExtKeyUsage = append(template.ExtKeyUsage, extKeyUsages[name])
+		}
+	}
+	if len(p.Extensions) > 0 {
+		template.ExtraExtensions = append([]pkix.Extension{}, template.ExtraExtensions...)
+		for _, ext := range p.Extensions {
+			parsed, _ := ext.parse()
+			template.ExtraExtensions = append(template.ExtraExtensions, parsed)
+		}
+	}
+}
+
+// setProfiles sets the profiles orders can select. They must have been
+// checked with CheckProfiles.
+func (ca *CAImpl) setProfiles(profiles []Profile) {
+	ca.profiles = make(map[string]*Profile, len(profiles))
+	for i := range profiles {
+		ca.profiles[profiles[i].Name] = &profiles[i]
+	}
+}
+
+// Profiles returns the descriptions of the CA's profiles by name, the value of
+// the profiles field of the directory meta. A nil CA, e.g. of the fuzzing WFE,
+// has no profiles.
+func (ca *CAImpl) Profiles() map[string]string {
+	if ca == nil {
+		return nil
+	}
+	descriptions := make(map[string]string, len(ca.profiles))
+	for name, p := range ca.profiles {
+		descriptions[name] = p.Description
+	}
+	return descriptions
+}
+
+// HasProfile returns true if orders can select the profile with the name.
+func (ca *CAImpl) HasProfile(name string) bool {
+	if ca == nil {
+		return false
+	}
+	_, ok := ca.profiles[name]
+	return ok
+}
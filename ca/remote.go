@@ -0,0 +1,294 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	// Paths of the signer API served by a standalone CA
+	issuersPath = "/issuers"
+	signPath    = "/sign"
+
+	// How long does the CA wait for a remote signer?
+	signerTimeout = 30 * time.Second
+)
+
+// RemoteSigner configures the link to a standalone CA that signs
+// certificates. Latency and FailureRate are injected on the link to model a
+// remote signer.
+type RemoteSigner struct {
+	// URL is the base URL of the standalone CA. The local CA signs
+	// certificates itself when it is empty.
+	URL string
+	// Latency is the range of the random delay added to every signing request
+	Latency core.DelayRange
+	// FailureRate is the chance from 0 to 1 that a signing request fails
+	// without reaching the signer
+	FailureRate float64
+}
+
+// Validate returns an error if the latency range or failure rate is invalid.
+func (s RemoteSigner) Validate() error {
+	if err := s.Latency.Validate(); err != nil {
+		return err
+	}
+	if s.FailureRate < 0 || s.FailureRate > 1 {
+		return fmt.Errorf("failureRate %v is not between 0 and 1", s.FailureRate)
+	}
+	return nil
+}
+
+// issuersResponse holds the DER encoded issuer certificates of a signer
+type issuersResponse struct {
+	Root         []byte `json:"root"`
+	Intermediate []byte `json:"intermediate"`
+}
+
+// signRequest asks a signer for a certificate with the given names and PKIX
+// DER encoded public key
+type signRequest struct {
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	PublicKey      []byte   `json:"publicKey"`
+	// Extensions are added to the certificate as-is
+	Extensions []pkix.Extension `json:"extensions,omitempty"`
+	// NotBefore and NotAfter are the validity window requested by the order,
+	// the signer's defaults are used for zero times
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	NotAfter  time.Time `json:"notAfter,omitempty"`
+	// Validity is the validity period used when no notAfter is requested, the
+	// signer's default is used if it is zero
+	Validity core.Duration `json:"validity"`
+	// Profile is the certificate profile selected by the order, if any. The
+	// signer applies it as is, it doesn't need to be configured with it.
+	Profile *Profile `json:"profile,omitempty"`
+}
+
+// signResponse holds the DER encoded certificate issued for a signRequest
+type signResponse struct {
+	Certificate []byte `json:"certificate"`
+}
+
+// SignerHandler returns an http.Handler serving the signer API, so that the
+// CA can run as a standalone signing service for other Pebble processes.
+func (ca *CAImpl) SignerHandler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(issuersPath, func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" {
+			response.Header().Set("Allow", "GET")
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeSignerResponse(response, issuersResponse{
+			Root:         ca.root.cert.DER,
+			Intermediate: ca.intermediate.cert.DER,
+		})
+	})
+	m.HandleFunc(signPath, func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" {
+			response.Header().Set("Allow", "POST")
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req signRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(response, "Error unmarshaling body JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, err := x509.ParsePKIXPublicKey(req.PublicKey)
+		if err != nil {
+			http.Error(response, "Error parsing publicKey: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Validity.Duration < 0 {
+			http.Error(response, "Invalid negative validity", http.StatusBadRequest)
+			return
+		}
+		if req.Profile != nil {
+			if err := req.Profile.Validate(); err != nil {
+				http.Error(response, "Invalid profile: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var ips []net.IP
+		for _, s := range req.IPAddresses {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				http.Error(response, fmt.Sprintf("Invalid IP address %q", s), http.StatusBadRequest)
+				return
+			}
+			ips = append(ips, ip)
+		}
+		cert, err := ca.newCertificate(req.DNSNames, ips, req.EmailAddresses, key, req.Extensions,
+			ca.intermediate, req.NotBefore, req.NotAfter, req.Validity.Duration, req.Profile)
+		if err != nil {
+			ca.log.Printf("Error: unable to sign certificate: %s", err.Error())
+			http.Error(response, "Error signing certificate: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ca.log.Printf("Signed certificate serial %s for %s\n",
+			cert.ID, strings.Join(append(req.DNSNames, req.IPAddresses...), ", "))
+		writeSignerResponse(response, signResponse{Certificate: cert.DER})
+	})
+	return m
+}
+
+func writeSignerResponse(response http.ResponseWriter, v interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(response).Encode(v)
+}
+
+// NewRemote creates a CA that has certificates signed by the standalone CA
+// configured by signer instead of holding issuer keys itself. The signer's
+// issuer certificates are added to the db. Orders select the profiles as with
+// New, the signer is sent the profile of each certificate it signs. The
+// Intermediate, Hierarchy, Policies and CTLogs of config aren't used, every
+// certificate is signed by the signer's intermediate.
+func NewRemote(
+	log *logging.Logger,
+	db *db.MemoryStore,
+	clk clock.Clock,
+	signer RemoteSigner,
+	config Config) (*CAImpl, error) {
+	ca := &CAImpl{
+		log:         log,
+		clk:         clk,
+		db:          db,
+		hooks:       config.Hooks,
+		latency:     config.Latency,
+		randomDelay: config.RandomDelay,
+		signer:      &signer,
+	}
+	ca.setProfiles(config.Profiles)
+	ca.signer.URL = strings.TrimSuffix(ca.signer.URL, "/")
+
+	client := &http.Client{Timeout: signerTimeout}
+	resp, err := client.Get(ca.signer.URL + issuersPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signer returned status %d for issuers", resp.StatusCode)
+	}
+	var issuers issuersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&issuers); err != nil {
+		return nil, err
+	}
+
+	rootCert, err := ca.addIssuerCert(issuers.Root, nil)
+	if err != nil {
+		return nil, fmt.Errorf("root issuer: %s", err.Error())
+	}
+	intermediateCert, err := ca.addIssuerCert(issuers.Intermediate, rootCert)
+	if err != nil {
+		return nil, fmt.Errorf("intermediate issuer: %s", err.Error())
+	}
+	ca.root = &issuer{cert: rootCert}
+	ca.intermediate = &issuer{cert: intermediateCert}
+	ca.log.Printf("Using remote signer %s with intermediate issuer serial %s\n",
+		ca.signer.URL, intermediateCert.ID)
+	// The signer picks the serials of the certificates it signs
+	ca.startLoadTest(LoadTestOptions{IssuanceWorkers: config.LoadTest.IssuanceWorkers})
+	return ca, nil
+}
+
+// addIssuerCert adds an issuer certificate received from a signer to the db,
+// unless it is already there (e.g. loaded from a -dbfile).
+func (ca *CAImpl) addIssuerCert(der []byte, parent *core.Certificate) (*core.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(cert.SerialNumber.Bytes())
+	if existing := ca.db.GetCertificateByID(id); existing != nil {
+		return existing, nil
+	}
+	newCert := &core.Certificate{
+		ID:     id,
+		Cert:   cert,
+		DER:    der,
+		Issuer: parent,
+	}
+	if _, err := ca.db.AddCertificate(newCert); err != nil {
+		return nil, err
+	}
+	return newCert, nil
+}
+
+// remoteSign has the remote signer issue a certificate and returns its DER
+// encoding. The validity and profile of the order are sent along, so that the
+// signer issues the certificate the CA would. The configured latency and
+// failures are injected first.
+func (ca *CAImpl) remoteSign(
+	domains []string,
+	ips []net.IP,
+	emails []string,
+	key crypto.PublicKey,
+	extensions []pkix.Extension,
+	notBefore time.Time,
+	notAfter time.Time,
+	validity time.Duration,
+	profile *Profile) ([]byte, error) {
+	time.Sleep(ca.signer.Latency.Random())
+	if ca.signer.FailureRate > 0 && rand.Float64() < ca.signer.FailureRate {
+		return nil, errors.New("injected failure on the link to the remote signer")
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req := signRequest{
+		DNSNames:       domains,
+		EmailAddresses: emails,
+		PublicKey:      publicKey,
+		Extensions:     extensions,
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+		Validity:       core.Duration{Duration: validity},
+		Profile:        profile,
+	}
+	for _, ip := range ips {
+		req.IPAddresses = append(req.IPAddresses, ip.String())
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: signerTimeout}
+	resp, err := client.Post(ca.signer.URL+signPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("signer returned status %d: %s", resp.StatusCode, respBody)
+	}
+	var signed signResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return nil, err
+	}
+	return signed.Certificate, nil
+}
@@ -0,0 +1,84 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+func TestRemoteSignForwardsProfileAndValidity(t *testing.T) {
+	logger := logging.New(ioutil.Discard, logging.FormatText)
+	clk := clock.Default()
+	// The signer has no profiles of its own
+	signerCA := New(logger, db.NewMemoryStore(), clk, Config{})
+	signer := httptest.NewServer(signerCA.SignerHandler())
+	defer signer.Close()
+
+	profiles := []Profile{{
+		Name:           "short",
+		Validity:       core.Duration{Duration: 6 * time.Hour},
+		OmitCommonName: true,
+	}}
+	if err := CheckProfiles(profiles); err != nil {
+		t.Fatalf("CheckProfiles() = %s", err)
+	}
+	remoteCA, err := NewRemote(logger, db.NewMemoryStore(), clk, RemoteSigner{URL: signer.URL},
+		Config{Profiles: profiles})
+	if err != nil {
+		t.Fatalf("NewRemote() = %s", err)
+	}
+	if !remoteCA.HasProfile("short") {
+		t.Fatalf("remote CA doesn't have the profile it was created with")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	domains := []string{"example.com"}
+	testCases := []struct {
+		name     string
+		validity time.Duration
+		profile  *Profile
+		// wantValidity is the expected validity period, wantCN the common name
+		wantValidity time.Duration
+		wantCN       string
+	}{
+		{
+			name:         "validity without a profile",
+			validity:     48 * time.Hour,
+			wantValidity: 48 * time.Hour,
+			wantCN:       "example.com",
+		},
+		{
+			name:         "profile",
+			validity:     6 * time.Hour,
+			profile:      remoteCA.profiles["short"],
+			wantValidity: 6 * time.Hour,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := remoteCA.newCertificate(domains, nil, nil, &key.PublicKey, nil,
+				remoteCA.intermediate, time.Time{}, time.Time{}, tc.validity, tc.profile)
+			if err != nil {
+				t.Fatalf("newCertificate() = %s", err)
+			}
+			if got := cert.Cert.NotAfter.Sub(cert.Cert.NotBefore); got != tc.wantValidity {
+				t.Errorf("remotely signed certificate is valid for %s, want %s", got, tc.wantValidity)
+			}
+			if got := cert.Cert.Subject.CommonName; got != tc.wantCN {
+				t.Errorf("remotely signed certificate has common name %q, want %q", got, tc.wantCN)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// serverKeyType is the key type of the certificates ServerCertificate mints
+const serverKeyType = "ecdsa-p256"
+
+// ServerCertificate mints a TLS server certificate for the names, DNS names or
+// IP addresses, issued by the intermediate issuer, so that clients trusting
+// the root can verify the ACME API. It has the validity of the intermediate and
+// isn't stored with the certificates issued for orders. The certificate chain
+// ends below the root.
+func (ca *CAImpl) ServerCertificate(names []string) (tls.Certificate, error) {
+	if len(names) == 0 {
+		return tls.Certificate{}, errors.New("must specify at least one server name")
+	}
+	if ca.intermediate == nil || ca.intermediate.key == nil {
+		return tls.Certificate{}, errors.New("the intermediate issuer's key isn't held by this CA")
+	}
+	var domains []string
+	var ips []net.IP
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			domains = append(domains, name)
+		}
+	}
+
+	key, err := makeKey(serverKeyType)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	issuerCert := ca.intermediate.cert
+	template := &x509.Certificate{
+		DNSNames:     domains,
+		IPAddresses:  ips,
+		Subject:      pkix.Name{CommonName: names[0]},
+		SerialNumber: makeSerial(),
+		NotBefore:    issuerCert.Cert.NotBefore,
+		NotAfter:     issuerCert.Cert.NotAfter,
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert.Cert, key.Public(), ca.intermediate.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("signing server certificate: %s", err.Error())
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+	// Add the intermediates, the root is left out like in the chains of
+	// issued certificates
+	for issuer := issuerCert; issuer != nil && issuer.Issuer != nil; issuer = issuer.Issuer {
+		cert.Certificate = append(cert.Certificate, issuer.DER)
+	}
+	return cert, nil
+}
@@ -0,0 +1,115 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// State holds the root and intermediate issuers of a CA so that it can be
+// recreated with the same keys and certificates. The certificates themselves
+// are stored in the db and are referred to by ID.
+type State struct {
+	Root         IssuerState `json:"root"`
+	Intermediate IssuerState `json:"intermediate"`
+}
+
+// IssuerState is the PKCS #8 DER encoded private key and certificate ID of an
+// issuer.
+type IssuerState struct {
+	Key           []byte `json:"key"`
+	CertificateID string `json:"certificateID"`
+}
+
+func (i *issuer) state() (IssuerState, error) {
+	key, err := x509.MarshalPKCS8PrivateKey(i.key)
+	if err != nil {
+		return IssuerState{}, err
+	}
+	return IssuerState{
+		Key:           key,
+		CertificateID: i.cert.ID,
+	}, nil
+}
+
+// State returns the State of the CA's issuers.
+func (ca *CAImpl) State() (*State, error) {
+	if ca.signer != nil {
+		return nil, errors.New("a CA with a remote signer has no issuer keys")
+	}
+	root, err := ca.root.state()
+	if err != nil {
+		return nil, fmt.Errorf("root issuer: %s", err.Error())
+	}
+	intermediate, err := ca.intermediate.state()
+	if err != nil {
+		return nil, fmt.Errorf("intermediate issuer: %s", err.Error())
+	}
+	return &State{
+		Root:         root,
+		Intermediate: intermediate,
+	}, nil
+}
+
+func (ca *CAImpl) loadIssuer(state IssuerState) (*issuer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(state.Key)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T is not a crypto.Signer", key)
+	}
+	cert := ca.db.GetCertificateByID(state.CertificateID)
+	if cert == nil {
+		return nil, fmt.Errorf("certificate %q is not in the db", state.CertificateID)
+	}
+	return &issuer{
+		key:  signer,
+		cert: cert,
+	}, nil
+}
+
+// NewFromState creates a CA using the issuers in state instead of generating
+// new ones. The issuer certificates must already be in the db. The
+// intermediate issuers of issuance policies aren't part of the state and are
+// always generated. The Intermediate and Hierarchy of config, which only apply
+// to new issuers, are ignored.
+func NewFromState(
+	log *logging.Logger,
+	db *db.MemoryStore,
+	clk clock.Clock,
+	state *State,
+	config Config) (*CAImpl, error) {
+	ca := &CAImpl{
+		log:         log,
+		clk:         clk,
+		db:          db,
+		hooks:       config.Hooks,
+		latency:     config.Latency,
+		randomDelay: config.RandomDelay,
+		ctLogs:      config.CTLogs,
+	}
+	var err error
+	ca.root, err = ca.loadIssuer(state.Root)
+	if err != nil {
+		return nil, fmt.Errorf("loading root issuer: %s", err.Error())
+	}
+	ca.intermediate, err = ca.loadIssuer(state.Intermediate)
+	if err != nil {
+		return nil, fmt.Errorf("loading intermediate issuer: %s", err.Error())
+	}
+	ca.log.Printf("Loaded root issuer with serial %s\n", ca.root.cert.ID)
+	ca.log.Printf("Loaded intermediate issuer with serial %s\n", ca.intermediate.cert.ID)
+	if err := ca.newPolicyIssuers(config.Policies); err != nil {
+		return nil, fmt.Errorf("creating issuance policy issuers: %s", err.Error())
+	}
+	ca.setProfiles(config.Profiles)
+	ca.startLoadTest(config.LoadTest)
+	return ca, nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ConnectionConfig is the JSON configuration of the connection handling of an
+// HTTP listener, to test client connection pooling against constrained
+// servers. Zero values use the Go defaults, which don't limit connections.
+type ConnectionConfig struct {
+	// MaxConnections is the maximum number of concurrent connections. Further
+	// connections wait in the listen backlog until an open one is closed.
+	MaxConnections int
+	// MaxRequestsPerConnection closes HTTP/1.1 connections after they have
+	// served this many requests, by sending "Connection: close" with the last
+	// response.
+	MaxRequestsPerConnection int
+	// KeepAliveTimeout is how long an idle keep-alive connection is kept open
+	// waiting for the next request.
+	KeepAliveTimeout core.Duration
+	// DisableKeepAlives closes every connection after one request.
+	DisableKeepAlives bool
+}
+
+// Validate returns an error if a limit is negative.
+func (c ConnectionConfig) Validate() error {
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("maxConnections must not be negative, got %d", c.MaxConnections)
+	}
+	if c.MaxRequestsPerConnection < 0 {
+		return fmt.Errorf("maxRequestsPerConnection must not be negative, got %d",
+			c.MaxRequestsPerConnection)
+	}
+	if c.KeepAliveTimeout.Duration < 0 {
+		return fmt.Errorf("keepAliveTimeout must not be negative, got %s", c.KeepAliveTimeout.Duration)
+	}
+	return nil
+}
+
+// Apply configures the keep-alives and per-connection request limit of srv.
+// It must be called after srv.Handler is set.
+func (c ConnectionConfig) Apply(srv *http.Server) {
+	if c.KeepAliveTimeout.Duration > 0 {
+		srv.IdleTimeout = c.KeepAliveTimeout.Duration
+	}
+	if c.DisableKeepAlives {
+		srv.SetKeepAlivesEnabled(false)
+	}
+	if c.MaxRequestsPerConnection > 0 {
+		srv.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+			return context.WithValue(ctx, connRequestsKey{}, new(int64))
+		}
+		srv.Handler = limitRequestsPerConnection(c.MaxRequestsPerConnection, srv.Handler)
+	}
+}
+
+// Listen returns a TCP listener on addr that accepts at most MaxConnections
+// concurrent connections.
+func (c ConnectionConfig) Listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxConnections > 0 {
+		l = &limitListener{Listener: l, slots: make(chan struct{}, c.MaxConnections)}
+	}
+	return l, nil
+}
+
+// connRequestsKey is the context key of the number of requests served by
+// a connection
+type connRequestsKey struct{}
+
+func limitRequestsPerConnection(max int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if count, ok := request.Context().Value(connRequestsKey{}).(*int64); ok {
+			if atomic.AddInt64(count, 1) >= int64(max) {
+				response.Header().Set("Connection", "close")
+			}
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// limitListener is a net.Listener whose Accept blocks while all of its slots
+// are taken by open connections.
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.slots <- struct{}{}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// limitConn frees its slot of a limitListener once it is closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/cmd"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// config is the configuration of a standalone CA. Pebble has certificates
+// signed by it when its remoteSigner config field has the CA's URL.
+type config struct {
+	CA struct {
+		ListenAddress string
+		// Intermediate overrides the extensions of the intermediate certificate
+		Intermediate ca.IntermediateOptions
+	}
+}
+
+func main() {
+	configFile := flag.String(
+		"config",
+		"test/config/ca-config.json",
+		"File path to the Pebble CA configuration file")
+	logFormat := flag.String(
+		"log-format",
+		string(logging.FormatText),
+		"Log output format, \"text\" or \"json\"")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	format, err := logging.ParseFormat(*logFormat)
+	cmd.FailOnError(err, "Parsing -log-format")
+
+	// Log to stdout
+	logger := logging.New(os.Stdout, format)
+
+	var c config
+	err = cmd.ReadConfigFile(*configFile, &c)
+	cmd.FailOnError(err, "Reading JSON config file into config structure")
+	err = c.CA.Intermediate.Validate()
+	cmd.FailOnError(err, "Invalid intermediate")
+
+	// Issuance hooks, latency and delays are applied by the Pebble process
+	// using the signer
+	ca := ca.New(logger, db.NewMemoryStore(), clock.Default(), ca.Config{Intermediate: c.CA.Intermediate})
+
+	logger.Printf("Pebble CA running, listening on: %s\n", c.CA.ListenAddress)
+	err = http.ListenAndServe(c.CA.ListenAddress, ca.SignerHandler())
+	cmd.FailOnError(err, "Calling ListenAndServe()")
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -41,7 +43,9 @@ type client struct {
 	nonce     string
 }
 
-func newClient(server, email string) (*client, error) {
+// newClient creates a client for the directory at server. A nil roots uses
+// the system roots for HTTPS directories.
+func newClient(server, email string, roots *x509.CertPool) (*client, error) {
 	url, err := url.Parse(server)
 	if err != nil {
 		return nil, err
@@ -55,7 +59,11 @@ func newClient(server, email string) (*client, error) {
 	c := &client{
 		server: url,
 		email:  email,
-		http:   &http.Client{},
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		},
 		privKey: jose.SigningKey{
 			Key:       privKey,
 			Algorithm: jose.RS256,
@@ -313,13 +321,24 @@ func (c *client) repl() error {
 }
 
 func main() {
-	server := flag.String("server", "http://localhost:14000/dir", "Directory address for Pebble server")
+	server := flag.String("server", "https://localhost:14000/dir", "Directory address for Pebble server")
 	email := flag.String("email", "", "Email address for ACME registration contact")
+	rootFile := flag.String("root", "", "PEM file of the root CA certificate that Pebble's HTTPS certificate is verified with")
 	flag.Parse()
 
 	fmt.Println("welcome to the pebble shell")
 
-	c, err := newClient(*server, *email)
+	var roots *x509.CertPool
+	if *rootFile != "" {
+		rootPEM, err := ioutil.ReadFile(*rootFile)
+		cmd.FailOnError(err, "Reading -root")
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(rootPEM) {
+			cmd.FailOnError(fmt.Errorf("no certificates in %q", *rootFile), "Reading -root")
+		}
+	}
+
+	c, err := newClient(*server, *email, roots)
 	cmd.FailOnError(err,
 		fmt.Sprintf("Failed to make new pebble client with email %q", *email))
 
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/cmd"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/va"
+)
+
+// config is the configuration of a standalone VA. Pebble uses it for
+// validations when its remoteVA config field is set to the VA's address.
+type config struct {
+	VA struct {
+		ListenAddress   string
+		HTTPPort        int
+		TLSPort         int
+		ValidationDelay core.DelayRange
+		// DNS configures the DNS queries of DNS-01 validations
+		DNS va.DNSConfig
+		// SourceAddress is the IP address validation connections and DNS
+		// queries are made from, the system picks it when it is empty
+		SourceAddress string
+		// AddressFamily selects the addresses HTTP-01 and TLS-ALPN-01
+		// validations connect to, "preferIPv6" when it is empty
+		AddressFamily va.AddressFamily
+	}
+}
+
+func main() {
+	configFile := flag.String(
+		"config",
+		"test/config/va-config.json",
+		"File path to the Pebble VA configuration file")
+	logFormat := flag.String(
+		"log-format",
+		string(logging.FormatText),
+		"Log output format, \"text\" or \"json\"")
+	flag.Parse()
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	format, err := logging.ParseFormat(*logFormat)
+	cmd.FailOnError(err, "Parsing -log-format")
+
+	// Log to stdout
+	logger := logging.New(os.Stdout, format)
+
+	var c config
+	err = cmd.ReadConfigFile(*configFile, &c)
+	cmd.FailOnError(err, "Reading JSON config file into config structure")
+	err = c.VA.ValidationDelay.Validate()
+	cmd.FailOnError(err, "Invalid validationDelay")
+	err = c.VA.DNS.Validate()
+	cmd.FailOnError(err, "Invalid dns")
+	sourceAddr, err := va.ParseSourceAddress(c.VA.SourceAddress)
+	cmd.FailOnError(err, "Invalid sourceAddress")
+	err = c.VA.AddressFamily.Validate()
+	cmd.FailOnError(err, "Invalid addressFamily")
+
+	clk := clock.Default()
+	va := va.New(logger, clk, va.Config{
+		HTTPPort:      c.VA.HTTPPort,
+		TLSPort:       c.VA.TLSPort,
+		SleepRange:    c.VA.ValidationDelay,
+		DNS:           c.VA.DNS,
+		SourceAddr:    sourceAddr,
+		AddressFamily: c.VA.AddressFamily,
+	})
+
+	logger.Printf("Pebble VA running, listening on: %s\n", c.VA.ListenAddress)
+	err = http.ListenAndServe(c.VA.ListenAddress, va.Handler())
+	cmd.FailOnError(err, "Calling ListenAndServe()")
+}
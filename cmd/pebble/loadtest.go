@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/letsencrypt/pebble/ca"
+)
+
+// pprofPath is the prefix of the pprof endpoints served on the management API
+// in load-test mode
+const pprofPath = "/debug/pprof/"
+
+// defaultSerialPoolSize is the serialPoolSize of load-test mode when the
+// config doesn't set one
+const defaultSerialPoolSize = 1024
+
+// loadTestDefaults returns opts with the defaults of load-test mode for the
+// options that aren't set: a pool of pre-generated serials and an issuance
+// worker per CPU.
+func loadTestDefaults(opts ca.LoadTestOptions) ca.LoadTestOptions {
+	if opts.SerialPoolSize == 0 {
+		opts.SerialPoolSize = defaultSerialPoolSize
+	}
+	if opts.IssuanceWorkers == 0 {
+		opts.IssuanceWorkers = runtime.NumCPU()
+	}
+	return opts
+}
+
+// pprofHandler serves the net/http/pprof endpoints under pprofPath and every
+// other path with next.
+func pprofHandler(next http.Handler) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(pprofPath, func(response http.ResponseWriter, request *http.Request) {
+		// The index serves the named profiles, e.g. heap and goroutine, too
+		switch strings.TrimPrefix(request.URL.Path, pprofPath) {
+		case "cmdline":
+			pprof.Cmdline(response, request)
+		case "profile":
+			pprof.Profile(response, request)
+		case "symbol":
+			pprof.Symbol(response, request)
+		case "trace":
+			pprof.Trace(response, request)
+		default:
+			pprof.Index(response, request)
+		}
+	})
+	m.Handle("/", next)
+	return m
+}
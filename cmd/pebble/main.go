@@ -1,24 +1,173 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/pebble/ca"
 	"github.com/letsencrypt/pebble/cmd"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/ct"
 	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/dns"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/ratelimit"
 	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/webhook"
 	"github.com/letsencrypt/pebble/wfe"
 )
 
 type config struct {
 	Pebble struct {
-		ListenAddress string
-		HTTPPort      int
-		TLSPort       int
+		ListenAddress           string
+		ManagementListenAddress string
+		HTTPPort                int
+		TLSPort                 int
+		IssuanceHooks           []ca.IssuanceHook
+		// IssuanceLatency delays issuance for orders with matching identifiers
+		IssuanceLatency []ca.LatencyRule
+		// ValidationDelay is the range of the random sleep before each validation
+		// attempt and IssuanceDelay the range of the random delay before issuance
+		ValidationDelay core.DelayRange
+		IssuanceDelay   core.DelayRange
+		// RemoteVA is the base URL of a pebble-va process that performs
+		// validations. They are performed in-process when it is empty.
+		RemoteVA string
+		// CAA enables CAA checking before authorizations become valid
+		CAA va.CAAConfig
+		// DNS configures the DNS queries of the VA
+		DNS va.DNSConfig
+		// VASourceAddress is the IP address the VA's validation connections and
+		// DNS queries are made from, the system picks it when it is empty
+		VASourceAddress string
+		// VAAddressFamily selects the addresses HTTP-01 and TLS-ALPN-01
+		// validations connect to, "preferIPv6" when it is empty
+		VAAddressFamily va.AddressFamily
+		// Intermediate overrides the extensions of the intermediate certificate
+		Intermediate ca.IntermediateOptions
+		// Hierarchy sets the number of intermediates, the cross-signing and
+		// the key types of the issuers
+		Hierarchy ca.HierarchyOptions
+		// IssuancePolicies change the validity and issuer of certificates for
+		// matching identifiers. The first matching policy is used.
+		IssuancePolicies []ca.IssuancePolicy
+		// Profiles are the certificate profiles advertised in the directory
+		// meta that newOrder requests can select
+		Profiles []ca.Profile
+		// CTLogs are the URLs of the CT logs precertificates are submitted to.
+		// The returned SCTs are embedded in the certificates.
+		CTLogs []string
+		// MockCTLogListenAddress is where the built-in mock CT log is served, it
+		// isn't started when empty
+		MockCTLogListenAddress string
+		// ReadOnlyManagementListenAddress is where the read-only management
+		// API is served, it isn't started when empty
+		ReadOnlyManagementListenAddress string
+		// MockDNS configures the built-in mock DNS server. When it is started
+		// the VA uses it as its resolver, unless dns.resolver is set.
+		MockDNS dns.MockServerConfig
+		// ClockOffset is added to the system clock's time for Pebble's clock
+		ClockOffset core.Duration
+		// LoadTest sets the serial pool and issuance workers of the CA. The
+		// ones that aren't set get defaults with -strictmode=false.
+		LoadTest ca.LoadTestOptions
+		// Webhooks are the URLs that JSON events of finalizations, issuances,
+		// revocations and failed validations are POSTed to
+		Webhooks []webhook.Hook
+		// RemoteSigner is the pebble-ca process certificates are signed by.
+		// Pebble signs them itself when its URL is empty.
+		RemoteSigner ca.RemoteSigner
+		// ExternalAccountBindingRequired makes an external account binding
+		// mandatory for new accounts. ExternalAccountMACKeys maps EAB key IDs to
+		// base64url encoded HMAC keys.
+		ExternalAccountBindingRequired bool
+		ExternalAccountMACKeys         map[string]string
+		CSRPolicy                      wfe.CSRPolicy
+		// KeyPolicy rejects weak and blocked account and certificate keys
+		KeyPolicy wfe.KeyPolicy
+		// IdentifierPolicy rejects blocked DNS identifiers of new orders
+		IdentifierPolicy wfe.IdentifierPolicy
+		// JWSAlgorithms are the JWS signature algorithms accepted in POSTs
+		JWSAlgorithms []string
+		// Chaos are the rules used to inject faults into ACME requests
+		Chaos []wfe.ChaosRule
+		// NewAccountsPerIP limits how many accounts each client IP can create
+		NewAccountsPerIP ratelimit.Limit
+		// NewOrdersPerAccount limits how many orders each account can create
+		// and DuplicateCertificates how many certificates can be issued for the
+		// same set of identifiers
+		NewOrdersPerAccount   ratelimit.Limit
+		DuplicateCertificates ratelimit.Limit
+		// DirectoryMeta populates the meta object of the directory
+		DirectoryMeta wfe.DirectoryMeta
+		// PollRetryAfter is the Retry-After sent to clients polling processing
+		// orders and pending authorizations and challenges
+		PollRetryAfter core.Duration
+		// AuthzExpiryWarning is how long before they expire valid
+		// authorizations are warned about
+		AuthzExpiryWarning core.Duration
+		// Backpressure rejects new validations and finalizations with a 503
+		// while too many are in progress
+		Backpressure wfe.Backpressure
+		// CertificateChain controls the chain returned with certificates
+		CertificateChain wfe.CertificateChain
+		// AuthzReuse controls the reuse of valid authorizations by new orders
+		AuthzReuse wfe.AuthzReuse
+		// HTTPQuirks change the HTTP framing and formatting of directory and
+		// certificate responses
+		HTTPQuirks wfe.HTTPQuirks
+		// Lifetimes are the lifetimes of orders, authorizations and nonces
+		// and the schedule of the sweeper that expires and evicts them
+		Lifetimes wfe.Lifetimes
+		// ValidityWindow limits the notBefore and notAfter that newOrder
+		// requests can ask for
+		ValidityWindow wfe.ValidityWindow
+		// AllowLegacyGET accepts plain GETs of orders, authorizations,
+		// challenges and certificates, to test clients that predate
+		// POST-as-GET
+		AllowLegacyGET bool
+		// DrainTimeout is how long a graceful shutdown on SIGINT or SIGTERM
+		// waits for in-flight requests, validations and issuances
+		DrainTimeout core.Duration
+		// Tenants are the names of isolated virtual CAs served under
+		// "/<name>/" next to the default CA
+		Tenants []string
+		// CancelValidationOnDisconnect cancels a validation when the client
+		// disconnects before receiving the response to its challenge POST
+		CancelValidationOnDisconnect bool
+		// PreAuthorization enables the newAuthz endpoint
+		PreAuthorization bool
+		// OrdersPerPage is the page size of account orders lists
+		OrdersPerPage int
+		// OrderAnnotationKey is the newOrder extension field stored and echoed
+		// on orders
+		OrderAnnotationKey string
+		// The ACME API is served over HTTPS, with the TLS versions and cipher
+		// suites allowed by TLS. Certificate and PrivateKey are PEM file
+		// paths, when they are empty a server certificate for ServerNames is
+		// minted from the intermediate issuer. PlainHTTP serves the ACME API
+		// over HTTP instead.
+		Certificate string
+		PrivateKey  string
+		ServerNames []string
+		PlainHTTP   bool
+		TLS         cmd.TLSConfig
+		// RootListenAddress is where the root CA certificate is served over
+		// plain HTTP, to bootstrap the trust in the minted server certificate.
+		// It is only served when configured.
+		RootListenAddress string
+		// Connections limits the connections and keep-alives of the ACME API
+		// listener
+		Connections cmd.ConnectionConfig
 	}
 }
 
@@ -27,33 +176,486 @@ func main() {
 		"config",
 		"test/config/pebble-config.json",
 		"File path to the Pebble configuration file")
+	dbFile := flag.String(
+		"dbfile",
+		"",
+		"Optional file path the database and the CA's issuer keys are persisted to between runs")
+	dumpStateFile := flag.String(
+		"dumpstate",
+		"",
+		"Optional file path the full Pebble state is written to on shutdown")
+	loadStateFile := flag.String(
+		"loadstate",
+		"",
+		"Optional file path of a -dumpstate file to load the full Pebble state from")
+	logFormat := flag.String(
+		"log-format",
+		string(logging.FormatText),
+		"Log output format, \"text\" or \"json\"")
+	manifestFile := flag.String(
+		"manifest",
+		"",
+		"Optional file path a JSON manifest of the bound endpoints is written to on startup")
+	rootBundleDir := flag.String(
+		"root-bundle-dir",
+		"",
+		"Optional directory the root CA certificate is written to in PEM, DER and Java keystore format")
+	recordDir := flag.String(
+		"record",
+		"",
+		"Optional directory every ACME request and its response is recorded to, with signatures and HMACs redacted")
+	strictMode := flag.Bool(
+		"strictmode",
+		true,
+		"Set to false for load-test mode: no validation sleeps, pre-generated serials, issuance workers and pprof on the management API")
 	flag.Parse()
 	if *configFile == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if *dbFile != "" && *loadStateFile != "" {
+		cmd.FailOnError(errors.New("-dbfile and -loadstate can't be used together"),
+			"Loading state")
+	}
+
+	format, err := logging.ParseFormat(*logFormat)
+	cmd.FailOnError(err, "Parsing -log-format")
 
 	// Log to stdout
-	logger := log.New(os.Stdout, "Pebble ", log.LstdFlags)
+	logger := logging.New(os.Stdout, format)
 
 	var c config
-	err := cmd.ReadConfigFile(*configFile, &c)
+	err = cmd.ReadConfigFile(*configFile, &c)
 	cmd.FailOnError(err, "Reading JSON config file into config structure")
 
-	clk := clock.Default()
+	// Pebble's clock can be skewed from the system clock, at startup with the
+	// clockOffset and later with the management API
+	clk := core.NewSkewedClock(clock.Default(), c.Pebble.ClockOffset.Duration)
+	if c.Pebble.ClockOffset.Duration != 0 {
+		logger.Printf("Clock offset from the system clock by %s\n", c.Pebble.ClockOffset.Duration)
+	}
+	// A -dbfile is a state file, it is loaded like a -loadstate file unless it
+	// doesn't exist yet
+	stateFileName := *loadStateFile
+	if *dbFile != "" {
+		if _, err := os.Stat(*dbFile); err == nil {
+			stateFileName = *dbFile
+		} else if !os.IsNotExist(err) {
+			cmd.FailOnError(err, fmt.Sprintf("Loading database from %q", *dbFile))
+		}
+	}
+	var state *stateFile
+	if stateFileName != "" {
+		state, err = loadState(stateFileName)
+		cmd.FailOnError(err, fmt.Sprintf("Loading state from %q", stateFileName))
+	}
+	// onShutdown is run when Pebble is interrupted or terminated, after
+	// draining
+	var onShutdown []func() error
 	db := db.NewMemoryStore()
-	ca := ca.New(logger, db)
-	va := va.New(logger, clk, c.Pebble.HTTPPort, c.Pebble.TLSPort, ca)
+	if state != nil {
+		err = db.Restore(state.DB)
+		cmd.FailOnError(err, fmt.Sprintf("Restoring database from %q", stateFileName))
+		logger.Printf("Loaded state from %q\n", stateFileName)
+	}
+	for keyID, b64Key := range c.Pebble.ExternalAccountMACKeys {
+		key, err := base64.RawURLEncoding.DecodeString(b64Key)
+		cmd.FailOnError(err, fmt.Sprintf("Decoding external account MAC key %q", keyID))
+		err = db.AddExternalAccountKeyByID(keyID, key)
+		cmd.FailOnError(err, "Adding external account MAC key")
+	}
+	if c.Pebble.ExternalAccountBindingRequired && len(c.Pebble.ExternalAccountMACKeys) == 0 {
+		cmd.FailOnError(errors.New("no externalAccountMACKeys configured"),
+			"Enabling externalAccountBindingRequired")
+	}
+	err = c.Pebble.ValidationDelay.Validate()
+	cmd.FailOnError(err, "Invalid validationDelay")
+	err = c.Pebble.IssuanceDelay.Validate()
+	cmd.FailOnError(err, "Invalid issuanceDelay")
+	err = c.Pebble.Intermediate.Validate()
+	cmd.FailOnError(err, "Invalid intermediate")
+	err = c.Pebble.Hierarchy.Validate()
+	cmd.FailOnError(err, "Invalid hierarchy")
+	for _, policy := range c.Pebble.IssuancePolicies {
+		err = policy.Validate()
+		cmd.FailOnError(err, "Invalid issuance policy")
+	}
+	err = ca.CheckProfiles(c.Pebble.Profiles)
+	cmd.FailOnError(err, "Invalid profiles")
+	err = c.Pebble.RemoteSigner.Validate()
+	cmd.FailOnError(err, "Invalid remoteSigner")
+	if c.Pebble.RemoteSigner.URL != "" && *dumpStateFile != "" {
+		cmd.FailOnError(errors.New("-dumpstate can't be used with a remoteSigner"),
+			"Dumping state")
+	}
+	err = c.Pebble.LoadTest.Validate()
+	cmd.FailOnError(err, "Invalid loadTest")
+	if !*strictMode {
+		c.Pebble.LoadTest = loadTestDefaults(c.Pebble.LoadTest)
+		logger.Printf("Running in load-test mode\n")
+	}
+	ca, err := newCA(logger, db, clk, c.Pebble.RemoteSigner, state, newCAConfig(&c))
+	cmd.FailOnError(err, "Creating CA")
+	if *dumpStateFile != "" {
+		onShutdown = append(onShutdown, func() error {
+			return dumpState(*dumpStateFile, db, ca)
+		})
+	}
+	if *dbFile != "" {
+		// The issuer keys are written right away, before anything is issued
+		// with them. A remote signer keeps its keys to itself.
+		keysCA := ca
+		if c.Pebble.RemoteSigner.URL != "" {
+			keysCA = nil
+		}
+		p := newPersister(logger, *dbFile, db, keysCA)
+		err = p.save()
+		cmd.FailOnError(err, fmt.Sprintf("Saving database to %q", *dbFile))
+		db.OnChange(p.notify)
+		go p.run()
+		onShutdown = append(onShutdown, p.close)
+	}
+	err = c.Pebble.DNS.Validate()
+	cmd.FailOnError(err, "Invalid dns")
+	err = c.Pebble.Lifetimes.Validate()
+	cmd.FailOnError(err, "Invalid lifetimes")
+	err = c.Pebble.ValidityWindow.Validate()
+	cmd.FailOnError(err, "Invalid validityWindow")
+	vaSourceAddr, err := va.ParseSourceAddress(c.Pebble.VASourceAddress)
+	cmd.FailOnError(err, "Invalid vaSourceAddress")
+	err = c.Pebble.VAAddressFamily.Validate()
+	cmd.FailOnError(err, "Invalid vaAddressFamily")
+	// The mock DNS server is optional and started before the VA, which
+	// resolves every name with it
+	var endpoints manifest
+	if c.Pebble.MockDNS.ListenAddress != "" {
+		err = c.Pebble.MockDNS.Validate()
+		cmd.FailOnError(err, "Invalid mockDNS")
+		resolver := startMockDNS(logger, c.Pebble.MockDNS, &endpoints)
+		if c.Pebble.DNS.Resolver == "" {
+			c.Pebble.DNS.Resolver = resolver
+			c.Pebble.DNS.ResolveHosts = true
+		}
+	}
+	for _, hook := range c.Pebble.Webhooks {
+		err = hook.Validate()
+		cmd.FailOnError(err, "Invalid webhook")
+	}
+	notifier := webhook.New(logger, clk, c.Pebble.Webhooks)
+	va := va.New(logger, clk, va.Config{
+		HTTPPort:           c.Pebble.HTTPPort,
+		TLSPort:            c.Pebble.TLSPort,
+		SleepRange:         c.Pebble.ValidationDelay,
+		NoSleep:            !*strictMode,
+		Remote:             c.Pebble.RemoteVA,
+		CAA:                c.Pebble.CAA,
+		DNS:                c.Pebble.DNS,
+		ValidAuthzLifetime: c.Pebble.Lifetimes.ValidAuthorization.Duration,
+		SourceAddr:         vaSourceAddr,
+		AddressFamily:      c.Pebble.VAAddressFamily,
+		Notifier:           notifier,
+	})
 
-	wfe := wfe.New(logger, clk, db, va)
-	muxHandler := wfe.Handler()
+	err = c.Pebble.CSRPolicy.Validate()
+	cmd.FailOnError(err, "Invalid csrPolicy")
+	err = c.Pebble.KeyPolicy.Load()
+	cmd.FailOnError(err, "Invalid keyPolicy")
+	err = c.Pebble.IdentifierPolicy.Load()
+	cmd.FailOnError(err, "Invalid identifierPolicy")
+	err = wfe.CheckJWSAlgorithms(c.Pebble.JWSAlgorithms)
+	cmd.FailOnError(err, "Invalid jwsAlgorithms")
+	for _, rule := range c.Pebble.Chaos {
+		err = rule.Validate()
+		cmd.FailOnError(err, "Invalid chaos rule")
+	}
+	err = c.Pebble.NewAccountsPerIP.Validate()
+	cmd.FailOnError(err, "Invalid newAccountsPerIP")
+	err = c.Pebble.NewOrdersPerAccount.Validate()
+	cmd.FailOnError(err, "Invalid newOrdersPerAccount")
+	err = c.Pebble.DuplicateCertificates.Validate()
+	cmd.FailOnError(err, "Invalid duplicateCertificates")
+	err = c.Pebble.DirectoryMeta.Validate()
+	cmd.FailOnError(err, "Invalid directoryMeta")
+	err = wfe.CheckOrderAnnotationKey(c.Pebble.OrderAnnotationKey)
+	cmd.FailOnError(err, "Invalid orderAnnotationKey")
+	if c.Pebble.PollRetryAfter.Duration < 0 {
+		cmd.FailOnError(fmt.Errorf("must not be negative, got %s", c.Pebble.PollRetryAfter.Duration),
+			"Invalid pollRetryAfter")
+	}
+	if c.Pebble.AuthzExpiryWarning.Duration < 0 {
+		cmd.FailOnError(fmt.Errorf("must not be negative, got %s", c.Pebble.AuthzExpiryWarning.Duration),
+			"Invalid authzExpiryWarning")
+	}
+	err = c.Pebble.Backpressure.Validate()
+	cmd.FailOnError(err, "Invalid backpressure")
+	err = c.Pebble.CertificateChain.Validate()
+	cmd.FailOnError(err, "Invalid certificateChain")
+	err = c.Pebble.AuthzReuse.Validate()
+	cmd.FailOnError(err, "Invalid authzReuse")
+	err = c.Pebble.HTTPQuirks.Validate()
+	cmd.FailOnError(err, "Invalid httpQuirks")
+	if c.Pebble.DrainTimeout.Duration < 0 {
+		cmd.FailOnError(fmt.Errorf("must not be negative, got %s", c.Pebble.DrainTimeout.Duration),
+			"Invalid drainTimeout")
+	}
+	drainTimeout := c.Pebble.DrainTimeout.Duration
+	if drainTimeout == 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	// The events of the drained validations and issuances are still delivered
+	onShutdown = append(onShutdown, func() error {
+		if err := notifier.Flush(drainTimeout); err != nil {
+			logger.Printf("Error: %s\n", err.Error())
+		}
+		return nil
+	})
+	tenants, err := newTenants(logger, clk, &c, va, notifier)
+	cmd.FailOnError(err, "Creating tenants")
+	if *rootBundleDir != "" {
+		err = writeRootBundle(*rootBundleDir, ca.RootCert())
+		cmd.FailOnError(err, fmt.Sprintf("Writing root bundle to %q", *rootBundleDir))
+		// Each tenant's root is written to a subdirectory named after it
+		for _, t := range tenants {
+			err = writeRootBundle(filepath.Join(*rootBundleDir, t.name), t.ca.RootCert())
+			cmd.FailOnError(err, fmt.Sprintf("Writing root bundle of tenant %q", t.name))
+		}
+		logger.Printf("Wrote root bundle to %q\n", *rootBundleDir)
+	}
+	var recorder *wfe.Recorder
+	if *recordDir != "" {
+		recorder, err = wfe.NewRecorder(logger, clk, *recordDir)
+		cmd.FailOnError(err, fmt.Sprintf("Recording to %q", *recordDir))
+		logger.Printf("Recording ACME requests to %q\n", *recordDir)
+	}
+	wfe := newWFE(logger, clk, &c, db, va, ca, "", notifier)
+	muxHandler := tenantHandler(wfe.Handler(), tenants, acmeHandler)
+	if recorder != nil {
+		muxHandler = recorder.Handler(muxHandler)
+	}
+	// The default CA is reloaded and drained like the tenants
+	allTenants := append([]*tenant{{ca: ca, wfe: &wfe}}, tenants...)
+	// The background goroutines are stopped before the final state is saved
+	onShutdown = append([]func() error{func() error {
+		for _, t := range allTenants {
+			t.wfe.Close()
+			t.ca.Close()
+		}
+		return nil
+	}}, onShutdown...)
 
 	srv := &http.Server{
 		Addr:    c.Pebble.ListenAddress,
 		Handler: muxHandler,
 	}
+	err = c.Pebble.Connections.Validate()
+	cmd.FailOnError(err, "Invalid connections")
+	c.Pebble.Connections.Apply(srv)
+	listener, err := c.Pebble.Connections.Listen(c.Pebble.ListenAddress)
+	cmd.FailOnError(err, "Listening on listenAddress")
+	useTLS := !c.Pebble.PlainHTTP
+	if useTLS {
+		srv.TLSConfig, err = c.Pebble.TLS.Build()
+		cmd.FailOnError(err, "Invalid tls config")
+		if c.Pebble.TLS.RequestClientCert {
+			srv.TLSConfig.VerifyPeerCertificate = logClientCerts(logger)
+		}
+		serverCert, err := loadServerCertificate(logger, &c, ca)
+		cmd.FailOnError(err, "Enabling HTTPS")
+		srv.TLSConfig.Certificates = []tls.Certificate{serverCert}
+	} else if c.Pebble.Certificate != "" || c.Pebble.PrivateKey != "" {
+		cmd.FailOnError(errors.New("certificate and privateKey can't be used with plainHTTP"),
+			"Enabling HTTPS")
+	}
 
-	logger.Printf("Pebble running, listening on: %s\n", c.Pebble.ListenAddress)
-	err = srv.ListenAndServe()
-	cmd.FailOnError(err, "Calling ListenAndServe()")
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	var acmeURL string
+	acmeURL, endpoints.Ports.ACME = listenerURL(scheme, listener.Addr())
+	endpoints.DirectoryURL = acmeURL + "/dir"
+	for _, t := range tenants {
+		if endpoints.Tenants == nil {
+			endpoints.Tenants = make(map[string]string)
+		}
+		endpoints.Tenants[t.name] = acmeURL + "/" + t.name + "/dir"
+	}
+
+	// The mock CT log is optional and only served when configured
+	if c.Pebble.MockCTLogListenAddress != "" {
+		mockLog, err := ct.NewMockLog(logger, clk)
+		cmd.FailOnError(err, "Creating mock CT log")
+		ctSrv := &http.Server{
+			Addr:    c.Pebble.MockCTLogListenAddress,
+			Handler: mockLog.Handler(),
+		}
+		ctListener, err := net.Listen("tcp", c.Pebble.MockCTLogListenAddress)
+		cmd.FailOnError(err, "Listening on mockCTLogListenAddress")
+		endpoints.MockCTLogURL, endpoints.Ports.MockCTLog = listenerURL("http", ctListener.Addr())
+		go func() {
+			logger.Printf("Mock CT log %s listening on: %s\n", mockLog.LogID(), ctListener.Addr())
+			err := ctSrv.Serve(ctListener)
+			cmd.FailOnError(err, "Calling Serve() for mock CT log")
+		}()
+	}
+
+	// The servers that are drained on shutdown. The mock CT log keeps serving
+	// until Pebble exits, for the issuances that are still in progress.
+	servers := []*http.Server{srv}
+
+	// The management API is optional and only served when configured
+	if c.Pebble.ManagementListenAddress != "" {
+		mgmtHandler := tenantHandler(wfe.ManagementHandler(), tenants, managementHandler)
+		if !*strictMode {
+			mgmtHandler = pprofHandler(mgmtHandler)
+		}
+		mgmtSrv := &http.Server{
+			Addr:    c.Pebble.ManagementListenAddress,
+			Handler: mgmtHandler,
+		}
+		mgmtListener, err := net.Listen("tcp", c.Pebble.ManagementListenAddress)
+		cmd.FailOnError(err, "Listening on managementListenAddress")
+		endpoints.ManagementURL, endpoints.Ports.Management = listenerURL("http", mgmtListener.Addr())
+		servers = append(servers, mgmtSrv)
+		go func() {
+			logger.Printf("Management API listening on: %s\n", mgmtListener.Addr())
+			err := mgmtSrv.Serve(mgmtListener)
+			if err != http.ErrServerClosed {
+				cmd.FailOnError(err, "Calling Serve() for management API")
+			}
+		}()
+	}
+
+	// The read-only management API is optional too
+	if c.Pebble.ReadOnlyManagementListenAddress != "" {
+		readOnlySrv := &http.Server{
+			Addr:    c.Pebble.ReadOnlyManagementListenAddress,
+			Handler: tenantHandler(wfe.ReadOnlyManagementHandler(), tenants, readOnlyManagementHandler),
+		}
+		readOnlyListener, err := net.Listen("tcp", c.Pebble.ReadOnlyManagementListenAddress)
+		cmd.FailOnError(err, "Listening on readOnlyManagementListenAddress")
+		endpoints.ReadOnlyManagementURL, endpoints.Ports.ReadOnlyManagement =
+			listenerURL("http", readOnlyListener.Addr())
+		servers = append(servers, readOnlySrv)
+		go func() {
+			logger.Printf("Read-only management API listening on: %s\n", readOnlyListener.Addr())
+			err := readOnlySrv.Serve(readOnlyListener)
+			if err != http.ErrServerClosed {
+				cmd.FailOnError(err, "Calling Serve() for read-only management API")
+			}
+		}()
+	}
+
+	// The root CA endpoint is optional too
+	if c.Pebble.RootListenAddress != "" {
+		rootSrv := &http.Server{
+			Addr:    c.Pebble.RootListenAddress,
+			Handler: tenantHandler(wfe.RootCAHandler(), tenants, rootCAHandler),
+		}
+		rootListener, err := net.Listen("tcp", c.Pebble.RootListenAddress)
+		cmd.FailOnError(err, "Listening on rootListenAddress")
+		endpoints.RootURL, endpoints.Ports.Root = listenerURL("http", rootListener.Addr())
+		endpoints.RootURL += "/root"
+		servers = append(servers, rootSrv)
+		go func() {
+			logger.Printf("Root CA certificate served over HTTP on: %s\n", rootListener.Addr())
+			err := rootSrv.Serve(rootListener)
+			if err != http.ErrServerClosed {
+				cmd.FailOnError(err, "Calling Serve() for root CA endpoint")
+			}
+		}()
+	}
+
+	// Every listener is bound, so the manifest has the actual ports
+	if *manifestFile != "" {
+		err = writeManifest(*manifestFile, endpoints, ca.RootCert().PEM())
+		cmd.FailOnError(err, fmt.Sprintf("Writing manifest to %q", *manifestFile))
+		logger.Printf("Wrote endpoint manifest to %q, directory URL %s\n",
+			*manifestFile, endpoints.DirectoryURL)
+	}
+
+	go func() {
+		if useTLS {
+			logger.Printf("Pebble running, listening with HTTPS on: %s\n", listener.Addr())
+			err := srv.ServeTLS(listener, "", "")
+			if err != http.ErrServerClosed {
+				cmd.FailOnError(err, "Calling ServeTLS()")
+			}
+			return
+		}
+		logger.Printf("Pebble running, listening on: %s\n", listener.Addr())
+		err := srv.Serve(listener)
+		if err != http.ErrServerClosed {
+			cmd.FailOnError(err, "Calling Serve()")
+		}
+	}()
+
+	handleSignals(logger,
+		func() error {
+			return reloadConfig(logger, *configFile, allTenants)
+		},
+		servers,
+		func() bool {
+			if va.PendingValidations() > 0 {
+				return false
+			}
+			for _, t := range allTenants {
+				if t.wfe.PendingFinalizations() > 0 {
+					return false
+				}
+			}
+			return true
+		},
+		drainTimeout, onShutdown)
+}
+
+// logClientCerts returns a tls.Config VerifyPeerCertificate callback that logs
+// the certificates clients present in response to a CertificateRequest. It
+// never rejects a handshake.
+func logClientCerts(logger *logging.Logger) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			logger.Printf("TLS client presented no client certificate\n")
+			return nil
+		}
+		for i, der := range rawCerts {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				logger.Printf("TLS client certificate %d is unparseable: %s\n", i, err.Error())
+				continue
+			}
+			logger.Printf("TLS client certificate %d: subject %q, issuer %q, serial %s\n",
+				i, cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.String())
+		}
+		return nil
+	}
+}
+
+// defaultServerNames are the names of the minted server certificate of the
+// ACME API when serverNames isn't configured
+var defaultServerNames = []string{"localhost", "pebble", "127.0.0.1", "::1"}
+
+// loadServerCertificate returns the HTTPS certificate of the ACME API. It is
+// loaded from the certificate and privateKey files when they are set and
+// minted from the intermediate issuer of theCA otherwise.
+func loadServerCertificate(logger *logging.Logger, c *config, theCA *ca.CAImpl) (tls.Certificate, error) {
+	if c.Pebble.Certificate != "" || c.Pebble.PrivateKey != "" {
+		if c.Pebble.Certificate == "" || c.Pebble.PrivateKey == "" {
+			return tls.Certificate{}, errors.New("both certificate and privateKey must be set")
+		}
+		if len(c.Pebble.ServerNames) > 0 {
+			return tls.Certificate{}, errors.New("serverNames can't be used with certificate and privateKey")
+		}
+		return tls.LoadX509KeyPair(c.Pebble.Certificate, c.Pebble.PrivateKey)
+	}
+	names := c.Pebble.ServerNames
+	if len(names) == 0 {
+		names = defaultServerNames
+	}
+	cert, err := theCA.ServerCertificate(names)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("minting server certificate: %s", err.Error())
+	}
+	logger.Printf("Minted HTTPS certificate for %v from the intermediate issuer\n", names)
+	return cert, nil
 }
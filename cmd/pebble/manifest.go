@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// manifest is the JSON file written by the -manifest flag once Pebble is
+// listening. It lets test harnesses that use ephemeral ports (":0" listen
+// addresses) find the endpoints without parsing the logs.
+type manifest struct {
+	DirectoryURL          string `json:"directoryURL"`
+	ManagementURL         string `json:"managementURL,omitempty"`
+	ReadOnlyManagementURL string `json:"readOnlyManagementURL,omitempty"`
+	MockCTLogURL          string `json:"mockCTLogURL,omitempty"`
+	// RootURL serves the root CA certificate over plain HTTP
+	RootURL string `json:"rootURL,omitempty"`
+	// MockDNSAddress is the host:port of the mock DNS server, over UDP and TCP
+	MockDNSAddress       string `json:"mockDNSAddress,omitempty"`
+	MockDNSManagementURL string `json:"mockDNSManagementURL,omitempty"`
+	// Tenants maps the names of tenants to their directory URLs
+	Tenants map[string]string `json:"tenants,omitempty"`
+	// RootCertificateFile is the path of the PEM encoded root CA certificate,
+	// written next to the manifest
+	RootCertificateFile string `json:"rootCertificateFile"`
+	// Ports are the ports actually bound by each listener
+	Ports manifestPorts `json:"ports"`
+}
+
+type manifestPorts struct {
+	ACME               int `json:"acme"`
+	Management         int `json:"management,omitempty"`
+	ReadOnlyManagement int `json:"readOnlyManagement,omitempty"`
+	MockCTLog          int `json:"mockCTLog,omitempty"`
+	Root               int `json:"root,omitempty"`
+	MockDNS            int `json:"mockDNS,omitempty"`
+	MockDNSManagement  int `json:"mockDNSManagement,omitempty"`
+}
+
+// listenerURL returns the base URL of a listener. Unspecified listen hosts are
+// reported as localhost.
+func listenerURL(scheme string, addr net.Addr) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", 0
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	port, _ := strconv.Atoi(portStr)
+	return scheme + "://" + net.JoinHostPort(host, portStr), port
+}
+
+// writeManifest writes m to path and rootPEM to the root certificate file of
+// the manifest. Both are written to a temporary file first and renamed, so
+// a harness polling for the manifest never reads a partial file.
+func writeManifest(path string, m manifest, rootPEM []byte) error {
+	m.RootCertificateFile = strings.TrimSuffix(path, filepath.Ext(path)) + "-root.pem"
+	if err := writeFileAtomic(m.RootCertificateFile, rootPEM); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/cmd"
+	"github.com/letsencrypt/pebble/dns"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// startMockDNS starts the mock DNS server and its management API and records
+// their addresses in endpoints. It returns the address the VA sends queries to,
+// which is on the loopback interface when the server listens on every
+// interface.
+func startMockDNS(logger *logging.Logger, config dns.MockServerConfig, endpoints *manifest) string {
+	server := dns.NewMockServer(logger, config)
+	udp, tcp, err := dns.Listen(config.ListenAddress)
+	cmd.FailOnError(err, "Listening on mockDNS listenAddress")
+	go func() {
+		err := server.ServeUDP(udp)
+		cmd.FailOnError(err, "Serving mock DNS over UDP")
+	}()
+	go func() {
+		err := server.ServeTCP(tcp)
+		cmd.FailOnError(err, "Serving mock DNS over TCP")
+	}()
+	logger.Printf("Mock DNS server listening on: %s\n", udp.LocalAddr())
+
+	host, port, _ := net.SplitHostPort(udp.LocalAddr().String())
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	resolver := net.JoinHostPort(host, port)
+	endpoints.MockDNSAddress = resolver
+	endpoints.Ports.MockDNS = udp.LocalAddr().(*net.UDPAddr).Port
+
+	if config.ManagementListenAddress != "" {
+		mgmtListener, err := net.Listen("tcp", config.ManagementListenAddress)
+		cmd.FailOnError(err, "Listening on mockDNS managementListenAddress")
+		endpoints.MockDNSManagementURL, endpoints.Ports.MockDNSManagement =
+			listenerURL("http", mgmtListener.Addr())
+		go func() {
+			logger.Printf("Mock DNS management API listening on: %s\n", mgmtListener.Addr())
+			err := http.Serve(mgmtListener, server.Handler())
+			cmd.FailOnError(err, "Calling Serve() for mock DNS management API")
+		}()
+	}
+	return resolver
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// persister keeps the state file of -dbfile up to date. Every change to the db
+// schedules a write of the whole state file. Writes happen in the background,
+// one at a time, and the changes made while a write is in progress are all
+// saved by the next one.
+type persister struct {
+	logger   *logging.Logger
+	path     string
+	memStore *db.MemoryStore
+	ca       *ca.CAImpl
+	// changed holds a scheduled write, one is enough for any number of changes
+	changed chan struct{}
+	// done is closed to stop run
+	done chan struct{}
+	// stopped is closed when run has returned
+	stopped chan struct{}
+}
+
+func newPersister(logger *logging.Logger, path string, memStore *db.MemoryStore, theCA *ca.CAImpl) *persister {
+	return &persister{
+		logger:   logger,
+		path:     path,
+		memStore: memStore,
+		ca:       theCA,
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// save writes the state file.
+func (p *persister) save() error {
+	return dumpState(p.path, p.memStore, p.ca)
+}
+
+// notify schedules a write of the state file. It never blocks, so it can be
+// the OnChange function of the db.
+func (p *persister) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the state file whenever a write is scheduled, until close is
+// called.
+func (p *persister) run() {
+	defer close(p.stopped)
+	for {
+		select {
+		case <-p.changed:
+			if err := p.save(); err != nil {
+				p.logger.Printf("Error saving database to %q: %s\n", p.path, err.Error())
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// close stops run once the write in progress, if any, is done and writes the
+// final state file.
+func (p *persister) close() error {
+	close(p.done)
+	<-p.stopped
+	return p.save()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// writeRootBundle writes root to dir in each of the core.TrustStoreFormats,
+// creating dir if it doesn't exist.
+func writeRootBundle(dir string, root *core.Certificate) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, format := range core.TrustStoreFormats {
+		if err := writeFileAtomic(filepath.Join(dir, format.FileName), format.Encode(root)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/letsencrypt/pebble/cmd"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	// defaultDrainTimeout is how long a graceful shutdown waits for in-flight
+	// work when the config doesn't specify a drainTimeout
+	defaultDrainTimeout = 30 * time.Second
+	// drainPollInterval is how often a graceful shutdown checks if in-flight
+	// validations and issuances have completed
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// reloadConfig reads the config file at path and applies the settings that can
+// be changed while Pebble runs to the default CA and every tenant: the validity
+// of the issuance policies, the chaos rules and the rate limits. The other
+// settings of the file are ignored. Nothing is changed if one of the reloaded
+// settings is invalid.
+func reloadConfig(logger *logging.Logger, path string, tenants []*tenant) error {
+	var c config
+	if err := cmd.ReadConfigFile(path, &c); err != nil {
+		return err
+	}
+	for _, policy := range c.Pebble.IssuancePolicies {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("invalid issuance policy: %s", err.Error())
+		}
+	}
+	for _, rule := range c.Pebble.Chaos {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid chaos rule: %s", err.Error())
+		}
+	}
+	for name, limit := range map[string]interface{ Validate() error }{
+		"newAccountsPerIP":      c.Pebble.NewAccountsPerIP,
+		"newOrdersPerAccount":   c.Pebble.NewOrdersPerAccount,
+		"duplicateCertificates": c.Pebble.DuplicateCertificates,
+	} {
+		if err := limit.Validate(); err != nil {
+			return fmt.Errorf("invalid %s: %s", name, err.Error())
+		}
+	}
+	// Every CA was created with the same issuance policies, so if the policies
+	// can't be reloaded it fails for the first CA, before any is changed
+	for _, t := range tenants {
+		if err := t.ca.ReloadPolicies(c.Pebble.IssuancePolicies); err != nil {
+			return err
+		}
+	}
+	for _, t := range tenants {
+		t.wfe.Reload(c.Pebble.Chaos, c.Pebble.NewAccountsPerIP,
+			c.Pebble.NewOrdersPerAccount, c.Pebble.DuplicateCertificates)
+	}
+	logger.Printf("Reloaded config from %q\n", path)
+	return nil
+}
+
+// handleSignals blocks until Pebble is interrupted or terminated, calling
+// reload for every SIGHUP. On SIGINT or SIGTERM the servers stop accepting
+// requests and Pebble waits up to timeout for in-flight requests and for idle
+// to report that no validations or issuances are in progress. It then runs
+// each of the shutdown funcs and exits.
+func handleSignals(
+	logger *logging.Logger,
+	reload func() error,
+	servers []*http.Server,
+	idle func() bool,
+	timeout time.Duration,
+	shutdownFuncs []func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if err := reload(); err != nil {
+				logger.Printf("Error reloading config on %s, keeping the current settings: %s\n",
+					sig, err.Error())
+			}
+			continue
+		}
+		logger.Printf("Draining on %s\n", sig)
+		drain(logger, servers, idle, timeout)
+		for _, f := range shutdownFuncs {
+			err := f()
+			cmd.FailOnError(err, "Saving state on shutdown")
+		}
+		logger.Printf("Shut down on %s\n", sig)
+		os.Exit(0)
+	}
+}
+
+// drain gracefully shuts down the servers, then waits until idle returns true.
+// It gives up when timeout has passed.
+func drain(logger *logging.Logger, servers []*http.Server, idle func() bool, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Printf("Error shutting down the server on %s: %s\n", srv.Addr, err.Error())
+		}
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !idle() {
+		select {
+		case <-ctx.Done():
+			logger.Printf("Validations or issuances still in progress after %s, shutting down anyway\n",
+				timeout)
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// stateFile is the JSON format used by the -dumpstate, -loadstate and -dbfile
+// flags. It holds everything needed to recreate a Pebble instance: the db
+// contents and the CA's issuer keys. A -dbfile of a CA with a remote signer has
+// no issuer keys.
+type stateFile struct {
+	DB *db.Snapshot `json:"db"`
+	CA *ca.State    `json:"ca,omitempty"`
+}
+
+// loadState reads a state file written by dumpState.
+func loadState(path string) (*stateFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.DB == nil {
+		return nil, fmt.Errorf("state file %q must include \"db\"", path)
+	}
+	return &state, nil
+}
+
+// dumpState writes the contents of memStore and the issuers of theCA, unless
+// it is nil, to a state file at path. The file is replaced by renaming a
+// temporary file, so that a crash while writing never leaves a truncated file
+// behind.
+func dumpState(path string, memStore *db.MemoryStore, theCA *ca.CAImpl) error {
+	state := stateFile{DB: memStore.Snapshot()}
+	if theCA != nil {
+		caState, err := theCA.State()
+		if err != nil {
+			return err
+		}
+		state.CA = caState
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newCA creates the CA, using the issuers of state if it isn't nil or the
+// remote signer if it has a URL. The intermediate options are only used when
+// a new intermediate is generated. Issuance policies and CT logs only apply to
+// certificates the CA signs itself.
+func newCA(
+	logger *logging.Logger,
+	memStore *db.MemoryStore,
+	clk clock.Clock,
+	signer ca.RemoteSigner,
+	state *stateFile,
+	config ca.Config) (*ca.CAImpl, error) {
+	if signer.URL != "" {
+		if state != nil && state.CA != nil {
+			return nil, errors.New("the issuer keys of a state file can't be used with a remoteSigner")
+		}
+		// Policies have issuers of their own, the signer only has its
+		// intermediate
+		if len(config.Policies) > 0 {
+			return nil, errors.New("issuancePolicies can't be used with a remoteSigner")
+		}
+		if len(config.CTLogs) > 0 {
+			return nil, errors.New("ctLogs can't be used with a remoteSigner")
+		}
+		if h := config.Hierarchy; h.Intermediates > 1 || h.CrossSign || h.RootKeyType != "" ||
+			len(h.IntermediateKeyTypes) > 0 {
+			return nil, errors.New("hierarchy can't be used with a remoteSigner")
+		}
+		return ca.NewRemote(logger, memStore, clk, signer, config)
+	}
+	if state != nil && state.CA == nil {
+		return nil, errors.New("the state file has no issuer keys, it was saved with a remoteSigner")
+	}
+	if state == nil {
+		return ca.New(logger, memStore, clk, config), nil
+	}
+	return ca.NewFromState(logger, memStore, clk, state.CA, config)
+}
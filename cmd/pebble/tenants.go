@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/webhook"
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+// tenant is an isolated virtual CA of a multi-tenant Pebble. It has its own
+// database and CA hierarchy and its ACME and management APIs are served under
+// "/<name>/".
+type tenant struct {
+	name string
+	ca   *ca.CAImpl
+	wfe  *wfe.WebFrontEndImpl
+}
+
+// newWFE creates a WFE with the config's ACME API settings for the database
+// and CA of the default CA or a tenant. Each WFE gets its own rate limiters.
+func newWFE(
+	logger *logging.Logger,
+	clk clock.Clock,
+	c *config,
+	memStore *db.MemoryStore,
+	vaImpl *va.VAImpl,
+	caImpl *ca.CAImpl,
+	basePath string,
+	notifier *webhook.Notifier) wfe.WebFrontEndImpl {
+	return wfe.New(logger, clk, memStore, vaImpl, caImpl, wfe.Config{
+		ExternalAccountBindingRequired: c.Pebble.ExternalAccountBindingRequired,
+		PreAuthorization:               c.Pebble.PreAuthorization,
+		OrdersPerPage:                  c.Pebble.OrdersPerPage,
+		OrderAnnotationKey:             c.Pebble.OrderAnnotationKey,
+		CSRPolicy:                      c.Pebble.CSRPolicy,
+		KeyPolicy:                      c.Pebble.KeyPolicy,
+		IdentifierPolicy:               c.Pebble.IdentifierPolicy,
+		JWSAlgorithms:                  c.Pebble.JWSAlgorithms,
+		Chaos:                          c.Pebble.Chaos,
+		NewAccountLimiter:              c.Pebble.NewAccountsPerIP.NewLimiter(clk),
+		NewOrderLimiter:                c.Pebble.NewOrdersPerAccount.NewLimiter(clk),
+		DuplicateCertLimiter:           c.Pebble.DuplicateCertificates.NewLimiter(clk),
+		CancelValidationOnDisconnect:   c.Pebble.CancelValidationOnDisconnect,
+		DirectoryMeta:                  c.Pebble.DirectoryMeta,
+		PollRetryAfter:                 c.Pebble.PollRetryAfter.Duration,
+		AuthzExpiryWarning:             c.Pebble.AuthzExpiryWarning.Duration,
+		Backpressure:                   c.Pebble.Backpressure,
+		CertificateChain:               c.Pebble.CertificateChain,
+		BasePath:                       basePath,
+		AuthzReuse:                     c.Pebble.AuthzReuse,
+		HTTPQuirks:                     c.Pebble.HTTPQuirks,
+		Lifetimes:                      c.Pebble.Lifetimes,
+		ValidityWindow:                 c.Pebble.ValidityWindow,
+		AllowLegacyGET:                 c.Pebble.AllowLegacyGET,
+		Notifier:                       notifier,
+	})
+}
+
+// newCAConfig returns the config's CA settings for the default CA and the
+// tenants.
+func newCAConfig(c *config) ca.Config {
+	return ca.Config{
+		Hooks:        c.Pebble.IssuanceHooks,
+		Latency:      c.Pebble.IssuanceLatency,
+		RandomDelay:  c.Pebble.IssuanceDelay,
+		Intermediate: c.Pebble.Intermediate,
+		Hierarchy:    c.Pebble.Hierarchy,
+		Policies:     c.Pebble.IssuancePolicies,
+		CTLogs:       c.Pebble.CTLogs,
+		LoadTest:     c.Pebble.LoadTest,
+		Profiles:     c.Pebble.Profiles,
+	}
+}
+
+// newTenants creates the tenants named by the config. They share the VA and
+// the external account MAC keys, and always sign certificates themselves, in
+// memory.
+func newTenants(
+	logger *logging.Logger,
+	clk clock.Clock,
+	c *config,
+	vaImpl *va.VAImpl,
+	notifier *webhook.Notifier) ([]*tenant, error) {
+	var tenants []*tenant
+	seen := make(map[string]bool)
+	for _, name := range c.Pebble.Tenants {
+		if err := wfe.CheckTenantName(name); err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("tenant %q is configured twice", name)
+		}
+		seen[name] = true
+
+		memStore := db.NewMemoryStore()
+		for keyID, b64Key := range c.Pebble.ExternalAccountMACKeys {
+			key, err := base64.RawURLEncoding.DecodeString(b64Key)
+			if err != nil {
+				return nil, err
+			}
+			if err := memStore.AddExternalAccountKeyByID(keyID, key); err != nil {
+				return nil, err
+			}
+		}
+		tenantCA := ca.New(logger, memStore, clk, newCAConfig(c))
+		tenantWFE := newWFE(logger, clk, c, memStore, vaImpl, tenantCA, "/"+name, notifier)
+		tenants = append(tenants, &tenant{name: name, ca: tenantCA, wfe: &tenantWFE})
+		logger.Printf("Created tenant %q with root CA %q\n", name,
+			tenantCA.RootCert().Cert.Subject.CommonName)
+	}
+	return tenants, nil
+}
+
+// tenantHandler serves root at "/" and the handler returned by handler for
+// each tenant under "/<name>/".
+func tenantHandler(
+	root http.Handler,
+	tenants []*tenant,
+	handler func(*wfe.WebFrontEndImpl) http.Handler) http.Handler {
+	if len(tenants) == 0 {
+		return root
+	}
+	m := http.NewServeMux()
+	m.Handle("/", root)
+	for _, t := range tenants {
+		m.Handle("/"+t.name+"/", http.StripPrefix("/"+t.name, handler(t.wfe)))
+	}
+	return m
+}
+
+// The handlers served by tenantHandler for the ACME and management APIs and
+// the root CA endpoint
+var (
+	acmeHandler               = (*wfe.WebFrontEndImpl).Handler
+	managementHandler         = (*wfe.WebFrontEndImpl).ManagementHandler
+	readOnlyManagementHandler = (*wfe.WebFrontEndImpl).ReadOnlyManagementHandler
+	rootCAHandler             = (*wfe.WebFrontEndImpl).RootCAHandler
+)
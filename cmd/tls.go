@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSConfig is the JSON configuration of the TLS versions and cipher suites
+// a listener accepts. Empty fields use the Go defaults.
+type TLSConfig struct {
+	// MinVersion and MaxVersion are one of "1.0", "1.1", "1.2" or "1.3"
+	MinVersion string
+	MaxVersion string
+	// CipherSuites are cipher suite names as used by the crypto/tls package,
+	// e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". They only apply to TLS 1.2
+	// and earlier.
+	CipherSuites []string
+	// RequestClientCert makes the listener send a CertificateRequest during the
+	// handshake. Client certificates are requested but never required or
+	// verified.
+	RequestClientCert bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteID returns the ID of the cipher suite with the given name, which
+// may be an insecure suite.
+func cipherSuiteID(name string) (uint16, bool) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, suite := range suites {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Build returns a tls.Config using the configured versions and cipher suites.
+// An error is returned for unknown versions or cipher suite names.
+func (c TLSConfig) Build() (*tls.Config, error) {
+	config := &tls.Config{}
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS minVersion %q", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	if c.MaxVersion != "" {
+		version, ok := tlsVersions[c.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS maxVersion %q", c.MaxVersion)
+		}
+		config.MaxVersion = version
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return nil, fmt.Errorf("TLS minVersion %q is greater than maxVersion %q",
+			c.MinVersion, c.MaxVersion)
+	}
+	for _, name := range c.CipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	if c.RequestClientCert {
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return config, nil
+}
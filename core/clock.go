@@ -0,0 +1,49 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+// SkewedClock is a clock.Clock whose time is offset from another clock's, to
+// test certificate, order and nonce expiry without waiting. The offset can be
+// changed while the clock is in use. Durations, e.g. of After, are not skewed.
+type SkewedClock struct {
+	clock.Clock
+	// offset is the time.Duration added to the wrapped clock's time
+	offset *int64
+}
+
+// NewSkewedClock returns a SkewedClock that is offset from clk.
+func NewSkewedClock(clk clock.Clock, offset time.Duration) *SkewedClock {
+	c := &SkewedClock{Clock: clk, offset: new(int64)}
+	c.SetOffset(offset)
+	return c
+}
+
+// Now returns the wrapped clock's time plus the offset.
+func (c *SkewedClock) Now() time.Time {
+	return c.Clock.Now().Add(c.Offset())
+}
+
+// Offset returns the current offset of the clock.
+func (c *SkewedClock) Offset() time.Duration {
+	return time.Duration(atomic.LoadInt64(c.offset))
+}
+
+// SetOffset changes the offset of the clock.
+func (c *SkewedClock) SetOffset(offset time.Duration) {
+	atomic.StoreInt64(c.offset, int64(offset))
+}
+
+// Advance moves the clock forward by d, or back if d is negative.
+func (c *SkewedClock) Advance(d time.Duration) {
+	atomic.AddInt64(c.offset, int64(d))
+}
+
+// Set changes the offset of the clock so that it is now t.
+func (c *SkewedClock) Set(t time.Time) {
+	c.SetOffset(t.Sub(c.Clock.Now()))
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Duration is a time.Duration that is configured in JSON as a string parsed
+// by time.ParseDuration, e.g. "1.5s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes the duration in the format UnmarshalJSON reads.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// DelayRange is the range of an artificial random delay.
+type DelayRange struct {
+	Min Duration
+	Max Duration
+}
+
+// IsZero returns true if neither bound of the range is set.
+func (r DelayRange) IsZero() bool {
+	return r.Min.Duration == 0 && r.Max.Duration == 0
+}
+
+// Validate returns an error if a bound is negative or Min is greater than Max.
+func (r DelayRange) Validate() error {
+	if r.Min.Duration < 0 || r.Max.Duration < 0 {
+		return fmt.Errorf("delay range %s-%s can't be negative", r.Min, r.Max)
+	}
+	if r.Min.Duration > r.Max.Duration {
+		return fmt.Errorf("delay range min %s is greater than max %s", r.Min, r.Max)
+	}
+	return nil
+}
+
+// Random returns a random delay from Min up to Max.
+func (r DelayRange) Random() time.Duration {
+	if r.Max.Duration <= r.Min.Duration {
+		return r.Min.Duration
+	}
+	return r.Min.Duration + time.Duration(rand.Int63n(int64(r.Max.Duration-r.Min.Duration)))
+}
@@ -0,0 +1,22 @@
+package core
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// OIDTLSFeature is the OID of the TLS Feature extension (RFC 7633). With the
+// status_request feature it is known as OCSP Must-Staple.
+var OIDTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// TLSFeatureExtension returns the TLS Feature extension requested by a CSR,
+// or nil if it doesn't request one.
+func TLSFeatureExtension(csr *x509.CertificateRequest) *pkix.Extension {
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(OIDTLSFeature) {
+			return &ext
+		}
+	}
+	return nil
+}
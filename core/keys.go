@@ -0,0 +1,34 @@
+package core
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// KeyToID produces a string with the hex representation of the SHA256 digest
+// over a provided public key. It is used to index accounts by their current
+// key (required by the spec for retrieving an existing account) and to compare
+// keys, e.g. to check a CSR key differs from the account key.
+func KeyToID(key crypto.PublicKey) (string, error) {
+	switch t := key.(type) {
+	case *jose.JSONWebKey:
+		if t == nil {
+			return "", fmt.Errorf("Cannot compute ID of nil key")
+		}
+		return KeyToID(t.Key)
+	case jose.JSONWebKey:
+		return KeyToID(t.Key)
+	default:
+		keyDER, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		spkiDigest := sha256.Sum256(keyDER)
+		return hex.EncodeToString(spkiDigest[:]), nil
+	}
+}
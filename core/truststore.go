@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// JavaKeyStorePassword is the integrity password of the Java keystores written
+// by JavaKeyStore, the JDK's default trust store password
+const JavaKeyStorePassword = "changeit"
+
+// TrustStoreFormat is a file format that client environments install trusted
+// root CA certificates from.
+type TrustStoreFormat struct {
+	// Name identifies the format, e.g. "der"
+	Name string
+	// FileName is the name of the format's file in a root bundle
+	FileName    string
+	ContentType string
+	// Encode returns the certificate in the format
+	Encode func(cert *Certificate) []byte
+}
+
+// TrustStoreFormats are the formats of a root bundle:
+//
+//   - "pem": a PEM file, e.g. for update-ca-certificates or SSL_CERT_FILE
+//   - "der": a DER file, e.g. for the macOS security tool or Windows certutil
+//   - "jks": a Java keystore holding the certificate as a trusted entry, with
+//     the password of JavaKeyStorePassword
+var TrustStoreFormats = []TrustStoreFormat{
+	{
+		Name:        "pem",
+		FileName:    "pebble-root.crt",
+		ContentType: "application/pem-certificate-chain",
+		Encode:      func(cert *Certificate) []byte { return cert.PEM() },
+	},
+	{
+		Name:        "der",
+		FileName:    "pebble-root.cer",
+		ContentType: "application/pkix-cert",
+		Encode:      func(cert *Certificate) []byte { return cert.DER },
+	},
+	{
+		Name:        "jks",
+		FileName:    "pebble-root.jks",
+		ContentType: "application/x-java-keystore",
+		Encode: func(cert *Certificate) []byte {
+			return JavaKeyStore("pebble-root", cert, JavaKeyStorePassword)
+		},
+	},
+}
+
+// TrustStoreFormatByName returns the TrustStoreFormat with the name, or false
+// if there is none.
+func TrustStoreFormatByName(name string) (TrustStoreFormat, bool) {
+	for _, format := range TrustStoreFormats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return TrustStoreFormat{}, false
+}
+
+// JavaKeyStore returns a JKS keystore with cert as its only entry, a trusted
+// certificate entry with the alias. The keystore's integrity is protected with
+// password, as keytool does. The entry's creation date is the certificate's
+// NotBefore so that the keystore of a certificate is always the same.
+func JavaKeyStore(alias string, cert *Certificate, password string) []byte {
+	const (
+		jksMagic          = 0xFEEDFEED
+		jksVersion        = 2
+		trustedCertEntry  = 2
+		integrityWhitener = "Mighty Aphrodite"
+	)
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		// Writes to a bytes.Buffer don't fail
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	write(uint32(jksMagic))
+	write(uint32(jksVersion))
+	// The number of entries
+	write(uint32(1))
+	write(uint32(trustedCertEntry))
+	writeUTF(alias)
+	write(cert.Cert.NotBefore.UnixNano() / 1e6)
+	writeUTF("X.509")
+	write(uint32(len(cert.DER)))
+	buf.Write(cert.DER)
+
+	// The keystore ends with the SHA-1 digest of the UTF-16 password, the
+	// whitener and the keystore contents
+	digest := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		_, _ = digest.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	_, _ = digest.Write([]byte(integrityWhitener))
+	_, _ = digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+	return buf.Bytes()
+}
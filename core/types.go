@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"sync"
@@ -18,25 +19,36 @@ type Order struct {
 	sync.RWMutex
 	acme.Order
 	ID                   string
+	AccountID            string
 	ParsedCSR            *x509.CertificateRequest
 	ExpiresDate          time.Time
 	AuthorizationObjects []*Authorization
 	CertificateObject    *Certificate
+	// Annotation is the value of the order annotation field the client
+	// included in its newOrder request, if any
+	Annotation json.RawMessage
 }
 
 type Account struct {
+	sync.RWMutex
 	acme.Account
 	Key *jose.JSONWebKey `json:"key"`
 	ID  string
+	// AgreedTermsOfService is the URL of the terms of service the account
+	// last agreed to
+	AgreedTermsOfService string
 }
 
 type Authorization struct {
 	sync.RWMutex
 	acme.Authorization
 	ID          string
+	AccountID   string
 	URL         string
 	ExpiresDate time.Time
-	Order       *Order
+	// ValidatedDate is when the authorization became valid
+	ValidatedDate time.Time
+	Order         *Order
 }
 
 type Challenge struct {
@@ -45,6 +57,9 @@ type Challenge struct {
 	ID            string
 	Authz         *Authorization
 	ValidatedDate time.Time
+	// ValidationRecords holds the result of every validation attempt made for
+	// the challenge, in the order they completed.
+	ValidationRecords []*ValidationRecord
 }
 
 func (ch Challenge) ExpectedKeyAuthorization(key *jose.JSONWebKey) string {
@@ -65,6 +80,10 @@ type Certificate struct {
 	Cert   *x509.Certificate
 	DER    []byte
 	Issuer *Certificate
+	// CrossSigned is a certificate for the same subject and key from another
+	// issuer, which starts the alternate chain of the certificates this one
+	// issues. It is nil unless the certificate is a cross-signed intermediate.
+	CrossSigned *Certificate
 }
 
 func (c Certificate) PEM() []byte {
@@ -104,8 +123,39 @@ func (c Certificate) Chain() []byte {
 	return bytes.Join(chain, nil)
 }
 
+// AlternateChain returns a copy of the certificate whose Issuer chain goes
+// through the CrossSigned certificate of the closest issuer that has one, or
+// nil if no issuer has one.
+func (c Certificate) AlternateChain() *Certificate {
+	if c.Issuer == nil {
+		return nil
+	}
+	alt := c
+	if c.Issuer.CrossSigned != nil {
+		alt.Issuer = c.Issuer.CrossSigned
+		return &alt
+	}
+	alt.Issuer = c.Issuer.AlternateChain()
+	if alt.Issuer == nil {
+		return nil
+	}
+	return &alt
+}
+
+// ChainDER returns the DER encoded certificates of Chain, leaf cert first.
+func (c Certificate) ChainDER() [][]byte {
+	chain := [][]byte{c.DER}
+	for issuer := c.Issuer; issuer != nil && issuer.Issuer != nil; issuer = issuer.Issuer {
+		chain = append(chain, issuer.DER)
+	}
+	return chain
+}
+
 type ValidationRecord struct {
-	URL         string
-	Error       *acme.ProblemDetails
-	ValidatedAt time.Time
+	URL         string               `json:"url"`
+	Error       *acme.ProblemDetails `json:"error,omitempty"`
+	ValidatedAt time.Time            `json:"validatedAt"`
+	// Cancelled is true if the validation was cancelled before this attempt
+	// completed
+	Cancelled bool `json:"cancelled,omitempty"`
 }
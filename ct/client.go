@@ -0,0 +1,47 @@
+package ct
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// submitTimeout bounds how long a log may take to return an SCT
+const submitTimeout = 10 * time.Second
+
+// SubmitPrecert submits a precertificate chain, leaf first and including its
+// issuer, to the add-pre-chain endpoint of the log at logURL and returns the
+// SCT the log issued.
+func SubmitPrecert(logURL string, chain [][]byte) (*SCT, error) {
+	req := addChainRequest{}
+	for _, der := range chain {
+		req.Chain = append(req.Chain, base64.StdEncoding.EncodeToString(der))
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: submitTimeout}
+	url := strings.TrimSuffix(logURL, "/") + addPreChainPath
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("returned status %d: %s", resp.StatusCode, respBody)
+	}
+	var sctResp addChainResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sctResp); err != nil {
+		return nil, err
+	}
+	return sctResp.sct()
+}
@@ -0,0 +1,237 @@
+package ct
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	addChainPath    = "/ct/v1/add-chain"
+	addPreChainPath = "/ct/v1/add-pre-chain"
+	// keyPath serves the PEM encoded public key of the mock log. It is not part
+	// of RFC 6962.
+	keyPath = "/key"
+)
+
+// Log entry types
+const (
+	x509Entry    uint16 = 0
+	precertEntry uint16 = 1
+)
+
+// MockLog is a CT log that returns an SCT for every submitted chain without
+// storing it. The SCTs are signed with a key generated when the log is
+// created, so they can be verified with the key it serves.
+type MockLog struct {
+	log   *logging.Logger
+	clk   clock.Clock
+	key   *ecdsa.PrivateKey
+	logID [32]byte
+}
+
+// NewMockLog creates a MockLog with a new ECDSA P-256 key.
+func NewMockLog(log *logging.Logger, clk clock.Clock) (*MockLog, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &MockLog{
+		log:   log,
+		clk:   clk,
+		key:   key,
+		logID: sha256.Sum256(spki),
+	}, nil
+}
+
+// LogID returns the base64 encoded ID of the log.
+func (l *MockLog) LogID() string {
+	return base64.StdEncoding.EncodeToString(l.logID[:])
+}
+
+// Handler returns an http.Handler serving the add-chain and add-pre-chain
+// endpoints of the log and its public key.
+func (l *MockLog) Handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(addChainPath, l.handleAddChain(x509Entry))
+	m.HandleFunc(addPreChainPath, l.handleAddChain(precertEntry))
+	m.HandleFunc(keyPath, func(response http.ResponseWriter, request *http.Request) {
+		spki, err := x509.MarshalPKIXPublicKey(&l.key.PublicKey)
+		if err != nil {
+			http.Error(response, "Error marshaling key: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Header().Set("Content-Type", "application/x-pem-file")
+		_ = pem.Encode(response, &pem.Block{Type: "PUBLIC KEY", Bytes: spki})
+	})
+	return m
+}
+
+func (l *MockLog) handleAddChain(entryType uint16) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" {
+			response.Header().Set("Allow", "POST")
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req addChainRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(response, "Error unmarshaling body JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var chain [][]byte
+		for _, b64 := range req.Chain {
+			der, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				http.Error(response, "Error decoding chain: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			chain = append(chain, der)
+		}
+		sct, err := l.sign(entryType, chain)
+		if err != nil {
+			l.log.Printf("Error: mock CT log: %s", err.Error())
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		l.log.Printf("Mock CT log issued SCT for %s with timestamp %d\n",
+			request.URL.Path, sct.Timestamp)
+		response.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(response).Encode(sct.response())
+	}
+}
+
+// sign returns an SCT for the leaf of chain. A precertificate chain must
+// include the issuer of the precertificate.
+func (l *MockLog) sign(entryType uint16, chain [][]byte) (*SCT, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("chain is empty")
+	}
+	sct := &SCT{
+		LogID:     l.logID,
+		Timestamp: uint64(l.clk.Now().UnixNano() / 1e6),
+	}
+
+	var signed bytes.Buffer
+	// sct_version v1 and signature_type certificate_timestamp
+	signed.Write([]byte{0, 0})
+	_ = binary.Write(&signed, binary.BigEndian, sct.Timestamp)
+	_ = binary.Write(&signed, binary.BigEndian, entryType)
+	if entryType == x509Entry {
+		writeUint24Prefixed(&signed, chain[0])
+	} else {
+		if len(chain) < 2 {
+			return nil, errors.New("precertificate chain has no issuer")
+		}
+		issuer, err := x509.ParseCertificate(chain[1])
+		if err != nil {
+			return nil, fmt.Errorf("parsing issuer: %s", err.Error())
+		}
+		precert, err := x509.ParseCertificate(chain[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing precertificate: %s", err.Error())
+		}
+		tbs, err := removeExtension(precert.RawTBSCertificate, OIDPoison)
+		if err != nil {
+			return nil, fmt.Errorf("removing poison: %s", err.Error())
+		}
+		issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		signed.Write(issuerKeyHash[:])
+		writeUint24Prefixed(&signed, tbs)
+	}
+	writeUint16Prefixed(&signed, sct.Extensions)
+
+	digest := sha256.Sum256(signed.Bytes())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	// DigitallySigned with hash algorithm sha256 (4) and signature algorithm
+	// ecdsa (3)
+	var ds bytes.Buffer
+	ds.Write([]byte{4, 3})
+	writeUint16Prefixed(&ds, sig)
+	sct.Signature = ds.Bytes()
+	return sct, nil
+}
+
+// removeExtension returns the DER TBSCertificate tbs without the extension
+// with the given ID, which is how the TBSCertificate of a precertificate is
+// turned into the one of the final certificate when it is signed by a log.
+func removeExtension(tbs []byte, id asn1.ObjectIdentifier) ([]byte, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(tbs, &seq); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after TBSCertificate")
+	}
+
+	var fields []byte
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return nil, err
+		}
+		// The extensions are the explicitly tagged [3] field
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			fields = append(fields, field.FullBytes...)
+			continue
+		}
+		var exts []asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, err
+		}
+		var kept []asn1.RawValue
+		for _, raw := range exts {
+			var ext pkix.Extension
+			if _, err := asn1.Unmarshal(raw.FullBytes, &ext); err != nil {
+				return nil, err
+			}
+			if !ext.Id.Equal(id) {
+				kept = append(kept, raw)
+			}
+		}
+		extsDER, err := asn1.Marshal(kept)
+		if err != nil {
+			return nil, err
+		}
+		tagged, err := asn1.Marshal(asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        3,
+			IsCompound: true,
+			Bytes:      extsDER,
+		})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, tagged...)
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      fields,
+	})
+}
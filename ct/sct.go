@@ -0,0 +1,139 @@
+// Package ct implements the parts of Certificate Transparency (RFC 6962) Pebble
+// needs to issue precertificates, submit them to CT logs and embed the
+// returned SCTs in certificates, together with a mock CT log.
+package ct
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// OIDPoison is the critical extension that makes a precertificate unusable
+	// as a certificate
+	OIDPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// OIDSCTList is the extension holding the SCTs embedded in a certificate
+	OIDSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// PoisonExtension returns the precertificate poison extension, a critical
+// extension with an ASN.1 NULL value.
+func PoisonExtension() pkix.Extension {
+	return pkix.Extension{
+		Id:       OIDPoison,
+		Critical: true,
+		Value:    asn1.NullBytes,
+	}
+}
+
+// SCT is a version 1 signed certificate timestamp.
+type SCT struct {
+	LogID     [32]byte
+	Timestamp uint64
+	// Extensions are the opaque CT extensions, usually empty
+	Extensions []byte
+	// Signature is the TLS encoded DigitallySigned struct
+	Signature []byte
+}
+
+// addChainRequest is the body of add-chain and add-pre-chain requests, with
+// the base64 encoded DER certificates of the chain, leaf first.
+type addChainRequest struct {
+	Chain []string `json:"chain"`
+}
+
+// addChainResponse is the JSON encoding of an SCT returned by a log.
+type addChainResponse struct {
+	SCTVersion int    `json:"sct_version"`
+	ID         string `json:"id"`
+	Timestamp  uint64 `json:"timestamp"`
+	Extensions string `json:"extensions"`
+	Signature  string `json:"signature"`
+}
+
+func (sct *SCT) response() addChainResponse {
+	return addChainResponse{
+		ID:         base64.StdEncoding.EncodeToString(sct.LogID[:]),
+		Timestamp:  sct.Timestamp,
+		Extensions: base64.StdEncoding.EncodeToString(sct.Extensions),
+		Signature:  base64.StdEncoding.EncodeToString(sct.Signature),
+	}
+}
+
+func (resp addChainResponse) sct() (*SCT, error) {
+	if resp.SCTVersion != 0 {
+		return nil, fmt.Errorf("unsupported sct_version %d", resp.SCTVersion)
+	}
+	id, err := base64.StdEncoding.DecodeString(resp.ID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %s", err.Error())
+	}
+	if len(id) != 32 {
+		return nil, fmt.Errorf("id is %d bytes, not 32", len(id))
+	}
+	extensions, err := base64.StdEncoding.DecodeString(resp.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extensions: %s", err.Error())
+	}
+	signature, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err.Error())
+	}
+	sct := &SCT{
+		Timestamp:  resp.Timestamp,
+		Extensions: extensions,
+		Signature:  signature,
+	}
+	copy(sct.LogID[:], id)
+	return sct, nil
+}
+
+// serialize returns the TLS encoding of the SCT used in SCT lists.
+func (sct *SCT) serialize() []byte {
+	var buf bytes.Buffer
+	// sct_version v1
+	buf.WriteByte(0)
+	buf.Write(sct.LogID[:])
+	_ = binary.Write(&buf, binary.BigEndian, sct.Timestamp)
+	writeUint16Prefixed(&buf, sct.Extensions)
+	buf.Write(sct.Signature)
+	return buf.Bytes()
+}
+
+// SCTListExtension returns the extension that embeds scts in a certificate.
+func SCTListExtension(scts []*SCT) (pkix.Extension, error) {
+	if len(scts) == 0 {
+		return pkix.Extension{}, errors.New("no SCTs to embed")
+	}
+	var list bytes.Buffer
+	for _, sct := range scts {
+		writeUint16Prefixed(&list, sct.serialize())
+	}
+	var buf bytes.Buffer
+	writeUint16Prefixed(&buf, list.Bytes())
+	// The extension value is an OCTET STRING holding the TLS encoded list
+	value, err := asn1.Marshal(buf.Bytes())
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{
+		Id:    OIDSCTList,
+		Value: value,
+	}, nil
+}
+
+func writeUint16Prefixed(buf *bytes.Buffer, data []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	buf.Write(data)
+}
+
+func writeUint24Prefixed(buf *bytes.Buffer, data []byte) {
+	n := len(data)
+	buf.Write([]byte{byte(n >> 16), byte(n >> 8), byte(n)})
+	buf.Write(data)
+}
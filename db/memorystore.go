@@ -1,51 +1,185 @@
 package db
 
 import (
+	"crypto"
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/letsencrypt/pebble/acme"
 	"github.com/letsencrypt/pebble/core"
+	"gopkg.in/square/go-jose.v2"
 )
 
-// Pebble keeps all of its various objects (accounts, orders, etc)
-// in-memory, not persisted anywhere. MemoryStore implements this in-memory
-// "database"
-type MemoryStore struct {
+// shardCount is the number of shards the objects of a MemoryStore are spread
+// across. It is a power of two so that a shard is picked by masking a hash.
+const shardCount = 32
+
+// A shard holds the objects whose keys hash to it, each map being indexed by
+// the key named in its comment. Every shard has its own lock so that
+// concurrent clients working on different objects rarely wait for each other.
+type shard struct {
 	sync.RWMutex
 
-	// Each Accounts's ID is the hex encoding of a SHA256 sum over its public
-	// key bytes.
+	// accountsByID is indexed by account ID
 	accountsByID map[string]*core.Account
 
+	// Each account is also indexed by the hex encoding of a SHA256 sum over
+	// its current public key bytes (see core.KeyToID). This index is updated
+	// when an account's key is rolled over.
+	accountsByKeyID map[string]*core.Account
+
+	// ordersByID is indexed by order ID
 	ordersByID map[string]*core.Order
 
+	// Orders are also indexed by the ID of the account that created them, in
+	// the order they were added
+	ordersByAccountID map[string][]*core.Order
+
+	// authorizationsByID is indexed by authorization ID
 	authorizationsByID map[string]*core.Authorization
 
+	// challengesByID is indexed by challenge ID
 	challengesByID map[string]*core.Challenge
 
+	// certificatesByID is indexed by certificate ID
 	certificatesByID map[string]*core.Certificate
+
+	// revokedCertificatesByID holds the time each revoked certificate was
+	// revoked, indexed by certificate ID
+	revokedCertificatesByID map[string]time.Time
+
+	// revocationReasonsByID holds the CRLReason of each revoked certificate
+	// that was revoked with a reason, indexed by certificate ID
+	revocationReasonsByID map[string]int
 }
 
-func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
+func newShard() *shard {
+	return &shard{
 		accountsByID:       make(map[string]*core.Account),
+		accountsByKeyID:    make(map[string]*core.Account),
 		ordersByID:         make(map[string]*core.Order),
+		ordersByAccountID:  make(map[string][]*core.Order),
 		authorizationsByID: make(map[string]*core.Authorization),
 		challengesByID:     make(map[string]*core.Challenge),
 		certificatesByID:   make(map[string]*core.Certificate),
+
+		revokedCertificatesByID: make(map[string]time.Time),
+		revocationReasonsByID:   make(map[string]int),
+	}
+}
+
+// Pebble keeps all of its various objects (accounts, orders, etc)
+// in-memory, not persisted anywhere. MemoryStore implements this in-memory
+// "database". The objects are sharded by key so that load tests with many
+// concurrent clients aren't serialized on a single lock.
+type MemoryStore struct {
+	// The number of objects of each type in the store, updated atomically.
+	// They come first to be 64-bit aligned on 32-bit platforms.
+	accountCount       int64
+	orderCount         int64
+	authorizationCount int64
+	challengeCount     int64
+	certificateCount   int64
+
+	shards [shardCount]*shard
+
+	// compromisedKeyIDs holds the key IDs (see core.KeyToID) of certificates
+	// revoked for key compromise. It is only ever added to and read on every
+	// finalization, which is what a sync.Map is made for.
+	compromisedKeyIDs sync.Map
+
+	// externalAccountKeysByID holds the []byte HMAC keys used to verify
+	// external account bindings, indexed by the key ID given to the account
+	// holder. Keys are only added at startup.
+	externalAccountKeysByID sync.Map
+
+	// onChange is called by Changed, it is only set at startup
+	onChange func()
+}
+
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{}
+	for i := range m.shards {
+		m.shards[i] = newShard()
+	}
+	return m
+}
+
+// OnChange sets the function called after every change to the store or to an
+// object in it, e.g. to persist the store. It is called while locks are held
+// and must not block. OnChange must be called before the store is used.
+func (m *MemoryStore) OnChange(f func()) {
+	m.onChange = f
+}
+
+// Changed reports a change to the function set with OnChange. The store calls
+// it when objects are added, deleted or revoked, the WFE, VA and CA call it
+// after updating the objects they got from the store in place.
+func (m *MemoryStore) Changed() {
+	if m.onChange != nil {
+		m.onChange()
+	}
+}
+
+// shardIndex returns the index of the shard holding the objects with key.
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() & (shardCount - 1))
+}
+
+// shard returns the shard holding the objects with key.
+func (m *MemoryStore) shard(key string) *shard {
+	return m.shards[shardIndex(key)]
+}
+
+// lockShards write locks the shards holding the objects with the keys and
+// returns a function unlocking them. Shards are always locked in index order
+// so that callers locking several can't deadlock each other.
+func (m *MemoryStore) lockShards(keys ...string) func() {
+	var locked [shardCount]bool
+	for _, key := range keys {
+		locked[shardIndex(key)] = true
+	}
+	for i, l := range locked {
+		if l {
+			m.shards[i].Lock()
+		}
+	}
+	return func() {
+		for i := shardCount - 1; i >= 0; i-- {
+			if locked[i] {
+				m.shards[i].Unlock()
+			}
+		}
+	}
+}
+
+// rlockAll read locks every shard, in index order, for a consistent view of
+// the whole store and returns a function unlocking them.
+func (m *MemoryStore) rlockAll() func() {
+	for _, s := range m.shards {
+		s.RLock()
+	}
+	return func() {
+		for i := shardCount - 1; i >= 0; i-- {
+			m.shards[i].RUnlock()
+		}
 	}
 }
 
 func (m *MemoryStore) GetAccountByID(id string) *core.Account {
-	m.RLock()
-	defer m.RUnlock()
-	return m.accountsByID[id]
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.accountsByID[id]
 }
 
 func (m *MemoryStore) AddAccount(acct *core.Account) (int, error) {
-	m.Lock()
-	defer m.Unlock()
-
 	acctID := acct.ID
 	if len(acctID) == 0 {
 		return 0, fmt.Errorf("account must have a non-empty ID to add to MemoryStore")
@@ -55,68 +189,168 @@ func (m *MemoryStore) AddAccount(acct *core.Account) (int, error) {
 		return 0, fmt.Errorf("account must not have a nil Key")
 	}
 
-	if _, present := m.accountsByID[acctID]; present {
+	acct.RLock()
+	keyID, err := core.KeyToID(acct.Key)
+	acct.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockShards(acctID, keyID)
+	defer unlock()
+
+	if _, present := m.shard(acctID).accountsByID[acctID]; present {
 		return 0, fmt.Errorf("account %q already exists", acctID)
 	}
 
-	m.accountsByID[acctID] = acct
-	return len(m.accountsByID), nil
+	if _, present := m.shard(keyID).accountsByKeyID[keyID]; present {
+		return 0, fmt.Errorf("an account with key ID %q already exists", keyID)
+	}
+
+	m.shard(acctID).accountsByID[acctID] = acct
+	m.shard(keyID).accountsByKeyID[keyID] = acct
+	m.Changed()
+	return int(atomic.AddInt64(&m.accountCount, 1)), nil
 }
 
-func (m *MemoryStore) AddOrder(order *core.Order) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+// GetAccountByKey returns the account currently using the given key, or nil if
+// there is no such account.
+func (m *MemoryStore) GetAccountByKey(key crypto.PublicKey) (*core.Account, error) {
+	keyID, err := core.KeyToID(key)
+	if err != nil {
+		return nil, err
+	}
 
+	s := m.shard(keyID)
+	s.RLock()
+	defer s.RUnlock()
+	return s.accountsByKeyID[keyID], nil
+}
+
+// ChangeAccountKey atomically replaces the key of acct with newKey. If newKey is
+// already used by another account that account is returned along with an
+// error and no change is made.
+func (m *MemoryStore) ChangeAccountKey(acct *core.Account, newKey *jose.JSONWebKey) (*core.Account, error) {
+	if newKey == nil {
+		return nil, fmt.Errorf("new account key must not be nil")
+	}
+	acct.RLock()
+	oldKeyID, err := core.KeyToID(acct.Key)
+	acct.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	newKeyID, err := core.KeyToID(newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := m.lockShards(oldKeyID, newKeyID)
+	defer unlock()
+
+	if existing, present := m.shard(newKeyID).accountsByKeyID[newKeyID]; present {
+		return existing, fmt.Errorf("key ID %q is already in use by account %q", newKeyID, existing.ID)
+	}
+
+	delete(m.shard(oldKeyID).accountsByKeyID, oldKeyID)
+	acct.Lock()
+	acct.Key = newKey
+	acct.Unlock()
+	m.shard(newKeyID).accountsByKeyID[newKeyID] = acct
+	m.Changed()
+	return nil, nil
+}
+
+func (m *MemoryStore) AddOrder(order *core.Order) (int, error) {
 	order.RLock()
 	orderID := order.ID
+	acctID := order.AccountID
+	order.RUnlock()
 	if len(orderID) == 0 {
 		return 0, fmt.Errorf("order must have a non-empty ID to add to MemoryStore")
 	}
-	order.RUnlock()
 
-	if _, present := m.ordersByID[orderID]; present {
+	unlock := m.lockShards(orderID, acctID)
+	defer unlock()
+
+	if _, present := m.shard(orderID).ordersByID[orderID]; present {
 		return 0, fmt.Errorf("order %q already exists", orderID)
 	}
 
-	m.ordersByID[orderID] = order
-	return len(m.ordersByID), nil
+	m.shard(orderID).ordersByID[orderID] = order
+	acctShard := m.shard(acctID)
+	acctShard.ordersByAccountID[acctID] = append(acctShard.ordersByAccountID[acctID], order)
+	m.Changed()
+	return int(atomic.AddInt64(&m.orderCount, 1)), nil
 }
 
 func (m *MemoryStore) GetOrderByID(id string) *core.Order {
-	m.RLock()
-	defer m.RUnlock()
-	return m.ordersByID[id]
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.ordersByID[id]
 }
 
-func (m *MemoryStore) AddAuthorization(authz *core.Authorization) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+// GetOrdersByAccountID returns the orders created by the given account ID in
+// the order they were added.
+func (m *MemoryStore) GetOrdersByAccountID(acctID string) []*core.Order {
+	s := m.shard(acctID)
+	s.RLock()
+	defer s.RUnlock()
+	orders := s.ordersByAccountID[acctID]
+	result := make([]*core.Order, len(orders))
+	copy(result, orders)
+	return result
+}
 
+func (m *MemoryStore) AddAuthorization(authz *core.Authorization) (int, error) {
 	authz.RLock()
 	authzID := authz.ID
+	authz.RUnlock()
 	if len(authzID) == 0 {
 		return 0, fmt.Errorf("authz must have a non-empty ID to add to MemoryStore")
 	}
-	authz.RUnlock()
 
-	if _, present := m.authorizationsByID[authzID]; present {
+	s := m.shard(authzID)
+	s.Lock()
+	defer s.Unlock()
+
+	if _, present := s.authorizationsByID[authzID]; present {
 		return 0, fmt.Errorf("authz %q already exists", authzID)
 	}
 
-	m.authorizationsByID[authzID] = authz
-	return len(m.authorizationsByID), nil
+	s.authorizationsByID[authzID] = authz
+	m.Changed()
+	return int(atomic.AddInt64(&m.authorizationCount, 1)), nil
 }
 
 func (m *MemoryStore) GetAuthorizationByID(id string) *core.Authorization {
-	m.RLock()
-	defer m.RUnlock()
-	return m.authorizationsByID[id]
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.authorizationsByID[id]
 }
 
-func (m *MemoryStore) AddChallenge(chal *core.Challenge) (int, error) {
-	m.Lock()
-	defer m.Unlock()
+// GetAuthorizationsByAccountID returns all of the authorizations created for
+// the given account ID, in no particular order.
+func (m *MemoryStore) GetAuthorizationsByAccountID(acctID string) []*core.Authorization {
+	var authzs []*core.Authorization
+	for _, s := range m.shards {
+		s.RLock()
+		for _, authz := range s.authorizationsByID {
+			authz.RLock()
+			owned := authz.AccountID == acctID
+			authz.RUnlock()
+			if owned {
+				authzs = append(authzs, authz)
+			}
+		}
+		s.RUnlock()
+	}
+	return authzs
+}
 
+func (m *MemoryStore) AddChallenge(chal *core.Challenge) (int, error) {
 	chal.RLock()
 	chalID := chal.ID
 	chal.RUnlock()
@@ -124,39 +358,263 @@ func (m *MemoryStore) AddChallenge(chal *core.Challenge) (int, error) {
 		return 0, fmt.Errorf("challenge must have a non-empty ID to add to MemoryStore")
 	}
 
-	if _, present := m.challengesByID[chalID]; present {
+	s := m.shard(chalID)
+	s.Lock()
+	defer s.Unlock()
+
+	if _, present := s.challengesByID[chalID]; present {
 		return 0, fmt.Errorf("challenge %q already exists", chalID)
 	}
 
-	m.challengesByID[chalID] = chal
-	return len(m.challengesByID), nil
+	s.challengesByID[chalID] = chal
+	m.Changed()
+	return int(atomic.AddInt64(&m.challengeCount, 1)), nil
 }
 
 func (m *MemoryStore) GetChallengeByID(id string) *core.Challenge {
-	m.RLock()
-	defer m.RUnlock()
-	return m.challengesByID[id]
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.challengesByID[id]
 }
 
 func (m *MemoryStore) AddCertificate(cert *core.Certificate) (int, error) {
-	m.Lock()
-	defer m.Unlock()
-
 	certID := cert.ID
 	if len(certID) == 0 {
 		return 0, fmt.Errorf("cert must have a non-empty ID to add to MemoryStore")
 	}
 
-	if _, present := m.certificatesByID[certID]; present {
+	s := m.shard(certID)
+	s.Lock()
+	defer s.Unlock()
+
+	if _, present := s.certificatesByID[certID]; present {
 		return 0, fmt.Errorf("cert %q already exists", certID)
 	}
 
-	m.certificatesByID[certID] = cert
-	return len(m.certificatesByID), nil
+	s.certificatesByID[certID] = cert
+	m.Changed()
+	return int(atomic.AddInt64(&m.certificateCount, 1)), nil
 }
 
 func (m *MemoryStore) GetCertificateByID(id string) *core.Certificate {
-	m.RLock()
-	defer m.RUnlock()
-	return m.certificatesByID[id]
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.certificatesByID[id]
+}
+
+func (m *MemoryStore) AddExternalAccountKeyByID(keyID string, key []byte) error {
+	if len(keyID) == 0 {
+		return fmt.Errorf("external account key must have a non-empty key ID")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("external account key %q must not be empty", keyID)
+	}
+
+	if _, present := m.externalAccountKeysByID.LoadOrStore(keyID, key); present {
+		return fmt.Errorf("external account key %q already exists", keyID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetExternalAccountKeyByID(keyID string) ([]byte, bool) {
+	key, present := m.externalAccountKeysByID.Load(keyID)
+	if !present {
+		return nil, false
+	}
+	return key.([]byte), true
+}
+
+// RevokeCertificate marks the certificate with the given ID as revoked at the
+// given time for the given CRLReason, 0 if no reason is given. An error is
+// returned if the certificate doesn't exist or is already revoked. The key of
+// a certificate revoked for key compromise is remembered as compromised.
+func (m *MemoryStore) RevokeCertificate(id string, at time.Time, reason int) error {
+	s := m.shard(id)
+	s.Lock()
+	defer s.Unlock()
+
+	cert, present := s.certificatesByID[id]
+	if !present {
+		return fmt.Errorf("cert %q does not exist", id)
+	}
+	if _, revoked := s.revokedCertificatesByID[id]; revoked {
+		return fmt.Errorf("cert %q is already revoked", id)
+	}
+	if reason == acme.RevocationReasonKeyCompromise {
+		keyID, err := core.KeyToID(cert.Cert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("cert %q has an invalid key: %s", id, err.Error())
+		}
+		m.compromisedKeyIDs.Store(keyID, true)
+	}
+	s.revokedCertificatesByID[id] = at
+	if reason != 0 {
+		s.revocationReasonsByID[id] = reason
+	}
+	m.Changed()
+	return nil
+}
+
+// GetRevocationReasonByID returns the CRLReason the certificate with the given
+// ID was revoked for, or 0 if it isn't revoked or was revoked without a reason.
+func (m *MemoryStore) GetRevocationReasonByID(id string) int {
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	return s.revocationReasonsByID[id]
+}
+
+// IsKeyCompromised returns true if a certificate for the key with the given ID
+// (see core.KeyToID) was revoked for key compromise.
+func (m *MemoryStore) IsKeyCompromised(keyID string) bool {
+	_, compromised := m.compromisedKeyIDs.Load(keyID)
+	return compromised
+}
+
+// GetRevocationByID returns the time the certificate with the given ID was
+// revoked and true, or false if it isn't revoked.
+func (m *MemoryStore) GetRevocationByID(id string) (time.Time, bool) {
+	s := m.shard(id)
+	s.RLock()
+	defer s.RUnlock()
+	at, revoked := s.revokedCertificatesByID[id]
+	return at, revoked
+}
+
+// ListAccounts returns all of the accounts, sorted by ID.
+func (m *MemoryStore) ListAccounts() []*core.Account {
+	accts := make([]*core.Account, 0, atomic.LoadInt64(&m.accountCount))
+	for _, s := range m.shards {
+		s.RLock()
+		for _, acct := range s.accountsByID {
+			accts = append(accts, acct)
+		}
+		s.RUnlock()
+	}
+	sort.Slice(accts, func(i, j int) bool { return accts[i].ID < accts[j].ID })
+	return accts
+}
+
+// ListOrders returns all of the orders, sorted by ID.
+func (m *MemoryStore) ListOrders() []*core.Order {
+	orders := make([]*core.Order, 0, atomic.LoadInt64(&m.orderCount))
+	for _, s := range m.shards {
+		s.RLock()
+		for _, order := range s.ordersByID {
+			orders = append(orders, order)
+		}
+		s.RUnlock()
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders
+}
+
+// ListAuthorizations returns all of the authorizations, sorted by ID.
+func (m *MemoryStore) ListAuthorizations() []*core.Authorization {
+	authzs := make([]*core.Authorization, 0, atomic.LoadInt64(&m.authorizationCount))
+	for _, s := range m.shards {
+		s.RLock()
+		for _, authz := range s.authorizationsByID {
+			authzs = append(authzs, authz)
+		}
+		s.RUnlock()
+	}
+	sort.Slice(authzs, func(i, j int) bool { return authzs[i].ID < authzs[j].ID })
+	return authzs
+}
+
+// ListCertificates returns all of the certificates, sorted by ID.
+func (m *MemoryStore) ListCertificates() []*core.Certificate {
+	certs := make([]*core.Certificate, 0, atomic.LoadInt64(&m.certificateCount))
+	for _, s := range m.shards {
+		s.RLock()
+		for _, cert := range s.certificatesByID {
+			certs = append(certs, cert)
+		}
+		s.RUnlock()
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].ID < certs[j].ID })
+	return certs
+}
+
+// ListChallenges returns all of the challenges, sorted by ID.
+func (m *MemoryStore) ListChallenges() []*core.Challenge {
+	chals := make([]*core.Challenge, 0, atomic.LoadInt64(&m.challengeCount))
+	for _, s := range m.shards {
+		s.RLock()
+		for _, chal := range s.challengesByID {
+			chals = append(chals, chal)
+		}
+		s.RUnlock()
+	}
+	sort.Slice(chals, func(i, j int) bool { return chals[i].ID < chals[j].ID })
+	return chals
+}
+
+// DeleteOrders removes the orders from the store. The caller must not hold
+// the lock of any of them.
+func (m *MemoryStore) DeleteOrders(orders []*core.Order) {
+	for _, order := range orders {
+		order.RLock()
+		orderID := order.ID
+		acctID := order.AccountID
+		order.RUnlock()
+
+		unlock := m.lockShards(orderID, acctID)
+		orderShard := m.shard(orderID)
+		if orderShard.ordersByID[orderID] == order {
+			delete(orderShard.ordersByID, orderID)
+			atomic.AddInt64(&m.orderCount, -1)
+		}
+		acctShard := m.shard(acctID)
+		acctOrders := acctShard.ordersByAccountID[acctID]
+		kept := acctOrders[:0]
+		for _, o := range acctOrders {
+			if o != order {
+				kept = append(kept, o)
+			}
+		}
+		acctShard.ordersByAccountID[acctID] = kept
+		unlock()
+	}
+	if len(orders) > 0 {
+		m.Changed()
+	}
+}
+
+// DeleteAuthorizations removes the authorizations and their challenges from
+// the store. The caller must not hold the lock of any of them.
+func (m *MemoryStore) DeleteAuthorizations(authzs []*core.Authorization) {
+	if len(authzs) == 0 {
+		return
+	}
+	deleted := make(map[*core.Authorization]bool, len(authzs))
+	for _, authz := range authzs {
+		deleted[authz] = true
+
+		authz.RLock()
+		authzID := authz.ID
+		authz.RUnlock()
+		s := m.shard(authzID)
+		s.Lock()
+		if s.authorizationsByID[authzID] == authz {
+			delete(s.authorizationsByID, authzID)
+			atomic.AddInt64(&m.authorizationCount, -1)
+		}
+		s.Unlock()
+	}
+	// A challenge's authorization never changes, so it can be read unlocked
+	for _, s := range m.shards {
+		s.Lock()
+		for id, chal := range s.challengesByID {
+			if deleted[chal.Authz] {
+				delete(s.challengesByID, id)
+				atomic.AddInt64(&m.challengeCount, -1)
+			}
+		}
+		s.Unlock()
+	}
+	m.Changed()
 }
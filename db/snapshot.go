@@ -0,0 +1,356 @@
+package db
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// A Snapshot is a serializable copy of the objects in a MemoryStore. Pointers
+// between objects are replaced by the IDs of the objects they refer to.
+type Snapshot struct {
+	Accounts       []accountRecord       `json:"accounts"`
+	Orders         []orderRecord         `json:"orders"`
+	Authorizations []authorizationRecord `json:"authorizations"`
+	Challenges     []challengeRecord     `json:"challenges"`
+	Certificates   []certificateRecord   `json:"certificates"`
+	// Revocations maps the IDs of revoked certificates to their revocation time
+	Revocations map[string]time.Time `json:"revocations,omitempty"`
+	// RevocationReasons maps the IDs of certificates revoked with a reason to
+	// their CRLReason
+	RevocationReasons map[string]int `json:"revocationReasons,omitempty"`
+}
+
+type accountRecord struct {
+	ID                   string           `json:"id"`
+	Account              acme.Account     `json:"account"`
+	Key                  *jose.JSONWebKey `json:"key"`
+	AgreedTermsOfService string           `json:"agreedTermsOfService,omitempty"`
+}
+
+type orderRecord struct {
+	ID               string          `json:"id"`
+	AccountID        string          `json:"accountID"`
+	Order            acme.Order      `json:"order"`
+	CSR              []byte          `json:"csr,omitempty"`
+	ExpiresDate      time.Time       `json:"expiresDate"`
+	AuthorizationIDs []string        `json:"authorizationIDs"`
+	CertificateID    string          `json:"certificateID,omitempty"`
+	Annotation       json.RawMessage `json:"annotation,omitempty"`
+}
+
+type authorizationRecord struct {
+	ID            string             `json:"id"`
+	AccountID     string             `json:"accountID"`
+	URL           string             `json:"url"`
+	ExpiresDate   time.Time          `json:"expiresDate"`
+	ValidatedDate time.Time          `json:"validatedDate"`
+	Authorization acme.Authorization `json:"authorization"`
+	OrderID       string             `json:"orderID,omitempty"`
+	ChallengeIDs  []string           `json:"challengeIDs"`
+}
+
+type challengeRecord struct {
+	ID                string                   `json:"id"`
+	AuthorizationID   string                   `json:"authorizationID"`
+	Challenge         acme.Challenge           `json:"challenge"`
+	ValidatedDate     time.Time                `json:"validatedDate"`
+	ValidationRecords []*core.ValidationRecord `json:"validationRecords,omitempty"`
+}
+
+type certificateRecord struct {
+	ID       string `json:"id"`
+	DER      []byte `json:"der"`
+	IssuerID string `json:"issuerID,omitempty"`
+	// CrossSignedID is the ID of the cross-signed certificate of an
+	// intermediate, if it has one
+	CrossSignedID string `json:"crossSignedID,omitempty"`
+}
+
+// Snapshot returns a copy of all of the accounts, orders, authorizations,
+// challenges, certificates and revocations in the MemoryStore. External
+// account keys are not included, they are always loaded from the
+// configuration.
+func (m *MemoryStore) Snapshot() *Snapshot {
+	// Collect the objects while holding every shard lock, so that the
+	// snapshot is consistent, but only lock each object after releasing them.
+	// Callers may hold an object lock while calling the store, so a shard lock
+	// must never be held while waiting for one.
+	unlock := m.rlockAll()
+	var accts []*core.Account
+	var orders []*core.Order
+	var authzs []*core.Authorization
+	var chals []*core.Challenge
+	var certs []*core.Certificate
+	revocations := make(map[string]time.Time)
+	reasons := make(map[string]int)
+	for _, s := range m.shards {
+		for _, acct := range s.accountsByID {
+			accts = append(accts, acct)
+		}
+		// Orders are collected per account so that each account's orders
+		// list keeps its order when the snapshot is restored
+		for _, acctOrders := range s.ordersByAccountID {
+			orders = append(orders, acctOrders...)
+		}
+		for _, authz := range s.authorizationsByID {
+			authzs = append(authzs, authz)
+		}
+		for _, chal := range s.challengesByID {
+			chals = append(chals, chal)
+		}
+		for _, cert := range s.certificatesByID {
+			certs = append(certs, cert)
+		}
+		for id, at := range s.revokedCertificatesByID {
+			revocations[id] = at
+		}
+		for id, reason := range s.revocationReasonsByID {
+			reasons[id] = reason
+		}
+	}
+	unlock()
+
+	snap := &Snapshot{Revocations: revocations, RevocationReasons: reasons}
+	for _, acct := range accts {
+		acct.RLock()
+		snap.Accounts = append(snap.Accounts, accountRecord{
+			ID:                   acct.ID,
+			Account:              acct.Account,
+			Key:                  acct.Key,
+			AgreedTermsOfService: acct.AgreedTermsOfService,
+		})
+		acct.RUnlock()
+	}
+
+	for _, order := range orders {
+		order.RLock()
+		rec := orderRecord{
+			ID:          order.ID,
+			AccountID:   order.AccountID,
+			Order:       order.Order,
+			ExpiresDate: order.ExpiresDate,
+			Annotation:  order.Annotation,
+		}
+		if order.ParsedCSR != nil {
+			rec.CSR = order.ParsedCSR.Raw
+		}
+		for _, authz := range order.AuthorizationObjects {
+			rec.AuthorizationIDs = append(rec.AuthorizationIDs, authz.ID)
+		}
+		if order.CertificateObject != nil {
+			rec.CertificateID = order.CertificateObject.ID
+		}
+		order.RUnlock()
+		snap.Orders = append(snap.Orders, rec)
+	}
+
+	// An authorization's challenges are the acme.Challenge embedded in each of
+	// its core.Challenges, map them back to the challenge IDs
+	chalIDs := make(map[*acme.Challenge]string, len(chals))
+	for _, chal := range chals {
+		chal.RLock()
+		chalIDs[&chal.Challenge] = chal.ID
+		rec := challengeRecord{
+			ID:                chal.ID,
+			Challenge:         chal.Challenge,
+			ValidatedDate:     chal.ValidatedDate,
+			ValidationRecords: chal.ValidationRecords,
+		}
+		if chal.Authz != nil {
+			rec.AuthorizationID = chal.Authz.ID
+		}
+		chal.RUnlock()
+		snap.Challenges = append(snap.Challenges, rec)
+	}
+
+	for _, authz := range authzs {
+		authz.RLock()
+		rec := authorizationRecord{
+			ID:            authz.ID,
+			AccountID:     authz.AccountID,
+			URL:           authz.URL,
+			ExpiresDate:   authz.ExpiresDate,
+			ValidatedDate: authz.ValidatedDate,
+			Authorization: authz.Authorization,
+		}
+		rec.Authorization.Challenges = nil
+		for _, chal := range authz.Challenges {
+			rec.ChallengeIDs = append(rec.ChallengeIDs, chalIDs[chal])
+		}
+		if authz.Order != nil {
+			rec.OrderID = authz.Order.ID
+		}
+		authz.RUnlock()
+		snap.Authorizations = append(snap.Authorizations, rec)
+	}
+
+	for _, cert := range certs {
+		rec := certificateRecord{
+			ID:  cert.ID,
+			DER: cert.DER,
+		}
+		if cert.Issuer != nil {
+			rec.IssuerID = cert.Issuer.ID
+		}
+		if cert.CrossSigned != nil {
+			rec.CrossSignedID = cert.CrossSigned.ID
+		}
+		snap.Certificates = append(snap.Certificates, rec)
+	}
+	return snap
+}
+
+// Restore adds all of the objects in a Snapshot to the MemoryStore, relinking
+// the objects that refer to each other. It should only be used with an empty
+// MemoryStore.
+func (m *MemoryStore) Restore(snap *Snapshot) error {
+	certs := make(map[string]*core.Certificate, len(snap.Certificates))
+	for _, rec := range snap.Certificates {
+		cert, err := x509.ParseCertificate(rec.DER)
+		if err != nil {
+			return fmt.Errorf("certificate %q: %s", rec.ID, err.Error())
+		}
+		certs[rec.ID] = &core.Certificate{
+			ID:   rec.ID,
+			Cert: cert,
+			DER:  rec.DER,
+		}
+	}
+	for _, rec := range snap.Certificates {
+		if rec.CrossSignedID != "" {
+			cross, ok := certs[rec.CrossSignedID]
+			if !ok {
+				return fmt.Errorf("certificate %q has unknown cross-signed certificate %q", rec.ID, rec.CrossSignedID)
+			}
+			certs[rec.ID].CrossSigned = cross
+		}
+		if rec.IssuerID == "" {
+			continue
+		}
+		issuer, ok := certs[rec.IssuerID]
+		if !ok {
+			return fmt.Errorf("certificate %q has unknown issuer %q", rec.ID, rec.IssuerID)
+		}
+		certs[rec.ID].Issuer = issuer
+	}
+
+	for _, rec := range snap.Accounts {
+		acct := &core.Account{
+			Account:              rec.Account,
+			Key:                  rec.Key,
+			ID:                   rec.ID,
+			AgreedTermsOfService: rec.AgreedTermsOfService,
+		}
+		if _, err := m.AddAccount(acct); err != nil {
+			return err
+		}
+	}
+
+	chals := make(map[string]*core.Challenge, len(snap.Challenges))
+	for _, rec := range snap.Challenges {
+		chals[rec.ID] = &core.Challenge{
+			Challenge:         rec.Challenge,
+			ID:                rec.ID,
+			ValidatedDate:     rec.ValidatedDate,
+			ValidationRecords: rec.ValidationRecords,
+		}
+	}
+
+	authzs := make(map[string]*core.Authorization, len(snap.Authorizations))
+	for _, rec := range snap.Authorizations {
+		authz := &core.Authorization{
+			Authorization: rec.Authorization,
+			ID:            rec.ID,
+			AccountID:     rec.AccountID,
+			URL:           rec.URL,
+			ExpiresDate:   rec.ExpiresDate,
+			ValidatedDate: rec.ValidatedDate,
+		}
+		for _, chalID := range rec.ChallengeIDs {
+			chal, ok := chals[chalID]
+			if !ok {
+				return fmt.Errorf("authorization %q has unknown challenge %q", rec.ID, chalID)
+			}
+			authz.Challenges = append(authz.Challenges, &chal.Challenge)
+		}
+		authzs[rec.ID] = authz
+	}
+	for _, rec := range snap.Challenges {
+		if rec.AuthorizationID != "" {
+			chals[rec.ID].Authz = authzs[rec.AuthorizationID]
+		}
+	}
+
+	orders := make(map[string]*core.Order, len(snap.Orders))
+	for _, rec := range snap.Orders {
+		order := &core.Order{
+			Order:       rec.Order,
+			ID:          rec.ID,
+			AccountID:   rec.AccountID,
+			ExpiresDate: rec.ExpiresDate,
+			Annotation:  rec.Annotation,
+		}
+		if len(rec.CSR) > 0 {
+			csr, err := x509.ParseCertificateRequest(rec.CSR)
+			if err != nil {
+				return fmt.Errorf("order %q CSR: %s", rec.ID, err.Error())
+			}
+			order.ParsedCSR = csr
+		}
+		for _, authzID := range rec.AuthorizationIDs {
+			authz, ok := authzs[authzID]
+			if !ok {
+				return fmt.Errorf("order %q has unknown authorization %q", rec.ID, authzID)
+			}
+			order.AuthorizationObjects = append(order.AuthorizationObjects, authz)
+		}
+		if rec.CertificateID != "" {
+			cert, ok := certs[rec.CertificateID]
+			if !ok {
+				return fmt.Errorf("order %q has unknown certificate %q", rec.ID, rec.CertificateID)
+			}
+			order.CertificateObject = cert
+		}
+		orders[rec.ID] = order
+	}
+	for _, rec := range snap.Authorizations {
+		if rec.OrderID != "" {
+			authzs[rec.ID].Order = orders[rec.OrderID]
+		}
+	}
+
+	// Add the relinked objects to the store. Orders are added in the order of
+	// the snapshot so that each account's orders list keeps its order.
+	for _, cert := range certs {
+		if _, err := m.AddCertificate(cert); err != nil {
+			return err
+		}
+	}
+	for _, chal := range chals {
+		if _, err := m.AddChallenge(chal); err != nil {
+			return err
+		}
+	}
+	for _, authz := range authzs {
+		if _, err := m.AddAuthorization(authz); err != nil {
+			return err
+		}
+	}
+	for _, rec := range snap.Orders {
+		if _, err := m.AddOrder(orders[rec.ID]); err != nil {
+			return err
+		}
+	}
+	for id, at := range snap.Revocations {
+		if err := m.RevokeCertificate(id, at, snap.RevocationReasons[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
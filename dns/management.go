@@ -0,0 +1,222 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/letsencrypt/pebble/core"
+)
+
+// recordsPath lists the records of every host
+const recordsPath = "/records"
+
+// mgmtRequest is the body of a management API request. Each endpoint uses the
+// host and its own fields.
+type mgmtRequest struct {
+	Host      string        `json:"host"`
+	Addresses []string      `json:"addresses"`
+	Value     string        `json:"value"`
+	Tag       string        `json:"tag"`
+	Critical  bool          `json:"critical"`
+	Target    string        `json:"target"`
+	Delay     core.Duration `json:"delay"`
+}
+
+// mgmtUpdate changes the records of a host for a management API request
+type mgmtUpdate func(host *hostRecords, req mgmtRequest) error
+
+// mgmtUpdates are the management API endpoints changing records, by path
+var mgmtUpdates = map[string]mgmtUpdate{
+	"/add-a": func(host *hostRecords, req mgmtRequest) error {
+		ips, err := parseAddresses(req.Addresses, false)
+		if err != nil {
+			return err
+		}
+		host.a = append(host.a, ips...)
+		return nil
+	},
+	"/clear-a": func(host *hostRecords, _ mgmtRequest) error {
+		host.a = nil
+		return nil
+	},
+	"/add-aaaa": func(host *hostRecords, req mgmtRequest) error {
+		ips, err := parseAddresses(req.Addresses, true)
+		if err != nil {
+			return err
+		}
+		host.aaaa = append(host.aaaa, ips...)
+		return nil
+	},
+	"/clear-aaaa": func(host *hostRecords, _ mgmtRequest) error {
+		host.aaaa = nil
+		return nil
+	},
+	"/add-txt": func(host *hostRecords, req mgmtRequest) error {
+		host.txt = append(host.txt, req.Value)
+		return nil
+	},
+	"/clear-txt": func(host *hostRecords, _ mgmtRequest) error {
+		host.txt = nil
+		return nil
+	},
+	"/add-caa": func(host *hostRecords, req mgmtRequest) error {
+		if req.Tag == "" || len(req.Tag) > 255 {
+			return fmt.Errorf("tag must have 1 to 255 characters, got %q", req.Tag)
+		}
+		host.caa = append(host.caa, CAARecord{Critical: req.Critical, Tag: req.Tag, Value: req.Value})
+		return nil
+	},
+	"/clear-caa": func(host *hostRecords, _ mgmtRequest) error {
+		host.caa = nil
+		return nil
+	},
+	"/set-cname": func(host *hostRecords, req mgmtRequest) error {
+		target := normalizeName(req.Target)
+		if err := validHost(target); err != nil {
+			return err
+		}
+		host.cname = target
+		return nil
+	},
+	"/clear-cname": func(host *hostRecords, _ mgmtRequest) error {
+		host.cname = ""
+		return nil
+	},
+	"/set-servfail": func(host *hostRecords, _ mgmtRequest) error {
+		host.servFail = true
+		return nil
+	},
+	"/clear-servfail": func(host *hostRecords, _ mgmtRequest) error {
+		host.servFail = false
+		return nil
+	},
+	"/set-delay": func(host *hostRecords, req mgmtRequest) error {
+		if req.Delay.Duration <= 0 {
+			return fmt.Errorf("delay must be positive, got %s", req.Delay.Duration)
+		}
+		host.delay = req.Delay.Duration
+		return nil
+	},
+	"/clear-delay": func(host *hostRecords, _ mgmtRequest) error {
+		host.delay = 0
+		return nil
+	},
+}
+
+// parseAddresses parses the addresses of A records, or of AAAA records if v6
+// is true.
+func parseAddresses(addresses []string, v6 bool) ([]net.IP, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("addresses must not be empty")
+	}
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	var ips []net.IP
+	for _, addr := range addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil || (ip.To4() == nil) != v6 {
+			return nil, fmt.Errorf("%q is not an %s address", addr, family)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// Handler returns an http.Handler for the management API of the server. Every
+// endpoint but recordsPath takes a POST with a JSON body naming the host, e.g.
+// {"host": "example.com", "addresses": ["10.0.0.1"]} for "/add-a".
+func (s *MockServer) Handler() http.Handler {
+	m := http.NewServeMux()
+	for path, update := range mgmtUpdates {
+		m.HandleFunc(path, s.handleUpdate(path, update))
+	}
+	m.HandleFunc(recordsPath, s.handleRecords)
+	return m
+}
+
+func (s *MockServer) handleUpdate(path string, update mgmtUpdate) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" {
+			response.Header().Set("Allow", "POST")
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req mgmtRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(response, "Error unmarshaling body JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := normalizeName(req.Host)
+		if err := validHost(name); err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Lock()
+		defer s.Unlock()
+		// Changes are made to a copy so a failed update changes nothing
+		host := hostRecords{}
+		if existing := s.hosts[name]; existing != nil {
+			host = *existing
+		}
+		if err := update(&host, req); err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if host.empty() {
+			delete(s.hosts, name)
+		} else {
+			s.hosts[name] = &host
+		}
+		s.log.Printf("Mock DNS %s for %s\n", path, name)
+		response.WriteHeader(http.StatusOK)
+	}
+}
+
+// hostRecordsJSON is the JSON of a host in the records listing
+type hostRecordsJSON struct {
+	Host     string      `json:"host"`
+	A        []net.IP    `json:"a,omitempty"`
+	AAAA     []net.IP    `json:"aaaa,omitempty"`
+	TXT      []string    `json:"txt,omitempty"`
+	CAA      []CAARecord `json:"caa,omitempty"`
+	CNAME    string      `json:"cname,omitempty"`
+	ServFail bool        `json:"servfail,omitempty"`
+	Delay    string      `json:"delay,omitempty"`
+}
+
+// handleRecords lists the records and behaviors of every host, sorted by name.
+func (s *MockServer) handleRecords(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		response.Header().Set("Allow", "GET")
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.RLock()
+	hosts := make([]hostRecordsJSON, 0, len(s.hosts))
+	for name, host := range s.hosts {
+		h := hostRecordsJSON{
+			Host:     name,
+			A:        host.a,
+			AAAA:     host.aaaa,
+			TXT:      host.txt,
+			CAA:      host.caa,
+			CNAME:    host.cname,
+			ServFail: host.servFail,
+		}
+		if host.delay > 0 {
+			h.Delay = host.delay.String()
+		}
+		hosts = append(hosts, h)
+	}
+	s.RUnlock()
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+
+	response.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(response).Encode(hosts)
+}
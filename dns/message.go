@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// RR types answered by the mock server
+const (
+	typeA     = 1
+	typeCNAME = 5
+	typeTXT   = 16
+	typeAAAA  = 28
+	typeOPT   = 41
+	typeCAA   = 257
+	classIN   = 1
+)
+
+// Response codes
+const (
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+)
+
+const (
+	// minUDPSize is the largest UDP response sent for a query without an
+	// EDNS(0) record advertising a larger size
+	minUDPSize = 512
+	// answerTTL is the TTL of every answer, short so that resolvers in front
+	// of the mock server pick up changes quickly
+	answerTTL = 60
+)
+
+var errMalformedQuery = errors.New("malformed DNS query")
+
+// query is the part of a DNS query the mock server answers
+type query struct {
+	id uint16
+	// flags are the opcode and RD bit of the query
+	flags uint16
+	// question is the raw question section, which is echoed in the response
+	question []byte
+	name     string
+	qtype    uint16
+	// udpSize is the UDP payload size advertised by an EDNS(0) record, or
+	// zero if the query has none
+	udpSize int
+}
+
+// parseQuery parses a query with a single question. Names in queries are
+// never compressed, so compression pointers are rejected.
+func parseQuery(msg []byte) (*query, error) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 {
+		return nil, errMalformedQuery
+	}
+	q := &query{
+		id:    binary.BigEndian.Uint16(msg),
+		flags: binary.BigEndian.Uint16(msg[2:]) & 0x7900,
+	}
+	if binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return q, errMalformedQuery
+	}
+	off := 12
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return q, errMalformedQuery
+		}
+		length := int(msg[off])
+		if length == 0 {
+			off++
+			break
+		}
+		if length&0xC0 != 0 || off+1+length > len(msg) {
+			return q, errMalformedQuery
+		}
+		labels = append(labels, string(msg[off+1:off+1+length]))
+		off += 1 + length
+	}
+	if off+4 > len(msg) {
+		return q, errMalformedQuery
+	}
+	q.qtype = binary.BigEndian.Uint16(msg[off:])
+	off += 4
+	q.question = msg[12:off]
+	q.name = normalizeName(strings.Join(labels, "."))
+
+	// An EDNS(0) OPT record in the additional section is the only record
+	// queries have after the question, it is owned by the root name
+	if binary.BigEndian.Uint16(msg[10:]) > 0 && off+11 <= len(msg) && msg[off] == 0 &&
+		binary.BigEndian.Uint16(msg[off+1:]) == typeOPT {
+		q.udpSize = int(binary.BigEndian.Uint16(msg[off+3:]))
+	}
+	return q, nil
+}
+
+// maxUDPSize returns the size of the largest UDP response to the query.
+func (q *query) maxUDPSize() int {
+	if q.udpSize > minUDPSize {
+		return q.udpSize
+	}
+	return minUDPSize
+}
+
+// record is an answer record, with the RDATA already encoded
+type record struct {
+	name  string
+	rtype uint16
+	rdata []byte
+}
+
+// response returns the response to q with the rcode and answers. If the
+// response is longer than maxSize it is truncated to the question and has the
+// TC bit set, so that the client retries over TCP. A maxSize of zero doesn't
+// limit the size of the response.
+func (q *query) response(rcode int, answers []record, maxSize int) []byte {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg, q.id)
+	// QR, AA and RA are set, the opcode and RD are copied from the query
+	binary.BigEndian.PutUint16(msg[2:], 0x8480|q.flags|uint16(rcode))
+	if q.question == nil {
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = append(msg, q.question...)
+	header := len(msg)
+	for _, rr := range answers {
+		msg = appendName(msg, rr.name)
+		var fixed [10]byte
+		binary.BigEndian.PutUint16(fixed[0:], rr.rtype)
+		binary.BigEndian.PutUint16(fixed[2:], classIN)
+		binary.BigEndian.PutUint32(fixed[4:], answerTTL)
+		binary.BigEndian.PutUint16(fixed[8:], uint16(len(rr.rdata)))
+		msg = append(msg, fixed[:]...)
+		msg = append(msg, rr.rdata...)
+	}
+	if maxSize > 0 && len(msg) > maxSize {
+		msg = msg[:header]
+		msg[2] |= 0x02
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	return msg
+}
+
+// appendName appends the uncompressed wire format of name to msg.
+func appendName(msg []byte, name string) []byte {
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0)
+}
+
+// txtRData returns the RDATA of a TXT record with the value, split into
+// character strings of at most 255 bytes.
+func txtRData(value string) []byte {
+	var rdata []byte
+	for {
+		chunk := value
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		rdata = append(rdata, byte(len(chunk)))
+		rdata = append(rdata, chunk...)
+		value = value[len(chunk):]
+		if value == "" {
+			return rdata
+		}
+	}
+}
+
+// caaRData returns the RDATA of a CAA record.
+func caaRData(caa CAARecord) []byte {
+	var flags byte
+	if caa.Critical {
+		flags = 0x80
+	}
+	rdata := []byte{flags, byte(len(caa.Tag))}
+	rdata = append(rdata, caa.Tag...)
+	return append(rdata, caa.Value...)
+}
+
+// normalizeName returns name lowercased without a trailing dot, the form
+// names are stored and looked up in.
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,310 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	// maxCNAMEs is how many CNAMEs are followed for an answer, so that a CNAME
+	// loop can't hang the server
+	maxCNAMEs = 8
+	// tcpIdleTimeout is how long a TCP connection is kept open without queries
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// MockServerConfig configures the mock DNS server.
+type MockServerConfig struct {
+	// ListenAddress is the host:port the server answers queries on, over both
+	// UDP and TCP. The server isn't started when it is empty.
+	ListenAddress string
+	// ManagementListenAddress is where the HTTP API that changes the records
+	// of the server is served, it isn't started when empty
+	ManagementListenAddress string
+	// DefaultIPv4 and DefaultIPv6 are the A and AAAA answers for names
+	// without records of their own, e.g. "127.0.0.1" so that HTTP-01 and
+	// TLS-ALPN-01 validations of any name connect to the local host. Names
+	// without records are NXDOMAIN when both are empty.
+	DefaultIPv4 string
+	DefaultIPv6 string
+}
+
+// Validate returns an error if a default address isn't an IP address of its
+// family.
+func (c MockServerConfig) Validate() error {
+	if c.DefaultIPv4 != "" {
+		if ip := net.ParseIP(c.DefaultIPv4); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("defaultIPv4 must be an IPv4 address, got %q", c.DefaultIPv4)
+		}
+	}
+	if c.DefaultIPv6 != "" {
+		if ip := net.ParseIP(c.DefaultIPv6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("defaultIPv6 must be an IPv6 address, got %q", c.DefaultIPv6)
+		}
+	}
+	return nil
+}
+
+// CAARecord is a CAA record of a host
+type CAARecord struct {
+	Critical bool   `json:"critical,omitempty"`
+	Tag      string `json:"tag"`
+	Value    string `json:"value"`
+}
+
+// hostRecords are the records and behaviors of a host name
+type hostRecords struct {
+	a     []net.IP
+	aaaa  []net.IP
+	txt   []string
+	caa   []CAARecord
+	cname string
+	// servFail makes every query for the host fail with SERVFAIL
+	servFail bool
+	// delay is how long the server waits before answering queries for the
+	// host
+	delay time.Duration
+}
+
+func (h *hostRecords) empty() bool {
+	return len(h.a) == 0 && len(h.aaaa) == 0 && len(h.txt) == 0 && len(h.caa) == 0 &&
+		h.cname == "" && !h.servFail && h.delay == 0
+}
+
+// MockServer is an authoritative DNS server for every name, answering A, AAAA,
+// TXT, CAA and CNAME queries with the records set through its management API.
+// The VA can use it as its resolver so that DNS-01 and CAA tests need no real
+// DNS.
+type MockServer struct {
+	log         *logging.Logger
+	defaultIPv4 net.IP
+	defaultIPv6 net.IP
+
+	sync.RWMutex
+	hosts map[string]*hostRecords
+}
+
+// NewMockServer creates a MockServer without any records. The config must have
+// been validated.
+func NewMockServer(log *logging.Logger, config MockServerConfig) *MockServer {
+	return &MockServer{
+		log:         log,
+		defaultIPv4: net.ParseIP(config.DefaultIPv4),
+		defaultIPv6: net.ParseIP(config.DefaultIPv6),
+		hosts:       make(map[string]*hostRecords),
+	}
+}
+
+// Listen binds the UDP and TCP listeners of addr. If addr has no port the TCP
+// listener is bound to the port picked for UDP.
+func Listen(addr string) (net.PacketConn, net.Listener, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		_ = udp.Close()
+		return nil, nil, err
+	}
+	_, port, _ := net.SplitHostPort(udp.LocalAddr().String())
+	tcp, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		_ = udp.Close()
+		return nil, nil, err
+	}
+	return udp, tcp, nil
+}
+
+// ServeUDP answers the queries received on conn until it is closed.
+func (s *MockServer) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.answer(msg, true); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// ServeTCP answers the queries of the connections accepted by listener until
+// it is closed.
+func (s *MockServer) ServeTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+// serveTCPConn answers the length prefixed queries of conn, in order, until
+// the client closes it or is idle for too long.
+func (s *MockServer) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		_ = conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		resp := s.answer(msg, false)
+		if resp == nil {
+			return
+		}
+		prefixed := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(resp)))
+		if _, err := conn.Write(append(prefixed, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the response to the query msg, or nil if msg is too garbled
+// to respond to. UDP responses are truncated to the size the query accepts.
+func (s *MockServer) answer(msg []byte, udp bool) []byte {
+	q, err := parseQuery(msg)
+	if q == nil {
+		return nil
+	}
+	if err != nil {
+		return q.response(rcodeFormErr, nil, 0)
+	}
+	maxSize := 0
+	if udp {
+		maxSize = q.maxUDPSize()
+	}
+
+	s.RLock()
+	host := s.hosts[q.name]
+	var delay time.Duration
+	servFail := false
+	if host != nil {
+		delay, servFail = host.delay, host.servFail
+	}
+	s.RUnlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if servFail {
+		s.log.Printf("Mock DNS answered %s query for %s with SERVFAIL\n", typeName(q.qtype), q.name)
+		return q.response(rcodeServFail, nil, maxSize)
+	}
+	rcode, answers := s.lookup(q.name, q.qtype)
+	s.log.Printf("Mock DNS answered %s query for %s with %d records\n",
+		typeName(q.qtype), q.name, len(answers))
+	return q.response(rcode, answers, maxSize)
+}
+
+// lookup returns the rcode and answers for the name and RR type, following
+// CNAMEs. The answers of a CNAME are the CNAME record followed by the answers
+// for its target.
+func (s *MockServer) lookup(name string, qtype uint16) (int, []record) {
+	s.RLock()
+	defer s.RUnlock()
+	var answers []record
+	for i := 0; i <= maxCNAMEs; i++ {
+		host := s.hosts[name]
+		if host != nil && host.cname != "" && qtype != typeCNAME {
+			answers = append(answers, record{name: name, rtype: typeCNAME, rdata: appendName(nil, host.cname)})
+			name = host.cname
+			continue
+		}
+		answers = append(answers, s.records(name, host, qtype)...)
+		if host == nil && s.defaultIPv4 == nil && s.defaultIPv6 == nil && len(answers) == 0 {
+			return rcodeNXDomain, nil
+		}
+		return rcodeSuccess, answers
+	}
+	s.log.Printf("Mock DNS stopped following CNAMEs of %s after %d\n", name, maxCNAMEs)
+	return rcodeServFail, nil
+}
+
+// records returns the records of the RR type of the host with the name, which
+// is nil if the name has no records. Hosts without A or AAAA records have the
+// default address of the type, if there is one.
+func (s *MockServer) records(name string, host *hostRecords, qtype uint16) []record {
+	if host == nil {
+		host = &hostRecords{}
+	}
+	var answers []record
+	switch qtype {
+	case typeA, typeAAAA:
+		ips, def := host.a, s.defaultIPv4.To4()
+		if qtype == typeAAAA {
+			ips, def = host.aaaa, s.defaultIPv6
+		}
+		if len(ips) == 0 && def != nil {
+			ips = []net.IP{def}
+		}
+		for _, ip := range ips {
+			rdata := []byte(ip.To4())
+			if qtype == typeAAAA {
+				rdata = ip.To16()
+			}
+			answers = append(answers, record{name: name, rtype: qtype, rdata: rdata})
+		}
+	case typeTXT:
+		for _, txt := range host.txt {
+			answers = append(answers, record{name: name, rtype: typeTXT, rdata: txtRData(txt)})
+		}
+	case typeCAA:
+		for _, caa := range host.caa {
+			answers = append(answers, record{name: name, rtype: typeCAA, rdata: caaRData(caa)})
+		}
+	case typeCNAME:
+		if host.cname != "" {
+			answers = append(answers, record{name: name, rtype: typeCNAME, rdata: appendName(nil, host.cname)})
+		}
+	}
+	return answers
+}
+
+// typeName returns the mnemonic of an RR type for logging.
+func typeName(qtype uint16) string {
+	switch qtype {
+	case typeA:
+		return "A"
+	case typeAAAA:
+		return "AAAA"
+	case typeTXT:
+		return "TXT"
+	case typeCAA:
+		return "CAA"
+	case typeCNAME:
+		return "CNAME"
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// validHost returns an error if name can't be the owner of records.
+func validHost(name string) error {
+	if name == "" {
+		return fmt.Errorf("host must not be empty")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("host %q has an empty or too long label", name)
+		}
+	}
+	return nil
+}
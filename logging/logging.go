@@ -0,0 +1,118 @@
+// Package logging provides the logger used by the Pebble components. Every
+// line can be tagged with the ID of the ACME request that caused it, so that
+// the handling of a request can be followed from the WFE through validation in
+// the VA and issuance in the CA.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// A Format is the output format of a Logger.
+type Format string
+
+const (
+	// FormatText logs plain text lines prefixed with "Pebble " and the date
+	// and time
+	FormatText Format = "text"
+	// FormatJSON logs one JSON object per line
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the Format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatText, FormatJSON:
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unknown log format %q, must be %q or %q",
+		name, FormatText, FormatJSON)
+}
+
+// A Logger writes log lines in a Format. It is safe for concurrent use.
+type Logger struct {
+	out       *log.Logger
+	format    Format
+	requestID string
+}
+
+// New returns a Logger writing to w in the given format.
+func New(w io.Writer, format Format) *Logger {
+	if format == FormatJSON {
+		return &Logger{out: log.New(w, "", 0), format: format}
+	}
+	return &Logger{out: log.New(w, "Pebble ", log.LstdFlags), format: FormatText}
+}
+
+// WithRequestID returns a Logger that tags every line with the request ID.
+func (l *Logger) WithRequestID(id string) *Logger {
+	return &Logger{out: l.out, format: l.format, requestID: id}
+}
+
+// WithContext returns a Logger that tags every line with the request ID of
+// ctx, if it has one.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id := RequestID(ctx)
+	if id == "" {
+		return l
+	}
+	return l.WithRequestID(id)
+}
+
+type jsonLine struct {
+	Time      string `json:"time"`
+	RequestID string `json:"requestID,omitempty"`
+	Message   string `json:"msg"`
+}
+
+// Printf logs a line formatted like fmt.Printf. A trailing newline is
+// optional.
+func (l *Logger) Printf(format string, v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+	if l.format != FormatJSON {
+		if l.requestID != "" {
+			msg = "[" + l.requestID + "] " + msg
+		}
+		l.out.Println(msg)
+		return
+	}
+	line, err := json.Marshal(jsonLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		RequestID: l.requestID,
+		Message:   msg,
+	})
+	if err != nil {
+		// Marshalling a struct of strings can't fail but never drop the line
+		l.out.Println(msg)
+		return
+	}
+	l.out.Println(string(line))
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a new random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
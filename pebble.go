@@ -0,0 +1,199 @@
+// Package pebble runs a Pebble ACME server in-process, so that Go ACME client
+// test suites can serve it with net/http/httptest instead of running the
+// pebble binary:
+//
+//	srv, err := pebble.NewServer(pebble.Config{})
+//	...
+//	defer srv.Close()
+//	ts := httptest.NewTLSServer(srv.Handler())
+//	defer ts.Close()
+//	srv.PreSolve(acme.Identifier{Type: acme.IdentifierDNS, Value: "example.com"})
+//	// Point the client at ts.URL + pebble.DirectoryPath and trust ts.Certificate()
+//
+// The server keeps all of its state in memory and generates new CA keys every
+// time it is created.
+package pebble
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/wfe"
+)
+
+// DirectoryPath is the path of the ACME directory served by Server.Handler
+const DirectoryPath = "/dir"
+
+// Config holds the settings of an embedded server. The zero value is a usable
+// server that validates challenges without sleeping.
+type Config struct {
+	// HTTPPort and TLSPort are the ports HTTP-01 and TLS-SNI-02/TLS-ALPN-01
+	// validations connect to, by default 5002 and 5001
+	HTTPPort int
+	TLSPort  int
+	// ValidationDelay is the range of the random sleep before each validation
+	// attempt. Unlike the pebble binary there is no sleep when it is zero.
+	ValidationDelay core.DelayRange
+	// IssuanceDelay is the range of the random delay before issuance
+	IssuanceDelay core.DelayRange
+	// PreAuthorization enables the newAuthz endpoint
+	PreAuthorization bool
+	// ExternalAccountBindingRequired makes an external account binding
+	// mandatory for new accounts, with the HMAC keys of ExternalAccountMACKeys
+	ExternalAccountBindingRequired bool
+	ExternalAccountMACKeys         map[string][]byte
+	// DirectoryMeta populates the meta object of the directory
+	DirectoryMeta wfe.DirectoryMeta
+	// AuthzReuse configures the reuse of valid authorizations by new orders
+	AuthzReuse wfe.AuthzReuse
+	// Profiles are the certificate profiles newOrder requests can select
+	Profiles []ca.Profile
+	// Lifetimes configures the lifetimes of orders, authorizations and nonces
+	// and the sweeper that expires them. Tests with a fake Clock are swept on
+	// the real clock's schedule.
+	Lifetimes wfe.Lifetimes
+	// ValidityWindow limits the notBefore and notAfter of newOrder requests
+	ValidityWindow wfe.ValidityWindow
+	// AllowLegacyGET accepts plain GETs of orders, authorizations,
+	// challenges and certificates instead of requiring POST-as-GET
+	AllowLegacyGET bool
+	// Log receives the server's log, which is discarded when it is nil
+	Log io.Writer
+	// Clock is the clock of the server, by default the system clock. Tests can
+	// use a fake clock to expire authorizations and orders.
+	Clock clock.Clock
+}
+
+// Server is an in-process Pebble ACME server.
+type Server struct {
+	db  *db.MemoryStore
+	va  *va.VAImpl
+	ca  *ca.CAImpl
+	wfe wfe.WebFrontEndImpl
+}
+
+// NewServer creates the database, VA, CA and WFE of a server configured by
+// config.
+func NewServer(config Config) (*Server, error) {
+	if config.Log == nil {
+		config.Log = ioutil.Discard
+	}
+	if config.Clock == nil {
+		config.Clock = clock.Default()
+	}
+	if err := config.ValidationDelay.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.IssuanceDelay.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.DirectoryMeta.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.AuthzReuse.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Lifetimes.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.ValidityWindow.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ca.CheckProfiles(config.Profiles); err != nil {
+		return nil, err
+	}
+
+	logger := logging.New(config.Log, logging.FormatText)
+	memStore := db.NewMemoryStore()
+	for keyID, key := range config.ExternalAccountMACKeys {
+		if err := memStore.AddExternalAccountKeyByID(keyID, key); err != nil {
+			return nil, err
+		}
+	}
+	theVA := va.New(logger, config.Clock, va.Config{
+		HTTPPort:           config.HTTPPort,
+		TLSPort:            config.TLSPort,
+		SleepRange:         config.ValidationDelay,
+		NoSleep:            config.ValidationDelay.IsZero(),
+		ValidAuthzLifetime: config.Lifetimes.ValidAuthorization.Duration,
+	})
+	theCA := ca.New(logger, memStore, config.Clock, ca.Config{
+		RandomDelay: config.IssuanceDelay,
+		Profiles:    config.Profiles,
+	})
+	theWFE := wfe.New(logger, config.Clock, memStore, theVA, theCA, wfe.Config{
+		ExternalAccountBindingRequired: config.ExternalAccountBindingRequired,
+		PreAuthorization:               config.PreAuthorization,
+		DirectoryMeta:                  config.DirectoryMeta,
+		AuthzReuse:                     config.AuthzReuse,
+		Lifetimes:                      config.Lifetimes,
+		ValidityWindow:                 config.ValidityWindow,
+		AllowLegacyGET:                 config.AllowLegacyGET,
+	})
+	return &Server{
+		db:  memStore,
+		va:  theVA,
+		ca:  theCA,
+		wfe: theWFE,
+	}, nil
+}
+
+// Close stops the goroutines of the server: the validations in progress are
+// cancelled and nothing is validated or expired anymore. The handlers keep
+// serving and orders are still issued.
+func (s *Server) Close() {
+	s.wfe.Close()
+	s.va.Close()
+	s.ca.Close()
+}
+
+// Handler returns the http.Handler of the ACME API.
+func (s *Server) Handler() http.Handler {
+	return s.wfe.Handler()
+}
+
+// ManagementHandler returns the http.Handler of the management API, see the
+// README for its endpoints.
+func (s *Server) ManagementHandler() http.Handler {
+	return s.wfe.ManagementHandler()
+}
+
+// ReadOnlyManagementHandler returns the http.Handler of the management API
+// that only serves GET requests.
+func (s *Server) ReadOnlyManagementHandler() http.Handler {
+	return s.wfe.ReadOnlyManagementHandler()
+}
+
+// RootCertificate returns the root CA certificate the server's certificates
+// chain to.
+func (s *Server) RootCertificate() *x509.Certificate {
+	return s.ca.RootCert().Cert
+}
+
+// RootPEM returns the PEM encoded root CA certificate.
+func (s *Server) RootPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.RootCert().DER})
+}
+
+// PreSolve makes every challenge of ident valid as soon as the client POSTs
+// it, without Pebble contacting the client's challenge servers. Wildcard
+// identifiers are pre-solved by their base domain.
+func (s *Server) PreSolve(ident acme.Identifier) {
+	s.va.PreSolve(ident)
+}
+
+// RegisterValidationMethod adds a challenge type to the server's VA, see
+// va.ValidationMethod.
+func (s *Server) RegisterValidationMethod(method va.ValidationMethod) {
+	s.va.RegisterMethod(method)
+}
@@ -0,0 +1,30 @@
+package pebble
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCloseStopsBackgroundGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		srv, err := NewServer(Config{})
+		if err != nil {
+			t.Fatalf("NewServer() = %s", err)
+		}
+		srv.Close()
+		// Closing again is a no-op
+		srv.Close()
+	}
+
+	// The goroutines notice they were stopped asynchronously
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines a second after closing 5 servers, %d before creating them",
+				runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
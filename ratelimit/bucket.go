@@ -0,0 +1,77 @@
+// Package ratelimit provides the token bucket rate limiters Pebble uses to
+// simulate CA rate limits. They are independent of the WFE so that embedders
+// can attach the same limiters to their own endpoints.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+// TokenBucket is a token bucket rate limiter. It holds at most capacity tokens
+// and is refilled continuously at a rate of capacity tokens per window. Each
+// allowed event takes one token.
+type TokenBucket struct {
+	sync.Mutex
+	clk      clock.Clock
+	capacity float64
+	// the number of tokens added per nanosecond
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket that allows capacity events per
+// window, all of which may happen in a burst.
+func NewTokenBucket(clk clock.Clock, capacity int, window time.Duration) *TokenBucket {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if window <= 0 {
+		window = time.Nanosecond
+	}
+	return &TokenBucket{
+		clk:      clk,
+		capacity: float64(capacity),
+		rate:     float64(capacity) / float64(window),
+		tokens:   float64(capacity),
+		last:     clk.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last refill. The bucket must be
+// locked by the caller.
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+float64(elapsed)*b.rate)
+	}
+	b.last = now
+}
+
+// Take attempts to take a token from the bucket. If no token is available it
+// returns false and how long the caller has to wait before one will be.
+func (b *TokenBucket) Take() (bool, time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(b.clk.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration(math.Ceil((1 - b.tokens) / b.rate))
+	return false, wait
+}
+
+// Full returns true if the bucket is at capacity, i.e. it has seen no events
+// for at least a full window.
+func (b *TokenBucket) Full() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refill(b.clk.Now())
+	return b.tokens >= b.capacity
+}
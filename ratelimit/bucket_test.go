@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	clk := clock.NewFake()
+	b := NewTokenBucket(clk, 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if ok, wait := b.Take(); !ok || wait != 0 {
+			t.Fatalf("Take() %d of the burst = %t, %s, want true, 0", i+1, ok, wait)
+		}
+	}
+	ok, wait := b.Take()
+	if ok {
+		t.Fatalf("Take() after the burst was allowed")
+	}
+	// One token is refilled every 20 seconds
+	if wait != 20*time.Second {
+		t.Errorf("Take() after the burst waits %s, want 20s", wait)
+	}
+	if b.Full() {
+		t.Errorf("Full() of an exhausted bucket = true")
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	clk := clock.NewFake()
+	b := NewTokenBucket(clk, 2, time.Minute)
+	b.Take()
+	b.Take()
+
+	// Half a token isn't enough, the wait is for the rest of it
+	clk.Add(15 * time.Second)
+	if ok, wait := b.Take(); ok || wait != 15*time.Second {
+		t.Errorf("Take() with half a token = %t, %s, want false, 15s", ok, wait)
+	}
+	clk.Add(15 * time.Second)
+	if ok, _ := b.Take(); !ok {
+		t.Errorf("Take() after refilling a token was rejected")
+	}
+	if ok, _ := b.Take(); ok {
+		t.Errorf("Take() of a second token after refilling only one was allowed")
+	}
+
+	// Refilling never goes over capacity
+	clk.Add(time.Hour)
+	if !b.Full() {
+		t.Errorf("Full() after a long wait = false")
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Take(); !ok {
+			t.Fatalf("Take() %d after refilling the bucket was rejected", i+1)
+		}
+	}
+	if ok, _ := b.Take(); ok {
+		t.Errorf("Take() beyond capacity after a long wait was allowed")
+	}
+}
+
+func TestNewTokenBucketMinimums(t *testing.T) {
+	clk := clock.NewFake()
+	b := NewTokenBucket(clk, 0, 0)
+	if ok, _ := b.Take(); !ok {
+		t.Fatalf("Take() from a bucket with no capacity was rejected, want a capacity of 1")
+	}
+	if ok, _ := b.Take(); ok {
+		t.Errorf("second Take() from a bucket with a capacity of 1 was allowed")
+	}
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// pruneThreshold is the number of buckets a Limiter holds before it discards
+// the ones that are full (and so carry no state worth keeping).
+const pruneThreshold = 1024
+
+// Limiter applies an independent TokenBucket to each key, e.g. one bucket per
+// account or per client IP.
+type Limiter struct {
+	sync.Mutex
+	clk      clock.Clock
+	capacity int
+	window   time.Duration
+	buckets  map[string]*TokenBucket
+}
+
+// NewLimiter returns a Limiter allowing capacity events per window for every
+// key.
+func NewLimiter(clk clock.Clock, capacity int, window time.Duration) *Limiter {
+	return &Limiter{
+		clk:      clk,
+		capacity: capacity,
+		window:   window,
+		buckets:  make(map[string]*TokenBucket),
+	}
+}
+
+// Allow records an event for key. If the limit for key has been exceeded it
+// returns false and how long until the next event would be allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.Lock()
+	bucket, present := l.buckets[key]
+	if !present {
+		if len(l.buckets) >= pruneThreshold {
+			l.prune()
+		}
+		bucket = NewTokenBucket(l.clk, l.capacity, l.window)
+		l.buckets[key] = bucket
+	}
+	l.Unlock()
+
+	return bucket.Take()
+}
+
+// String describes the limit, e.g. "5 per 1h0m0s".
+func (l *Limiter) String() string {
+	return fmt.Sprintf("%d per %s", l.capacity, l.window)
+}
+
+// Matches returns true if the limiter enforces limit. A nil limiter matches
+// a disabled limit.
+func (l *Limiter) Matches(limit Limit) bool {
+	if l == nil {
+		return limit.Count == 0
+	}
+	return l.capacity == limit.Count && l.window == limit.Window.Duration
+}
+
+// prune removes buckets that are full. The limiter must be locked by the
+// caller.
+func (l *Limiter) prune() {
+	for key, bucket := range l.buckets {
+		if bucket.Full() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RetryAfter formats a wait duration as a Retry-After header value in whole
+// seconds, rounding up so clients never retry too early.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// Handler wraps next so that requests exceeding the limit for the key returned
+// by keyFunc are rejected with a rateLimited problem document and
+// a Retry-After header instead of being passed to next.
+func (l *Limiter) Handler(keyFunc func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		key := keyFunc(request)
+		if ok, wait := l.Allow(key); !ok {
+			prob := acme.RateLimitedProblem(fmt.Sprintf(
+				"Rate limit of %s exceeded for %q", l, key))
+			problemDoc, err := json.MarshalIndent(prob, "", "   ")
+			if err != nil {
+				problemDoc = []byte("{\"detail\": \"Problem marshalling error message.\"}")
+			}
+			response.Header().Set("Retry-After", RetryAfter(wait))
+			response.Header().Set("Content-Type", "application/problem+json")
+			response.WriteHeader(prob.HTTPStatus)
+			_, _ = response.Write(problemDoc)
+			return
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// Limit is the JSON configuration of a Limiter, e.g.
+// {"count": 5, "window": "1h"}.
+type Limit struct {
+	Count  int
+	Window core.Duration
+}
+
+// Validate returns an error if the count is negative or an enabled limit has
+// no window.
+func (l Limit) Validate() error {
+	if l.Count < 0 {
+		return fmt.Errorf("rate limit count %d can't be negative", l.Count)
+	}
+	if l.Count > 0 && l.Window.Duration <= 0 {
+		return fmt.Errorf("rate limit of %d needs a positive window", l.Count)
+	}
+	return nil
+}
+
+// NewLimiter returns a Limiter for the configured limit, or nil if the limit
+// has no count and so is disabled.
+func (l Limit) NewLimiter(clk clock.Clock) *Limiter {
+	if l.Count == 0 {
+		return nil
+	}
+	return NewLimiter(clk, l.Count, l.Window.Duration)
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/core"
+)
+
+func TestLimiterKeys(t *testing.T) {
+	clk := clock.NewFake()
+	l := NewLimiter(clk, 2, time.Hour)
+
+	for _, key := range []string{"acct-1", "acct-1"} {
+		if ok, _ := l.Allow(key); !ok {
+			t.Fatalf("Allow(%q) within the limit was rejected", key)
+		}
+	}
+	if ok, wait := l.Allow("acct-1"); ok || wait != 30*time.Minute {
+		t.Errorf("Allow(\"acct-1\") over the limit = %t, %s, want false, 30m", ok, wait)
+	}
+	// Every key has a bucket of its own
+	if ok, _ := l.Allow("acct-2"); !ok {
+		t.Errorf("Allow(\"acct-2\") was rejected by the limit of another key")
+	}
+}
+
+// limitedHandler returns a handler that allows one request per hour for each
+// key of keyFunc.
+func limitedHandler(clk clock.Clock, keyFunc func(*http.Request) string) http.Handler {
+	return NewLimiter(clk, 1, time.Hour).Handler(keyFunc, http.HandlerFunc(
+		func(response http.ResponseWriter, _ *http.Request) {
+			response.WriteHeader(http.StatusNoContent)
+		}))
+}
+
+func TestHandlerKeys(t *testing.T) {
+	perIP := func(request *http.Request) string {
+		host, _, _ := net.SplitHostPort(request.RemoteAddr)
+		return host
+	}
+	perAccount := func(request *http.Request) string {
+		return request.Header.Get("Account")
+	}
+
+	testCases := []struct {
+		name    string
+		keyFunc func(*http.Request) string
+		// requests are the remote IP and account of each request
+		requests [][2]string
+		want     []int
+	}{
+		{
+			name:     "per IP, accounts sharing an IP",
+			keyFunc:  perIP,
+			requests: [][2]string{{"10.0.0.1", "a"}, {"10.0.0.1", "b"}, {"10.0.0.2", "a"}},
+			want:     []int{http.StatusNoContent, http.StatusTooManyRequests, http.StatusNoContent},
+		},
+		{
+			name:     "per account, accounts sharing an IP",
+			keyFunc:  perAccount,
+			requests: [][2]string{{"10.0.0.1", "a"}, {"10.0.0.1", "b"}, {"10.0.0.2", "a"}},
+			want:     []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := limitedHandler(clock.NewFake(), tc.keyFunc)
+			for i, r := range tc.requests {
+				request := httptest.NewRequest("POST", "/new-order", nil)
+				request.RemoteAddr = net.JoinHostPort(r[0], "4321")
+				request.Header.Set("Account", r[1])
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				if response.Code != tc.want[i] {
+					t.Errorf("request %d from %s for account %s: status %d, want %d",
+						i+1, r[0], r[1], response.Code, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerRateLimitedProblem(t *testing.T) {
+	clk := clock.NewFake()
+	handler := limitedHandler(clk, func(*http.Request) string { return "key" })
+	serve := func() *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("POST", "/new-order", nil))
+		return response
+	}
+
+	serve()
+	clk.Add(59*time.Minute + 30*time.Second)
+	response := serve()
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d over the limit, want %d", response.Code, http.StatusTooManyRequests)
+	}
+	// The 30 seconds left are sent as is
+	if got := response.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After %q, want \"30\"", got)
+	}
+	if got := response.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Content-Type %q, want \"application/problem+json\"", got)
+	}
+	var prob struct {
+		Type   string
+		Status int
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &prob); err != nil {
+		t.Fatalf("unmarshalling problem %q: %s", response.Body.String(), err)
+	}
+	if prob.Type != "urn:ietf:params:acme:error:rateLimited" || prob.Status != http.StatusTooManyRequests {
+		t.Errorf("problem type %q and status %d, want rateLimited and %d",
+			prob.Type, prob.Status, http.StatusTooManyRequests)
+	}
+
+	clk.Add(30 * time.Second)
+	if response := serve(); response.Code != http.StatusNoContent {
+		t.Errorf("status %d after Retry-After, want %d", response.Code, http.StatusNoContent)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	testCases := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "0"},
+		{time.Nanosecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Hour, "3600"},
+	}
+	for _, tc := range testCases {
+		if got := RetryAfter(tc.wait); got != tc.want {
+			t.Errorf("RetryAfter(%s) = %q, want %q", tc.wait, got, tc.want)
+		}
+	}
+}
+
+func TestLimitNewLimiter(t *testing.T) {
+	clk := clock.NewFake()
+	if l := (Limit{}).NewLimiter(clk); l != nil {
+		t.Errorf("NewLimiter() of a zero limit = %s, want nil", l)
+	}
+	limit := Limit{Count: 5, Window: core.Duration{Duration: time.Hour}}
+	l := limit.NewLimiter(clk)
+	if !l.Matches(limit) {
+		t.Errorf("NewLimiter() of %+v = %s, which doesn't match it", limit, l)
+	}
+	if (*Limiter)(nil).Matches(limit) || !(*Limiter)(nil).Matches(Limit{}) {
+		t.Errorf("nil limiter must only match a disabled limit")
+	}
+}
@@ -0,0 +1,109 @@
+package va
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// CAAConfig enables CAA checking (RFC 8659) before authorizations become
+// valid.
+type CAAConfig struct {
+	// IssuerDomains are the issuer domain names of Pebble. Issuance is allowed
+	// when the relevant CAA records name one of them. CAA is not checked if it
+	// is empty.
+	IssuerDomains []string
+	// Resolver is the host:port of the DNS resolver CAA records are looked up
+	// with. The resolver of the VA's DNSConfig is used if it is empty.
+	Resolver string
+}
+
+// checkCAA returns a caa problem if the CAA records of the authorization's
+// identifier forbid Pebble from issuing for it. IP identifiers have no CAA
+// records.
+func (va VAImpl) checkCAA(authz *core.Authorization) *acme.ProblemDetails {
+	if len(va.caa.IssuerDomains) == 0 {
+		return nil
+	}
+	authz.RLock()
+	ident := authz.Identifier
+	wildcard := authz.Wildcard
+	authz.RUnlock()
+	if ident.Type != acme.IdentifierDNS {
+		return nil
+	}
+
+	records, err := va.relevantCAA(ident.Value)
+	if err != nil {
+		return acme.CAAProblem(fmt.Sprintf(
+			"Error looking up CAA records for %s: %s", ident.Value, err.Error()))
+	}
+	if va.caaPermits(records, wildcard) {
+		return nil
+	}
+	name := ident.Value
+	if wildcard {
+		name = "*." + name
+	}
+	return acme.CAAProblem(fmt.Sprintf("CAA records forbid issuance for %s", name))
+}
+
+// relevantCAA returns the CAA records of the closest of name and its parent
+// domains that has any.
+func (va VAImpl) relevantCAA(name string) ([]caaRecord, error) {
+	resolver := va.caa.Resolver
+	if resolver == "" {
+		resolver = va.dns.resolver()
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := range labels {
+		records, err := va.dns.lookupCAA(resolver, strings.Join(labels[i:], "."))
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+	return nil, nil
+}
+
+// caaPermits returns true if the relevant CAA records allow issuance by one
+// of the configured issuer domains. Wildcard names use the issuewild records
+// if there are any.
+func (va VAImpl) caaPermits(records []caaRecord, wildcard bool) bool {
+	var issue, issueWild []caaRecord
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issueWild = append(issueWild, record)
+		case "iodef", "issuemail", "issuevmc":
+		default:
+			// Unknown critical properties forbid issuance
+			if record.Critical {
+				return false
+			}
+		}
+	}
+	relevant := issue
+	if wildcard && len(issueWild) > 0 {
+		relevant = issueWild
+	}
+	if len(relevant) == 0 {
+		return true
+	}
+	for _, record := range relevant {
+		// The value is an issuer domain name optionally followed by parameters
+		domain := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+		for _, allowed := range va.caa.IssuerDomains {
+			if domain != "" && strings.EqualFold(domain, allowed) {
+				return true
+			}
+		}
+	}
+	return false
+}
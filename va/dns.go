@@ -0,0 +1,300 @@
+package va
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeTXT = 16
+	// dnsTypeOPT is the RR type of the EDNS(0) pseudo-record (RFC 6891)
+	dnsTypeOPT = 41
+	// dnsTypeCAA is the RR type of CAA records (RFC 8659)
+	dnsTypeCAA = 257
+	dnsClassIN = 1
+
+	// dnsMinUDPSize is the largest UDP response every resolver must accept,
+	// which is also the limit when EDNS(0) isn't used
+	dnsMinUDPSize = 512
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeNXDomain = 3
+
+	dnsTimeout = 5 * time.Second
+)
+
+// DNSConfig controls how the VA makes DNS queries, for DNS-01 challenges and
+// CAA checks.
+type DNSConfig struct {
+	// Resolver is the host:port of the DNS resolver queries are sent to. The
+	// first nameserver of /etc/resolv.conf is used if it is empty.
+	Resolver string
+	// UDPSize is the UDP payload size advertised with EDNS(0), the largest
+	// answer the resolver may send over UDP before truncating it. EDNS(0) is
+	// not used if it is zero, which limits UDP answers to 512 bytes.
+	UDPSize int
+	// DisableTCP stops truncated UDP answers from being retried over TCP, to
+	// simulate a network path where DNS over TCP is broken. Lookups with
+	// truncated answers then fail.
+	DisableTCP bool
+	// ResolveHosts makes the A and AAAA lookups of HTTP-01 and TLS-ALPN-01
+	// validations use the resolver too. They use the system's resolver
+	// otherwise.
+	ResolveHosts bool
+	// sourceAddr is the address queries are sent from, set by the VA
+	sourceAddr net.IP
+}
+
+// Validate returns an error if the UDPSize is out of range.
+func (c DNSConfig) Validate() error {
+	if c.UDPSize != 0 && (c.UDPSize < dnsMinUDPSize || c.UDPSize > 65535) {
+		return fmt.Errorf("udpSize must be 0 or from %d to 65535, got %d", dnsMinUDPSize, c.UDPSize)
+	}
+	return nil
+}
+
+// resolver returns the configured resolver or the system one.
+func (c DNSConfig) resolver() string {
+	if c.Resolver != "" {
+		return c.Resolver
+	}
+	return systemResolver()
+}
+
+// caaRecord is the RDATA of a CAA resource record
+type caaRecord struct {
+	Critical bool
+	Tag      string
+	Value    string
+}
+
+// systemResolver returns the address of the first nameserver in
+// /etc/resolv.conf, or "127.0.0.1:53" if there is none.
+func systemResolver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// lookupCAA queries the resolver for the CAA records of name. The stdlib
+// resolver can't look up CAA records so the query is made directly.
+func (c DNSConfig) lookupCAA(resolver, name string) ([]caaRecord, error) {
+	rdatas, err := c.lookup(resolver, name, dnsTypeCAA)
+	if err != nil {
+		return nil, err
+	}
+	var records []caaRecord
+	for _, rdata := range rdatas {
+		if len(rdata) < 2 || 2+int(rdata[1]) > len(rdata) {
+			return nil, errors.New("malformed CAA record")
+		}
+		tagLength := int(rdata[1])
+		records = append(records, caaRecord{
+			Critical: rdata[0]&0x80 != 0,
+			Tag:      string(rdata[2 : 2+tagLength]),
+			Value:    string(rdata[2+tagLength:]),
+		})
+	}
+	return records, nil
+}
+
+// lookupTXT queries the resolver for the TXT records of name. Like
+// net.LookupTXT the strings of each record are concatenated.
+func (c DNSConfig) lookupTXT(name string) ([]string, error) {
+	rdatas, err := c.lookup(c.resolver(), name, dnsTypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, rdata := range rdatas {
+		var txt []byte
+		for off := 0; off < len(rdata); {
+			length := int(rdata[off])
+			if off+1+length > len(rdata) {
+				return nil, errors.New("malformed TXT record")
+			}
+			txt = append(txt, rdata[off+1:off+1+length]...)
+			off += 1 + length
+		}
+		txts = append(txts, string(txt))
+	}
+	return txts, nil
+}
+
+// lookup queries the resolver for the records of name with the RR type and
+// returns their RDATA. The query is retried over TCP if the UDP response is
+// truncated, unless TCP is disabled.
+func (c DNSConfig) lookup(resolver, name string, qtype uint16) ([][]byte, error) {
+	query, id, err := dnsQuery(name, qtype, c.UDPSize)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dnsExchange("udp", resolver, c.sourceAddr, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) >= 3 && resp[2]&0x02 != 0 {
+		if c.DisableTCP {
+			return nil, fmt.Errorf("DNS response for %s is truncated and TCP is disabled", name)
+		}
+		resp, err = dnsExchange("tcp", resolver, c.sourceAddr, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseDNSResponse(resp, id, qtype)
+}
+
+// dnsQuery returns a recursive query for the RR type of name and its ID. If
+// udpSize isn't zero the query has an EDNS(0) OPT record advertising it.
+func dnsQuery(name string, qtype uint16, udpSize int) ([]byte, uint16, error) {
+	id := uint16(rand.Intn(1 << 16))
+	// Header: ID, flags with RD set, one question
+	msg := []byte{byte(id >> 8), byte(id), 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	if udpSize > 0 {
+		// One additional record: the root name, type OPT, the UDP size as the
+		// class, a zero extended rcode, version and flags and no options
+		msg[11] = 1
+		msg = append(msg, 0, 0, dnsTypeOPT, byte(udpSize>>8), byte(udpSize), 0, 0, 0, 0, 0, 0)
+	}
+	return msg, id, nil
+}
+
+func dnsExchange(network, resolver string, source net.IP, query []byte) ([]byte, error) {
+	conn, err := newDialer(source, network, dnsTimeout).Dial(network, resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	// DNS over TCP prefixes every message with its length
+	prefixed := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(prefixed, uint16(len(query)))
+	if _, err := conn.Write(append(prefixed, query...)); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+var errShortDNSMessage = errors.New("DNS response is too short")
+
+// skipDNSName returns the offset following the possibly compressed name
+// starting at off.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errShortDNSMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			return off + 1, nil
+		case length&0xC0 == 0xC0:
+			// A compression pointer ends the name
+			return off + 2, nil
+		default:
+			off += length + 1
+		}
+	}
+}
+
+// parseDNSResponse returns the RDATA of the records with the RR type in the
+// answer section of resp. A NXDOMAIN response has no records. Any other error
+// rcode is an error.
+func parseDNSResponse(resp []byte, id uint16, qtype uint16) ([][]byte, error) {
+	if len(resp) < 12 {
+		return nil, errShortDNSMessage
+	}
+	if binary.BigEndian.Uint16(resp) != id || resp[2]&0x80 == 0 {
+		return nil, errors.New("DNS response does not match the query")
+	}
+	switch rcode := resp[3] & 0x0F; rcode {
+	case dnsRcodeSuccess:
+	case dnsRcodeNXDomain:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("DNS response has rcode %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(resp[4:]))
+	ancount := int(binary.BigEndian.Uint16(resp[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipDNSName(resp, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+
+	var rdatas [][]byte
+	for i := 0; i < ancount; i++ {
+		if off, err = skipDNSName(resp, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(resp) {
+			return nil, errShortDNSMessage
+		}
+		rrType := binary.BigEndian.Uint16(resp[off:])
+		rdLength := int(binary.BigEndian.Uint16(resp[off+8:]))
+		off += 10
+		if off+rdLength > len(resp) {
+			return nil, errShortDNSMessage
+		}
+		rdata := resp[off : off+rdLength]
+		off += rdLength
+		// Answers can include the CNAMEs followed to the records
+		if rrType != qtype {
+			continue
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	return rdatas, nil
+}
@@ -0,0 +1,115 @@
+package va
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// AddressFamily selects the addresses of an identifier that HTTP-01 and
+// TLS-ALPN-01 validations connect to
+type AddressFamily string
+
+// Address families
+const (
+	// PreferIPv6 connects to an IPv6 address of the identifier first and
+	// falls back to an IPv4 address if that fails, like Boulder. It is the
+	// default.
+	PreferIPv6 AddressFamily = "preferIPv6"
+	// IPv4Only ignores the AAAA records of identifiers
+	IPv4Only AddressFamily = "ipv4"
+	// IPv6Only ignores the A records of identifiers
+	IPv6Only AddressFamily = "ipv6"
+)
+
+// Validate returns an error if the address family is unknown. The empty
+// family is PreferIPv6.
+func (f AddressFamily) Validate() error {
+	switch f {
+	case "", PreferIPv6, IPv4Only, IPv6Only:
+		return nil
+	}
+	return fmt.Errorf("unknown address family %q, must be %q, %q or %q", f, PreferIPv6, IPv4Only, IPv6Only)
+}
+
+// allows returns true if connections to ip are made in the address family.
+func (f AddressFamily) allows(ip net.IP) bool {
+	switch f {
+	case IPv4Only:
+		return ip.To4() != nil
+	case IPv6Only:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// dialAddresses returns the addresses of a host to connect to, in order, from
+// its resolved addresses: the first IPv6 address and then the first IPv4
+// address of those the address family allows.
+func (f AddressFamily) dialAddresses(resolved []net.IPAddr) []net.IP {
+	var v4, v6 net.IP
+	for _, addr := range resolved {
+		if !f.allows(addr.IP) {
+			continue
+		}
+		if addr.IP.To4() != nil {
+			if v4 == nil {
+				v4 = addr.IP
+			}
+		} else if v6 == nil {
+			v6 = addr.IP
+		}
+	}
+	var addrs []net.IP
+	for _, ip := range []net.IP{v6, v4} {
+		if ip != nil {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// dialValidation connects to the host:port addr of a validation over TCP. The
+// addresses of a host name are resolved, with the DNS resolver if ResolveHosts
+// is set, and tried in the order of the VA's address family.
+func (va VAImpl) dialValidation(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := va.dialer(timeout)
+	var resolved []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		resolved = []net.IPAddr{{IP: ip}}
+	} else {
+		resolver := dialer.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		resolveCtx, cancel := context.WithTimeout(ctx, timeout)
+		resolved, err = resolver.LookupIPAddr(resolveCtx, host)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addrs := va.addressFamily.dialAddresses(resolved)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no addresses in the VA's address family %q", host, va.addressFamily)
+	}
+	var errs []string
+	for _, ip := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			if len(errs) > 0 {
+				va.log.Printf("Fell back to %s for %s after: %s\n", ip, host, strings.Join(errs, "; "))
+			}
+			return conn, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("connecting to %s: %s", host, strings.Join(errs, "; "))
+}
@@ -0,0 +1,141 @@
+package va
+
+import (
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// ValidationMethod is a challenge type the VA can validate. The VA offers the
+// registered methods for new authorizations and dispatches challenge
+// validations to them by type, so embedders can prototype draft challenge types
+// with RegisterMethod.
+type ValidationMethod interface {
+	// Type is the challenge type, e.g. "http-01"
+	Type() string
+	// Offer returns true if a challenge of the type is offered for an
+	// authorization of ident. wildcard is true for wildcard authorizations,
+	// whose identifier doesn't have the "*." prefix.
+	Offer(ident acme.Identifier, wildcard bool) bool
+	// Validate makes one validation attempt of the task's challenge and
+	// returns its record, with an error if the attempt failed. It is called
+	// concurrently for each of the attempts of a validation.
+	Validate(task *ValidationTask) *core.ValidationRecord
+}
+
+// ValidationMethodFuncs is a ValidationMethod implemented by functions. A nil
+// OfferFunc offers the challenge for every authorization.
+type ValidationMethodFuncs struct {
+	Name         string
+	OfferFunc    func(ident acme.Identifier, wildcard bool) bool
+	ValidateFunc func(task *ValidationTask) *core.ValidationRecord
+}
+
+func (m ValidationMethodFuncs) Type() string {
+	return m.Name
+}
+
+func (m ValidationMethodFuncs) Offer(ident acme.Identifier, wildcard bool) bool {
+	return m.OfferFunc == nil || m.OfferFunc(ident, wildcard)
+}
+
+func (m ValidationMethodFuncs) Validate(task *ValidationTask) *core.ValidationRecord {
+	return m.ValidateFunc(task)
+}
+
+// methodRegistry holds the validation methods of a VA in the order their
+// challenges are offered.
+type methodRegistry struct {
+	sync.RWMutex
+	methods []ValidationMethod
+}
+
+func (r *methodRegistry) register(method ValidationMethod) {
+	r.Lock()
+	defer r.Unlock()
+	for i, m := range r.methods {
+		if m.Type() == method.Type() {
+			r.methods[i] = method
+			return
+		}
+	}
+	r.methods = append(r.methods, method)
+}
+
+func (r *methodRegistry) get(chalType string) ValidationMethod {
+	r.RLock()
+	defer r.RUnlock()
+	for _, m := range r.methods {
+		if m.Type() == chalType {
+			return m
+		}
+	}
+	return nil
+}
+
+// builtinMethods returns the challenge types implemented by the VA itself.
+// Wildcard authorizations can only be satisfied with a DNS-01 challenge and IP
+// identifiers can't be validated with DNS-01 or TLS-SNI-02 (RFC 8738).
+func (va *VAImpl) builtinMethods() []ValidationMethod {
+	notWildcard := func(_ acme.Identifier, wildcard bool) bool {
+		return !wildcard
+	}
+	dnsName := func(ident acme.Identifier, wildcard bool) bool {
+		return !wildcard && ident.Type != acme.IdentifierIP
+	}
+	return []ValidationMethod{
+		ValidationMethodFuncs{
+			Name:      acme.ChallengeHTTP01,
+			OfferFunc: notWildcard,
+			ValidateFunc: func(task *ValidationTask) *core.ValidationRecord {
+				return va.validateHTTP01(task)
+			},
+		},
+		ValidationMethodFuncs{
+			Name:      acme.ChallengeTLSSNI02,
+			OfferFunc: dnsName,
+			ValidateFunc: func(task *ValidationTask) *core.ValidationRecord {
+				return va.validateTLSSNI02(task)
+			},
+		},
+		ValidationMethodFuncs{
+			Name:      acme.ChallengeTLSALPN01,
+			OfferFunc: notWildcard,
+			ValidateFunc: func(task *ValidationTask) *core.ValidationRecord {
+				return va.validateTLSALPN01(task)
+			},
+		},
+		ValidationMethodFuncs{
+			Name: acme.ChallengeDNS01,
+			OfferFunc: func(ident acme.Identifier, _ bool) bool {
+				return ident.Type != acme.IdentifierIP
+			},
+			ValidateFunc: func(task *ValidationTask) *core.ValidationRecord {
+				return va.validateDNS01(task)
+			},
+		},
+	}
+}
+
+// RegisterMethod adds a validation method to the VA. A method with the type of
+// an existing one, including the built-in types, replaces it. Challenges of
+// new types are offered after the existing ones.
+func (va VAImpl) RegisterMethod(method ValidationMethod) {
+	va.methods.register(method)
+	va.log.Printf("Registered validation method %s", method.Type())
+}
+
+// ChallengeTypes returns the types of the challenges offered for a new
+// authorization of ident, in registration order.
+func (va VAImpl) ChallengeTypes(ident acme.Identifier, wildcard bool) []string {
+	va.methods.RLock()
+	defer va.methods.RUnlock()
+	var types []string
+	for _, m := range va.methods.methods {
+		if m.Offer(ident, wildcard) {
+			types = append(types, m.Type())
+		}
+	}
+	return types
+}
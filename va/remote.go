@@ -0,0 +1,163 @@
+package va
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+const (
+	// validatePath is the path of the remote VA API. Pebble POSTs
+	// a validationRequest to it and gets back a validationResponse.
+	validatePath = "/validate"
+
+	// How long does Pebble wait for a remote VA to perform a validation?
+	remoteTimeout = time.Minute
+)
+
+// validationRequest asks a remote VA to validate a challenge
+type validationRequest struct {
+	Identifier acme.Identifier  `json:"identifier"`
+	Type       string           `json:"type"`
+	Token      string           `json:"token"`
+	AccountKey *jose.JSONWebKey `json:"accountKey"`
+	RequestID  string           `json:"requestID,omitempty"`
+}
+
+// validationResponse holds the records of every validation attempt a remote
+// VA made for a validationRequest
+type validationResponse struct {
+	Records []*core.ValidationRecord `json:"records"`
+}
+
+// Handler returns an http.Handler serving the remote VA API, so that the VA
+// can run as a separate process from the rest of Pebble.
+func (va VAImpl) Handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(validatePath, func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != "POST" {
+			response.Header().Set("Allow", "POST")
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req validationRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+			http.Error(response, "Error unmarshaling body JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.AccountKey == nil {
+			http.Error(response, "Validation request has no accountKey", http.StatusBadRequest)
+			return
+		}
+
+		task := &ValidationTask{
+			Identifier: req.Identifier,
+			Challenge: &core.Challenge{
+				Challenge: acme.Challenge{
+					Type:  req.Type,
+					Token: req.Token,
+				},
+			},
+			Account:   &core.Account{Key: req.AccountKey},
+			RequestID: req.RequestID,
+			// A Pebble that cancels its validation cancels the request too
+			ctx: request.Context(),
+		}
+		va.log.WithRequestID(task.RequestID).Printf(
+			"Remote validation of %s for %s:%s", req.Type, req.Identifier.Type, req.Identifier.Value)
+
+		results := make(chan *core.ValidationRecord, concurrentValidations)
+		for i := 0; i < concurrentValidations; i++ {
+			go va.performValidation(task, results)
+		}
+		var resp validationResponse
+		for i := 0; i < concurrentValidations; i++ {
+			resp.Records = append(resp.Records, <-results)
+		}
+
+		response.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(response).Encode(resp); err != nil {
+			va.log.Printf("Error: writing remote validation response: %s", err.Error())
+		}
+	})
+	return m
+}
+
+// remoteValidation sends a task to the remote VA and passes the records of its
+// validation attempts to results. If the remote VA can't be reached every
+// attempt fails with a serverInternal problem.
+func (va VAImpl) remoteValidation(task *ValidationTask, results chan<- *core.ValidationRecord) {
+	records, err := va.postRemote(task)
+	if err != nil {
+		va.log.WithRequestID(task.RequestID).Printf("Error: remote VA %s: %s", va.remote, err.Error())
+	}
+	for i := 0; i < concurrentValidations; i++ {
+		if err == nil && i < len(records) && records[i] != nil {
+			results <- records[i]
+			continue
+		}
+		if task.cancelled() {
+			results <- &core.ValidationRecord{
+				ValidatedAt: va.clk.Now(),
+				Cancelled:   true,
+			}
+			continue
+		}
+		detail := "Remote VA did not return a result for the validation attempt"
+		if err != nil {
+			detail = "Remote VA is unavailable: " + err.Error()
+		}
+		results <- &core.ValidationRecord{
+			ValidatedAt: va.clk.Now(),
+			Error:       acme.InternalErrorProblem(detail),
+		}
+	}
+}
+
+func (va VAImpl) postRemote(task *ValidationTask) ([]*core.ValidationRecord, error) {
+	task.Challenge.RLock()
+	req := validationRequest{
+		Identifier: task.Identifier,
+		Type:       task.Challenge.Type,
+		Token:      task.Challenge.Token,
+		RequestID:  task.RequestID,
+	}
+	task.Challenge.RUnlock()
+	task.Account.RLock()
+	req.AccountKey = task.Account.Key
+	task.Account.RUnlock()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, va.remote+validatePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: remoteTimeout}
+	resp, err := client.Do(httpReq.WithContext(task.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("returned status %d: %s", resp.StatusCode, respBody)
+	}
+	var vr validationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&vr); err != nil {
+		return nil, err
+	}
+	return vr.Records, nil
+}
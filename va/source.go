@@ -0,0 +1,54 @@
+package va
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ParseSourceAddress parses the IP address that the VA's outbound connections
+// are made from. It returns nil for an empty address, in which case the system
+// picks the source address of each connection.
+func ParseSourceAddress(addr string) (net.IP, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", addr)
+	}
+	return ip, nil
+}
+
+// newDialer returns a dialer with the timeout for connections over the
+// network. If source isn't nil the connections are bound to it, with any local
+// port.
+func newDialer(source net.IP, network string, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if source == nil {
+		return d
+	}
+	switch network {
+	case "udp", "udp4", "udp6":
+		d.LocalAddr = &net.UDPAddr{IP: source}
+	default:
+		d.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	return d
+}
+
+// dialer returns a dialer for the validation connections of the VA, which
+// looks up the addresses of hosts with the DNS resolver if ResolveHosts is set.
+func (va VAImpl) dialer(timeout time.Duration) *net.Dialer {
+	d := newDialer(va.sourceAddr, "tcp", timeout)
+	if va.dns.ResolveHosts {
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return newDialer(va.sourceAddr, network, dnsTimeout).DialContext(ctx, network, va.dns.resolver())
+			},
+		}
+	}
+	return d
+}
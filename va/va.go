@@ -1,16 +1,16 @@
 package va
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,21 +18,30 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/pebble/acme"
-	"github.com/letsencrypt/pebble/ca"
 	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/webhook"
 )
 
 const (
 	whitespaceCutset = "\n\r\t"
 	userAgentBase    = "LetsEncrypt-Pebble-VA"
 
-	// How long do valid authorizations last before expiring?
+	// How long do valid authorizations last before expiring, unless New is
+	// given another lifetime?
 	validAuthzExpire = time.Hour
 
+	// The ports HTTP-01 and TLS-SNI-02/TLS-ALPN-01 validations connect to,
+	// unless New is given other ports
+	defaultHTTPPort = 5002
+	defaultTLSPort  = 5001
+
 	// How many vaTasks can be in the channel before the WFE blocks on adding
 	// another?
 	taskQueueSize = 6
@@ -45,8 +54,29 @@ const (
 	// invoke Pebble if you wish validation to be done at full speed, e.g.:
 	//   PEBBLE_VA_NOSLEEP=1 pebble
 	noSleepEnvVar = "PEBBLE_VA_NOSLEEP"
+
+	// ipv6OnlyEnvVar defines the environment variable name used to signal that
+	// the VA should only connect to IPv6 addresses, ignoring A records entirely,
+	// to emulate a v6-only network. It is the same as the IPv6Only address
+	// family. E.g.:
+	//   PEBBLE_VA_IPV6_ONLY=1 pebble
+	ipv6OnlyEnvVar = "PEBBLE_VA_IPV6_ONLY"
+
+	// waitForAllAuthzsEnvVar defines the environment variable name used to
+	// signal that an order with a failed authorization should only become
+	// invalid once none of its authorizations are pending. By default the order
+	// becomes invalid as soon as one authorization fails. E.g.:
+	//   PEBBLE_VA_WAIT_FOR_ALL_AUTHZS=1 pebble
+	waitForAllAuthzsEnvVar = "PEBBLE_VA_WAIT_FOR_ALL_AUTHZS"
 )
 
+// defaultSleepRange is used when no validation delay is configured, it sleeps
+// from 1 to 15 seconds before each validation attempt
+var defaultSleepRange = core.DelayRange{
+	Min: core.Duration{Duration: time.Second},
+	Max: core.Duration{Duration: 15 * time.Second},
+}
+
 func userAgent() string {
 	return fmt.Sprintf(
 		"%s (%s; %s)",
@@ -61,82 +91,295 @@ func certNames(cert *x509.Certificate) string {
 		names = append(names, cert.Subject.CommonName)
 	}
 	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
 	return strings.Join(names, ", ")
 }
 
-type vaTask struct {
-	Identifier string
+// ValidationTask is a challenge validation of an identifier for an account.
+// The challenge and account must be locked for reading to access their fields.
+type ValidationTask struct {
+	Identifier acme.Identifier
 	Challenge  *core.Challenge
 	Account    *core.Account
+	// RequestID is the ID of the request that submitted the task
+	RequestID string
+	// ctx cancels the validation when it is done, cancel when the validation
+	// completed or the VA is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// changed is called once the validation updated the challenge and its
+	// authorization and order, it may be nil
+	changed func()
+}
+
+// cancelled returns true if the task's validation has been cancelled.
+func (task *ValidationTask) cancelled() bool {
+	return task.ctx.Err() != nil
+}
+
+// Context returns the context of the validation, which is cancelled when the
+// validation is.
+func (task *ValidationTask) Context() context.Context {
+	return task.ctx
 }
 
 type VAImpl struct {
-	log      *log.Logger
+	log      *logging.Logger
 	clk      clock.Clock
 	httpPort int
 	tlsPort  int
-	tasks    chan *vaTask
-	ca       *ca.CAImpl
+	tasks    chan *ValidationTask
 	sleep    bool
+	// sleepRange is the range of the random sleep before each validation
+	// attempt
+	sleepRange core.DelayRange
+	// addressFamily selects the addresses HTTP-01 and TLS-ALPN-01 validations
+	// connect to
+	addressFamily AddressFamily
+	// If waitForAllAuthzs is true orders only become invalid once all of their
+	// authorizations are no longer pending
+	waitForAllAuthzs bool
+	// remote is the base URL of a remote VA that performs the validations, or
+	// empty if they are performed by this VA
+	remote string
+	// caa configures the CAA checks made before authorizations become valid
+	caa CAAConfig
+	// dns configures the DNS queries of DNS-01 validations and CAA checks
+	dns DNSConfig
+	// methods are the challenge types the VA can validate
+	methods *methodRegistry
+	// pending is the number of submitted validations that haven't completed
+	pending *int64
+	// presolved are the identifiers whose validations always succeed
+	presolved *presolvedSet
+	// validAuthzLifetime is how long authorizations are valid once validated
+	validAuthzLifetime time.Duration
+	// sourceAddr is the address validation connections and DNS queries are
+	// made from, or nil for the system's choice
+	sourceAddr net.IP
+	// notifier sends the webhook events of failed validations
+	notifier *webhook.Notifier
+	// closed is closed by Close to cancel the validations in progress.
+	// closeLock keeps validations from being submitted to the closed tasks.
+	closed    chan struct{}
+	closeLock *sync.RWMutex
 }
 
-func New(
-	log *log.Logger,
-	clk clock.Clock,
-	httpPort, tlsPort int,
-	ca *ca.CAImpl) *VAImpl {
+// Config holds the settings of a VA. The zero value validates locally with
+// the default ports and random sleeps, and sends no webhook events.
+type Config struct {
+	// HTTPPort and TLSPort are the ports HTTP-01 and TLS-ALPN-01/TLS-SNI-02
+	// validations connect to, by default 5002 and 5001
+	HTTPPort int
+	TLSPort  int
+	// SleepRange is the range of the random sleep before each validation
+	// attempt, by default 1 to 15 seconds
+	SleepRange core.DelayRange
+	// NoSleep disables the random sleep, as if PEBBLE_VA_NOSLEEP was set
+	NoSleep bool
+	// Remote is the URL of a remote VA that performs the validations, or
+	// empty to validate locally
+	Remote string
+	CAA    CAAConfig
+	DNS    DNSConfig
+	// ValidAuthzLifetime is how long authorizations are valid once validated,
+	// by default an hour
+	ValidAuthzLifetime time.Duration
+	// SourceAddr is the address validation connections and DNS queries are
+	// made from, or nil for the system's choice
+	SourceAddr net.IP
+	// AddressFamily is the family of the addresses validations connect to, by
+	// default PreferIPv6
+	AddressFamily AddressFamily
+	// Notifier sends the webhook events of failed validations, none are sent
+	// if it is nil
+	Notifier *webhook.Notifier
+}
+
+// New creates a VA and starts the goroutine processing its validations.
+func New(log *logging.Logger, clk clock.Clock, config Config) *VAImpl {
+	if config.HTTPPort == 0 {
+		config.HTTPPort = defaultHTTPPort
+	}
+	if config.TLSPort == 0 {
+		config.TLSPort = defaultTLSPort
+	}
+	config.DNS.sourceAddr = config.SourceAddr
+	if config.SleepRange.IsZero() {
+		config.SleepRange = defaultSleepRange
+	}
+	if config.ValidAuthzLifetime <= 0 {
+		config.ValidAuthzLifetime = validAuthzExpire
+	}
+	if config.AddressFamily == "" {
+		config.AddressFamily = PreferIPv6
+	}
 	va := &VAImpl{
-		log:      log,
-		clk:      clk,
-		httpPort: httpPort,
-		tlsPort:  tlsPort,
-		tasks:    make(chan *vaTask, taskQueueSize),
-		ca:       ca,
-		sleep:    true,
+		log:        log,
+		clk:        clk,
+		httpPort:   config.HTTPPort,
+		tlsPort:    config.TLSPort,
+		tasks:      make(chan *ValidationTask, taskQueueSize),
+		sleep:      !config.NoSleep,
+		sleepRange: config.SleepRange,
+		remote:     strings.TrimSuffix(config.Remote, "/"),
+		caa:        config.CAA,
+		dns:        config.DNS,
+		methods:    &methodRegistry{},
+		pending:    new(int64),
+		presolved:  &presolvedSet{idents: make(map[acme.Identifier]bool)},
+		closed:     make(chan struct{}),
+		closeLock:  new(sync.RWMutex),
+
+		validAuthzLifetime: config.ValidAuthzLifetime,
+		sourceAddr:         config.SourceAddr,
+		notifier:           config.Notifier,
+		addressFamily:      config.AddressFamily,
+	}
+	for _, method := range va.builtinMethods() {
+		va.methods.register(method)
 	}
 
 	// Read the PEBBLE_VA_NOSLEEP environment variable string
-	noSleep := os.Getenv(noSleepEnvVar)
+	noSleepEnv := os.Getenv(noSleepEnvVar)
 	// If it is set to something true-like, then the VA shouldn't sleep
-	switch noSleep {
+	switch noSleepEnv {
 	case "1", "true", "True", "TRUE":
 		va.sleep = false
 		va.log.Printf("Disabling random VA sleeps")
 	}
 
+	// Read the PEBBLE_VA_IPV6_ONLY environment variable string
+	ipv6Only := os.Getenv(ipv6OnlyEnvVar)
+	// If it is set to something true-like, then the VA only connects over IPv6
+	switch ipv6Only {
+	case "1", "true", "True", "TRUE":
+		va.addressFamily = IPv6Only
+	}
+	switch va.addressFamily {
+	case IPv4Only:
+		va.log.Printf("Validating over IPv4 only, ignoring AAAA records")
+	case IPv6Only:
+		va.log.Printf("Validating over IPv6 only, ignoring A records")
+	}
+
+	// Read the PEBBLE_VA_WAIT_FOR_ALL_AUTHZS environment variable string
+	waitForAll := os.Getenv(waitForAllAuthzsEnvVar)
+	// If it is set to something true-like, then orders wait for all authzs
+	switch waitForAll {
+	case "1", "true", "True", "TRUE":
+		va.waitForAllAuthzs = true
+		va.log.Printf("Waiting for all authzs before setting orders INVALID")
+	}
+
+	if va.remote != "" {
+		va.log.Printf("Performing validations with the remote VA at %s", va.remote)
+	} else if va.sourceAddr != nil {
+		va.log.Printf("Validating from source address %s", va.sourceAddr)
+	}
+
 	go va.processTasks()
 	return va
 }
 
-func (va VAImpl) ValidateChallenge(ident string, chal *core.Challenge, acct *core.Account) {
-	task := &vaTask{
+// ValidateChallenge submits a challenge for asynchronous validation. The
+// validation is logged with the request ID of ctx. If ctx is cancelled before
+// the validation completes the result is discarded and the challenge stays
+// pending. changed, unless it is nil, is called when the validation has
+// updated the challenge, e.g. to persist the db holding it.
+func (va VAImpl) ValidateChallenge(
+	ctx context.Context,
+	ident acme.Identifier,
+	chal *core.Challenge,
+	acct *core.Account,
+	changed func()) {
+	va.closeLock.RLock()
+	defer va.closeLock.RUnlock()
+	select {
+	case <-va.closed:
+		va.log.WithContext(ctx).Printf("VA is closed, not validating challenge %s", chal.ID)
+		return
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	task := &ValidationTask{
 		Identifier: ident,
 		Challenge:  chal,
 		Account:    acct,
-	}
+		RequestID:  logging.RequestID(ctx),
+		ctx:        ctx,
+		cancel:     cancel,
+		changed:    changed,
+	}
+	// Closing the VA cancels the validation
+	go func() {
+		select {
+		case <-va.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 	// Submit the task for validation
+	atomic.AddInt64(va.pending, 1)
 	va.tasks <- task
 }
 
+// Close stops processing validations. The validations in progress are
+// cancelled, their challenges stay pending, and challenges submitted later
+// are never validated. It can be called more than once.
+func (va VAImpl) Close() {
+	va.closeLock.Lock()
+	defer va.closeLock.Unlock()
+	select {
+	case <-va.closed:
+		return
+	default:
+	}
+	close(va.closed)
+	close(va.tasks)
+}
+
+// PendingValidations returns the number of submitted validations that haven't
+// completed.
+func (va VAImpl) PendingValidations() int {
+	return int(atomic.LoadInt64(va.pending))
+}
+
 func (va VAImpl) processTasks() {
 	for task := range va.tasks {
 		go va.process(task)
 	}
 }
 
-func (va VAImpl) firstError(results chan *core.ValidationRecord) *acme.ProblemDetails {
+// firstError waits for all of the validation attempts for a challenge to
+// complete, records each of them in the challenge's validation history, and
+// returns the first error encountered (if any).
+func (va VAImpl) firstError(chal *core.Challenge, results chan *core.ValidationRecord) *acme.ProblemDetails {
+	var firstErr *acme.ProblemDetails
 	for i := 0; i < concurrentValidations; i++ {
 		result := <-results
-		if result.Error != nil {
-			return result.Error
+		chal.Lock()
+		chal.ValidationRecords = append(chal.ValidationRecords, result)
+		chal.Unlock()
+		if result.Error != nil && firstErr == nil {
+			firstErr = result.Error
 		}
 	}
-	return nil
+	return firstErr
 }
 
-func (va VAImpl) process(task *vaTask) {
-	va.log.Printf("Pulled a task from the Tasks queue: %#v", task)
-	va.log.Printf("Starting %d validations.", concurrentValidations)
+func (va VAImpl) process(task *ValidationTask) {
+	defer atomic.AddInt64(va.pending, -1)
+	defer task.cancel()
+	if task.changed != nil {
+		defer task.changed()
+	}
+	log := va.log.WithRequestID(task.RequestID)
+	log.Printf("Pulled a task from the Tasks queue: %#v", task)
+	log.Printf("Starting %d validations.", concurrentValidations)
 
 	chal := task.Challenge
 	chal.Lock()
@@ -149,12 +392,28 @@ func (va VAImpl) process(task *vaTask) {
 
 	results := make(chan *core.ValidationRecord, concurrentValidations)
 
-	// Start a number of go routines to perform concurrent validations
-	for i := 0; i < concurrentValidations; i++ {
-		go va.performValidation(task, results)
+	if va.remote != "" {
+		// The remote VA performs the concurrent validations
+		go va.remoteValidation(task, results)
+	} else {
+		// Start a number of go routines to perform concurrent validations
+		for i := 0; i < concurrentValidations; i++ {
+			go va.performValidation(task, results)
+		}
 	}
 
-	err := va.firstError(results)
+	err := va.firstError(chal, results)
+	// A cancelled validation doesn't change the challenge, its attempts are
+	// only recorded in the validation history
+	if task.cancelled() {
+		log.Printf("Validation of challenge %s cancelled, challenge stays %s",
+			chal.ID, acme.StatusPending)
+		return
+	}
+	// A successful validation still fails if CAA forbids issuance
+	if err == nil {
+		err = va.checkCAA(authz)
+	}
 	// If one of the results was an error, the challenge fails
 	if err != nil {
 		// Lock the challenge to update the error & status
@@ -163,91 +422,191 @@ func (va VAImpl) process(task *vaTask) {
 		chal.Error = err
 		// Set the challenge and authorization to invalid
 		chal.Status = acme.StatusInvalid
+		event := webhook.Event{
+			Type:          webhook.ValidationFailed,
+			ChallengeID:   chal.ID,
+			ChallengeType: chal.Type,
+			Identifiers:   []acme.Identifier{task.Identifier},
+			Error:         err,
+		}
 		chal.Unlock()
 
-		// Lock the authz to update the authz status
+		// Lock the authz to update the authz status. If the authz was
+		// deactivated while the validation was running it stays deactivated.
 		authz.Lock()
-		authz.Status = acme.StatusInvalid
+		if authz.Status == acme.StatusPending {
+			authz.Status = acme.StatusInvalid
+		}
+		order := authz.Order
+		event.AccountID = authz.AccountID
+		event.AuthorizationID = authz.ID
 		authz.Unlock()
 
-		va.log.Printf("authz %s set INVALID by completed challenge %s", authz.ID, chal.ID)
-		// Return immediately - there's no need to check for order issuance
-		return
+		log.Printf("authz %s set INVALID by completed challenge %s", authz.ID, chal.ID)
+		if order != nil {
+			event.OrderID = order.ID
+		}
+		va.notifier.Notify(event)
+		va.UpdateOrder(log, order)
 	} else {
 		// If none of the results were an error then the challenge succeeded.
-		// Update the expiry for the valid authorization
+		// If the authz was deactivated while the validation was running it
+		// stays deactivated, and the challenge isn't updated either.
 		authz.Lock()
-		authz.ExpiresDate = now.Add(validAuthzExpire)
-		authz.Expires = authz.ExpiresDate.Format(time.RFC3339)
-		authz.Status = acme.StatusValid
-		authz.Unlock()
-
-		// Set the authorization & challenge to valid
+		if authz.Status != acme.StatusPending {
+			status := authz.Status
+			authz.Unlock()
+			log.Printf("authz %s is %s, not updating it for completed challenge %s",
+				authz.ID, status, chal.ID)
+			return
+		}
+		// The challenge is set valid together with its authz
 		chal.Lock()
 		chal.Status = acme.StatusValid
 		chal.Unlock()
+		authz.ExpiresDate = now.Add(va.validAuthzLifetime)
+		authz.Expires = authz.ExpiresDate.Format(time.RFC3339)
+		authz.ValidatedDate = now
+		authz.Status = acme.StatusValid
+		order := authz.Order
+		authz.Unlock()
 
-		va.log.Printf("authz %s set VALID by completed challenge %s", authz.ID, chal.ID)
+		log.Printf("authz %s set VALID by completed challenge %s", authz.ID, chal.ID)
+		// An earlier failed authz may have been waiting for this one
+		va.UpdateOrder(log, order)
 	}
-
-	// Lock the authz to read the order, check if it can be fulfilled
-	authz.RLock()
-	order := authz.Order
-	authz.RUnlock()
-	va.maybeIssue(order)
 }
 
-func (va VAImpl) maybeIssue(order *core.Order) {
-	// Lock the order for reading to check whether all authorizations are valid
-	order.RLock()
+// UpdateOrder sets a pending order to ready once all of its authorizations
+// are valid, or a pending or ready order to invalid when any of them failed,
+// with a compound error listing the failed identifiers. If waitForAllAuthzs is
+// set the order stays pending until none of its authorizations are pending.
+// Authorizations that fail after their order was invalidated by failed
+// authorizations are added to its error, so that clients validating them
+// concurrently see every failed identifier. Pre-authorizations have no order,
+// in which case order is nil.
+func (va VAImpl) UpdateOrder(log *logging.Logger, order *core.Order) {
+	if order == nil {
+		return
+	}
+	order.Lock()
+	defer order.Unlock()
+	// Only failed authorizations give the error of an order subproblems
+	failedByAuthzs := order.Status == acme.StatusInvalid &&
+		order.Error != nil && len(order.Error.Subproblems) > 0
+	if order.Status != acme.StatusPending && order.Status != acme.StatusReady && !failedByAuthzs {
+		return
+	}
+
+	var failed []string
+	var subproblems []acme.SubProblemDetails
+	pending := false
 	for _, authz := range order.AuthorizationObjects {
-		// Lock the authorization for reading to check its status
 		authz.RLock()
-		authzStatus := authz.Status
+		switch authz.Status {
+		case acme.StatusPending:
+			pending = true
+		case acme.StatusInvalid, acme.StatusDeactivated:
+			ident := authz.Identifier
+			if authz.Wildcard {
+				ident.Value = "*." + ident.Value
+			}
+			failed = append(failed, ident.Value)
+			subproblems = append(subproblems, acme.SubProblemDetails{
+				ProblemDetails: authzProblem(authz),
+				Identifier:     ident,
+			})
+		}
 		authz.RUnlock()
-		// If any of the authorizations are invalid the order isn't ready to issue
-		if authzStatus != acme.StatusValid {
-			return
+	}
+	if len(failed) == 0 {
+		if !pending && order.Status != acme.StatusReady {
+			order.Status = acme.StatusReady
+			log.Printf("order %s set READY by valid authzs", order.ID)
 		}
+		return
 	}
-	order.RUnlock()
-	// All the authorizations are valid, ask the CA to complete the order in
-	// a separate goroutine
-	go va.ca.CompleteOrder(order)
+	if failedByAuthzs {
+		if len(subproblems) > len(order.Error.Subproblems) {
+			order.Error = acme.CompoundProblem(fmt.Sprintf(
+				"Order has failed authorizations for %s", strings.Join(failed, ", ")), subproblems)
+			log.Printf("invalid order %s has failed authzs for %s", order.ID, strings.Join(failed, ", "))
+		}
+		return
+	}
+	if pending && va.waitForAllAuthzs {
+		return
+	}
+
+	order.Status = acme.StatusInvalid
+	order.Error = acme.CompoundProblem(fmt.Sprintf(
+		"Order has failed authorizations for %s", strings.Join(failed, ", ")), subproblems)
+	log.Printf("order %s set INVALID by failed authzs for %s", order.ID, strings.Join(failed, ", "))
+}
+
+// authzProblem returns the error of the failed challenge of an invalid
+// authorization, or a generic problem if it has none (e.g. it was
+// deactivated). The caller must hold the authz lock.
+func authzProblem(authz *core.Authorization) acme.ProblemDetails {
+	for _, chal := range authz.Challenges {
+		if chal.Status == acme.StatusInvalid && chal.Error != nil {
+			return *chal.Error
+		}
+	}
+	return *acme.UnauthorizedProblem(fmt.Sprintf("Authorization is %s", authz.Status))
 }
 
-func (va VAImpl) performValidation(task *vaTask, results chan<- *core.ValidationRecord) {
+func (va VAImpl) performValidation(task *ValidationTask, results chan<- *core.ValidationRecord) {
+	if va.presolved.has(task.Identifier) {
+		results <- &core.ValidationRecord{
+			URL:         "presolved:" + task.Identifier.Value,
+			ValidatedAt: va.clk.Now(),
+		}
+		return
+	}
 	if va.sleep {
-		// Sleep for a random amount of time between 1-15s
-		len := time.Duration(rand.Intn(15))
-		va.log.Printf("Sleeping for %s seconds before validating", time.Second*len)
-		va.clk.Sleep(time.Second * len)
-	}
-
-	switch task.Challenge.Type {
-	case acme.ChallengeHTTP01:
-		results <- va.validateHTTP01(task)
-	case acme.ChallengeTLSSNI02:
-		results <- va.validateTLSSNI02(task)
-	case acme.ChallengeDNS01:
-		results <- va.validateDNS01(task)
-	default:
-		va.log.Printf("Error: performValidation(): Invalid challenge type: %q", task.Challenge.Type)
+		// Sleep for a random amount of time within the configured range
+		delay := va.sleepRange.Random()
+		va.log.WithRequestID(task.RequestID).Printf(
+			"Sleeping for %s before validating", delay)
+		select {
+		case <-va.clk.After(delay):
+		case <-task.ctx.Done():
+			results <- &core.ValidationRecord{
+				ValidatedAt: va.clk.Now(),
+				Cancelled:   true,
+			}
+			return
+		}
+	}
+
+	method := va.methods.get(task.Challenge.Type)
+	if method == nil {
+		va.log.WithRequestID(task.RequestID).Printf(
+			"Error: performValidation(): Invalid challenge type: %q", task.Challenge.Type)
+		results <- &core.ValidationRecord{
+			ValidatedAt: va.clk.Now(),
+			Error: acme.MalformedProblem(
+				fmt.Sprintf("Invalid challenge type: %q", task.Challenge.Type)),
+		}
+		return
 	}
+	results <- method.Validate(task)
 }
 
-func (va VAImpl) validateDNS01(task *vaTask) *core.ValidationRecord {
+func (va VAImpl) validateDNS01(task *ValidationTask) *core.ValidationRecord {
 	const dns01Prefix = "_acme-challenge"
-	challengeSubdomain := fmt.Sprintf("%s.%s", dns01Prefix, task.Identifier)
+	challengeSubdomain := fmt.Sprintf("%s.%s", dns01Prefix, task.Identifier.Value)
 
 	result := &core.ValidationRecord{
 		URL:         challengeSubdomain,
 		ValidatedAt: va.clk.Now(),
 	}
 
-	txts, err := net.LookupTXT(challengeSubdomain)
+	txts, err := va.dns.lookupTXT(challengeSubdomain)
 	if err != nil {
-		result.Error = acme.UnauthorizedProblem("Error retrieving TXT records for DNS challenge")
+		result.Error = acme.UnauthorizedProblem(
+			"Error retrieving TXT records for DNS challenge: " + err.Error())
 		return result
 	}
 
@@ -274,9 +633,9 @@ func (va VAImpl) validateDNS01(task *vaTask) *core.ValidationRecord {
 	return result
 }
 
-func (va VAImpl) validateTLSSNI02(task *vaTask) *core.ValidationRecord {
+func (va VAImpl) validateTLSSNI02(task *ValidationTask) *core.ValidationRecord {
 	portString := strconv.Itoa(va.tlsPort)
-	hostPort := net.JoinHostPort(task.Identifier, portString)
+	hostPort := net.JoinHostPort(task.Identifier.Value, portString)
 
 	result := &core.ValidationRecord{
 		URL:         hostPort,
@@ -303,15 +662,22 @@ func (va VAImpl) validateTLSSNI02(task *vaTask) *core.ValidationRecord {
 	sanBName := fmt.Sprintf("%s.%s.%s.%s", zb[:32], zb[32:], tlsSNIKaID, tlsSNISuffix)
 
 	// Perform the validation
-	result.Error = va.validateTLSSNI02WithNames(hostPort, sanAName, sanBName)
+	result.Error = va.validateTLSSNI02WithNames(task.Context(), hostPort, sanAName, sanBName)
 	return result
 }
 
-func (va VAImpl) validateTLSSNI02WithNames(hostPort string, sanAName, sanBName string) *acme.ProblemDetails {
-	certs, problem := va.fetchCerts(hostPort, sanAName)
+func (va VAImpl) validateTLSSNI02WithNames(
+	ctx context.Context,
+	hostPort string,
+	sanAName, sanBName string) *acme.ProblemDetails {
+	connState, problem := va.fetchConnState(ctx, hostPort, &tls.Config{
+		ServerName:         sanAName,
+		InsecureSkipVerify: true,
+	}, acme.ChallengeTLSSNI02)
 	if problem != nil {
 		return problem
 	}
+	certs := connState.PeerCertificates
 
 	leafCert := certs[0]
 	if len(leafCert.DNSNames) != 2 {
@@ -348,18 +714,29 @@ func (va VAImpl) validateTLSSNI02WithNames(hostPort string, sanAName, sanBName s
 	return nil
 }
 
-func (va VAImpl) fetchCerts(hostPort string, sni string) ([]*x509.Certificate, *acme.ProblemDetails) {
-	conn, err := tls.DialWithDialer(
-		&net.Dialer{Timeout: time.Second * 5}, "tcp", hostPort,
-		&tls.Config{
-			ServerName:         sni,
-			InsecureSkipVerify: true,
-		})
-
+// fetchConnState performs a TLS handshake with hostPort using the provided
+// config and returns the resulting connection state. An error is returned if
+// the handshake fails or the server presents no certificates. The lookup and
+// dial are abandoned when ctx is cancelled.
+func (va VAImpl) fetchConnState(
+	ctx context.Context,
+	hostPort string,
+	config *tls.Config,
+	chalType string) (*tls.ConnectionState, *acme.ProblemDetails) {
+	timeout := time.Second * 5
+	rawConn, err := va.dialValidation(ctx, hostPort, timeout)
+	var conn *tls.Conn
+	if err == nil {
+		conn = tls.Client(rawConn, config)
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		if err = conn.Handshake(); err != nil {
+			_ = conn.Close()
+		}
+	}
 	if err != nil {
 		// TODO(@cpu): Return better err - see parseHTTPConnError from boulder
 		return nil, acme.UnauthorizedProblem(
-			fmt.Sprintf("Failed to connect to %s for the %s challenge", hostPort, acme.ChallengeTLSSNI02))
+			fmt.Sprintf("Failed to connect to %s for the %s challenge", hostPort, chalType))
 	}
 
 	// close errors are not important here
@@ -367,16 +744,123 @@ func (va VAImpl) fetchCerts(hostPort string, sni string) ([]*x509.Certificate, *
 		_ = conn.Close()
 	}()
 
-	certs := conn.ConnectionState().PeerCertificates
-	if len(certs) == 0 {
+	connState := conn.ConnectionState()
+	if len(connState.PeerCertificates) == 0 {
 		return nil, acme.UnauthorizedProblem(
-			fmt.Sprintf("No certs presented for %s challenge", acme.ChallengeTLSSNI02))
+			fmt.Sprintf("No certs presented for %s challenge", chalType))
+	}
+	return &connState, nil
+}
+
+// reverseName returns the reverse DNS name ("in-addr.arpa" or "ip6.arpa") for
+// an IP address. It is used as the TLS-ALPN-01 SNI value for IP identifiers
+// (RFC 8738 Section 6).
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hexDigit = "0123456789abcdef"
+	var labels []string
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigit[ip[i]&0xF]), string(hexDigit[ip[i]>>4]))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
+
+func (va VAImpl) validateTLSALPN01(task *ValidationTask) *core.ValidationRecord {
+	portString := strconv.Itoa(va.tlsPort)
+	hostPort := net.JoinHostPort(task.Identifier.Value, portString)
+
+	result := &core.ValidationRecord{
+		URL:         hostPort,
+		ValidatedAt: va.clk.Now(),
 	}
-	return certs, nil
+
+	sni := task.Identifier.Value
+	var identIP net.IP
+	if task.Identifier.Type == acme.IdentifierIP {
+		identIP = net.ParseIP(task.Identifier.Value)
+		if identIP == nil {
+			result.Error = acme.MalformedProblem(
+				fmt.Sprintf("Invalid IP address identifier %q", task.Identifier.Value))
+			return result
+		}
+		sni = reverseName(identIP)
+	}
+
+	connState, problem := va.fetchConnState(task.Context(), hostPort, &tls.Config{
+		ServerName:         sni,
+		NextProtos:         []string{acme.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	}, acme.ChallengeTLSALPN01)
+	if problem != nil {
+		result.Error = problem
+		return result
+	}
+
+	if connState.NegotiatedProtocol != acme.ACMETLS1Protocol {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"Cannot negotiate ALPN protocol %q for %s challenge",
+			acme.ACMETLS1Protocol, acme.ChallengeTLSALPN01))
+		return result
+	}
+
+	leafCert := connState.PeerCertificates[0]
+	names := certNames(leafCert)
+
+	// The certificate must contain exactly one SAN matching the identifier
+	var sanMatches bool
+	if identIP != nil {
+		sanMatches = len(leafCert.DNSNames) == 0 &&
+			len(leafCert.IPAddresses) == 1 && leafCert.IPAddresses[0].Equal(identIP)
+	} else {
+		sanMatches = len(leafCert.IPAddresses) == 0 &&
+			len(leafCert.DNSNames) == 1 && leafCert.DNSNames[0] == task.Identifier.Value
+	}
+	if !sanMatches {
+		result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+			"Incorrect validation certificate for %s challenge. "+
+				"Requested %s from %s. Received certificate with names %q",
+			acme.ChallengeTLSALPN01, task.Identifier.Value, hostPort, names))
+		return result
+	}
+
+	task.Challenge.RLock()
+	expectedKeyAuthorization := task.Challenge.ExpectedKeyAuthorization(task.Account.Key)
+	task.Challenge.RUnlock()
+	h := sha256.Sum256([]byte(expectedKeyAuthorization))
+	expectedExtValue, err := asn1.Marshal(h[:])
+	if err != nil {
+		result.Error = acme.InternalErrorProblem(err.Error())
+		return result
+	}
+
+	for _, ext := range leafCert.Extensions {
+		if !ext.Id.Equal(acme.IDPeAcmeIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+				"Incorrect validation certificate for %s challenge. "+
+					"acmeValidationV1 extension not critical", acme.ChallengeTLSALPN01))
+			return result
+		}
+		if subtle.ConstantTimeCompare(ext.Value, expectedExtValue) != 1 {
+			result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+				"Incorrect validation certificate for %s challenge. "+
+					"Invalid acmeValidationV1 extension value", acme.ChallengeTLSALPN01))
+		}
+		return result
+	}
+
+	result.Error = acme.UnauthorizedProblem(fmt.Sprintf(
+		"Incorrect validation certificate for %s challenge. "+
+			"Missing acmeValidationV1 extension", acme.ChallengeTLSALPN01))
+	return result
 }
 
-func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
-	body, url, err := va.fetchHTTP(task.Identifier, task.Challenge.Token)
+func (va VAImpl) validateHTTP01(task *ValidationTask) *core.ValidationRecord {
+	body, url, err := va.fetchHTTP(task)
 
 	result := &core.ValidationRecord{
 		URL:         url,
@@ -402,16 +886,17 @@ func (va VAImpl) validateHTTP01(task *vaTask) *core.ValidationRecord {
 // NOTE(@cpu): fetchHTTP only fetches the ACME HTTP-01 challenge path for
 // a given challenge & identifier domain. It is not a challenge agnostic general
 // purpose HTTP function
-func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *acme.ProblemDetails) {
+func (va VAImpl) fetchHTTP(task *ValidationTask) ([]byte, string, *acme.ProblemDetails) {
+	identifier, token := task.Identifier.Value, task.Challenge.Token
 	path := fmt.Sprintf("%s%s", acme.HTTP01BaseURL, token)
 
 	url := &url.URL{
 		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", identifier, va.httpPort),
+		Host:   net.JoinHostPort(identifier, strconv.Itoa(va.httpPort)),
 		Path:   path,
 	}
 
-	va.log.Printf("Attempting to validate w/ HTTP: %s\n", url)
+	va.log.WithRequestID(task.RequestID).Printf("Attempting to validate w/ HTTP: %s\n", url)
 	httpRequest, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
 		return nil, url.String(), acme.MalformedProblem(
@@ -421,6 +906,11 @@ func (va VAImpl) fetchHTTP(identifier string, token string) ([]byte, string, *ac
 	httpRequest.Header.Set("Accept", "*/*")
 
 	transport := &http.Transport{
+		// Dial the addresses of the VA's address family, in its order, also
+		// for the hosts of redirects
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return va.dialValidation(ctx, addr, time.Second*5)
+		},
 		// We don't expect to make multiple requests to a client, so close
 		// connection immediately.
 		DisableKeepAlives: true,
@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// Event types
+const (
+	// OrderFinalized is sent when a ready order is finalized and the CA starts
+	// issuing its certificate
+	OrderFinalized = "order.finalized"
+	// CertificateIssued is sent when the certificate of an order is issued and
+	// the order is valid
+	CertificateIssued = "certificate.issued"
+	// CertificateRevoked is sent when a certificate is revoked
+	CertificateRevoked = "certificate.revoked"
+	// ValidationFailed is sent when a challenge validation fails and the
+	// challenge is invalid
+	ValidationFailed = "validation.failed"
+)
+
+// eventTypes are the types a Hook can subscribe to
+var eventTypes = map[string]bool{
+	OrderFinalized:     true,
+	CertificateIssued:  true,
+	CertificateRevoked: true,
+	ValidationFailed:   true,
+}
+
+const (
+	// queueSize is how many events can wait for delivery to a hook before new
+	// events for it are dropped
+	queueSize = 1024
+	// deliveryAttempts is how many times an event is POSTed to a hook that
+	// fails before it is dropped
+	deliveryAttempts = 3
+	// retryDelay is the wait between delivery attempts
+	retryDelay = time.Second
+	// deliveryTimeout bounds how long a hook can take to respond
+	deliveryTimeout = 10 * time.Second
+	// flushPollInterval is how often Flush checks if every event was delivered
+	flushPollInterval = 50 * time.Millisecond
+)
+
+// Event is the JSON body POSTed to hooks. Fields that don't apply to the event
+// type are omitted. The IDs are those of the management API.
+type Event struct {
+	Type            string            `json:"type"`
+	Time            time.Time         `json:"time"`
+	AccountID       string            `json:"accountID,omitempty"`
+	OrderID         string            `json:"orderID,omitempty"`
+	AuthorizationID string            `json:"authorizationID,omitempty"`
+	ChallengeID     string            `json:"challengeID,omitempty"`
+	ChallengeType   string            `json:"challengeType,omitempty"`
+	Identifiers     []acme.Identifier `json:"identifiers,omitempty"`
+	// CertificateSerial is the hex serial of the issued or revoked
+	// certificate, which is also its ID
+	CertificateSerial string `json:"certificateSerial,omitempty"`
+	// RevocationReason is the CRLReason of a revoked certificate
+	RevocationReason *int `json:"revocationReason,omitempty"`
+	// Error is the problem of a failed validation
+	Error *acme.ProblemDetails `json:"error,omitempty"`
+}
+
+// Hook is a URL that events are POSTed to as JSON.
+type Hook struct {
+	URL string
+	// Events are the types of the events sent to the hook, every type if it
+	// is empty
+	Events []string
+}
+
+// Validate returns an error if the hook has no URL or an unknown event type.
+func (h Hook) Validate() error {
+	if h.URL == "" {
+		return fmt.Errorf("url must not be empty")
+	}
+	for _, eventType := range h.Events {
+		if !eventTypes[eventType] {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+// hookQueue holds the events waiting for delivery to a hook. They are
+// delivered in order by a single goroutine.
+type hookQueue struct {
+	hook   Hook
+	events chan Event
+}
+
+func (q *hookQueue) wants(eventType string) bool {
+	if len(q.hook.Events) == 0 {
+		return true
+	}
+	for _, t := range q.hook.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier sends events to hooks in the background. A nil Notifier discards
+// every event, so components without hooks don't need to check for one.
+type Notifier struct {
+	log    *logging.Logger
+	clk    clock.Clock
+	client *http.Client
+	queues []*hookQueue
+	// pending is the number of queued events that haven't been delivered or
+	// dropped
+	pending *int64
+}
+
+// New creates a Notifier for the hooks, which must have been validated, and
+// starts delivering their events. It returns nil if there are no hooks.
+func New(log *logging.Logger, clk clock.Clock, hooks []Hook) *Notifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+	n := &Notifier{
+		log:     log,
+		clk:     clk,
+		client:  &http.Client{Timeout: deliveryTimeout},
+		pending: new(int64),
+	}
+	for _, hook := range hooks {
+		q := &hookQueue{hook: hook, events: make(chan Event, queueSize)}
+		n.queues = append(n.queues, q)
+		go n.deliver(q)
+	}
+	return n
+}
+
+// Notify queues the event for every hook subscribed to its type. Its Time is
+// set to the current time if it is zero. Notify never blocks, an event is
+// dropped for a hook whose queue is full.
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = n.clk.Now().UTC()
+	}
+	for _, q := range n.queues {
+		if !q.wants(event.Type) {
+			continue
+		}
+		atomic.AddInt64(n.pending, 1)
+		select {
+		case q.events <- event:
+		default:
+			atomic.AddInt64(n.pending, -1)
+			n.log.Printf("Error: webhook %s queue is full, dropped %s event\n", q.hook.URL, event.Type)
+		}
+	}
+}
+
+// Flush waits up to timeout for the queued events to be delivered. It returns
+// an error if some are still queued afterwards.
+func (n *Notifier) Flush(timeout time.Duration) error {
+	if n == nil {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := atomic.LoadInt64(n.pending)
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d webhook events not delivered after %s", pending, timeout)
+		}
+		time.Sleep(flushPollInterval)
+	}
+}
+
+// deliver POSTs the events of the queue to its hook, forever.
+func (n *Notifier) deliver(q *hookQueue) {
+	for event := range q.events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			n.log.Printf("Error: marshaling %s event for webhook %s: %s\n", event.Type, q.hook.URL, err.Error())
+			atomic.AddInt64(n.pending, -1)
+			continue
+		}
+		for attempt := 1; attempt <= deliveryAttempts; attempt++ {
+			err = n.post(q.hook.URL, body)
+			if err == nil {
+				break
+			}
+			n.log.Printf("Error: webhook %s failed for %s event, attempt %d of %d: %s\n",
+				q.hook.URL, event.Type, attempt, deliveryAttempts, err.Error())
+			if attempt < deliveryAttempts {
+				time.Sleep(retryDelay)
+			}
+		}
+		atomic.AddInt64(n.pending, -1)
+	}
+}
+
+// post POSTs the JSON body to url and returns an error if the request fails or
+// the hook doesn't return 2xx.
+func (n *Notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("hook returned status %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package wfe
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// reservedOrderFields are the order fields defined by ACME. They can't be used
+// as the order annotation key.
+var reservedOrderFields = map[string]bool{
+	"status":         true,
+	"expires":        true,
+	"identifiers":    true,
+	"notBefore":      true,
+	"notAfter":       true,
+	"error":          true,
+	"authorizations": true,
+	"finalize":       true,
+	"certificate":    true,
+}
+
+// CheckOrderAnnotationKey returns an error if key can't be used as the order
+// annotation key because it is one of the ACME order fields. An empty key
+// disables order annotations.
+func CheckOrderAnnotationKey(key string) error {
+	if reservedOrderFields[key] {
+		return fmt.Errorf("order annotation key %q is an ACME order field", key)
+	}
+	return nil
+}
+
+// annotatedOrder is an acme.Order that is marshalled with an additional top
+// level field holding the annotation the client included in its newOrder
+// request.
+type annotatedOrder struct {
+	acme.Order
+	key   string
+	value json.RawMessage
+}
+
+func (o annotatedOrder) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(o.Order)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields[o.key] = o.value
+	return json.Marshal(fields)
+}
+
+// orderAnnotation returns the value of the order annotation field in a newOrder
+// request body, or nil if order annotations are disabled or the field is
+// absent.
+func (wfe *WebFrontEndImpl) orderAnnotation(body []byte) (json.RawMessage, error) {
+	if wfe.orderAnnotationKey == "" {
+		return nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	return fields[wfe.orderAnnotationKey], nil
+}
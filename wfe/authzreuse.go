@@ -0,0 +1,61 @@
+package wfe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// AuthzReuse configures the reuse of valid authorizations across orders. Like
+// production CAs, Pebble gives a new order the account's valid authorization
+// for an identifier instead of a new pending one, so that clients skip
+// validating it.
+type AuthzReuse struct {
+	// Disabled always creates new authorizations for new orders
+	Disabled bool
+	// MaxAge is how long after it became valid an authorization is reused.
+	// Zero reuses it until it expires.
+	MaxAge core.Duration
+}
+
+// Validate returns an error if MaxAge is negative.
+func (r AuthzReuse) Validate() error {
+	if r.MaxAge.Duration < 0 {
+		return fmt.Errorf("maxAge must not be negative, got %s", r.MaxAge.Duration)
+	}
+	return nil
+}
+
+// findReusableAuthorization returns the account's valid, unexpired
+// authorization for the identifier of an earlier order that expires last, or
+// nil if there is none or reuse is disabled. Pre-authorizations are found by
+// findPreAuthorization instead.
+func (wfe *WebFrontEndImpl) findReusableAuthorization(
+	acctID string,
+	ident acme.Identifier,
+	wildcard bool) *core.Authorization {
+	if wfe.authzReuse.Disabled {
+		return nil
+	}
+	now := wfe.clk.Now()
+	var reusable *core.Authorization
+	var reusableExpires time.Time
+	for _, authz := range wfe.db.GetAuthorizationsByAccountID(acctID) {
+		authz.RLock()
+		usable := authz.Order != nil &&
+			authz.Status == acme.StatusValid &&
+			authz.Identifier == ident &&
+			authz.Wildcard == wildcard &&
+			now.Before(authz.ExpiresDate) &&
+			(wfe.authzReuse.MaxAge.Duration == 0 ||
+				now.Sub(authz.ValidatedDate) <= wfe.authzReuse.MaxAge.Duration)
+		expires := authz.ExpiresDate
+		authz.RUnlock()
+		if usable && (reusable == nil || expires.After(reusableExpires)) {
+			reusable, reusableExpires = authz, expires
+		}
+	}
+	return reusable
+}
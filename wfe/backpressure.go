@@ -0,0 +1,76 @@
+package wfe
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/ratelimit"
+)
+
+// defaultBackpressureRetryAfter is the Retry-After of overload responses when
+// the config doesn't specify one
+const defaultBackpressureRetryAfter = 5 * time.Second
+
+// Backpressure configures when Pebble sheds new work with a 503 and
+// a Retry-After header instead of queueing it, to test the overload handling
+// of clients. A zero maximum doesn't limit the corresponding queue.
+type Backpressure struct {
+	// MaxPendingValidations is the number of validations in progress at which
+	// challenge POSTs are rejected
+	MaxPendingValidations int
+	// MaxPendingFinalizations is the number of orders being issued at which
+	// finalize requests are rejected
+	MaxPendingFinalizations int
+	// RetryAfter is sent with overload responses, by default 5 seconds
+	RetryAfter core.Duration
+}
+
+// Validate returns an error if a maximum or the Retry-After is negative.
+func (b Backpressure) Validate() error {
+	if b.MaxPendingValidations < 0 {
+		return fmt.Errorf("maxPendingValidations must not be negative, got %d", b.MaxPendingValidations)
+	}
+	if b.MaxPendingFinalizations < 0 {
+		return fmt.Errorf("maxPendingFinalizations must not be negative, got %d",
+			b.MaxPendingFinalizations)
+	}
+	if b.RetryAfter.Duration < 0 {
+		return fmt.Errorf("retryAfter must not be negative, got %s", b.RetryAfter.Duration)
+	}
+	return nil
+}
+
+func (b Backpressure) retryAfter() time.Duration {
+	if b.RetryAfter.Duration > 0 {
+		return b.RetryAfter.Duration
+	}
+	return defaultBackpressureRetryAfter
+}
+
+// overloaded returns true if pending has reached max, a non-zero maximum of
+// the backpressure config. A serverInternal problem with a 503 status and
+// a Retry-After header is then sent.
+func (wfe *WebFrontEndImpl) overloaded(
+	response http.ResponseWriter,
+	pending int,
+	max int,
+	queue string) bool {
+	if max == 0 || pending < max {
+		return false
+	}
+	wfe.log.Printf("Rejecting new work, %d %s pending (limit %d)\n", pending, queue, max)
+	response.Header().Set("Retry-After", ratelimit.RetryAfter(wfe.backpressure.retryAfter()))
+	wfe.sendError(acme.ServiceUnavailableProblem(fmt.Sprintf(
+		"Too many %s pending, retry later", queue)), response)
+	return true
+}
+
+// PendingFinalizations returns the number of finalized orders the CA is still
+// issuing a certificate for.
+func (wfe *WebFrontEndImpl) PendingFinalizations() int {
+	return int(atomic.LoadInt64(wfe.pendingFinalizations))
+}
@@ -0,0 +1,223 @@
+package wfe
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// The media types certificates can be downloaded as, in order of preference
+const (
+	// The leaf certificate and its issuers as PEM (RFC 8555 Section 9.1)
+	pemChainContentType = "application/pem-certificate-chain"
+	// The DER encoded leaf certificate alone (RFC 2585)
+	pkixCertContentType = "application/pkix-cert"
+	// A certs-only PKCS #7 SignedData of the chain (RFC 2315 and 8551)
+	pkcs7ContentType = "application/pkcs7-mime"
+)
+
+var certificateContentTypes = []string{pemChainContentType, pkixCertContentType, pkcs7ContentType}
+
+// The certificates of a chain returned by the certificate endpoint
+const (
+	chainLeaf         = "leaf"
+	chainIntermediate = "intermediate"
+	chainRoot         = "root"
+)
+
+// CertificateChain configures the chain returned in PEM and PKCS #7 certificate
+// downloads, to test the chain building and sanitizing of clients.
+type CertificateChain struct {
+	// Include is "leaf" for the leaf certificate alone, "intermediate" (the
+	// default) for the leaf and its intermediates or "root" for the leaf, its
+	// intermediates and the root.
+	Include string
+	// Misordered returns the chain reversed, with the leaf certificate last
+	Misordered bool
+}
+
+// Validate returns an error if Include isn't one of the supported values.
+func (c CertificateChain) Validate() error {
+	switch c.Include {
+	case "", chainLeaf, chainIntermediate, chainRoot:
+		return nil
+	}
+	return fmt.Errorf("include must be %q, %q or %q, got %q",
+		chainLeaf, chainIntermediate, chainRoot, c.Include)
+}
+
+// certificates returns the DER encoded certificates of the chain of cert.
+func (c CertificateChain) certificates(cert *core.Certificate) [][]byte {
+	var chain [][]byte
+	switch c.Include {
+	case chainLeaf:
+		chain = [][]byte{cert.DER}
+	case chainRoot:
+		for link := cert; link != nil; link = link.Issuer {
+			chain = append(chain, link.DER)
+		}
+	default:
+		chain = cert.ChainDER()
+	}
+	if c.Misordered {
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+	}
+	return chain
+}
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// certificateBody returns the content type and body of a certificate download
+// for the request's Accept header, or a notAcceptable problem if the header
+// accepts none of certificateContentTypes. The PEM and PKCS #7 formats hold the
+// configured chain.
+func (wfe *WebFrontEndImpl) certificateBody(
+	cert *core.Certificate,
+	accept string) (string, []byte, *acme.ProblemDetails) {
+	contentType := negotiateContentType(accept, certificateContentTypes)
+	switch contentType {
+	case pemChainContentType:
+		var buf bytes.Buffer
+		for _, der := range wfe.certificateChain.certificates(cert) {
+			_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+		return contentType, buf.Bytes(), nil
+	case pkixCertContentType:
+		return contentType, cert.DER, nil
+	case pkcs7ContentType:
+		body, err := certsOnlyPKCS7(wfe.certificateChain.certificates(cert))
+		if err != nil {
+			return "", nil, acme.InternalErrorProblem("Error encoding PKCS #7 certificate chain")
+		}
+		return contentType, body, nil
+	}
+	return "", nil, acme.NotAcceptableProblem(fmt.Sprintf(
+		"Accept header %q accepts none of the certificate formats %s",
+		accept, strings.Join(certificateContentTypes, ", ")))
+}
+
+// negotiateContentType returns the media type of offers with the highest
+// quality in the accept header, preferring earlier offers on ties. An empty
+// header accepts the first offer. It returns "" if no offer is acceptable.
+func negotiateContentType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The quality of the most specific matching media range applies
+		q, specificity := 0.0, -1
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, rangeQ := parseMediaRange(mediaRange)
+			s := mediaRangeSpecificity(mediaType, offer)
+			if s > specificity {
+				q, specificity = rangeQ, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// parseMediaRange returns the lowercased media type and quality of a media
+// range of an Accept header, e.g. "application/pkix-cert;q=0.5".
+func parseMediaRange(mediaRange string) (string, float64) {
+	parts := strings.Split(mediaRange, ";")
+	q := 1.0
+	for _, param := range parts[1:] {
+		name, value := splitParam(param)
+		if name != "q" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0
+		}
+		q = parsed
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), q
+}
+
+func splitParam(param string) (string, string) {
+	kv := strings.SplitN(param, "=", 2)
+	if len(kv) != 2 {
+		return strings.TrimSpace(kv[0]), ""
+	}
+	return strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+}
+
+// mediaRangeSpecificity returns 2 if mediaType is the offer, 1 if it is the
+// offer's "type/*" range, 0 for "*/*" and -1 if it doesn't match the offer.
+func mediaRangeSpecificity(mediaType, offer string) int {
+	switch {
+	case mediaType == offer:
+		return 2
+	case mediaType == strings.SplitN(offer, "/", 2)[0]+"/*":
+		return 1
+	case mediaType == "*/*":
+		return 0
+	}
+	return -1
+}
+
+// certsOnlyPKCS7 returns a DER encoded "certs-only" PKCS #7 SignedData
+// ContentInfo holding the certificates, with no signers (RFC 8551 Section
+// 3.2.2).
+func certsOnlyPKCS7(certs [][]byte) ([]byte, error) {
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	signedData := struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      joinDER(certs),
+		},
+		SignerInfos: emptySet,
+	}
+	signedData.ContentInfo.ContentType = oidPKCS7Data
+	signedDataDER, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidPKCS7SignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      signedDataDER,
+		},
+	})
+}
+
+func joinDER(ders [][]byte) []byte {
+	var joined []byte
+	for _, der := range ders {
+		joined = append(joined, der...)
+	}
+	return joined
+}
@@ -0,0 +1,126 @@
+package wfe
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// Faults that can be injected by a ChaosRule
+const (
+	// FaultHTTP500 returns a bare 500 response that isn't an ACME problem
+	FaultHTTP500 = "http500"
+	// FaultServerInternal returns a serverInternal problem
+	FaultServerInternal = "serverInternal"
+	// FaultTruncate handles the request but cuts the response body short
+	FaultTruncate = "truncate"
+	// FaultSlow delays the response by Delay and adds a Retry-After header if
+	// RetryAfter is set
+	FaultSlow = "slow"
+)
+
+// ChaosRule injects a fault into a share of the requests to an ACME endpoint,
+// so that client retry and backoff behaviour can be tested.
+type ChaosRule struct {
+	// Endpoint is the path of the endpoint, e.g. "/order-plz". An empty
+	// Endpoint matches every endpoint except the directory.
+	Endpoint string
+	// Probability is the chance from 0 to 1 that a matching request gets the
+	// fault
+	Probability float64
+	// Fault is one of "http500", "serverInternal", "truncate" or "slow"
+	Fault string
+	// Delay and RetryAfter (in seconds) are only used by the "slow" fault
+	Delay      core.Duration
+	RetryAfter int
+}
+
+// Validate returns an error if the rule has an unknown fault or an invalid
+// probability.
+func (r ChaosRule) Validate() error {
+	switch r.Fault {
+	case FaultHTTP500, FaultServerInternal, FaultTruncate, FaultSlow:
+	default:
+		return fmt.Errorf("unknown chaos fault %q", r.Fault)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("chaos probability %v is not between 0 and 1", r.Probability)
+	}
+	if r.Delay.Duration < 0 || r.RetryAfter < 0 {
+		return fmt.Errorf("chaos delay and retryAfter can't be negative")
+	}
+	return nil
+}
+
+// chaosFault returns the first rule for the endpoint pattern that is rolled
+// for this request, or nil if no fault should be injected.
+func (wfe *WebFrontEndImpl) chaosFault(pattern string) *ChaosRule {
+	if pattern == directoryPath {
+		return nil
+	}
+	chaos := wfe.settings.get().chaos
+	for i := range chaos {
+		rule := &chaos[i]
+		if rule.Endpoint != "" && rule.Endpoint != pattern {
+			continue
+		}
+		if rand.Float64() < rule.Probability {
+			return rule
+		}
+	}
+	return nil
+}
+
+// injectFault applies the rule's fault to the request. It returns true if the
+// response was written and the handler must not be called. Otherwise the
+// returned writer must be used by the handler and finished afterwards.
+func (wfe *WebFrontEndImpl) injectFault(
+	rule *ChaosRule,
+	response http.ResponseWriter) (http.ResponseWriter, func(), bool) {
+	switch rule.Fault {
+	case FaultHTTP500:
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return nil, nil, true
+	case FaultServerInternal:
+		wfe.sendError(acme.InternalErrorProblem("Injected fault, retry later"), response)
+		return nil, nil, true
+	case FaultSlow:
+		time.Sleep(rule.Delay.Duration)
+		if rule.RetryAfter > 0 {
+			response.Header().Set("Retry-After", strconv.Itoa(rule.RetryAfter))
+		}
+		return response, func() {}, false
+	}
+	tw := &truncatingWriter{ResponseWriter: response, status: http.StatusOK}
+	return tw, tw.finish, false
+}
+
+// truncatingWriter buffers a response and only writes the first half of its
+// body, while advertising the full Content-Length so clients see the
+// connection end early.
+type truncatingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (tw *truncatingWriter) WriteHeader(status int) {
+	tw.status = status
+}
+
+func (tw *truncatingWriter) Write(data []byte) (int, error) {
+	return tw.body.Write(data)
+}
+
+func (tw *truncatingWriter) finish() {
+	body := tw.body.Bytes()
+	tw.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	tw.ResponseWriter.WriteHeader(tw.status)
+	_, _ = tw.ResponseWriter.Write(body[:len(body)/2])
+}
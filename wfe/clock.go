@@ -0,0 +1,82 @@
+package wfe
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// clockState is the JSON representation of the clock returned by the Clock
+// management handler.
+type clockState struct {
+	Now    time.Time `json:"now"`
+	Offset string    `json:"offset"`
+}
+
+// clockChange is the body of a POST to the Clock management handler. Exactly
+// one of its fields must be set.
+type clockChange struct {
+	// Advance moves the clock forward by the duration, or back if it is
+	// negative
+	Advance *core.Duration `json:"advance"`
+	// Offset sets the offset of the clock from the system clock
+	Offset *core.Duration `json:"offset"`
+	// Now sets the clock to the time
+	Now *time.Time `json:"now"`
+}
+
+// Clock returns the current time of Pebble's clock for a GET and changes it
+// for a POST. Changing the clock changes the time used for certificate
+// validity, order, authorization and nonce expiry and rate limits. The expired
+// objects of the WFE are swept right away.
+func (wfe *WebFrontEndImpl) Clock(response http.ResponseWriter, request *http.Request) {
+	skewed, ok := wfe.clk.(*core.SkewedClock)
+	if request.Method == "POST" {
+		if !ok {
+			wfe.sendError(acme.MalformedProblem("The clock of this Pebble can't be changed"), response)
+			return
+		}
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem("Unable to read request body"), response)
+			return
+		}
+		var change clockChange
+		err = json.Unmarshal(body, &change)
+		if err != nil {
+			wfe.sendError(
+				acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+			return
+		}
+		switch {
+		case change.Advance != nil && change.Offset == nil && change.Now == nil:
+			skewed.Advance(change.Advance.Duration)
+		case change.Advance == nil && change.Offset != nil && change.Now == nil:
+			skewed.SetOffset(change.Offset.Duration)
+		case change.Advance == nil && change.Offset == nil && change.Now != nil:
+			skewed.Set(*change.Now)
+		default:
+			wfe.sendError(acme.MalformedProblem(
+				"Exactly one of advance, offset and now must be set"), response)
+			return
+		}
+		now := wfe.clk.Now()
+		wfe.log.WithContext(request.Context()).Printf("Clock changed to %s (offset %s)\n",
+			now.UTC().Format(time.RFC3339), skewed.Offset())
+		wfe.sweep(now)
+	}
+
+	state := clockState{Now: wfe.clk.Now().UTC(), Offset: "0s"}
+	if ok {
+		state.Offset = skewed.Offset().String()
+	}
+	err := wfe.writeJsonResponse(response, http.StatusOK, state)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling clock"), response)
+		return
+	}
+}
@@ -0,0 +1,207 @@
+package wfe
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// SAN types that can be named in a CSRPolicy
+const (
+	SANTypeDNS   = "dns"
+	SANTypeIP    = "ip"
+	SANTypeEmail = "email"
+	SANTypeURI   = "uri"
+)
+
+// defaultSANTypes are the SAN types allowed when a CSRPolicy doesn't list any
+var defaultSANTypes = []string{SANTypeDNS, SANTypeIP}
+
+// Strictness levels of a CSRPolicy
+const (
+	// StrictnessLenient accepts duplicate SANs, a CN that isn't one of the
+	// SANs and any CSR attributes. SANs are compared to the order's identifiers
+	// without regard to case or a trailing dot.
+	StrictnessLenient = "lenient"
+	// StrictnessStrict additionally rejects duplicate SANs, a CN that isn't one
+	// of the SANs and challengePassword attributes.
+	StrictnessStrict = "strict"
+	// StrictnessPedantic additionally requires the SANs to be exactly the
+	// order's identifiers as the CA stored them, lowercase and without a
+	// trailing dot, and rejects every attribute except the extension request.
+	StrictnessPedantic = "pedantic"
+)
+
+var (
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+	oidExtensionRequest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+)
+
+// CSRPolicy controls which CSRs the WFE accepts for issuance.
+type CSRPolicy struct {
+	// AllowedSANTypes lists the SAN types ("dns", "ip", "email") a CSR may
+	// contain, e.g. only "dns" for a DNS-only CA. CSRs with other SAN types
+	// (including URI SANs, which are never allowed) are rejected with a badCSR
+	// problem. If empty, DNS and IP SANs are allowed.
+	AllowedSANTypes []string
+	// RejectMustStaple makes the WFE reject CSRs requesting the TLS Feature
+	// (OCSP Must-Staple) extension with a badCSR problem. By default the
+	// extension is copied into the issued certificate.
+	RejectMustStaple bool
+	// Strictness is one of StrictnessLenient (the default), StrictnessStrict
+	// or StrictnessPedantic.
+	Strictness string
+}
+
+// Validate returns an error if the policy names an unknown SAN type.
+func (p CSRPolicy) Validate() error {
+	for _, t := range p.AllowedSANTypes {
+		switch strings.ToLower(t) {
+		case SANTypeDNS, SANTypeIP, SANTypeEmail:
+		default:
+			return fmt.Errorf("unknown SAN type %q in allowedSANTypes", t)
+		}
+	}
+	switch p.Strictness {
+	case "", StrictnessLenient, StrictnessStrict, StrictnessPedantic:
+	default:
+		return fmt.Errorf("unknown strictness %q, expected one of %s, %s or %s",
+			p.Strictness, StrictnessLenient, StrictnessStrict, StrictnessPedantic)
+	}
+	return nil
+}
+
+func (p CSRPolicy) strict() bool {
+	return p.Strictness == StrictnessStrict || p.Strictness == StrictnessPedantic
+}
+
+func (p CSRPolicy) pedantic() bool {
+	return p.Strictness == StrictnessPedantic
+}
+
+// csrIdentifier returns the identifier a CSR SAN is compared to the order's
+// identifiers as. Only a pedantic policy compares the SAN as written.
+func (p CSRPolicy) csrIdentifier(ident acme.Identifier) acme.Identifier {
+	if p.pedantic() {
+		return ident
+	}
+	return normalizeIdentifier(ident)
+}
+
+// checkStrictness returns a badCSR problem if the CSR breaks one of the rules
+// of the policy's strictness level. A lenient policy has none.
+func (p CSRPolicy) checkStrictness(csr *x509.CertificateRequest) *acme.ProblemDetails {
+	if !p.strict() {
+		return nil
+	}
+
+	sans := make(map[acme.Identifier]bool)
+	var idents []acme.Identifier
+	for _, name := range csr.DNSNames {
+		idents = append(idents, acme.Identifier{Type: acme.IdentifierDNS, Value: name})
+	}
+	for _, ip := range csr.IPAddresses {
+		idents = append(idents, acme.Identifier{Type: acme.IdentifierIP, Value: ip.String()})
+	}
+	for _, ident := range idents {
+		ident = p.csrIdentifier(ident)
+		if sans[ident] {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR contains duplicate SAN %s:%s", ident.Type, ident.Value))
+		}
+		sans[ident] = true
+	}
+
+	if cn := csr.Subject.CommonName; cn != "" {
+		dnsCN := p.csrIdentifier(acme.Identifier{Type: acme.IdentifierDNS, Value: cn})
+		ipCN := p.csrIdentifier(acme.Identifier{Type: acme.IdentifierIP, Value: cn})
+		if !sans[dnsCN] && !sans[ipCN] {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR Common Name %q is not one of its SANs", cn))
+		}
+	}
+
+	attrs, err := csrAttributeTypes(csr)
+	if err != nil {
+		return acme.BadCSRProblem("Error parsing CSR attributes: " + err.Error())
+	}
+	for _, attr := range attrs {
+		if attr.Equal(oidChallengePassword) {
+			return acme.BadCSRProblem("CSR contains a challengePassword attribute")
+		}
+		if p.pedantic() && !attr.Equal(oidExtensionRequest) {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR contains an attribute of type %s, only an extension request is accepted", attr))
+		}
+	}
+	return nil
+}
+
+// csrAttributeTypes returns the types of the CSR's attributes. They are
+// parsed from the raw CSR because the x509 package silently skips attributes
+// it can't parse, such as a challengePassword.
+func csrAttributeTypes(csr *x509.CertificateRequest) ([]asn1.ObjectIdentifier, error) {
+	var tbs struct {
+		Version       int
+		Subject       asn1.RawValue
+		PublicKey     asn1.RawValue
+		RawAttributes []asn1.RawValue `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return nil, err
+	}
+	var types []asn1.ObjectIdentifier
+	for _, raw := range tbs.RawAttributes {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue
+		}
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return nil, err
+		}
+		types = append(types, attr.Type)
+	}
+	return types, nil
+}
+
+func (p CSRPolicy) sanTypeAllowed(sanType string) bool {
+	allowed := p.AllowedSANTypes
+	if len(allowed) == 0 {
+		allowed = defaultSANTypes
+	}
+	for _, t := range allowed {
+		if strings.ToLower(t) == sanType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSANTypes returns a badCSR problem if the CSR contains a SAN of a type
+// that the policy doesn't allow.
+func (p CSRPolicy) checkSANTypes(csr *x509.CertificateRequest) *acme.ProblemDetails {
+	present := map[string]int{
+		SANTypeDNS:   len(csr.DNSNames),
+		SANTypeIP:    len(csr.IPAddresses),
+		SANTypeEmail: len(csr.EmailAddresses),
+		SANTypeURI:   len(csr.URIs),
+	}
+	for _, sanType := range []string{SANTypeDNS, SANTypeIP, SANTypeEmail, SANTypeURI} {
+		if present[sanType] > 0 && !p.sanTypeAllowed(sanType) {
+			return acme.BadCSRProblem(fmt.Sprintf(
+				"CSR contains %d %s SAN(s), this CA only accepts SAN types: %s",
+				present[sanType], sanType, strings.Join(p.allowedSANTypes(), ", ")))
+		}
+	}
+	return nil
+}
+
+func (p CSRPolicy) allowedSANTypes() []string {
+	if len(p.AllowedSANTypes) == 0 {
+		return defaultSANTypes
+	}
+	return p.AllowedSANTypes
+}
@@ -0,0 +1,79 @@
+package wfe
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// DirectoryMeta configures the meta object of the directory (RFC 8555 Section
+// 7.1.1). Empty fields are omitted, except for the terms of service which
+// default to ToSURL.
+type DirectoryMeta struct {
+	// TermsOfService is the URL of the terms of service new accounts must
+	// agree to
+	TermsOfService string
+	// Website is the URL of a website describing the CA
+	Website string
+	// CAAIdentities are the issuer domain names the CA recognizes in CAA
+	// records
+	CAAIdentities []string
+	// ExternalAccountRequired advertises that new accounts need an external
+	// account binding. It is always advertised when bindings are required by
+	// externalAccountBindingRequired, which is what enforces them.
+	ExternalAccountRequired bool
+}
+
+// Validate returns an error if the terms of service or website aren't
+// absolute URLs or a CAA identity is empty.
+func (m DirectoryMeta) Validate() error {
+	for field, value := range map[string]string{
+		"termsOfService": m.TermsOfService,
+		"website":        m.Website,
+	} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+			return fmt.Errorf("%s %q is not an absolute URL", field, value)
+		}
+	}
+	for _, identity := range m.CAAIdentities {
+		if identity == "" {
+			return fmt.Errorf("caaIdentities must not contain empty names")
+		}
+	}
+	return nil
+}
+
+// termsOfService returns the configured terms of service URL or ToSURL.
+func (m DirectoryMeta) termsOfService() string {
+	if m.TermsOfService != "" {
+		return m.TermsOfService
+	}
+	return ToSURL
+}
+
+// object returns the meta object of the directory with the current terms of
+// service URL. requireEAB forces externalAccountRequired. The profiles are the
+// descriptions of the certificate profiles by name, they are omitted if there
+// are none.
+func (m DirectoryMeta) object(tos string, requireEAB bool, profiles map[string]string) map[string]interface{} {
+	meta := map[string]interface{}{
+		"termsOfService": tos,
+		// The name used by ACME drafts before RFC 8555, kept for older clients
+		"terms-of-service": tos,
+	}
+	if m.Website != "" {
+		meta["website"] = m.Website
+	}
+	if len(m.CAAIdentities) > 0 {
+		meta["caaIdentities"] = m.CAAIdentities
+	}
+	if requireEAB || m.ExternalAccountRequired {
+		meta["externalAccountRequired"] = true
+	}
+	if len(profiles) > 0 {
+		meta["profiles"] = profiles
+	}
+	return meta
+}
@@ -0,0 +1,39 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/logging"
+)
+
+// validationContext returns the context a validation submitted by a challenge
+// POST runs with. It has the request ID of ctx but, unlike ctx, isn't
+// cancelled when the request ends. The returned func must be called once the
+// response has been written. It logs whether the client disconnected before
+// receiving the response and, if cancelValidationOnDisconnect is set, cancels
+// the validation in that case.
+func (wfe *WebFrontEndImpl) validationContext(
+	ctx context.Context,
+	chalID string) (context.Context, func(http.ResponseWriter)) {
+	vaCtx, cancel := context.WithCancel(
+		logging.ContextWithRequestID(context.Background(), logging.RequestID(ctx)))
+
+	return vaCtx, func(response http.ResponseWriter) {
+		// Flush the response so that a client that went away before receiving it
+		// is noticed, the request context is cancelled when the write fails
+		if flusher, ok := response.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if ctx.Err() == nil {
+			return
+		}
+		log := wfe.log.WithContext(ctx)
+		if !wfe.cancelValidationOnDisconnect {
+			log.Printf("Client disconnected during POST to challenge %s, continuing validation\n", chalID)
+			return
+		}
+		log.Printf("Client disconnected during POST to challenge %s, cancelling validation\n", chalID)
+		cancel()
+	}
+}
@@ -0,0 +1,172 @@
+package wfe
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	// defaultAuthzExpiryWindow is the window of the expiring authorizations
+	// management query when neither the query nor the config names one
+	defaultAuthzExpiryWindow = 24 * time.Hour
+
+	// How often are valid authorizations checked for upcoming expiry?
+	authzExpiryCheckInterval = 10 * time.Second
+)
+
+// authzExpiryWatcher emits a warning event for every valid authorization that
+// is about to expire, so that clients re-validating before expiry can be
+// tested.
+type authzExpiryWatcher struct {
+	sync.Mutex
+	// window is how long before its expiry an authorization is warned about,
+	// or zero if no warnings are emitted
+	window time.Duration
+	// warned holds the IDs of the authorizations already warned about
+	warned map[string]bool
+}
+
+func newAuthzExpiryWatcher(window time.Duration) *authzExpiryWatcher {
+	return &authzExpiryWatcher{
+		window: window,
+		warned: make(map[string]bool),
+	}
+}
+
+// expiringAuthorizations returns the valid authorizations that expire within
+// the window, soonest first.
+func expiringAuthorizations(
+	memStore *db.MemoryStore,
+	now time.Time,
+	window time.Duration) []*core.Authorization {
+	var expiring []*core.Authorization
+	var expiries []time.Time
+	for _, authz := range memStore.ListAuthorizations() {
+		authz.RLock()
+		expires := authz.ExpiresDate
+		valid := authz.Status == acme.StatusValid
+		authz.RUnlock()
+		if valid && expires.After(now) && expires.Sub(now) <= window {
+			expiring = append(expiring, authz)
+			expiries = append(expiries, expires)
+		}
+	}
+	sort.Sort(byExpiry{expiring, expiries})
+	return expiring
+}
+
+// byExpiry sorts authorizations by their expiries, which are copied so that
+// sorting doesn't need the authorization locks.
+type byExpiry struct {
+	authzs   []*core.Authorization
+	expiries []time.Time
+}
+
+func (s byExpiry) Len() int           { return len(s.authzs) }
+func (s byExpiry) Less(i, j int) bool { return s.expiries[i].Before(s.expiries[j]) }
+func (s byExpiry) Swap(i, j int) {
+	s.authzs[i], s.authzs[j] = s.authzs[j], s.authzs[i]
+	s.expiries[i], s.expiries[j] = s.expiries[j], s.expiries[i]
+}
+
+// watch logs an "authz-expiry-warning" event once for each valid
+// authorization that comes within the window of its expiry, until stop is
+// closed.
+func (w *authzExpiryWatcher) watch(
+	log *logging.Logger,
+	clk clock.Clock,
+	memStore *db.MemoryStore,
+	stop <-chan struct{}) {
+	log.Printf("Warning about valid authorizations %s before they expire", w.window)
+	ticker := time.NewTicker(authzExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		now := clk.Now().UTC()
+		for _, authz := range expiringAuthorizations(memStore, now, w.window) {
+			w.Lock()
+			warned := w.warned[authz.ID]
+			w.warned[authz.ID] = true
+			w.Unlock()
+			if warned {
+				continue
+			}
+			authz.RLock()
+			log.Printf("Event authz-expiry-warning: authz %s for %s of account %s expires %s, in %s",
+				authz.ID, authz.Identifier.Value, authz.AccountID,
+				authz.ExpiresDate.Format(time.RFC3339), authz.ExpiresDate.Sub(now).Round(time.Second))
+			authz.RUnlock()
+		}
+	}
+}
+
+// warningsEmitted returns how many authorizations have been warned about.
+func (w *authzExpiryWatcher) warningsEmitted() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.warned)
+}
+
+type mgmtExpiringAuthorization struct {
+	mgmtAuthorization
+	// Warned is true if an authz-expiry-warning event was emitted for the
+	// authorization
+	Warned bool `json:"warned"`
+}
+
+// ExpiringAuthorizations lists the valid authorizations expiring within the
+// duration of the "within" query parameter, by default the configured expiry
+// warning window or 24 hours.
+func (wfe *WebFrontEndImpl) ExpiringAuthorizations(response http.ResponseWriter, request *http.Request) {
+	window := wfe.authzExpiry.window
+	if window == 0 {
+		window = defaultAuthzExpiryWindow
+	}
+	if within := request.URL.Query().Get("within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+				"Invalid within duration %q", within)), response)
+			return
+		}
+		window = d
+	}
+
+	result := struct {
+		Within          string                      `json:"within"`
+		WarningsEmitted int                         `json:"warningsEmitted"`
+		Authorizations  []mgmtExpiringAuthorization `json:"authorizations"`
+	}{
+		Within:          window.String(),
+		WarningsEmitted: wfe.authzExpiry.warningsEmitted(),
+		Authorizations:  []mgmtExpiringAuthorization{},
+	}
+	for _, authz := range expiringAuthorizations(wfe.db, wfe.clk.Now().UTC(), window) {
+		wfe.authzExpiry.Lock()
+		warned := wfe.authzExpiry.warned[authz.ID]
+		wfe.authzExpiry.Unlock()
+		result.Authorizations = append(result.Authorizations, mgmtExpiringAuthorization{
+			mgmtAuthorization: mgmtAuthorizationView(authz),
+			Warned:            warned,
+		})
+	}
+	err := wfe.writeJsonResponse(response, http.StatusOK, result)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling expiring authorizations"), response)
+		return
+	}
+}
@@ -0,0 +1,171 @@
+//go:build gofuzz
+// +build gofuzz
+
+package wfe
+
+// Fuzz targets for go-fuzz (https://github.com/dvyukov/go-fuzz). Build one of
+// them with e.g.:
+//
+//   go-fuzz-build -func FuzzJWS github.com/letsencrypt/pebble/wfe
+//   go-fuzz -bin wfe-fuzz.zip -workdir fuzz/jws
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/va"
+)
+
+// fuzzHost is the host of the requests made by httptest.NewRequest
+const fuzzHost = "example.com"
+
+var (
+	fuzzWFE     = newFuzzWFE()
+	fuzzHandler = fuzzWFE.Handler()
+	fuzzKey     = newFuzzKey()
+	fuzzAcct    = newFuzzAccount()
+)
+
+func newFuzzWFE() *WebFrontEndImpl {
+	logger := logging.New(ioutil.Discard, logging.FormatText)
+	clk := clock.Default()
+	// The VA decides which challenges new orders get, no challenge is ever
+	// validated
+	fuzzVA := va.New(logger, clk, va.Config{NoSleep: true})
+	wfe := New(logger, clk, db.NewMemoryStore(), fuzzVA, nil, Config{})
+	return &wfe
+}
+
+func newFuzzKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// newFuzzAccount adds an account for fuzzKey so that POSTs signed with a "kid"
+// reach the handlers.
+func newFuzzAccount() *core.Account {
+	acct := &core.Account{
+		ID:  "fuzz",
+		Key: &jose.JSONWebKey{Key: &fuzzKey.PublicKey},
+		Account: acme.Account{
+			Status: acme.StatusValid,
+		},
+	}
+	if _, err := fuzzWFE.db.AddAccount(acct); err != nil {
+		panic(err)
+	}
+	return acct
+}
+
+// FuzzJWS parses data as the JWS body of a POST to newAccount and checks its
+// embedded key, algorithm, signature and URL.
+func FuzzJWS(data []byte) int {
+	jws, err := fuzzWFE.parseJWS(string(data))
+	if err != nil {
+		return 0
+	}
+	key, prob := fuzzWFE.extractJWK(nil, jws)
+	if prob != nil {
+		return 0
+	}
+	if prob := fuzzWFE.checkAlgorithm(key, jws); prob != nil {
+		return 0
+	}
+	if _, err := jws.Verify(key); err != nil {
+		return 0
+	}
+	request := httptest.NewRequest("POST", newAccountPath, nil)
+	if prob := checkJWSURL(request, jws); prob != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzCSR parses data as the DER CSR of a finalize request and checks it
+// against an order for example.com.
+func FuzzCSR(data []byte) int {
+	csr, prob := parseCSR(base64.RawURLEncoding.EncodeToString(data))
+	if prob != nil {
+		return 0
+	}
+	order := &core.Order{
+		Order: acme.Order{
+			Identifiers: []acme.Identifier{{Type: acme.IdentifierDNS, Value: "example.com"}},
+		},
+	}
+	if prob := fuzzWFE.verifyFinalizeCSR(order, csr, fuzzAcct); prob != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzNewAccount POSTs data as the payload of a validly signed newAccount
+// request.
+func FuzzNewAccount(data []byte) int {
+	return fuzzPOST(newAccountPath, data, true)
+}
+
+// FuzzNewOrder POSTs data as the payload of a validly signed newOrder request.
+func FuzzNewOrder(data []byte) int {
+	return fuzzPOST(newOrderPath, data, false)
+}
+
+// fuzzPOST signs payload with fuzzKey and POSTs it to path. Panics in the
+// handlers are turned into serverInternal problems by recoverPanic, so any 500
+// response is reported as a crash.
+func fuzzPOST(path string, payload []byte, embedJWK bool) int {
+	url := "http://" + fuzzHost + path
+	opts := &jose.SignerOptions{
+		EmbedJWK: embedJWK,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{
+			"url":   url,
+			"nonce": fuzzWFE.nonce.createNonce(),
+		},
+	}
+	signingKey := jose.SigningKey{Algorithm: jose.ES256, Key: fuzzKey}
+	if !embedJWK {
+		signingKey.Key = &jose.JSONWebKey{
+			Key:   fuzzKey,
+			KeyID: "http://" + fuzzHost + acctPath + fuzzAcct.ID,
+		}
+	}
+	signer, err := jose.NewSigner(signingKey, opts)
+	if err != nil {
+		panic(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		panic(err)
+	}
+	body := []byte(jws.FullSerialize())
+
+	request := httptest.NewRequest("POST", path, bytes.NewReader(body))
+	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	response := httptest.NewRecorder()
+	fuzzHandler.ServeHTTP(response, request)
+	if response.Code == http.StatusInternalServerError {
+		panic(fmt.Sprintf("POST %s returned %d: %s", path, response.Code, response.Body))
+	}
+	if response.Code >= 400 {
+		return 0
+	}
+	return 1
+}
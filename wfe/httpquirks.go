@@ -0,0 +1,206 @@
+package wfe
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The framings of HTTPQuirks
+const (
+	// framingChunked sends the body with chunked transfer encoding, in uneven
+	// chunks with chunk extensions and a trailer
+	framingChunked = "chunked"
+	// framingClose sends the body without a Content-Length and ends it by
+	// closing the connection
+	framingClose = "close"
+)
+
+// quirksBodyLengthTrailer is the trailer field of chunked quirky responses
+const quirksBodyLengthTrailer = "Pebble-Body-Length"
+
+// quirksListHeaders are the header fields whose comma separated values
+// OddHeaders splits over repeated fields
+var quirksListHeaders = map[string]bool{
+	"Allow":         true,
+	"Cache-Control": true,
+	"Vary":          true,
+}
+
+// HTTPQuirks serves the directory and certificate responses with uncommon but
+// legal HTTP/1.1 framing and formatting, to test the HTTP handling of minimal
+// ACME clients. Responses with a framing or odd headers are written to the
+// hijacked connection, which is closed afterwards. Over HTTP/2 only Gzip
+// applies.
+type HTTPQuirks struct {
+	// Framing is "chunked" for chunked transfer encoding, "close" for a body
+	// without a Content-Length that ends when the connection is closed, or
+	// empty for a normal Content-Length
+	Framing string
+	// Gzip compresses the body with Content-Encoding gzip when the request
+	// accepts gzip
+	Gzip bool
+	// OddHeaders writes header field names in lower case, surrounds their
+	// values with optional whitespace and splits list values over repeated
+	// fields
+	OddHeaders bool
+}
+
+// Validate returns an error if the framing isn't supported.
+func (q HTTPQuirks) Validate() error {
+	switch q.Framing {
+	case "", framingChunked, framingClose:
+		return nil
+	}
+	return fmt.Errorf("framing must be %q or %q, got %q", framingChunked, framingClose, q.Framing)
+}
+
+func (q HTTPQuirks) enabled() bool {
+	return q.Framing != "" || q.Gzip || q.OddHeaders
+}
+
+// quirksWriter buffers a response so that finish can write it with the
+// configured quirks.
+type quirksWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (qw *quirksWriter) WriteHeader(status int) {
+	qw.status = status
+}
+
+func (qw *quirksWriter) Write(data []byte) (int, error) {
+	return qw.body.Write(data)
+}
+
+// quirkyResponse returns a writer for the handler of the request and the func
+// that writes its response with the quirks afterwards. conn is the writer of
+// the server's connection, which is hijacked for framing and odd headers.
+func (wfe *WebFrontEndImpl) quirkyResponse(
+	response http.ResponseWriter,
+	conn http.ResponseWriter,
+	request *http.Request) (http.ResponseWriter, func()) {
+	qw := &quirksWriter{ResponseWriter: response, status: http.StatusOK}
+	return qw, func() {
+		body := qw.body.Bytes()
+		header := qw.Header()
+		if wfe.httpQuirks.Gzip && acceptsGzip(request) {
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			_, _ = gz.Write(body)
+			_ = gz.Close()
+			body = compressed.Bytes()
+			header.Set("Content-Encoding", "gzip")
+			header.Add("Vary", "Accept-Encoding")
+		}
+		hijacker, ok := conn.(http.Hijacker)
+		if (wfe.httpQuirks.Framing == "" && !wfe.httpQuirks.OddHeaders) || !ok || request.ProtoMajor != 1 {
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			qw.ResponseWriter.WriteHeader(qw.status)
+			_, _ = qw.ResponseWriter.Write(body)
+			return
+		}
+		netConn, rw, err := hijacker.Hijack()
+		if err != nil {
+			wfe.log.WithContext(request.Context()).Printf("Error hijacking connection for HTTP quirks: %s\n", err.Error())
+			return
+		}
+		defer netConn.Close()
+		wfe.writeQuirkyResponse(rw.Writer, qw.status, header, body, request.Method == http.MethodHead)
+		_ = rw.Flush()
+	}
+}
+
+// writeQuirkyResponse writes a complete HTTP/1.1 response, without the body
+// for a HEAD request. The connection is always closed afterwards, so the
+// response says so.
+func (wfe *WebFrontEndImpl) writeQuirkyResponse(
+	w *bufio.Writer,
+	status int,
+	header http.Header,
+	body []byte,
+	head bool) {
+	header = header.Clone()
+	header.Set("Date", wfe.clk.Now().UTC().Format(http.TimeFormat))
+	header.Set("Connection", "close")
+	switch wfe.httpQuirks.Framing {
+	case framingChunked:
+		header.Set("Transfer-Encoding", "chunked")
+		header.Set("Trailer", quirksBodyLengthTrailer)
+	case framingClose:
+	default:
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	wfe.writeQuirkyHeader(w, header)
+	_, _ = w.WriteString("\r\n")
+
+	if head {
+		return
+	}
+	if wfe.httpQuirks.Framing != framingChunked {
+		_, _ = w.Write(body)
+		return
+	}
+	// Chunks of 1, 2, 4, ... bytes, every other one with a chunk extension
+	length := len(body)
+	for size, i := 1, 0; len(body) > 0; size, i = size*2, i+1 {
+		if size > len(body) {
+			size = len(body)
+		}
+		ext := ""
+		if i%2 == 1 {
+			ext = ";pebble=quirk"
+		}
+		fmt.Fprintf(w, "%X%s\r\n", size, ext)
+		_, _ = w.Write(body[:size])
+		_, _ = w.WriteString("\r\n")
+		body = body[size:]
+	}
+	_, _ = w.WriteString("0\r\n")
+	fmt.Fprintf(w, "%s: %d\r\n\r\n", quirksBodyLengthTrailer, length)
+}
+
+// writeQuirkyHeader writes the header fields in a stable order, with the odd
+// formatting if it is enabled.
+func (wfe *WebFrontEndImpl) writeQuirkyHeader(w *bufio.Writer, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if !wfe.httpQuirks.OddHeaders {
+				fmt.Fprintf(w, "%s: %s\r\n", name, value)
+				continue
+			}
+			elements := []string{value}
+			if quirksListHeaders[name] {
+				elements = strings.Split(value, ",")
+			}
+			for _, element := range elements {
+				fmt.Fprintf(w, "%s:\t %s \r\n", strings.ToLower(name), strings.TrimSpace(element))
+			}
+		}
+	}
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding accepts gzip.
+func acceptsGzip(request *http.Request) bool {
+	for _, coding := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		name, q := parseMediaRange(coding)
+		if (name == "gzip" || name == "*") && q > 0 {
+			return true
+		}
+	}
+	return false
+}
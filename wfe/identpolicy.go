@@ -0,0 +1,100 @@
+package wfe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// punycodePrefix starts the ACE labels of internationalized domain names
+const punycodePrefix = "xn--"
+
+// IdentifierPolicy controls which DNS identifiers the WFE accepts in newOrder
+// and newAuthz requests. Rejected names get a rejectedIdentifier problem, with
+// a subproblem for each name when several are rejected. By default every well
+// formed name is accepted.
+type IdentifierPolicy struct {
+	// BlockedDomains are forbidden together with all of their subdomains,
+	// e.g. "example.net" also forbids "www.example.net" and "*.example.net"
+	BlockedDomains []string
+	// BlockedPatterns are regular expressions in the syntax of the Go regexp
+	// package. Names they match anywhere, including the "*." of wildcards, are
+	// forbidden unless the pattern is anchored with ^ and $.
+	BlockedPatterns []string
+	// PublicSuffixes are forbidden themselves, e.g. "co.uk" or "github.io",
+	// but their subdomains are accepted
+	PublicSuffixes []string
+	// RejectTLDs forbids names with a single label, e.g. "com" or "localhost"
+	RejectTLDs bool
+	// RejectPunycode forbids names with a punycode ("xn--") label
+	RejectPunycode bool
+
+	// patterns are the compiled BlockedPatterns
+	patterns []*regexp.Regexp
+}
+
+// Load validates the policy, normalizes its domains like the identifiers
+// they are compared with and compiles its BlockedPatterns.
+func (p *IdentifierPolicy) Load() error {
+	for _, domains := range [][]string{p.BlockedDomains, p.PublicSuffixes} {
+		for i, domain := range domains {
+			if domain == "" || strings.HasPrefix(domain, ".") || strings.Contains(domain, "*") {
+				return fmt.Errorf("%q is not a domain name", domain)
+			}
+			domains[i] = normalizeIdentifier(acme.Identifier{Type: acme.IdentifierDNS, Value: domain}).Value
+		}
+	}
+	p.patterns = nil
+	for _, pattern := range p.BlockedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid blockedPatterns entry %q: %s", pattern, err.Error())
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return nil
+}
+
+// check returns a rejectedIdentifier problem if the policy forbids a
+// normalized identifier. Only DNS identifiers are checked.
+func (p IdentifierPolicy) check(ident acme.Identifier) *acme.ProblemDetails {
+	if ident.Type != acme.IdentifierDNS {
+		return nil
+	}
+	name := ident.Value
+	// Wildcards are checked for the name they cover
+	base := strings.TrimPrefix(name, "*.")
+	for _, domain := range p.BlockedDomains {
+		if base == domain || strings.HasSuffix(base, "."+domain) {
+			return acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"Policy forbids issuing for %q: the domain %q is blocked", name, domain))
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(name) {
+			return acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"Policy forbids issuing for %q: it matches the blocked pattern %q", name, re.String()))
+		}
+	}
+	for _, suffix := range p.PublicSuffixes {
+		if base == suffix {
+			return acme.RejectedIdentifierProblem(fmt.Sprintf(
+				"Policy forbids issuing for %q: %q is a public suffix", name, suffix))
+		}
+	}
+	if p.RejectTLDs && !strings.Contains(base, ".") {
+		return acme.RejectedIdentifierProblem(fmt.Sprintf(
+			"Policy forbids issuing for %q: it is a top-level domain", name))
+	}
+	if p.RejectPunycode {
+		for _, label := range strings.Split(base, ".") {
+			if strings.HasPrefix(label, punycodePrefix) {
+				return acme.RejectedIdentifierProblem(fmt.Sprintf(
+					"Policy forbids issuing for %q: the label %q is punycode", name, label))
+			}
+		}
+	}
+	return nil
+}
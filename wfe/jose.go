@@ -2,56 +2,101 @@ package wfe
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"fmt"
+	"strings"
 
 	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+var (
+	// supportedJWSAlgorithms are the JWS algorithms the WFE can verify. Only
+	// these may be allowed with the jwsAlgorithms config field.
+	supportedJWSAlgorithms = []string{
+		string(jose.RS256), string(jose.RS384), string(jose.RS512),
+		string(jose.PS256), string(jose.PS384), string(jose.PS512),
+		string(jose.ES256), string(jose.ES384), string(jose.ES512),
+		string(jose.EdDSA),
+	}
+
+	// defaultJWSAlgorithms are the JWS algorithms allowed when none are
+	// configured
+	defaultJWSAlgorithms = []string{
+		string(jose.RS256), string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	}
 )
 
-func algorithmForKey(key *jose.JSONWebKey) (string, error) {
+// CheckJWSAlgorithms returns an error if one of algs isn't a supported JWS
+// algorithm. An empty list allows the default algorithms.
+func CheckJWSAlgorithms(algs []string) error {
+	for _, alg := range algs {
+		if !containsAlgorithm(supportedJWSAlgorithms, alg) {
+			return fmt.Errorf("unsupported JWS algorithm %q, expected one of %s",
+				alg, strings.Join(supportedJWSAlgorithms, ", "))
+		}
+	}
+	return nil
+}
+
+func containsAlgorithm(algs []string, alg string) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// keyAlgorithms returns the supported JWS algorithms that can be used with
+// the key, based on its Golang type.
+func keyAlgorithms(key *jose.JSONWebKey) []string {
 	switch k := key.Key.(type) {
 	case *rsa.PublicKey:
-		return string(jose.RS256), nil
+		return []string{
+			string(jose.RS256), string(jose.RS384), string(jose.RS512),
+			string(jose.PS256), string(jose.PS384), string(jose.PS512),
+		}
 	case *ecdsa.PublicKey:
 		switch k.Params().Name {
 		case "P-256":
-			return string(jose.ES256), nil
+			return []string{string(jose.ES256)}
 		case "P-384":
-			return string(jose.ES384), nil
+			return []string{string(jose.ES384)}
 		case "P-521":
-			return string(jose.ES512), nil
+			return []string{string(jose.ES512)}
 		}
+	case ed25519.PublicKey:
+		return []string{string(jose.EdDSA)}
 	}
-	return "", fmt.Errorf("no signature algorithms suitable for given key type")
+	return nil
 }
 
-const (
-	noAlgorithmForKey     = "WFE.Errors.NoAlgorithmForKey"
-	invalidJWSAlgorithm   = "WFE.Errors.InvalidJWSAlgorithm"
-	invalidAlgorithmOnKey = "WFE.Errors.InvalidAlgorithmOnKey"
-)
-
-// Check that (1) there is a suitable algorithm for the provided key based on its
-// Golang type, (2) the Algorithm field on the JWK is either absent, or matches
-// that algorithm, and (3) the Algorithm field on the JWK is present and matches
+// Check that (1) the algorithm in the JWS header is one of the allowed
+// algorithms, (2) it can be used with the provided key based on its Golang
+// type, and (3) the Algorithm field on the JWK is either absent, or matches
 // that algorithm. Precondition: parsedJws must have exactly one signature on
-// it. Returns stat name to increment if err is non-nil.
-func checkAlgorithm(key *jose.JSONWebKey, parsedJws *jose.JSONWebSignature) (string, error) {
-	algorithm, err := algorithmForKey(key)
-	if err != nil {
-		return noAlgorithmForKey, err
-	}
+// it. A JWS algorithm that isn't allowed is a badSignatureAlgorithm problem
+// listing the allowed algorithms.
+func (wfe *WebFrontEndImpl) checkAlgorithm(
+	key *jose.JSONWebKey,
+	parsedJws *jose.JSONWebSignature) *acme.ProblemDetails {
 	jwsAlgorithm := parsedJws.Signatures[0].Header.Algorithm
-	if jwsAlgorithm != algorithm {
-		return invalidJWSAlgorithm,
-			fmt.Errorf(
-				"signature type '%s' in JWS header is not supported, expected one of RS256, ES256, ES384 or ES512",
-				jwsAlgorithm)
-	}
-	if key.Algorithm != "" && key.Algorithm != algorithm {
-		return invalidAlgorithmOnKey,
-			fmt.Errorf(
-				"algorithm '%s' on JWK is unacceptable", key.Algorithm)
-	}
-	return "", nil
+	if !containsAlgorithm(wfe.jwsAlgorithms, jwsAlgorithm) {
+		return acme.BadSignatureAlgorithmProblem(
+			fmt.Sprintf("signature type '%s' in JWS header is not supported, expected one of %s",
+				jwsAlgorithm, strings.Join(wfe.jwsAlgorithms, ", ")),
+			wfe.jwsAlgorithms)
+	}
+	if !containsAlgorithm(keyAlgorithms(key), jwsAlgorithm) {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"signature type '%s' in JWS header can't be used with the JWK", jwsAlgorithm))
+	}
+	if key.Algorithm != "" && key.Algorithm != jwsAlgorithm {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"algorithm '%s' on JWK is unacceptable", key.Algorithm))
+	}
+	return nil
 }
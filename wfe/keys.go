@@ -0,0 +1,120 @@
+package wfe
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// defaultMinRSABits is the smallest RSA modulus accepted when a KeyPolicy
+	// doesn't set one
+	defaultMinRSABits = 2048
+
+	// minRSAExponent is the smallest RSA public exponent accepted
+	minRSAExponent = 65537
+)
+
+// KeyPolicy controls which account keys and CSR public keys the WFE accepts.
+// Rejected keys get a badPublicKey problem. Keys of certificates revoked for
+// key compromise are always rejected.
+type KeyPolicy struct {
+	// MinRSABits is the smallest RSA modulus size accepted, 2048 if zero
+	MinRSABits int
+	// BlockedKeysFile is the path of a file listing blocked keys, one per line.
+	// A line is either the hex SHA-256 hash of a DER SubjectPublicKeyInfo or a
+	// 20 hex digit RSA fingerprint in the format of the Debian weak keys lists
+	// (the openssl-blacklist package). Empty lines and lines starting with "#"
+	// are ignored.
+	BlockedKeysFile string
+
+	// blocked holds the lowercase hex hashes read from BlockedKeysFile
+	blocked map[string]bool
+}
+
+// Load validates the policy and reads its BlockedKeysFile, if any.
+func (p *KeyPolicy) Load() error {
+	if p.MinRSABits < 0 {
+		return fmt.Errorf("minRSABits must not be negative, got %d", p.MinRSABits)
+	}
+	if p.BlockedKeysFile == "" {
+		return nil
+	}
+	f, err := os.Open(p.BlockedKeysFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	p.blocked = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(line); err != nil || (len(line) != 64 && len(line) != 20) {
+			return fmt.Errorf("%s:%d: expected a SHA-256 SPKI hash or a Debian weak key fingerprint, got %q",
+				p.BlockedKeysFile, lineNum, line)
+		}
+		p.blocked[line] = true
+	}
+	return scanner.Err()
+}
+
+// debianFingerprint returns the fingerprint of an RSA key used by the Debian
+// weak keys lists: the last 20 hex digits of the SHA-1 hash of the modulus as
+// printed by "openssl rsa -modulus".
+func debianFingerprint(key *rsa.PublicKey) string {
+	hash := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", key.N.Bytes())))
+	return hex.EncodeToString(hash[10:])
+}
+
+// checkKey returns a badPublicKey problem if key is a weak RSA key, is listed
+// in the BlockedKeysFile or belongs to a certificate revoked for key
+// compromise.
+func (wfe *WebFrontEndImpl) checkKey(key crypto.PublicKey) *acme.ProblemDetails {
+	if jwk, ok := key.(*jose.JSONWebKey); ok {
+		key = jwk.Key
+	}
+	if rsaKey, ok := key.(*rsa.PublicKey); ok {
+		minBits := wfe.keyPolicy.MinRSABits
+		if minBits == 0 {
+			minBits = defaultMinRSABits
+		}
+		if bits := rsaKey.N.BitLen(); bits < minBits {
+			return acme.BadPublicKeyProblem(fmt.Sprintf(
+				"RSA key is %d bits, must be at least %d", bits, minBits))
+		}
+		if rsaKey.E < minRSAExponent || rsaKey.E%2 == 0 {
+			return acme.BadPublicKeyProblem(fmt.Sprintf(
+				"RSA public exponent %d is not allowed, must be odd and at least %d",
+				rsaKey.E, minRSAExponent))
+		}
+		if wfe.keyPolicy.blocked[debianFingerprint(rsaKey)] {
+			return acme.BadPublicKeyProblem("Key is a known weak Debian key")
+		}
+	}
+
+	keyID, err := core.KeyToID(key)
+	if err != nil {
+		return acme.BadPublicKeyProblem("Error computing key ID: " + err.Error())
+	}
+	if wfe.keyPolicy.blocked[keyID] {
+		return acme.BadPublicKeyProblem("Key is blocked")
+	}
+	if wfe.db.IsKeyCompromised(keyID) {
+		return acme.BadPublicKeyProblem(
+			"Key belongs to a certificate that was revoked for key compromise")
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package wfe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// The defaults of Lifetimes
+const (
+	defaultOrderLifetime = 24 * time.Hour
+	defaultNonceLifetime = time.Hour
+	defaultSweepInterval = time.Minute
+	defaultRetention     = 24 * time.Hour
+	defaultMaxNonces     = 100000
+)
+
+// Lifetimes configures how long orders, authorizations and nonces live, how
+// many unused nonces are kept and the sweeper that expires them. Expired
+// pending and ready orders become invalid, expired pending and valid
+// authorizations become expired and their pending challenges invalid. Orders
+// and authorizations are evicted from the database once they have been expired
+// for the retention period. A zero duration uses its default.
+type Lifetimes struct {
+	// Order is how long new orders are valid, by default 24 hours
+	Order core.Duration
+	// PendingAuthorization is how long new authorizations can be validated, by
+	// default an hour
+	PendingAuthorization core.Duration
+	// ValidAuthorization is how long validated authorizations stay valid, by
+	// default an hour. It is applied by the VA.
+	ValidAuthorization core.Duration
+	// Nonce is how long a nonce can be used, by default an hour
+	Nonce core.Duration
+	// MaxNonces is how many unused nonces are kept, by default 100000. The
+	// oldest are evicted when a nonce is issued at the limit.
+	MaxNonces int
+	// SweepInterval is how often expired objects are swept, by default every
+	// minute
+	SweepInterval core.Duration
+	// Retention is how long expired orders and authorizations are kept before
+	// they are evicted, by default 24 hours
+	Retention core.Duration
+}
+
+// Validate returns an error if a lifetime is negative.
+func (l Lifetimes) Validate() error {
+	for name, d := range map[string]core.Duration{
+		"order":                l.Order,
+		"pendingAuthorization": l.PendingAuthorization,
+		"validAuthorization":   l.ValidAuthorization,
+		"nonce":                l.Nonce,
+		"sweepInterval":        l.SweepInterval,
+		"retention":            l.Retention,
+	} {
+		if d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", name, d.Duration)
+		}
+	}
+	if l.MaxNonces < 0 {
+		return fmt.Errorf("maxNonces must not be negative, got %d", l.MaxNonces)
+	}
+	return nil
+}
+
+// withDefaults returns the lifetimes with the defaults for zero durations.
+func (l Lifetimes) withDefaults() Lifetimes {
+	for _, d := range []struct {
+		duration *core.Duration
+		def      time.Duration
+	}{
+		{&l.Order, defaultOrderLifetime},
+		{&l.PendingAuthorization, pendingAuthzExpire},
+		{&l.Nonce, defaultNonceLifetime},
+		{&l.SweepInterval, defaultSweepInterval},
+		{&l.Retention, defaultRetention},
+	} {
+		if d.duration.Duration == 0 {
+			d.duration.Duration = d.def
+		}
+	}
+	if l.MaxNonces == 0 {
+		l.MaxNonces = defaultMaxNonces
+	}
+	return l
+}
+
+// sweepExpired sweeps every sweep interval until the WFE is closed.
+func (wfe *WebFrontEndImpl) sweepExpired() {
+	ticker := time.NewTicker(wfe.lifetimes.SweepInterval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wfe.sweep(wfe.clk.Now())
+		case <-wfe.stop:
+			return
+		}
+	}
+}
+
+// sweep expires the orders, authorizations, challenges and nonces that expired
+// before now and evicts the orders and authorizations expired for longer than
+// the retention period.
+func (wfe *WebFrontEndImpl) sweep(now time.Time) {
+	evictBefore := now.Add(-wfe.lifetimes.Retention.Duration)
+
+	var expiredOrders int
+	var evictedOrders []*core.Order
+	for _, order := range wfe.db.ListOrders() {
+		order.Lock()
+		if now.After(order.ExpiresDate) &&
+			(order.Status == acme.StatusPending || order.Status == acme.StatusReady) {
+			order.Status = acme.StatusInvalid
+			expiredOrders++
+		}
+		if evictBefore.After(order.ExpiresDate) {
+			evictedOrders = append(evictedOrders, order)
+		}
+		order.Unlock()
+	}
+
+	var expiredAuthzs int
+	expiredPending := make(map[*core.Authorization]bool)
+	var evictedAuthzs []*core.Authorization
+	for _, authz := range wfe.db.ListAuthorizations() {
+		authz.Lock()
+		if now.After(authz.ExpiresDate) &&
+			(authz.Status == acme.StatusPending || authz.Status == acme.StatusValid) {
+			expiredPending[authz] = authz.Status == acme.StatusPending
+			authz.Status = acme.StatusExpired
+			expiredAuthzs++
+		}
+		if evictBefore.After(authz.ExpiresDate) {
+			evictedAuthzs = append(evictedAuthzs, authz)
+		}
+		authz.Unlock()
+	}
+
+	// The pending challenges of expired pending authorizations can't be
+	// validated anymore
+	var expiredChals int
+	if len(expiredPending) > 0 {
+		for _, chal := range wfe.db.ListChallenges() {
+			chal.Lock()
+			if expiredPending[chal.Authz] && chal.Status == acme.StatusPending {
+				chal.Status = acme.StatusInvalid
+				expiredChals++
+			}
+			chal.Unlock()
+		}
+	}
+
+	wfe.db.DeleteOrders(evictedOrders)
+	wfe.db.DeleteAuthorizations(evictedAuthzs)
+	if expiredOrders+expiredAuthzs+expiredChals > 0 {
+		wfe.db.Changed()
+	}
+	expiredNonces, evictedNonces := wfe.nonce.sweep(now)
+
+	if expiredOrders+expiredAuthzs+len(evictedOrders)+len(evictedAuthzs)+expiredNonces+evictedNonces > 0 {
+		wfe.log.Printf("Swept expired objects: %d orders set INVALID, %d authorizations set EXPIRED, "+
+			"%d challenges set INVALID, evicted %d orders and %d authorizations, dropped %d nonces, "+
+			"%d nonces evicted over capacity\n",
+			expiredOrders, expiredAuthzs, expiredChals, len(evictedOrders), len(evictedAuthzs),
+			expiredNonces, evictedNonces)
+	}
+}
@@ -0,0 +1,576 @@
+package wfe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/webhook"
+)
+
+const (
+	// Management API paths. These are only served by the ManagementHandler and
+	// are never advertised in the ACME directory.
+	validationsPath    = "/validations/"
+	maintenancePath    = "/maintenance"
+	termsOfServicePath = "/terms-of-service"
+	mgmtAccountsPath   = "/accounts/"
+	mgmtOrdersPath     = "/orders/"
+	mgmtAuthzsPath     = "/authorizations/"
+	mgmtCertsPath      = "/certificates/"
+	mgmtRootCAPath     = "/root"
+	expiringAuthzsPath = "/expiring-authorizations"
+	clockPath          = "/clock"
+
+	// defaultMaintenanceRetryAfter is the Retry-After value, in seconds, sent
+	// while in maintenance mode when the toggle request doesn't specify one
+	defaultMaintenanceRetryAfter = 60
+)
+
+// mgmtHandlerFunc is a management API handler. Unlike ACME API handlers they
+// don't receive a request event and don't issue nonces.
+type mgmtHandlerFunc func(response http.ResponseWriter, request *http.Request)
+
+// ManagementHandler returns an http.Handler for the management API. Test
+// harnesses can use it to inspect Pebble's state. It should be served on
+// a separate listener from the ACME API Handler().
+func (wfe *WebFrontEndImpl) ManagementHandler() http.Handler {
+	return wfe.managementHandler(false)
+}
+
+// ReadOnlyManagementHandler returns an http.Handler for the management API
+// that only routes GET requests, so it can inspect but never change Pebble's
+// state. It is meant to be exposed to dashboards and triage tools that mustn't
+// get the full ManagementHandler().
+func (wfe *WebFrontEndImpl) ReadOnlyManagementHandler() http.Handler {
+	return wfe.managementHandler(true)
+}
+
+// RootCAHandler returns an http.Handler that only serves the root CA
+// certificate of the management API. It is served over plain HTTP so that
+// clients can bootstrap their trust in the ACME API's HTTPS certificate.
+func (wfe *WebFrontEndImpl) RootCAHandler() http.Handler {
+	m := http.NewServeMux()
+	wfe.handleMgmtFunc(m, true, mgmtRootCAPath, wfe.MgmtRootCA, "GET")
+	return m
+}
+
+func (wfe *WebFrontEndImpl) managementHandler(readOnly bool) http.Handler {
+	m := http.NewServeMux()
+	wfe.handleMgmtFunc(m, readOnly, validationsPath, wfe.Validations, "GET")
+	wfe.handleMgmtFunc(m, readOnly, maintenancePath, wfe.Maintenance, "GET", "POST")
+	wfe.handleMgmtFunc(m, readOnly, termsOfServicePath, wfe.TermsOfService, "GET", "POST")
+	wfe.handleMgmtFunc(m, readOnly, mgmtAccountsPath, wfe.MgmtAccounts, "GET")
+	wfe.handleMgmtFunc(m, readOnly, mgmtOrdersPath, wfe.MgmtOrders, "GET", "POST")
+	wfe.handleMgmtFunc(m, readOnly, mgmtAuthzsPath, wfe.MgmtAuthorizations, "GET", "POST")
+	wfe.handleMgmtFunc(m, readOnly, expiringAuthzsPath, wfe.ExpiringAuthorizations, "GET")
+	wfe.handleMgmtFunc(m, readOnly, mgmtCertsPath, wfe.MgmtCertificates, "GET", "POST")
+	wfe.handleMgmtFunc(m, readOnly, mgmtRootCAPath, wfe.MgmtRootCA, "GET")
+	wfe.handleMgmtFunc(m, readOnly, clockPath, wfe.Clock, "GET", "POST")
+	return m
+}
+
+// handleMgmtFunc routes the methods of pattern to handler. A read-only
+// management API only routes GETs, every other method is rejected before the
+// handler is called.
+func (wfe *WebFrontEndImpl) handleMgmtFunc(
+	mux *http.ServeMux,
+	readOnly bool,
+	pattern string,
+	handler mgmtHandlerFunc,
+	methods ...string) {
+
+	if readOnly {
+		methods = []string{"GET"}
+	}
+	methodsStr := strings.Join(methods, ", ")
+	mux.Handle(pattern, http.StripPrefix(pattern,
+		http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			request = request.WithContext(
+				logging.ContextWithRequestID(request.Context(), logging.NewRequestID()))
+			rw := &recoveryWriter{ResponseWriter: response}
+			response = rw
+			defer wfe.recoverPanic(request.Context(), request, rw)
+			addNoCacheHeader(response)
+
+			allowed := false
+			for _, m := range methods {
+				if m == request.Method {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				response.Header().Set("Allow", methodsStr)
+				wfe.sendError(acme.MethodNotAllowed(), response)
+				return
+			}
+
+			wfe.log.WithContext(request.Context()).Printf("Management API: %s %s%s\n", request.Method, pattern, request.URL.Path)
+			handler(response, request)
+			// POSTs may have updated objects of the db in place
+			if request.Method == "POST" {
+				wfe.db.Changed()
+			}
+		})))
+}
+
+// Validations returns the full history of validation attempts made for
+// a challenge, identified by its ID.
+func (wfe *WebFrontEndImpl) Validations(response http.ResponseWriter, request *http.Request) {
+	chalID := request.URL.Path
+	chal := wfe.db.GetChallengeByID(chalID)
+	if chal == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No challenge for ID %q", chalID)), response)
+		return
+	}
+
+	chal.RLock()
+	defer chal.RUnlock()
+
+	// Always return a JSON list, even when no validations have been attempted
+	records := chal.ValidationRecords
+	if records == nil {
+		records = []*core.ValidationRecord{}
+	}
+
+	err := wfe.writeJsonResponse(response, http.StatusOK, records)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling validation records"), response)
+		return
+	}
+}
+
+// maintenanceMode holds the state of the maintenance toggle. It is shared by
+// the ACME and management handlers.
+type maintenanceMode struct {
+	sync.RWMutex
+	enabled    bool
+	retryAfter int
+}
+
+func (m *maintenanceMode) get() (bool, int) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.enabled, m.retryAfter
+}
+
+func (m *maintenanceMode) set(enabled bool, retryAfter int) {
+	m.Lock()
+	defer m.Unlock()
+	m.enabled = enabled
+	m.retryAfter = retryAfter
+}
+
+// maintenanceState is the JSON representation of the maintenance toggle used
+// by the Maintenance management handler.
+type maintenanceState struct {
+	Enabled    bool `json:"enabled"`
+	RetryAfter int  `json:"retryAfter,omitempty"`
+}
+
+// Maintenance returns the state of maintenance mode for a GET and changes it
+// for a POST. While maintenance mode is enabled every ACME endpoint except the
+// directory returns a 503 with a Retry-After header.
+func (wfe *WebFrontEndImpl) Maintenance(response http.ResponseWriter, request *http.Request) {
+	if request.Method == "POST" {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem("Unable to read request body"), response)
+			return
+		}
+		var state maintenanceState
+		err = json.Unmarshal(body, &state)
+		if err != nil {
+			wfe.sendError(
+				acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+			return
+		}
+		if state.RetryAfter < 0 {
+			wfe.sendError(acme.MalformedProblem("retryAfter must not be negative"), response)
+			return
+		}
+		if state.RetryAfter == 0 {
+			state.RetryAfter = defaultMaintenanceRetryAfter
+		}
+		wfe.maintenance.set(state.Enabled, state.RetryAfter)
+		wfe.log.WithContext(request.Context()).Printf("Maintenance mode enabled: %t (Retry-After %ds)\n",
+			state.Enabled, state.RetryAfter)
+	}
+
+	enabled, retryAfter := wfe.maintenance.get()
+	state := maintenanceState{Enabled: enabled}
+	if enabled {
+		state.RetryAfter = retryAfter
+	}
+	err := wfe.writeJsonResponse(response, http.StatusOK, state)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling maintenance state"), response)
+		return
+	}
+}
+
+// splitMgmtPath splits the path of a management object request, with the
+// endpoint prefix already stripped, into the object ID and an optional action,
+// e.g. "1234/expire" is split into "1234" and "expire".
+func splitMgmtPath(path string) (string, string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// mgmtObject writes a list of all objects for a GET of the endpoint itself, or
+// a single object for a GET of an object ID. POSTs must name an object and an
+// action, which is passed to act. The view functions return the management API
+// view of an object and act returns the problem preventing the action, if any.
+func (wfe *WebFrontEndImpl) mgmtObject(
+	response http.ResponseWriter,
+	request *http.Request,
+	list func() []interface{},
+	get func(id string) (interface{}, bool),
+	act func(id, action string) (bool, *acme.ProblemDetails)) {
+	id, action := splitMgmtPath(request.URL.Path)
+
+	if request.Method == "POST" {
+		if id == "" || action == "" {
+			wfe.sendError(acme.MalformedProblem("POST requests must name an object and an action"), response)
+			return
+		}
+		found, prob := act(id, action)
+		if !found {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No object for ID %q", id)), response)
+			return
+		}
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	} else if action != "" {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No resource at %q", request.URL.Path)), response)
+		return
+	}
+
+	var result interface{}
+	if id == "" {
+		result = list()
+	} else {
+		obj, found := get(id)
+		if !found {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No object for ID %q", id)), response)
+			return
+		}
+		result = obj
+	}
+	err := wfe.writeJsonResponse(response, http.StatusOK, result)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling management object"), response)
+		return
+	}
+}
+
+// unknownMgmtAction returns the problem for a POST of an action the endpoint
+// doesn't support.
+func unknownMgmtAction(action string, supported string) *acme.ProblemDetails {
+	return acme.MalformedProblem(fmt.Sprintf(
+		"Unknown action %q, only %q is supported", action, supported))
+}
+
+type mgmtAccount struct {
+	ID string `json:"id"`
+	acme.Account
+}
+
+func mgmtAccountView(acct *core.Account) mgmtAccount {
+	acct.RLock()
+	defer acct.RUnlock()
+	return mgmtAccount{ID: acct.ID, Account: acct.Account}
+}
+
+// MgmtAccounts lists all accounts or returns a single account by ID.
+func (wfe *WebFrontEndImpl) MgmtAccounts(response http.ResponseWriter, request *http.Request) {
+	wfe.mgmtObject(response, request,
+		func() []interface{} {
+			views := []interface{}{}
+			for _, acct := range wfe.db.ListAccounts() {
+				views = append(views, mgmtAccountView(acct))
+			}
+			return views
+		},
+		func(id string) (interface{}, bool) {
+			acct := wfe.db.GetAccountByID(id)
+			if acct == nil {
+				return nil, false
+			}
+			return mgmtAccountView(acct), true
+		},
+		nil)
+}
+
+type mgmtOrder struct {
+	ID                string          `json:"id"`
+	AccountID         string          `json:"accountID"`
+	ExpiresDate       time.Time       `json:"expiresDate"`
+	CertificateSerial string          `json:"certificateSerial,omitempty"`
+	Annotation        json.RawMessage `json:"annotation,omitempty"`
+	acme.Order
+}
+
+func mgmtOrderView(order *core.Order) mgmtOrder {
+	order.RLock()
+	defer order.RUnlock()
+	view := mgmtOrder{
+		ID:          order.ID,
+		AccountID:   order.AccountID,
+		ExpiresDate: order.ExpiresDate,
+		Annotation:  order.Annotation,
+		Order:       order.Order,
+	}
+	if order.CertificateObject != nil {
+		view.CertificateSerial = order.CertificateObject.ID
+	}
+	return view
+}
+
+// MgmtOrders lists all orders or returns a single order by ID. POSTing to
+// "<order ID>/expire" force-expires the order: its expiry is set to now and
+// it becomes invalid unless it is already valid.
+func (wfe *WebFrontEndImpl) MgmtOrders(response http.ResponseWriter, request *http.Request) {
+	wfe.mgmtObject(response, request,
+		func() []interface{} {
+			views := []interface{}{}
+			for _, order := range wfe.db.ListOrders() {
+				views = append(views, mgmtOrderView(order))
+			}
+			return views
+		},
+		func(id string) (interface{}, bool) {
+			order := wfe.db.GetOrderByID(id)
+			if order == nil {
+				return nil, false
+			}
+			return mgmtOrderView(order), true
+		},
+		func(id, action string) (bool, *acme.ProblemDetails) {
+			order := wfe.db.GetOrderByID(id)
+			if order == nil {
+				return false, nil
+			}
+			if action != "expire" {
+				return true, unknownMgmtAction(action, "expire")
+			}
+			now := wfe.clk.Now().UTC()
+			order.Lock()
+			order.ExpiresDate = now
+			order.Expires = now.Format(time.RFC3339)
+			if order.Status != acme.StatusValid {
+				order.Status = acme.StatusInvalid
+			}
+			order.Unlock()
+			wfe.log.WithContext(request.Context()).Printf("Management API: order %s force-expired\n", id)
+			return true, nil
+		})
+}
+
+type mgmtAuthorization struct {
+	ID          string    `json:"id"`
+	AccountID   string    `json:"accountID"`
+	ExpiresDate time.Time `json:"expiresDate"`
+	acme.Authorization
+}
+
+func mgmtAuthorizationView(authz *core.Authorization) mgmtAuthorization {
+	authz.RLock()
+	defer authz.RUnlock()
+	return mgmtAuthorization{
+		ID:            authz.ID,
+		AccountID:     authz.AccountID,
+		ExpiresDate:   authz.ExpiresDate,
+		Authorization: authz.Authorization,
+	}
+}
+
+// MgmtAuthorizations lists all authorizations or returns a single
+// authorization by ID. POSTing to "<authz ID>/invalidate" sets the
+// authorization's status to invalid, and its order's if it is pending or ready.
+func (wfe *WebFrontEndImpl) MgmtAuthorizations(response http.ResponseWriter, request *http.Request) {
+	wfe.mgmtObject(response, request,
+		func() []interface{} {
+			views := []interface{}{}
+			for _, authz := range wfe.db.ListAuthorizations() {
+				views = append(views, mgmtAuthorizationView(authz))
+			}
+			return views
+		},
+		func(id string) (interface{}, bool) {
+			authz := wfe.db.GetAuthorizationByID(id)
+			if authz == nil {
+				return nil, false
+			}
+			return mgmtAuthorizationView(authz), true
+		},
+		func(id, action string) (bool, *acme.ProblemDetails) {
+			authz := wfe.db.GetAuthorizationByID(id)
+			if authz == nil {
+				return false, nil
+			}
+			if action != "invalidate" {
+				return true, unknownMgmtAction(action, "invalidate")
+			}
+			authz.Lock()
+			authz.Status = acme.StatusInvalid
+			order := authz.Order
+			authz.Unlock()
+			log := wfe.log.WithContext(request.Context())
+			log.Printf("Management API: authz %s set INVALID\n", id)
+			// The order fails like it does for an authz invalidated by a
+			// validation
+			wfe.va.UpdateOrder(log, order)
+			return true, nil
+		})
+}
+
+type mgmtCertificate struct {
+	Serial       string     `json:"serial"`
+	IssuerSerial string     `json:"issuerSerial,omitempty"`
+	NotBefore    time.Time  `json:"notBefore"`
+	NotAfter     time.Time  `json:"notAfter"`
+	Names        []string   `json:"names"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	// RevocationReason is the CRLReason of a certificate revoked with a reason
+	RevocationReason int    `json:"revocationReason,omitempty"`
+	PEM              string `json:"pem"`
+}
+
+func (wfe *WebFrontEndImpl) mgmtCertificateView(cert *core.Certificate) mgmtCertificate {
+	view := mgmtCertificate{
+		Serial:    cert.ID,
+		NotBefore: cert.Cert.NotBefore,
+		NotAfter:  cert.Cert.NotAfter,
+		Names:     append([]string{}, cert.Cert.DNSNames...),
+		PEM:       string(cert.PEM()),
+	}
+	for _, ip := range cert.Cert.IPAddresses {
+		view.Names = append(view.Names, ip.String())
+	}
+	if cert.Issuer != nil {
+		view.IssuerSerial = cert.Issuer.ID
+	}
+	if at, revoked := wfe.db.GetRevocationByID(cert.ID); revoked {
+		view.RevokedAt = &at
+		view.RevocationReason = wfe.db.GetRevocationReasonByID(cert.ID)
+	}
+	return view
+}
+
+// MgmtCertificates lists all certificates or returns a single certificate by
+// serial. A GET of "<serial>/report" returns the structured report of the
+// certificate's contents and lint results. POSTing to "<serial>/revoke" marks
+// the certificate as revoked. The optional JSON body {"reason": N} sets its
+// CRLReason. The key of a certificate revoked for keyCompromise (1) is
+// rejected for new accounts and certificates.
+func (wfe *WebFrontEndImpl) MgmtCertificates(response http.ResponseWriter, request *http.Request) {
+	if id, action := splitMgmtPath(request.URL.Path); request.Method == "GET" && action == "report" {
+		cert := wfe.db.GetCertificateByID(id)
+		if cert == nil {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No certificate for serial %q", id)), response)
+			return
+		}
+		err := wfe.writeJsonResponse(response, http.StatusOK, certificateReportView(cert))
+		if err != nil {
+			wfe.sendError(acme.InternalErrorProblem("Error marshalling certificate report"), response)
+		}
+		return
+	}
+	wfe.mgmtObject(response, request,
+		func() []interface{} {
+			views := []interface{}{}
+			for _, cert := range wfe.db.ListCertificates() {
+				views = append(views, wfe.mgmtCertificateView(cert))
+			}
+			return views
+		},
+		func(id string) (interface{}, bool) {
+			cert := wfe.db.GetCertificateByID(id)
+			if cert == nil {
+				return nil, false
+			}
+			return wfe.mgmtCertificateView(cert), true
+		},
+		func(id, action string) (bool, *acme.ProblemDetails) {
+			if wfe.db.GetCertificateByID(id) == nil {
+				return false, nil
+			}
+			if action != "revoke" {
+				return true, unknownMgmtAction(action, "revoke")
+			}
+			var revokeRequest struct {
+				Reason int `json:"reason"`
+			}
+			body, err := ioutil.ReadAll(request.Body)
+			if err != nil {
+				return true, acme.MalformedProblem("Error reading body: " + err.Error())
+			}
+			if len(bytes.TrimSpace(body)) > 0 {
+				if err := json.Unmarshal(body, &revokeRequest); err != nil {
+					return true, acme.MalformedProblem("Error unmarshaling body JSON: " + err.Error())
+				}
+			}
+			// Reason 7 is unused and reasons above 10 are undefined
+			if r := revokeRequest.Reason; r < 0 || r == 7 || r > 10 {
+				return true, acme.MalformedProblem(fmt.Sprintf("Invalid revocation reason %d", r))
+			}
+			if err := wfe.db.RevokeCertificate(id, wfe.clk.Now().UTC(), revokeRequest.Reason); err != nil {
+				return true, acme.MalformedProblem(err.Error())
+			}
+			wfe.log.WithContext(request.Context()).Printf(
+				"Management API: certificate %s revoked with reason %d\n", id, revokeRequest.Reason)
+			wfe.notifier.Notify(webhook.Event{
+				Type:              webhook.CertificateRevoked,
+				CertificateSerial: id,
+				RevocationReason:  &revokeRequest.Reason,
+			})
+			return true, nil
+		})
+}
+
+// MgmtRootCA returns the root CA certificate in the trust store format of the
+// "format" query parameter, by default PEM. With the "alternate" query
+// parameter set to true it returns the root that cross-signs the hierarchy.
+func (wfe *WebFrontEndImpl) MgmtRootCA(response http.ResponseWriter, request *http.Request) {
+	root := wfe.ca.RootCert()
+	if request.URL.Query().Get("alternate") == "true" {
+		root = wfe.ca.AlternateRootCert()
+		if root == nil {
+			wfe.sendError(acme.NotFoundProblem("The CA hierarchy isn't cross-signed"), response)
+			return
+		}
+	}
+	name := request.URL.Query().Get("format")
+	if name == "" {
+		name = core.TrustStoreFormats[0].Name
+	}
+	format, ok := core.TrustStoreFormatByName(name)
+	if !ok {
+		var names []string
+		for _, format := range core.TrustStoreFormats {
+			names = append(names, format.Name)
+		}
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Unknown root format %q, must be one of %s", name, strings.Join(names, ", "))), response)
+		return
+	}
+	response.Header().Set("Content-Type", format.ContentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", format.FileName))
+	response.WriteHeader(http.StatusOK)
+	_, _ = response.Write(format.Encode(root))
+}
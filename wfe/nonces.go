@@ -1,11 +1,15 @@
 package wfe
 
 import (
+	"container/list"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
 )
 
 /*
@@ -17,21 +21,49 @@ import (
 const nonceLen = 16
 
 /*
- * Note: We place no upper bound on the number of nonces we issue. We obtain
- * a lock for both issuing nonces and checking them. This is *not* a performant
- * or safe strategy for a production server. Consider the NonceServer
- * approach[0] used by Boulder if you are looking for a more robust nonce
- * implementation for an ACME server.
+ * Note: We keep at most `capacity` unused nonces, the oldest are evicted to
+ * make room for new ones and unused nonces are dropped once they expire. Every
+ * operation on a nonce is O(1), so validation doesn't slow down under load. We
+ * obtain a lock for both issuing nonces and checking them. This is *not* a
+ * performant or safe strategy for a production server. Consider the
+ * NonceServer approach[0] used by Boulder if you are looking for a more robust
+ * nonce implementation for an ACME server.
  *
  * [0] - https://github.com/letsencrypt/boulder/blob/c8f1fb3e2fade026aad76f23eafa137482d89bf5/nonce/nonce.go
  */
 type nonceMap struct {
 	sync.Mutex
-	nonces map[string]struct{}
+	clk      clock.Clock
+	lifetime time.Duration
+	capacity int
+	// nonces holds the list element of each unused nonce
+	nonces map[string]*list.Element
+	// issued holds the unused nonces oldest first, as *issuedNonce
+	issued *list.List
+	// evicted counts the nonces evicted over capacity since the last sweep
+	evicted int
+}
+
+// issuedNonce is an unused nonce and its expiry
+type issuedNonce struct {
+	nonce   string
+	expires time.Time
+}
+
+func newNonceMap(clk clock.Clock, lifetime time.Duration, capacity int) *nonceMap {
+	return &nonceMap{
+		clk:      clk,
+		lifetime: lifetime,
+		capacity: capacity,
+		nonces:   make(map[string]*list.Element),
+		issued:   list.New(),
+	}
 }
 
-func newNonceMap() *nonceMap {
-	return &nonceMap{nonces: make(map[string]struct{})}
+// remove forgets an unused nonce.
+func (n *nonceMap) remove(e *list.Element) {
+	delete(n.nonces, e.Value.(*issuedNonce).nonce)
+	n.issued.Remove(e)
 }
 
 func (n *nonceMap) createNonce() string {
@@ -47,8 +79,16 @@ func (n *nonceMap) createNonce() string {
 
 	// Encode the bytes to base64 URL encoding
 	nonce := base64.RawURLEncoding.EncodeToString(b)
+	// Make room for the nonce by evicting the oldest
+	for n.issued.Len() >= n.capacity {
+		n.remove(n.issued.Front())
+		n.evicted++
+	}
 	// Record the nonce, and give it back to the caller
-	n.nonces[nonce] = struct{}{}
+	n.nonces[nonce] = n.issued.PushBack(&issuedNonce{
+		nonce:   nonce,
+		expires: n.clk.Now().Add(n.lifetime),
+	})
 	return nonce
 }
 
@@ -56,13 +96,30 @@ func (n *nonceMap) validNonce(nonce string) bool {
 	n.Lock()
 	defer n.Unlock()
 
-	// If the nonce is one we generated its valid
-	if _, present := n.nonces[nonce]; present {
+	// If the nonce is one we generated and it hasn't expired its valid
+	if e, present := n.nonces[nonce]; present {
 		// Strike the nonce after it has been validated
 		// It can only be used once!
-		delete(n.nonces, nonce)
-		return true
+		n.remove(e)
+		return n.clk.Now().Before(e.Value.(*issuedNonce).expires)
 	}
 
 	return false
 }
+
+// sweep drops the nonces that expired before now and returns how many, and
+// how many were evicted over capacity since the last sweep. Nonces expire in
+// the order they were issued unless the clock was moved back, then some expired
+// nonces are only dropped by a later sweep or rejected when they are used.
+func (n *nonceMap) sweep(now time.Time) (int, int) {
+	n.Lock()
+	defer n.Unlock()
+	var dropped int
+	for e := n.issued.Front(); e != nil && !now.Before(e.Value.(*issuedNonce).expires); e = n.issued.Front() {
+		n.remove(e)
+		dropped++
+	}
+	evicted := n.evicted
+	n.evicted = 0
+	return dropped, evicted
+}
@@ -0,0 +1,103 @@
+package wfe
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// maxPOSTBodySize is the largest JWS body accepted in a POST request. Bigger
+// bodies are rejected before they are parsed.
+const maxPOSTBodySize = 64 * 1024
+
+// The functions in this file parse client input of the ACME endpoints. They
+// must never assume the input is well-formed: every error is returned to be
+// sent as a problem instead of causing a panic further on. Fuzz targets for
+// them are in fuzz.go.
+
+func (wfe *WebFrontEndImpl) parseJWS(body string) (*jose.JSONWebSignature, error) {
+	// Parse the raw JWS JSON to check that:
+	// * the unprotected Header field is not being used.
+	// * the "signatures" member isn't present, just "signature".
+	//
+	// This must be done prior to `jose.parseSigned` since it will strip away
+	// these headers.
+	if len(body) == 0 {
+		return nil, errors.New("POST JWS body is empty")
+	}
+	var unprotected struct {
+		Header     map[string]interface{}
+		Signatures []interface{}
+	}
+	if err := json.Unmarshal([]byte(body), &unprotected); err != nil {
+		return nil, errors.New("Parse error reading JWS")
+	}
+
+	// ACME v2 never uses values from the unprotected JWS header. Reject JWS that
+	// include unprotected headers.
+	if unprotected.Header != nil {
+		return nil, errors.New(
+			"JWS \"header\" field not allowed. All headers must be in \"protected\" field")
+	}
+
+	// ACME v2 never uses the "signatures" array of JSON serialized JWS, just the
+	// mandatory "signature" field. Reject JWS that include the "signatures" array.
+	if len(unprotected.Signatures) > 0 {
+		return nil, errors.New(
+			"JWS \"signatures\" field not allowed. Only the \"signature\" field should contain a signature")
+	}
+
+	parsedJWS, err := jose.ParseSigned(body)
+	if err != nil {
+		return nil, errors.New("Parse error reading JWS")
+	}
+
+	if len(parsedJWS.Signatures) > 1 {
+		return nil, errors.New("Too many signatures in POST body")
+	}
+
+	if len(parsedJWS.Signatures) == 0 {
+		return nil, errors.New("POST JWS not signed")
+	}
+	return parsedJWS, nil
+}
+
+// checkJWK returns a problem if key can't be used as an account key: it must be
+// a valid RSA, Ed25519 or ECDSA public key on a supported curve.
+func checkJWK(key *jose.JSONWebKey) *acme.ProblemDetails {
+	if !key.Valid() {
+		return acme.MalformedProblem("Invalid JWK in JWS header")
+	}
+	if !key.IsPublic() {
+		return acme.MalformedProblem("JWK in JWS header is not a public key")
+	}
+	if len(keyAlgorithms(key)) == 0 {
+		return acme.MalformedProblem(
+			"JWK in JWS header: no signature algorithms suitable for given key type")
+	}
+	return nil
+}
+
+// parseCSR decodes and parses the base64url encoded CSR of a finalize request.
+// A CSR with a public key of an unknown algorithm is rejected.
+func parseCSR(b64 string) (*x509.CertificateRequest, *acme.ProblemDetails) {
+	csrBytes, err := base64.RawURLEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, acme.MalformedProblem("Error decoding Base64url-encoded CSR: " + err.Error())
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, acme.BadCSRProblem("Error parsing Base64url-encoded CSR: " + err.Error())
+	}
+	if csr.PublicKey == nil {
+		return nil, acme.BadCSRProblem(fmt.Sprintf(
+			"CSR has an unsupported public key algorithm %s", csr.PublicKeyAlgorithm))
+	}
+	return csr, nil
+}
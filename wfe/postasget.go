@@ -0,0 +1,67 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// verifyAccountPOST verifies the JWS of a POST signed with the "kid" of an
+// account and returns its payload and the account.
+func (wfe *WebFrontEndImpl) verifyAccountPOST(
+	ctx context.Context,
+	logEvent *requestEvent,
+	request *http.Request) ([]byte, *core.Account, *acme.ProblemDetails) {
+	body, key, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWK)
+	if prob != nil {
+		return nil, nil, prob
+	}
+	acct, prob := wfe.getAcctByKey(key)
+	if prob != nil {
+		return nil, nil, prob
+	}
+	return body, acct, nil
+}
+
+// checkLegacyGET returns a malformed problem for a plain GET of a resource
+// that must be read with POST-as-GET, unless legacy GETs are allowed.
+func (wfe *WebFrontEndImpl) checkLegacyGET() *acme.ProblemDetails {
+	if wfe.allowLegacyGET {
+		return nil
+	}
+	return acme.MalformedProblem(
+		"GET is not allowed for this resource, use POST-as-GET (RFC 8555 Section 6.3)")
+}
+
+// verifyPOSTAsGET verifies a request reading a resource and returns the
+// account that made it. A POST-as-GET (RFC 8555 Section 6.3) must have an
+// empty JWS payload. A plain GET is only accepted if legacy GETs are allowed,
+// its account is nil.
+func (wfe *WebFrontEndImpl) verifyPOSTAsGET(
+	ctx context.Context,
+	logEvent *requestEvent,
+	request *http.Request) (*core.Account, *acme.ProblemDetails) {
+	if request.Method != "POST" {
+		return nil, wfe.checkLegacyGET()
+	}
+	body, acct, prob := wfe.verifyAccountPOST(ctx, logEvent, request)
+	if prob != nil {
+		return nil, prob
+	}
+	if len(body) != 0 {
+		return nil, acme.MalformedProblem("POST-as-GET requests must have an empty payload")
+	}
+	return acct, nil
+}
+
+// ownedBy returns an unauthorized problem if a POST-as-GET of a resource
+// wasn't made by the account that owns it. Legacy GETs have no account and
+// can read every resource.
+func ownedBy(acct *core.Account, ownerID string, resource string) *acme.ProblemDetails {
+	if acct == nil || acct.ID == ownerID {
+		return nil
+	}
+	return acme.UnauthorizedProblem("Account does not own the " + resource)
+}
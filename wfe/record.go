@@ -0,0 +1,290 @@
+package wfe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/logging"
+)
+
+const (
+	// redacted replaces the JWS signatures and HMACs of recorded requests
+	redacted = "REDACTED"
+	// maxRecordedBody is how much of a response body is recorded
+	maxRecordedBody = 1 << 20
+	// recordTimeFormat is the timestamp that recording file names start with,
+	// so that they sort in the order of the requests
+	recordTimeFormat = "20060102T150405.000000Z"
+)
+
+// recordedExchange is the JSON of a recorded request and its response
+type recordedExchange struct {
+	Time     time.Time        `json:"time"`
+	Duration string           `json:"duration"`
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	// JWS is the JWS body of a POST with its protected header and payload
+	// decoded and its signature redacted
+	JWS interface{} `json:"jws,omitempty"`
+	// Body is the body of a request that isn't a JWS
+	Body string `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	// Body is a JSON body, e.g. a problem document, as-is and any other
+	// text body as a string
+	Body interface{} `json:"body,omitempty"`
+	// BodyBase64 is a binary body, e.g. a DER certificate
+	BodyBase64 []byte `json:"bodyBase64,omitempty"`
+	// Hijacked is true if the response was written to the connection
+	// directly by the HTTP quirks and isn't recorded
+	Hijacked bool `json:"hijacked,omitempty"`
+}
+
+// Recorder writes every ACME request and its response to a file of its own in
+// a directory, to reconstruct the exchange of a failed client integration
+// test. JWS signatures, including the HMACs of external account bindings, are
+// redacted.
+type Recorder struct {
+	log *logging.Logger
+	clk clock.Clock
+	dir string
+	// seq numbers the recorded exchanges
+	seq *int64
+}
+
+// NewRecorder creates a Recorder writing to dir, which is created if it
+// doesn't exist.
+func NewRecorder(log *logging.Logger, clk clock.Clock, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{log: log, clk: clk, dir: dir, seq: new(int64)}, nil
+}
+
+// Handler returns an http.Handler that records the requests served by next.
+func (r *Recorder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := r.clk.Now()
+		seq := atomic.AddInt64(r.seq, 1)
+
+		var body []byte
+		if request.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(request.Body)
+			if err != nil {
+				r.log.Printf("Error: recording request body: %s\n", err.Error())
+			}
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		exchange := recordedExchange{
+			Time: start.UTC(),
+			Request: recordedRequest{
+				Method:  request.Method,
+				URL:     request.URL.String(),
+				Headers: redactHeaders(request.Header),
+			},
+		}
+		if jws, ok := decodeRecordedJWS(body); ok {
+			exchange.Request.JWS = jws
+		} else {
+			exchange.Request.Body = string(body)
+		}
+
+		rw := &recordingWriter{ResponseWriter: response, status: http.StatusOK}
+		next.ServeHTTP(rw, request)
+
+		exchange.Duration = r.clk.Now().Sub(start).String()
+		exchange.Response = rw.recorded()
+		r.write(seq, request, exchange)
+	})
+}
+
+// write writes a recorded exchange to its file, errors are only logged.
+func (r *Recorder) write(seq int64, request *http.Request, exchange recordedExchange) {
+	name := fmt.Sprintf("%s-%06d-%s-%s.json", exchange.Time.Format(recordTimeFormat), seq,
+		request.Method, recordFileEndpoint(request.URL.Path))
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(r.dir, name), data, 0644)
+	}
+	if err != nil {
+		r.log.Printf("Error: recording %s %s: %s\n", request.Method, request.URL.Path, err.Error())
+	}
+}
+
+// recordFileEndpoint returns the path of a request in the form used in the
+// name of its recording file, e.g. "my-order_abc" for "/my-order/abc".
+func recordFileEndpoint(path string) string {
+	endpoint := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, strings.Trim(path, "/"))
+	if endpoint == "" {
+		return "root"
+	}
+	if len(endpoint) > 64 {
+		endpoint = endpoint[:64]
+	}
+	return endpoint
+}
+
+// redactHeaders returns a copy of the headers of a request without the values
+// of credentials.
+func redactHeaders(header http.Header) http.Header {
+	redactedHeader := header.Clone()
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if _, ok := redactedHeader[name]; ok {
+			redactedHeader.Set(name, redacted)
+		}
+	}
+	return redactedHeader
+}
+
+// decodeRecordedJWS returns the JSON body of a request with every JWS in it
+// decoded by redactJWS, or false if the body isn't JSON.
+func decodeRecordedJWS(body []byte) (interface{}, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false
+	}
+	return redactJWS(v), true
+}
+
+// redactJWS returns v with every flattened JSON JWS in it, e.g. the inner JWS
+// of a key roll-over or an external account binding, replaced by its decoded
+// protected header and payload and a redacted signature.
+func redactJWS(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		_, hasProtected := value["protected"]
+		_, hasPayload := value["payload"]
+		if _, hasSignature := value["signature"]; hasProtected && hasPayload && hasSignature {
+			return map[string]interface{}{
+				"protected": decodeJWSSegment(value["protected"]),
+				"payload":   decodeJWSSegment(value["payload"]),
+				"signature": redacted,
+			}
+		}
+		for k, e := range value {
+			value[k] = redactJWS(e)
+		}
+	case []interface{}:
+		for i, e := range value {
+			value[i] = redactJWS(e)
+		}
+	}
+	return v
+}
+
+// decodeJWSSegment returns the base64url encoded protected header or payload
+// of a JWS decoded, as JSON if it is JSON and as a string otherwise. Values
+// that aren't base64url are returned as-is.
+func decodeJWSSegment(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	var payload interface{}
+	if err := json.Unmarshal(decoded, &payload); err == nil {
+		return redactJWS(payload)
+	}
+	return string(decoded)
+}
+
+// recordingWriter is the response writer of a recorded request. It passes the
+// response through and keeps a copy of its status and body.
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	hijacked bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if room := maxRecordedBody - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying response writer if it supports flushing.
+func (w *recordingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the underlying connection for the HTTP quirks, the response
+// is then not recorded.
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can't be hijacked")
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+// recorded returns the recorded response.
+func (w *recordingWriter) recorded() recordedResponse {
+	resp := recordedResponse{
+		Status:   w.status,
+		Headers:  w.Header().Clone(),
+		Hijacked: w.hijacked,
+	}
+	body := w.body.Bytes()
+	if len(body) == 0 {
+		return resp
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	switch {
+	case strings.HasSuffix(mediaType, "json") && json.Valid(body):
+		resp.Body = json.RawMessage(body)
+	case utf8.Valid(body):
+		resp.Body = string(body)
+	default:
+		resp.BodyBase64 = body
+	}
+	return resp
+}
@@ -0,0 +1,59 @@
+package wfe
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/letsencrypt/pebble/acme"
+)
+
+// recoveryWriter is the response writer of a handler run with recoverPanic. It
+// records whether the handler started its response.
+type recoveryWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *recoveryWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying response writer if it supports flushing.
+func (w *recoveryWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// recoverPanic recovers from a panic in an ACME or management API handler
+// that writes its response to rw. The panic is logged with its stack trace and
+// answered with a serverInternal problem, so that a bug fails one request of
+// a test suite instead of leaving the client with a dropped connection. If
+// the handler already started its response the connection is aborted. It must
+// be deferred by the handler.
+func (wfe *WebFrontEndImpl) recoverPanic(
+	ctx context.Context,
+	request *http.Request,
+	rw *recoveryWriter) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	// An aborted handler is how a response is cut short on purpose
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	wfe.log.WithContext(ctx).Printf("Error: panic handling %s %s: %v\n%s",
+		request.Method, request.RequestURI, err, debug.Stack())
+	if rw.written {
+		panic(http.ErrAbortHandler)
+	}
+	wfe.sendError(acme.InternalErrorProblem("Internal error handling request"), rw)
+}
@@ -0,0 +1,61 @@
+package wfe
+
+import (
+	"sync"
+
+	"github.com/letsencrypt/pebble/ratelimit"
+)
+
+// reloadableValues are the settings of a WFE that Reload can change
+type reloadableValues struct {
+	chaos                []ChaosRule
+	newAcctLimiter       *ratelimit.Limiter
+	newOrderLimiter      *ratelimit.Limiter
+	duplicateCertLimiter *ratelimit.Limiter
+}
+
+// reloadableSettings holds the current reloadable settings. Handlers get them
+// once per use, so a reload never changes a rule or limiter mid-request.
+type reloadableSettings struct {
+	sync.RWMutex
+	current reloadableValues
+}
+
+func (s *reloadableSettings) get() reloadableValues {
+	s.RLock()
+	defer s.RUnlock()
+	return s.current
+}
+
+// Reload replaces the chaos rules and the new account, new order and duplicate
+// certificate rate limits while the WFE serves requests. A limiter is only
+// replaced, and so its buckets reset, when its limit changed.
+func (wfe *WebFrontEndImpl) Reload(
+	chaos []ChaosRule,
+	newAccountsPerIP ratelimit.Limit,
+	newOrdersPerAccount ratelimit.Limit,
+	duplicateCertificates ratelimit.Limit) {
+	wfe.settings.Lock()
+	defer wfe.settings.Unlock()
+	current := &wfe.settings.current
+	current.chaos = chaos
+	for _, l := range []struct {
+		limiter **ratelimit.Limiter
+		limit   ratelimit.Limit
+		name    string
+	}{
+		{&current.newAcctLimiter, newAccountsPerIP, "newAccountsPerIP"},
+		{&current.newOrderLimiter, newOrdersPerAccount, "newOrdersPerAccount"},
+		{&current.duplicateCertLimiter, duplicateCertificates, "duplicateCertificates"},
+	} {
+		if (*l.limiter).Matches(l.limit) {
+			continue
+		}
+		*l.limiter = l.limit.NewLimiter(wfe.clk)
+		if *l.limiter == nil {
+			wfe.log.Printf("Disabled the %s rate limit\n", l.name)
+		} else {
+			wfe.log.Printf("Changed the %s rate limit to %s\n", l.name, *l.limiter)
+		}
+	}
+}
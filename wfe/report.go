@@ -0,0 +1,278 @@
+package wfe
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/ct"
+)
+
+// Lint results
+const (
+	lintPass  = "pass"
+	lintWarn  = "warn"
+	lintError = "error"
+	// lintNA is the result of lints that don't apply to the certificate
+	lintNA = "na"
+)
+
+// maxSubscriberValidity is the longest subscriber certificate validity that
+// doesn't produce a lint warning, the limit of the CA/Browser Forum Baseline
+// Requirements
+const maxSubscriberValidity = 398 * 24 * time.Hour
+
+// extensionNames are the names of the extensions reports list by name
+var extensionNames = map[string]string{
+	"2.5.29.14":            "subjectKeyIdentifier",
+	"2.5.29.15":            "keyUsage",
+	"2.5.29.17":            "subjectAltName",
+	"2.5.29.19":            "basicConstraints",
+	"2.5.29.30":            "nameConstraints",
+	"2.5.29.31":            "cRLDistributionPoints",
+	"2.5.29.32":            "certificatePolicies",
+	"2.5.29.35":            "authorityKeyIdentifier",
+	"2.5.29.37":            "extKeyUsage",
+	"1.3.6.1.5.5.7.1.1":    "authorityInfoAccess",
+	"1.3.6.1.5.5.7.1.24":   "tlsFeature",
+	"1.3.6.1.5.5.7.1.31":   "acmeIdentifier",
+	ct.OIDSCTList.String(): "signedCertificateTimestampList",
+	ct.OIDPoison.String():  "precertificatePoison",
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// certificateReport is the management API report of a certificate's contents.
+type certificateReport struct {
+	Serial             string            `json:"serial"`
+	Subject            string            `json:"subject"`
+	Issuer             string            `json:"issuer"`
+	NotBefore          time.Time         `json:"notBefore"`
+	NotAfter           time.Time         `json:"notAfter"`
+	SignatureAlgorithm string            `json:"signatureAlgorithm"`
+	PublicKeyAlgorithm string            `json:"publicKeyAlgorithm"`
+	PublicKeySize      int               `json:"publicKeySize,omitempty"`
+	DNSNames           []string          `json:"dnsNames,omitempty"`
+	IPAddresses        []string          `json:"ipAddresses,omitempty"`
+	EmailAddresses     []string          `json:"emailAddresses,omitempty"`
+	URIs               []string          `json:"uris,omitempty"`
+	IsCA               bool              `json:"isCA"`
+	KeyUsages          []string          `json:"keyUsages,omitempty"`
+	ExtKeyUsages       []string          `json:"extKeyUsages,omitempty"`
+	Extensions         []reportExtension `json:"extensions"`
+	// Chain lists the certificate followed by its issuers up to the root
+	Chain []reportChainEntry `json:"chain"`
+	Lints []lintResult       `json:"lints"`
+}
+
+type reportExtension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+	// Value is the hex encoded DER value of the extension
+	Value string `json:"value"`
+}
+
+type reportChainEntry struct {
+	Serial  string `json:"serial"`
+	Subject string `json:"subject"`
+}
+
+type lintResult struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// certificateReportView returns the report of a certificate.
+func certificateReportView(cert *core.Certificate) certificateReport {
+	c := cert.Cert
+	report := certificateReport{
+		Serial:             cert.ID,
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		NotBefore:          c.NotBefore,
+		NotAfter:           c.NotAfter,
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: c.PublicKeyAlgorithm.String(),
+		PublicKeySize:      publicKeySize(c.PublicKey),
+		DNSNames:           c.DNSNames,
+		EmailAddresses:     c.EmailAddresses,
+		IsCA:               c.IsCA,
+		Extensions:         []reportExtension{},
+	}
+	for _, ip := range c.IPAddresses {
+		report.IPAddresses = append(report.IPAddresses, ip.String())
+	}
+	for _, uri := range c.URIs {
+		report.URIs = append(report.URIs, uri.String())
+	}
+	for _, ku := range keyUsageNames {
+		if c.KeyUsage&ku.usage != 0 {
+			report.KeyUsages = append(report.KeyUsages, ku.name)
+		}
+	}
+	for _, eku := range c.ExtKeyUsage {
+		name, ok := extKeyUsageNames[eku]
+		if !ok {
+			name = fmt.Sprintf("unknown(%d)", eku)
+		}
+		report.ExtKeyUsages = append(report.ExtKeyUsages, name)
+	}
+	for _, oid := range c.UnknownExtKeyUsage {
+		report.ExtKeyUsages = append(report.ExtKeyUsages, oid.String())
+	}
+	for _, ext := range c.Extensions {
+		report.Extensions = append(report.Extensions, reportExtension{
+			OID:      ext.Id.String(),
+			Name:     extensionNames[ext.Id.String()],
+			Critical: ext.Critical,
+			Value:    hex.EncodeToString(ext.Value),
+		})
+	}
+	for link := cert; link != nil; link = link.Issuer {
+		report.Chain = append(report.Chain, reportChainEntry{
+			Serial:  link.ID,
+			Subject: link.Cert.Subject.String(),
+		})
+	}
+	report.Lints = lintCertificate(cert)
+	return report
+}
+
+// publicKeySize returns the size in bits of an RSA or ECDSA key, 256 for
+// Ed25519 keys and 0 for other keys.
+func publicKeySize(key interface{}) int {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Params().BitSize
+	case ed25519.PublicKey:
+		return 8 * len(k)
+	}
+	return 0
+}
+
+// lintCertificate runs a small set of checks of the certificate against RFC
+// 5280 and the CA/Browser Forum Baseline Requirements. The "e_" lints are
+// errors and the "w_" lints warnings when they fail. It is no replacement for
+// a full linter like zlint, which can be run by an issuance hook.
+func lintCertificate(cert *core.Certificate) []lintResult {
+	c := cert.Cert
+	subscriber := !c.IsCA
+	var lints []lintResult
+	add := func(name string, applies bool, failed bool, failResult string, detail string) {
+		result := lintResult{Name: name, Result: lintPass}
+		switch {
+		case !applies:
+			result.Result = lintNA
+		case failed:
+			result.Result = failResult
+			result.Detail = detail
+		}
+		lints = append(lints, result)
+	}
+
+	add("e_serial_number_not_positive", true, c.SerialNumber.Sign() <= 0, lintError,
+		"serial number must be positive")
+	add("e_serial_number_longer_than_20_octets", true, len(c.SerialNumber.Bytes()) > 20, lintError,
+		fmt.Sprintf("serial number is %d octets", len(c.SerialNumber.Bytes())))
+	add("e_validity_not_after_before_not_before", true, !c.NotAfter.After(c.NotBefore), lintError,
+		"notAfter is not after notBefore")
+
+	validity := c.NotAfter.Sub(c.NotBefore)
+	add("w_sub_cert_validity_over_398_days", subscriber, validity > maxSubscriberValidity, lintWarn,
+		fmt.Sprintf("validity is %s", validity))
+	sans := len(c.DNSNames) + len(c.IPAddresses) + len(c.EmailAddresses) + len(c.URIs)
+	add("e_sub_cert_missing_san", subscriber, sans == 0, lintError,
+		"certificate has no subjectAltName")
+	add("e_sub_cert_cn_not_in_san", subscriber && c.Subject.CommonName != "",
+		!commonNameInSANs(c), lintError,
+		fmt.Sprintf("common name %q is not one of the SANs", c.Subject.CommonName))
+	add("e_sub_cert_key_cert_sign", subscriber, c.KeyUsage&x509.KeyUsageCertSign != 0, lintError,
+		"subscriber certificate has the keyCertSign key usage")
+	add("w_sub_cert_eku_missing_server_auth", subscriber, !hasServerAuth(c), lintWarn,
+		"extKeyUsage doesn't include serverAuth")
+	add("e_precert_poison_in_cert", true, hasExtension(c, ct.OIDPoison), lintError,
+		"certificate has the precertificate poison extension")
+	add("e_ca_key_cert_sign_missing", c.IsCA, c.KeyUsage&x509.KeyUsageCertSign == 0, lintError,
+		"CA certificate lacks the keyCertSign key usage")
+
+	selfSigned := bytes.Equal(c.RawIssuer, c.RawSubject)
+	add("e_aki_missing", !selfSigned, len(c.AuthorityKeyId) == 0, lintError,
+		"certificate has no authorityKeyIdentifier")
+	issuer := c
+	if cert.Issuer != nil {
+		issuer = cert.Issuer.Cert
+	}
+	sigErr := c.CheckSignatureFrom(issuer)
+	detail := ""
+	if sigErr != nil {
+		detail = sigErr.Error()
+	}
+	add("e_signature_invalid", cert.Issuer != nil || selfSigned, sigErr != nil, lintError, detail)
+	return lints
+}
+
+func commonNameInSANs(c *x509.Certificate) bool {
+	for _, name := range c.DNSNames {
+		if name == c.Subject.CommonName {
+			return true
+		}
+	}
+	for _, ip := range c.IPAddresses {
+		if ip.String() == c.Subject.CommonName {
+			return true
+		}
+	}
+	return false
+}
+
+func hasServerAuth(c *x509.Certificate) bool {
+	for _, eku := range c.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageServerAuth || eku == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtension(c *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range c.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
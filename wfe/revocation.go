@@ -0,0 +1,187 @@
+package wfe
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/webhook"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// revocationReasons are the CRLReasons accepted by revokeCert, as by Let's
+// Encrypt: unspecified, keyCompromise, affiliationChanged, superseded and
+// cessationOfOperation
+var revocationReasons = map[int]bool{0: true, 1: true, 3: true, 4: true, 5: true}
+
+// revocationRequest is the payload of a revokeCert request (RFC 8555 Section
+// 7.6)
+type revocationRequest struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason"`
+}
+
+// RevokeCert revokes a certificate (RFC 8555 Section 7.6). The request is
+// authorized if it is signed by the certificate's key, with a "jwk" header, or
+// by an account, with a "kid" header, that either created the order of the
+// certificate or holds valid authorizations for all of its identifiers. A
+// certificate revoked for keyCompromise blocks its key from new accounts and
+// certificates.
+func (wfe *WebFrontEndImpl) RevokeCert(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	// The request can be signed with an account key or the certificate key
+	byAccount := false
+	body, key, prob := wfe.verifyPOST(ctx, logEvent, request,
+		func(request *http.Request, jws *jose.JSONWebSignature) (*jose.JSONWebKey, *acme.ProblemDetails) {
+			if jws.Signatures[0].Header.KeyID != "" {
+				byAccount = true
+				return wfe.lookupJWK(request, jws)
+			}
+			return wfe.extractJWK(request, jws)
+		})
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	var revokeRequest revocationRequest
+	if err := json.Unmarshal(body, &revokeRequest); err != nil {
+		wfe.sendError(
+			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(revokeRequest.Certificate)
+	if err != nil {
+		wfe.sendError(
+			acme.MalformedProblem("Error decoding Base64url-encoded certificate: "+err.Error()), response)
+		return
+	}
+	reason := 0
+	if revokeRequest.Reason != nil {
+		reason = *revokeRequest.Reason
+	}
+	if !revocationReasons[reason] {
+		wfe.sendError(acme.BadRevocationReasonProblem(fmt.Sprintf(
+			"Revocation reason %d is not allowed, must be one of 0, 1, 3, 4 or 5", reason)), response)
+		return
+	}
+
+	cert := wfe.certificateByDER(der)
+	if cert == nil {
+		wfe.sendError(acme.NotFoundProblem("Certificate not issued by this CA"), response)
+		return
+	}
+	if cert.Cert.IsCA {
+		wfe.sendError(acme.UnauthorizedProblem(fmt.Sprintf(
+			"Certificate %s is a CA certificate and can't be revoked", cert.ID)), response)
+		return
+	}
+
+	event := webhook.Event{
+		Type:              webhook.CertificateRevoked,
+		CertificateSerial: cert.ID,
+		RevocationReason:  &reason,
+	}
+	if byAccount {
+		acct, prob := wfe.getAcctByKey(key)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		orderID, prob := wfe.authorizeRevocation(acct.ID, cert)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		event.AccountID = acct.ID
+		event.OrderID = orderID
+	} else {
+		keyID, err := core.KeyToID(key)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem("Error computing key ID: "+err.Error()), response)
+			return
+		}
+		certKeyID, err := core.KeyToID(cert.Cert.PublicKey)
+		if err != nil || keyID != certKeyID {
+			wfe.sendError(acme.UnauthorizedProblem(
+				"JWS is not signed by the key of the certificate"), response)
+			return
+		}
+	}
+
+	if _, revoked := wfe.db.GetRevocationByID(cert.ID); revoked {
+		wfe.sendError(acme.AlreadyRevokedProblem(fmt.Sprintf(
+			"Certificate %s is already revoked", cert.ID)), response)
+		return
+	}
+	if err := wfe.db.RevokeCertificate(cert.ID, wfe.clk.Now().UTC(), reason); err != nil {
+		wfe.sendError(acme.AlreadyRevokedProblem(err.Error()), response)
+		return
+	}
+	wfe.log.WithContext(ctx).Printf("Certificate %s revoked with reason %d\n", cert.ID, reason)
+	wfe.notifier.Notify(event)
+	response.WriteHeader(http.StatusOK)
+}
+
+// certificateByDER returns the certificate with the DER encoding, or nil if
+// the CA didn't issue it.
+func (wfe *WebFrontEndImpl) certificateByDER(der []byte) *core.Certificate {
+	for _, cert := range wfe.db.ListCertificates() {
+		if bytes.Equal(cert.DER, der) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// authorizeRevocation returns an unauthorized problem unless the account may
+// revoke the certificate: it created the order of the certificate, whose ID is
+// returned, or it holds valid authorizations for all of the certificate's
+// identifiers. A valid authorization for a domain covers its wildcard name.
+func (wfe *WebFrontEndImpl) authorizeRevocation(acctID string, cert *core.Certificate) (string, *acme.ProblemDetails) {
+	for _, order := range wfe.db.GetOrdersByAccountID(acctID) {
+		order.RLock()
+		issued := order.CertificateObject == cert
+		order.RUnlock()
+		if issued {
+			return order.ID, nil
+		}
+	}
+
+	now := wfe.clk.Now()
+	authorized := make(map[acme.Identifier]bool)
+	for _, authz := range wfe.db.GetAuthorizationsByAccountID(acctID) {
+		authz.RLock()
+		if authz.Status == acme.StatusValid && now.Before(authz.ExpiresDate) {
+			authorized[authz.Identifier] = true
+		}
+		authz.RUnlock()
+	}
+	var unauthorized []string
+	for _, name := range cert.Cert.DNSNames {
+		ident := acme.Identifier{Type: acme.IdentifierDNS, Value: strings.TrimPrefix(name, "*.")}
+		if !authorized[ident] {
+			unauthorized = append(unauthorized, name)
+		}
+	}
+	for _, ip := range cert.Cert.IPAddresses {
+		if !authorized[acme.Identifier{Type: acme.IdentifierIP, Value: ip.String()}] {
+			unauthorized = append(unauthorized, ip.String())
+		}
+	}
+	if len(unauthorized) > 0 {
+		return "", acme.UnauthorizedProblem(fmt.Sprintf(
+			"Account %s is not authorized for %s", acctID, strings.Join(unauthorized, ", ")))
+	}
+	return "", nil
+}
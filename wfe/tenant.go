@@ -0,0 +1,31 @@
+package wfe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tenantNameRegexp matches the names of tenants, which are used as the first
+// path segment of their ACME and management API URLs
+var tenantNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// CheckTenantName returns an error if name isn't a lowercase alphanumeric path
+// segment, dashes allowed, or if it is the first path segment of an ACME or
+// management API endpoint.
+func CheckTenantName(name string) error {
+	if !tenantNameRegexp.MatchString(name) {
+		return fmt.Errorf("tenant name %q must be lowercase letters, digits and dashes", name)
+	}
+	for _, p := range []string{
+		directoryPath, noncePath, newAccountPath, acctPath, keyRolloverPath, newOrderPath,
+		newAuthzPath, orderPath, ordersPath, finalizePath, authzPath, challengePath, certPath,
+		revokeCertPath, validationsPath, maintenancePath, termsOfServicePath, mgmtAccountsPath,
+		mgmtOrdersPath, mgmtAuthzsPath, mgmtCertsPath, mgmtRootCAPath, expiringAuthzsPath,
+	} {
+		if strings.EqualFold(strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0], name) {
+			return fmt.Errorf("tenant name %q is the path of an API endpoint", name)
+		}
+	}
+	return nil
+}
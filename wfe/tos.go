@@ -0,0 +1,122 @@
+package wfe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// termsOfService holds the current terms of service URL. It is shared by the
+// ACME and management handlers.
+type termsOfService struct {
+	sync.RWMutex
+	url string
+	// base is the URL the terms of service are rotated from when the rotation
+	// doesn't name a new URL
+	base string
+	// version is incremented by every rotation
+	version int
+	// If enforced is true accounts that haven't agreed to the current terms of
+	// service get a userActionRequired problem. It is set when the terms of
+	// service are configured or have been rotated.
+	enforced bool
+}
+
+func newTermsOfService(meta DirectoryMeta) *termsOfService {
+	return &termsOfService{
+		url:      meta.termsOfService(),
+		base:     meta.termsOfService(),
+		version:  1,
+		enforced: meta.TermsOfService != "",
+	}
+}
+
+func (t *termsOfService) get() (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.url, t.enforced
+}
+
+// rotate replaces the terms of service with newURL, or with a new version of
+// the original URL if newURL is empty, and starts enforcing them.
+func (t *termsOfService) rotate(newURL string) string {
+	t.Lock()
+	defer t.Unlock()
+	t.version++
+	if newURL == "" {
+		newURL = fmt.Sprintf("%s#v%d", t.base, t.version)
+	}
+	t.url = newURL
+	t.enforced = true
+	return t.url
+}
+
+// termsOfServiceProblem returns a userActionRequired problem linking to the
+// current terms of service if they are enforced and the account hasn't agreed
+// to them. The caller must hold the account lock.
+func (wfe *WebFrontEndImpl) termsOfServiceProblem(acct *core.Account) *acme.ProblemDetails {
+	tosURL, enforced := wfe.tos.get()
+	if !enforced || acct.AgreedTermsOfService == tosURL {
+		return nil
+	}
+	prob := acme.UserActionRequiredProblem(fmt.Sprintf(
+		"Account must agree to the terms of service at %s by updating the account "+
+			"with termsOfServiceAgreed true", tosURL))
+	prob.Header = http.Header{}
+	prob.Header.Add("Link", link(tosURL, "terms-of-service"))
+	return prob
+}
+
+// termsOfServiceState is the JSON representation of the terms of service used
+// by the TermsOfService management handler.
+type termsOfServiceState struct {
+	URL      string `json:"url"`
+	Enforced bool   `json:"enforced"`
+}
+
+// TermsOfService returns the current terms of service for a GET and rotates
+// them for a POST, which may name the new URL with {"url": "..."}. After a
+// rotation accounts have to agree to the new terms of service before they can
+// make any request except updating their account.
+func (wfe *WebFrontEndImpl) TermsOfService(response http.ResponseWriter, request *http.Request) {
+	if request.Method == "POST" {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			wfe.sendError(acme.MalformedProblem("Unable to read request body"), response)
+			return
+		}
+		var state termsOfServiceState
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &state); err != nil {
+				wfe.sendError(
+					acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+				return
+			}
+		}
+		if state.URL != "" {
+			if u, err := url.Parse(state.URL); err != nil || !u.IsAbs() {
+				wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+					"url %q is not an absolute URL", state.URL)), response)
+				return
+			}
+		}
+		tosURL := wfe.tos.rotate(state.URL)
+		wfe.log.WithContext(request.Context()).Printf("Terms of service rotated to %s\n", tosURL)
+	}
+
+	tosURL, enforced := wfe.tos.get()
+	err := wfe.writeJsonResponse(response, http.StatusOK, termsOfServiceState{
+		URL:      tosURL,
+		Enforced: enforced,
+	})
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling terms of service"), response)
+		return
+	}
+}
@@ -0,0 +1,101 @@
+package wfe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+)
+
+// The defaults of ValidityWindow
+const (
+	defaultMaxBackdate = time.Hour
+	defaultMaxPostdate = 30 * 24 * time.Hour
+	// defaultMaxValidity is the validity of certificates without a policy or
+	// profile
+	defaultMaxValidity = 5 * 365 * 24 * time.Hour
+)
+
+// ValidityWindow limits the notBefore and notAfter fields that newOrder
+// requests can include (RFC 8555 Section 7.4). The CA issues the certificate of
+// the order for the requested window. A zero duration uses its default.
+type ValidityWindow struct {
+	// MaxBackdate is how far before the current time notBefore can be, by
+	// default an hour
+	MaxBackdate core.Duration
+	// MaxPostdate is how far after the current time notBefore can be, by
+	// default 30 days
+	MaxPostdate core.Duration
+	// MaxValidity is the longest time from notBefore, or from the current time
+	// without one, to notAfter, by default 5 years
+	MaxValidity core.Duration
+}
+
+// Validate returns an error if a limit is negative.
+func (w ValidityWindow) Validate() error {
+	for name, d := range map[string]core.Duration{
+		"maxBackdate": w.MaxBackdate,
+		"maxPostdate": w.MaxPostdate,
+		"maxValidity": w.MaxValidity,
+	} {
+		if d.Duration < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", name, d.Duration)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns the window with the defaults for zero durations.
+func (w ValidityWindow) withDefaults() ValidityWindow {
+	if w.MaxBackdate.Duration == 0 {
+		w.MaxBackdate.Duration = defaultMaxBackdate
+	}
+	if w.MaxPostdate.Duration == 0 {
+		w.MaxPostdate.Duration = defaultMaxPostdate
+	}
+	if w.MaxValidity.Duration == 0 {
+		w.MaxValidity.Duration = defaultMaxValidity
+	}
+	return w
+}
+
+// check returns a malformed problem if the notBefore or notAfter of a newOrder
+// request isn't an RFC 3339 timestamp or is outside the window at now. Empty
+// fields aren't checked.
+func (w ValidityWindow) check(now time.Time, notBefore, notAfter string) *acme.ProblemDetails {
+	start := now
+	if notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"notBefore %q is not an RFC 3339 timestamp", notBefore))
+		}
+		if t.Before(now.Add(-w.MaxBackdate.Duration)) {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"notBefore %s is more than %s before the current time", notBefore, w.MaxBackdate.Duration))
+		}
+		if t.After(now.Add(w.MaxPostdate.Duration)) {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"notBefore %s is more than %s after the current time", notBefore, w.MaxPostdate.Duration))
+		}
+		start = t
+	}
+	if notAfter != "" {
+		t, err := time.Parse(time.RFC3339, notAfter)
+		if err != nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"notAfter %q is not an RFC 3339 timestamp", notAfter))
+		}
+		if !t.After(start) || !t.After(now) {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"notAfter %s must be after notBefore and the current time", notAfter))
+		}
+		if validity := t.Sub(start); validity > w.MaxValidity.Duration {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Requested validity of %s is longer than the maximum of %s",
+				validity.Round(time.Second), w.MaxValidity.Duration))
+		}
+	}
+	return nil
+}
@@ -1,22 +1,26 @@
 package wfe
 
 import (
+	"bytes"
 	"context"
 	"crypto"
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/mail"
 	"net/url"
+	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -24,29 +28,57 @@ import (
 
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/ca"
 	"github.com/letsencrypt/pebble/core"
 	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/ratelimit"
 	"github.com/letsencrypt/pebble/va"
+	"github.com/letsencrypt/pebble/webhook"
 )
 
 const (
 	// Note: We deliberately pick endpoint paths that differ from Boulder to
 	// exercise clients processing of the /directory response
-	directoryPath  = "/dir"
-	noncePath      = "/nonce-plz"
-	newAccountPath = "/sign-me-up"
-	acctPath       = "/my-account/"
-	newOrderPath   = "/order-plz"
-	orderPath      = "/my-order/"
-	authzPath      = "/authZ/"
-	challengePath  = "/chalZ/"
-	certPath       = "/certZ/"
+	directoryPath   = "/dir"
+	noncePath       = "/nonce-plz"
+	newAccountPath  = "/sign-me-up"
+	acctPath        = "/my-account/"
+	keyRolloverPath = "/rollover-account-key"
+	newOrderPath    = "/order-plz"
+	newAuthzPath    = "/authz-plz"
+	orderPath       = "/my-order/"
+	ordersPath      = "/list-orderz/"
+	finalizePath    = "/finalize-order/"
+	authzPath       = "/authZ/"
+	challengePath   = "/chalZ/"
+	certPath        = "/certZ/"
+	revokeCertPath  = "/revoke-cert"
+
+	// alternateChainSuffix follows the URL of a certificate for the download
+	// of its alternate chain, if its issuer is cross-signed
+	alternateChainSuffix = "/1"
 
 	// How long do pending authorizations last before expiring?
 	pendingAuthzExpire = time.Hour
 
 	// How many contacts is an account allowed to have?
 	maxContactsPerAcct = 2
+
+	// How many order URLs are returned in each page of an account's orders list
+	// when no page size is configured?
+	defaultOrdersPerPage = 10
+
+	// rejectRefinalizeEnvVar defines the environment variable name used to
+	// signal that the WFE should reject finalize requests for orders that were
+	// already finalized, even if the CSR is identical. By default the existing
+	// order is returned.
+	rejectRefinalizeEnvVar = "PEBBLE_WFE_REJECT_REFINALIZE"
+
+	// nonceRejectEnvVar defines the environment variable name used to set the
+	// percentage of otherwise valid nonces the WFE rejects with a badNonce
+	// problem, so that client retry logic is exercised.
+	nonceRejectEnvVar = "PEBBLE_WFE_NONCEREJECT"
 )
 
 type requestEvent struct {
@@ -59,7 +91,7 @@ type requestEvent struct {
 type wfeHandlerFunc func(context.Context, *requestEvent, http.ResponseWriter, *http.Request)
 
 func (f wfeHandlerFunc) ServeHTTP(e *requestEvent, w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+	ctx := r.Context()
 	f(ctx, e, w, r)
 }
 
@@ -72,6 +104,10 @@ type topHandler struct {
 }
 
 func (th *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Tag the request with an ID that is logged by the WFE, and by the VA and
+	// CA for any validation or issuance it causes
+	r = r.WithContext(logging.ContextWithRequestID(r.Context(), logging.NewRequestID()))
+
 	// TODO(@cpu): consider restoring X-Forwarded-For handling for ClientAddr
 	rEvent := &requestEvent{
 		ClientAddr: r.RemoteAddr,
@@ -83,27 +119,266 @@ func (th *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 type WebFrontEndImpl struct {
-	log   *log.Logger
+	log   *logging.Logger
 	db    *db.MemoryStore
 	nonce *nonceMap
 	clk   clock.Clock
 	va    *va.VAImpl
+	ca    *ca.CAImpl
+
+	// If requireEAB is true new accounts must provide an external account
+	// binding signed with one of the MAC keys in the db
+	requireEAB bool
+
+	// If preAuthz is true the newAuthz endpoint is advertised in the directory
+	// and valid pre-authorizations are reused by new orders
+	preAuthz bool
+
+	// ordersPerPage is the number of order URLs in each page of an account's
+	// orders list
+	ordersPerPage int
+
+	// orderAnnotationKey is the name of the extension field of newOrder requests
+	// that is stored with the order and echoed back to clients. Annotations are
+	// disabled when it is empty.
+	orderAnnotationKey string
+
+	csrPolicy CSRPolicy
+
+	// keyPolicy decides which account and CSR keys are rejected as weak or
+	// blocked
+	keyPolicy KeyPolicy
+	// identPolicy decides which DNS identifiers of new orders and
+	// authorizations are rejected
+	identPolicy IdentifierPolicy
+
+	// jwsAlgorithms are the JWS signature algorithms accepted in POSTs
+	jwsAlgorithms []string
+
+	// settings are the chaos rules and rate limiters, which can be changed
+	// with Reload
+	settings *reloadableSettings
+
+	// directoryMeta is the meta object of the directory
+	directoryMeta DirectoryMeta
+
+	// tos are the current terms of service, which can be rotated with the
+	// management API
+	tos *termsOfService
+
+	// authzExpiry warns about valid authorizations that are about to expire
+	authzExpiry *authzExpiryWatcher
+
+	// backpressure limits the validations and finalizations in progress.
+	// pendingFinalizations counts the orders the CA is issuing.
+	backpressure         Backpressure
+	pendingFinalizations *int64
+
+	// certificateChain is the chain returned by the certificate endpoint
+	certificateChain CertificateChain
+
+	// basePath is the path prefix the handlers are served under, e.g.
+	// "/tenant" for a tenant of a multi-tenant Pebble, or empty
+	basePath string
+
+	// authzReuse decides if new orders reuse valid authorizations
+	authzReuse AuthzReuse
+
+	// httpQuirks change the HTTP framing and formatting of directory and
+	// certificate responses
+	httpQuirks HTTPQuirks
+
+	// lifetimes are the lifetimes of orders, authorizations and nonces, with
+	// defaults applied
+	lifetimes Lifetimes
+
+	// stop is closed by Close to stop the sweeper and the expiry watcher
+	stop      chan struct{}
+	closeOnce *sync.Once
+
+	// notifier sends the webhook events of finalizations, issuances and
+	// revocations
+	notifier *webhook.Notifier
+
+	// validityWindow limits the notBefore and notAfter of new orders, with
+	// defaults applied
+	validityWindow ValidityWindow
+
+	// allowLegacyGET accepts plain GETs of the resources that RFC 8555
+	// requires POST-as-GET for
+	allowLegacyGET bool
+
+	// pollRetryAfter is the Retry-After sent with processing orders and
+	// pending authorizations and challenges, or zero to send none
+	pollRetryAfter time.Duration
+
+	// If cancelValidationOnDisconnect is true a validation is cancelled when
+	// the client disconnects before receiving the response to its challenge
+	// POST. Otherwise the validation continues.
+	cancelValidationOnDisconnect bool
+
+	// If rejectRefinalize is true finalizing an already finalized order is an
+	// error even when the CSR is the same as the original
+	rejectRefinalize bool
+
+	// nonceErrPercent is the percentage of valid nonces rejected as invalid
+	nonceErrPercent int
+
+	// maintenance is toggled with the management API. While it is enabled all
+	// ACME endpoints except the directory are unavailable.
+	maintenance *maintenanceMode
 }
 
 const ToSURL = "data:text/plain,Do%20what%20thou%20wilt"
 
+// Config holds the settings of a WFE. The zero value serves the ACME API
+// without EAB, pre-authorizations, rate limits, chaos or webhooks.
+type Config struct {
+	// ExternalAccountBindingRequired makes an external account binding signed
+	// with one of the MAC keys of the database mandatory for new accounts
+	ExternalAccountBindingRequired bool
+	// PreAuthorization enables the newAuthz endpoint
+	PreAuthorization bool
+	// OrdersPerPage is the number of order URLs in each page of an account's
+	// orders list, by default 10
+	OrdersPerPage int
+	// OrderAnnotationKey is the name of the newOrder extension field stored
+	// with orders, or empty to disable annotations
+	OrderAnnotationKey string
+	CSRPolicy          CSRPolicy
+	KeyPolicy          KeyPolicy
+	IdentifierPolicy   IdentifierPolicy
+	// JWSAlgorithms are the JWS signature algorithms accepted in POSTs, by
+	// default all of the supported ones
+	JWSAlgorithms []string
+	// Chaos and the limiters are the initial settings of Reload. A nil limiter
+	// is no limit.
+	Chaos                []ChaosRule
+	NewAccountLimiter    *ratelimit.Limiter
+	NewOrderLimiter      *ratelimit.Limiter
+	DuplicateCertLimiter *ratelimit.Limiter
+	// CancelValidationOnDisconnect cancels a validation when the client
+	// disconnects before receiving the response to its challenge POST
+	CancelValidationOnDisconnect bool
+	DirectoryMeta                DirectoryMeta
+	// PollRetryAfter is the Retry-After sent with processing orders and
+	// pending authorizations and challenges, or zero to send none
+	PollRetryAfter time.Duration
+	// AuthzExpiryWarning is how long before their expiry valid authorizations
+	// are warned about, or zero to emit no warnings
+	AuthzExpiryWarning time.Duration
+	Backpressure       Backpressure
+	CertificateChain   CertificateChain
+	// BasePath is the path prefix the handlers are served under, e.g.
+	// "/tenant" for a tenant of a multi-tenant Pebble
+	BasePath       string
+	AuthzReuse     AuthzReuse
+	HTTPQuirks     HTTPQuirks
+	Lifetimes      Lifetimes
+	ValidityWindow ValidityWindow
+	// AllowLegacyGET accepts plain GETs of the resources that RFC 8555
+	// requires POST-as-GET for
+	AllowLegacyGET bool
+	// Notifier sends the webhook events of finalizations, issuances and
+	// revocations, none are sent if it is nil
+	Notifier *webhook.Notifier
+}
+
+// New creates a WFE serving the accounts and orders of db, validated by va and
+// issued by ca. It starts the goroutines that sweep expired objects and warn
+// about expiring authorizations, which run until Close is called.
 func New(
-	log *log.Logger,
+	log *logging.Logger,
 	clk clock.Clock,
 	db *db.MemoryStore,
-	va *va.VAImpl) WebFrontEndImpl {
-	return WebFrontEndImpl{
-		log:   log,
-		db:    db,
-		nonce: newNonceMap(),
-		clk:   clk,
-		va:    va,
+	va *va.VAImpl,
+	ca *ca.CAImpl,
+	config Config) WebFrontEndImpl {
+	if config.OrdersPerPage <= 0 {
+		config.OrdersPerPage = defaultOrdersPerPage
+	}
+	if len(config.JWSAlgorithms) == 0 {
+		config.JWSAlgorithms = defaultJWSAlgorithms
+	}
+	lifetimes := config.Lifetimes.withDefaults()
+	wfe := WebFrontEndImpl{
+		log:                log,
+		db:                 db,
+		nonce:              newNonceMap(clk, lifetimes.Nonce.Duration, lifetimes.MaxNonces),
+		maintenance:        &maintenanceMode{},
+		clk:                clk,
+		va:                 va,
+		ca:                 ca,
+		requireEAB:         config.ExternalAccountBindingRequired,
+		preAuthz:           config.PreAuthorization,
+		ordersPerPage:      config.OrdersPerPage,
+		orderAnnotationKey: config.OrderAnnotationKey,
+		csrPolicy:          config.CSRPolicy,
+		keyPolicy:          config.KeyPolicy,
+		identPolicy:        config.IdentifierPolicy,
+		jwsAlgorithms:      config.JWSAlgorithms,
+		settings: &reloadableSettings{current: reloadableValues{
+			chaos:                config.Chaos,
+			newAcctLimiter:       config.NewAccountLimiter,
+			newOrderLimiter:      config.NewOrderLimiter,
+			duplicateCertLimiter: config.DuplicateCertLimiter,
+		}},
+
+		directoryMeta:        config.DirectoryMeta,
+		tos:                  newTermsOfService(config.DirectoryMeta),
+		pollRetryAfter:       config.PollRetryAfter,
+		authzExpiry:          newAuthzExpiryWatcher(config.AuthzExpiryWarning),
+		backpressure:         config.Backpressure,
+		pendingFinalizations: new(int64),
+		certificateChain:     config.CertificateChain,
+		basePath:             strings.TrimSuffix(config.BasePath, "/"),
+		authzReuse:           config.AuthzReuse,
+		httpQuirks:           config.HTTPQuirks,
+		lifetimes:            lifetimes,
+		stop:                 make(chan struct{}),
+		closeOnce:            new(sync.Once),
+		notifier:             config.Notifier,
+		validityWindow:       config.ValidityWindow.withDefaults(),
+		allowLegacyGET:       config.AllowLegacyGET,
+
+		cancelValidationOnDisconnect: config.CancelValidationOnDisconnect,
+	}
+
+	// Read the PEBBLE_WFE_REJECT_REFINALIZE environment variable string
+	rejectRefinalize := os.Getenv(rejectRefinalizeEnvVar)
+	// If it is set to something true-like, then repeated finalizes are rejected
+	switch rejectRefinalize {
+	case "1", "true", "True", "TRUE":
+		wfe.rejectRefinalize = true
+		wfe.log.Printf("Rejecting repeated finalization of orders")
+	}
+
+	// Read the PEBBLE_WFE_NONCEREJECT environment variable string
+	if nonceReject := os.Getenv(nonceRejectEnvVar); nonceReject != "" {
+		percent, err := strconv.Atoi(nonceReject)
+		if err != nil || percent < 0 || percent > 100 {
+			wfe.log.Printf("Ignoring invalid %s %q, must be a percentage from 0 to 100",
+				nonceRejectEnvVar, nonceReject)
+		} else {
+			wfe.nonceErrPercent = percent
+			wfe.log.Printf("Rejecting %d%% of valid nonces", percent)
+		}
+	}
+
+	if config.AuthzExpiryWarning > 0 {
+		go wfe.authzExpiry.watch(log, clk, db, wfe.stop)
 	}
+	go wfe.sweepExpired()
+
+	return wfe
+}
+
+// Close stops sweeping expired objects and warning about expiring
+// authorizations. The handlers keep serving. It can be called more than once.
+func (wfe *WebFrontEndImpl) Close() {
+	wfe.closeOnce.Do(func() {
+		close(wfe.stop)
+	})
 }
 
 func (wfe *WebFrontEndImpl) HandleFunc(
@@ -127,7 +402,9 @@ func (wfe *WebFrontEndImpl) HandleFunc(
 	defaultHandler := http.StripPrefix(pattern,
 		&topHandler{
 			wfe: wfeHandlerFunc(func(ctx context.Context, logEvent *requestEvent, response http.ResponseWriter, request *http.Request) {
-				response.Header().Set("Replay-Nonce", wfe.nonce.createNonce())
+				rw := &recoveryWriter{ResponseWriter: response}
+				response = rw
+				defer wfe.recoverPanic(ctx, request, rw)
 
 				logEvent.Endpoint = pattern
 				if request.URL != nil {
@@ -136,19 +413,52 @@ func (wfe *WebFrontEndImpl) HandleFunc(
 
 				addNoCacheHeader(response)
 
+				// The directory stays available during maintenance so clients can
+				// still discover the CA
+				if pattern != directoryPath {
+					if enabled, retryAfter := wfe.maintenance.get(); enabled {
+						wfe.log.WithContext(ctx).Printf("%s %s -> rejected, maintenance mode\n", request.Method, logEvent.Endpoint)
+						response.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+						wfe.sendError(acme.ServiceUnavailableProblem(
+							"Pebble is down for maintenance, retry later"), response)
+						return
+					}
+				}
+
+				response.Header().Set("Replay-Nonce", wfe.nonce.createNonce())
+
 				if !methodsMap[request.Method] {
 					response.Header().Set("Allow", methodsStr)
 					wfe.sendError(acme.MethodNotAllowed(), response)
 					return
 				}
 
-				wfe.log.Printf("%s %s -> calling handler()\n", request.Method, logEvent.Endpoint)
+				finish := func() {}
+				if rule := wfe.chaosFault(pattern); rule != nil {
+					wfe.log.WithContext(ctx).Printf("%s %s -> injecting %s fault\n",
+						request.Method, logEvent.Endpoint, rule.Fault)
+					var done bool
+					response, finish, done = wfe.injectFault(rule, response)
+					if done {
+						return
+					}
+				} else if wfe.httpQuirks.enabled() && (pattern == directoryPath || pattern == certPath) {
+					response, finish = wfe.quirkyResponse(response, rw.ResponseWriter, request)
+				}
+
+				wfe.log.WithContext(ctx).Printf("%s %s -> calling handler()\n", request.Method, logEvent.Endpoint)
 
 				// TODO(@cpu): Configureable request timeout
 				timeout := 1 * time.Minute
 				ctx, cancel := context.WithTimeout(ctx, timeout)
 				handler(ctx, logEvent, response, request)
 				cancel()
+				finish()
+				// POSTs may have updated objects of the db in place, e.g. the
+				// status of an order
+				if request.Method == "POST" {
+					wfe.db.Changed()
+				}
 			},
 			)})
 	mux.Handle(pattern, defaultHandler)
@@ -160,6 +470,11 @@ func (wfe *WebFrontEndImpl) sendError(prob *acme.ProblemDetails, response http.R
 		problemDoc = []byte("{\"detail\": \"Problem marshalling error message.\"}")
 	}
 
+	for name, values := range prob.Header {
+		for _, value := range values {
+			response.Header().Add(name, value)
+		}
+	}
 	response.Header().Set("Content-Type", "application/problem+json")
 	response.WriteHeader(prob.HTTPStatus)
 	response.Write(problemDoc)
@@ -171,16 +486,34 @@ func (wfe *WebFrontEndImpl) Handler() http.Handler {
 	// Note for noncePath: "GET" also implies "HEAD"
 	wfe.HandleFunc(m, noncePath, wfe.Nonce, "GET")
 	wfe.HandleFunc(m, newAccountPath, wfe.NewAccount, "POST")
+	wfe.HandleFunc(m, keyRolloverPath, wfe.KeyRollover, "POST")
 	wfe.HandleFunc(m, newOrderPath, wfe.NewOrder, "POST")
-	wfe.HandleFunc(m, orderPath, wfe.Order, "GET")
-	wfe.HandleFunc(m, authzPath, wfe.Authz, "GET")
+	if wfe.preAuthz {
+		wfe.HandleFunc(m, newAuthzPath, wfe.NewAuthz, "POST")
+	}
+	wfe.HandleFunc(m, orderPath, wfe.Order, "GET", "POST")
+	wfe.HandleFunc(m, ordersPath, wfe.ListOrders, "GET", "POST")
+	wfe.HandleFunc(m, finalizePath, wfe.FinalizeOrder, "POST")
+	wfe.HandleFunc(m, authzPath, wfe.Authz, "GET", "POST")
 	wfe.HandleFunc(m, challengePath, wfe.Challenge, "GET", "POST")
-	wfe.HandleFunc(m, certPath, wfe.Certificate, "GET")
-
-	// TODO(@cpu): Handle POST to acctPath for existing account updates
+	wfe.HandleFunc(m, certPath, wfe.Certificate, "GET", "POST")
+	wfe.HandleFunc(m, revokeCertPath, wfe.RevokeCert, "POST")
+	wfe.HandleFunc(m, acctPath, wfe.UpdateAccount, "POST")
+	// Every other path is an unknown resource
+	wfe.HandleFunc(m, "/", wfe.NotFound, "GET", "POST")
 	return m
 }
 
+// NotFound sends a 404 problem document for requests of unknown paths.
+func (wfe *WebFrontEndImpl) NotFound(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+	// The "/" prefix has been stripped from the path
+	wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No resource at %q", "/"+request.URL.Path)), response)
+}
+
 func (wfe *WebFrontEndImpl) Directory(
 	ctx context.Context,
 	logEvent *requestEvent,
@@ -191,6 +524,11 @@ func (wfe *WebFrontEndImpl) Directory(
 		"new-nonce":   noncePath,
 		"new-account": newAccountPath,
 		"new-order":   newOrderPath,
+		"keyChange":   keyRolloverPath,
+		"revokeCert":  revokeCertPath,
+	}
+	if wfe.preAuthz {
+		directoryEndpoints["newAuthz"] = newAuthzPath
 	}
 
 	response.Header().Set("Content-Type", "application/json")
@@ -212,9 +550,8 @@ func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory m
 	for k, v := range directory {
 		relativeDir[k] = wfe.relativeEndpoint(request, v)
 	}
-	relativeDir["meta"] = map[string]string{
-		"terms-of-service": ToSURL,
-	}
+	tosURL, _ := wfe.tos.get()
+	relativeDir["meta"] = wfe.directoryMeta.object(tosURL, wfe.requireEAB, wfe.ca.Profiles())
 
 	directoryJSON, err := marshalIndent(relativeDir)
 	// This should never happen since we are just marshalling known strings
@@ -225,9 +562,23 @@ func (wfe *WebFrontEndImpl) relativeDirectory(request *http.Request, directory m
 }
 
 func (wfe *WebFrontEndImpl) relativeEndpoint(request *http.Request, endpoint string) string {
-	proto := "http"
+	proto := requestScheme(request)
 	host := request.Host
 
+	// Default to "localhost" when no request.Host is provided. Otherwise requests
+	// with an empty `Host` produce results like `http:///acme/new-authz`
+	if request.Host == "" {
+		host = "localhost"
+	}
+
+	resultUrl := url.URL{Scheme: proto, Host: host, Path: wfe.basePath + endpoint}
+	return resultUrl.String()
+}
+
+// requestScheme returns the URL scheme a request was sent to.
+func requestScheme(request *http.Request) string {
+	proto := "http"
+
 	// If the request was received via TLS, use `https://` for the protocol
 	if request.TLS != nil {
 		proto = "https"
@@ -238,15 +589,7 @@ func (wfe *WebFrontEndImpl) relativeEndpoint(request *http.Request, endpoint str
 	if specifiedProto := request.Header.Get("X-Forwarded-Proto"); specifiedProto != "" {
 		proto = specifiedProto
 	}
-
-	// Default to "localhost" when no request.Host is provided. Otherwise requests
-	// with an empty `Host` produce results like `http:///acme/new-authz`
-	if request.Host == "" {
-		host = "localhost"
-	}
-
-	resultUrl := url.URL{Scheme: proto, Host: host, Path: endpoint}
-	return resultUrl.String()
+	return proto
 }
 
 func (wfe *WebFrontEndImpl) Nonce(
@@ -257,76 +600,6 @@ func (wfe *WebFrontEndImpl) Nonce(
 	response.WriteHeader(http.StatusNoContent)
 }
 
-/*
- * keyToID produces a string with the hex representation of the SHA256 digest
- * over a provided public key. We use this for acme.Account ID values
- * because it makes looking up a account by key easy (required by the spec
- * for retreiving existing account), and becauase it makes the reg URLs
- * somewhat human digestable/comparable.
- */
-func keyToID(key crypto.PublicKey) (string, error) {
-	switch t := key.(type) {
-	case *jose.JSONWebKey:
-		if t == nil {
-			return "", fmt.Errorf("Cannot compute ID of nil key")
-		}
-		return keyToID(t.Key)
-	case jose.JSONWebKey:
-		return keyToID(t.Key)
-	default:
-		keyDER, err := x509.MarshalPKIXPublicKey(key)
-		if err != nil {
-			return "", err
-		}
-		spkiDigest := sha256.Sum256(keyDER)
-		return hex.EncodeToString(spkiDigest[:]), nil
-	}
-}
-
-func (wfe *WebFrontEndImpl) parseJWS(body string) (*jose.JSONWebSignature, error) {
-	// Parse the raw JWS JSON to check that:
-	// * the unprotected Header field is not being used.
-	// * the "signatures" member isn't present, just "signature".
-	//
-	// This must be done prior to `jose.parseSigned` since it will strip away
-	// these headers.
-	var unprotected struct {
-		Header     map[string]string
-		Signatures []interface{}
-	}
-	if err := json.Unmarshal([]byte(body), &unprotected); err != nil {
-		return nil, errors.New("Parse error reading JWS")
-	}
-
-	// ACME v2 never uses values from the unprotected JWS header. Reject JWS that
-	// include unprotected headers.
-	if unprotected.Header != nil {
-		return nil, errors.New(
-			"JWS \"header\" field not allowed. All headers must be in \"protected\" field")
-	}
-
-	// ACME v2 never uses the "signatures" array of JSON serialized JWS, just the
-	// mandatory "signature" field. Reject JWS that include the "signatures" array.
-	if len(unprotected.Signatures) > 0 {
-		return nil, errors.New(
-			"JWS \"signatures\" field not allowed. Only the \"signature\" field should contain a signature")
-	}
-
-	parsedJWS, err := jose.ParseSigned(body)
-	if err != nil {
-		return nil, errors.New("Parse error reading JWS")
-	}
-
-	if len(parsedJWS.Signatures) > 1 {
-		return nil, errors.New("Too many signatures in POST body")
-	}
-
-	if len(parsedJWS.Signatures) == 0 {
-		return nil, errors.New("POST JWS not signed")
-	}
-	return parsedJWS, nil
-}
-
 // extractJWK returns a JSONWebKey embedded in a JWS header.
 func (wfe *WebFrontEndImpl) extractJWK(_ *http.Request, jws *jose.JSONWebSignature) (*jose.JSONWebKey, *acme.ProblemDetails) {
 	header := jws.Signatures[0].Header
@@ -338,15 +611,33 @@ func (wfe *WebFrontEndImpl) extractJWK(_ *http.Request, jws *jose.JSONWebSignatu
 		return nil, acme.MalformedProblem("No JWK in JWS header")
 	}
 
-	if !key.Valid() {
-		return nil, acme.MalformedProblem("Invalid JWK in JWS header")
+	if prob := checkJWK(key); prob != nil {
+		return nil, prob
 	}
 
 	return key, nil
 }
 
 // lookupJWK returns a JSONWebKey referenced by the "kid" (key id) field in a JWS header.
+// Accounts that haven't agreed to the current terms of service get a
+// userActionRequired problem.
 func (wfe *WebFrontEndImpl) lookupJWK(request *http.Request, jws *jose.JSONWebSignature) (*jose.JSONWebKey, *acme.ProblemDetails) {
+	return wfe.lookupAccountJWK(request, jws, true)
+}
+
+// lookupJWKIgnoringToS is lookupJWK for requests that accounts can make
+// before agreeing to changed terms of service.
+func (wfe *WebFrontEndImpl) lookupJWKIgnoringToS(request *http.Request, jws *jose.JSONWebSignature) (*jose.JSONWebKey, *acme.ProblemDetails) {
+	return wfe.lookupAccountJWK(request, jws, false)
+}
+
+// lookupAccountJWK returns the key of the account in the "kid" header of the
+// JWS. Deactivated accounts are rejected, and so are accounts that haven't
+// agreed to the current terms of service if checkToS is true.
+func (wfe *WebFrontEndImpl) lookupAccountJWK(
+	request *http.Request,
+	jws *jose.JSONWebSignature,
+	checkToS bool) (*jose.JSONWebKey, *acme.ProblemDetails) {
 	header := jws.Signatures[0].Header
 	if header.JSONWebKey != nil {
 		return nil, acme.MalformedProblem("jwk and kid header fields are mutually exclusive.")
@@ -359,6 +650,18 @@ func (wfe *WebFrontEndImpl) lookupJWK(request *http.Request, jws *jose.JSONWebSi
 		return nil, acme.AccountDoesNotExistProblem(fmt.Sprintf(
 			"Account %s not found.", accountURL))
 	}
+	account.RLock()
+	defer account.RUnlock()
+	// Deactivated accounts can't be used to authorize any further requests
+	if account.Status == acme.StatusDeactivated {
+		return nil, acme.UnauthorizedProblem(fmt.Sprintf(
+			"Account %s has been deactivated", accountURL))
+	}
+	if checkToS {
+		if prob := wfe.termsOfServiceProblem(account); prob != nil {
+			return nil, prob
+		}
+	}
 	return account.Key, nil
 }
 
@@ -390,10 +693,14 @@ func (wfe *WebFrontEndImpl) verifyPOST(
 		return nil, nil, acme.MalformedProblem("no body on POST")
 	}
 
-	bodyBytes, err := ioutil.ReadAll(request.Body)
+	bodyBytes, err := ioutil.ReadAll(io.LimitReader(request.Body, maxPOSTBodySize+1))
 	if err != nil {
 		return nil, nil, acme.InternalErrorProblem("unable to read request body")
 	}
+	if len(bodyBytes) > maxPOSTBodySize {
+		return nil, nil, acme.MalformedProblem(fmt.Sprintf(
+			"POST body is larger than %d bytes", maxPOSTBodySize))
+	}
 
 	body := string(bodyBytes)
 	parsedJWS, err := wfe.parseJWS(body)
@@ -406,7 +713,9 @@ func (wfe *WebFrontEndImpl) verifyPOST(
 		return nil, nil, prob
 	}
 
-	// TODO(@cpu): `checkAlgorithm()`
+	if prob := wfe.checkAlgorithm(pubKey, parsedJWS); prob != nil {
+		return nil, nil, prob
+	}
 
 	payload, err := parsedJWS.Verify(pubKey)
 	if err != nil {
@@ -419,24 +728,39 @@ func (wfe *WebFrontEndImpl) verifyPOST(
 	} else if !wfe.nonce.validNonce(nonce) {
 		return nil, nil, acme.BadNonceProblem(fmt.Sprintf(
 			"JWS has an invalid anti-replay nonce: %s", nonce))
+	} else if wfe.nonceErrPercent > 0 && rand.Intn(100) < wfe.nonceErrPercent {
+		// The nonce was valid but is rejected anyway, as configured by
+		// PEBBLE_WFE_NONCEREJECT
+		wfe.log.WithContext(request.Context()).Printf("Injecting badNonce for valid nonce %s\n", nonce)
+		return nil, nil, acme.BadNonceProblem(fmt.Sprintf(
+			"JWS has an invalid anti-replay nonce: %s", nonce))
+	}
+
+	if prob := checkJWSURL(request, parsedJWS); prob != nil {
+		return nil, nil, prob
 	}
 
-	headerURL, ok := parsedJWS.Signatures[0].Header.ExtraHeaders[jose.HeaderKey("url")].(string)
+	return []byte(payload), pubKey, nil
+}
+
+// checkJWSURL verifies the "url" header parameter of a JWS matches the URL the
+// request was sent to.
+func checkJWSURL(request *http.Request, jws *jose.JSONWebSignature) *acme.ProblemDetails {
+	headerURL, ok := jws.Signatures[0].Header.ExtraHeaders[jose.HeaderKey("url")].(string)
 	if !ok || len(headerURL) == 0 {
-		return nil, nil, acme.MalformedProblem("JWS header parameter 'url' required.")
+		return acme.MalformedProblem("JWS header parameter 'url' required.")
 	}
 	expectedURL := url.URL{
-		Scheme: "http",
+		Scheme: requestScheme(request),
 		Host:   request.Host,
 		Path:   request.RequestURI,
 	}
 	if expectedURL.String() != headerURL {
-		return nil, nil, acme.MalformedProblem(fmt.Sprintf(
+		return acme.MalformedProblem(fmt.Sprintf(
 			"JWS header parameter 'url' incorrect. Expected %q, got %q",
 			expectedURL.String(), headerURL))
 	}
-
-	return []byte(payload), pubKey, nil
+	return nil
 }
 
 // isASCII determines if every character in a string is encoded in
@@ -472,6 +796,12 @@ func (wfe *WebFrontEndImpl) verifyContacts(acct acme.Account) *acme.ProblemDetai
 			return acme.UnsupportedContactProblem(fmt.Sprintf(
 				"contact method %q is not supported", parsed.Scheme))
 		}
+		// A contact is a single address, without the hfields (e.g. "?subject=")
+		// that mailto URLs can have
+		if parsed.RawQuery != "" || parsed.ForceQuery || parsed.Fragment != "" {
+			return acme.InvalidContactProblem(fmt.Sprintf(
+				"contact %q must not have hfields or a fragment", c))
+		}
 		email := parsed.Opaque
 		// An empty or ommitted Contact array should be used instead of an empty contact
 		if email == "" {
@@ -485,13 +815,80 @@ func (wfe *WebFrontEndImpl) verifyContacts(acct acme.Account) *acme.ProblemDetai
 		// display names. This is sufficient for Pebble because we don't intend to
 		// use the emails for anything and check this as a best effort for client
 		// developers to test invalid contact problems.
-		_, err = mail.ParseAddress(email)
+		addr, err := mail.ParseAddress(email)
 		if err != nil {
 			return acme.InvalidContactProblem(fmt.Sprintf(
 				"contact email %q is invalid", email))
 		}
+		// Display names and lists of addresses are rejected too
+		if addr.Address != email || addr.Name != "" {
+			return acme.InvalidContactProblem(fmt.Sprintf(
+				"contact email %q is not a single bare address", email))
+		}
+	}
+
+	return nil
+}
+
+// verifyEAB checks an external account binding per RFC 8555 Section 7.3.4. The
+// binding must be a JWS with a MAC "alg", a "kid" naming one of the configured
+// external account keys, the same "url" as the outer JWS, no nonce and the
+// account key as its payload.
+func (wfe *WebFrontEndImpl) verifyEAB(
+	eab json.RawMessage,
+	acctKey *jose.JSONWebKey,
+	request *http.Request) *acme.ProblemDetails {
+	eabJWS, err := wfe.parseJWS(string(eab))
+	if err != nil {
+		return acme.MalformedProblem("externalAccountBinding: " + err.Error())
+	}
+	header := eabJWS.Signatures[0].Header
+
+	switch jose.SignatureAlgorithm(header.Algorithm) {
+	case jose.HS256, jose.HS384, jose.HS512:
+	default:
+		return acme.MalformedProblem(fmt.Sprintf(
+			"externalAccountBinding uses unsupported algorithm %q, expected one of HS256, HS384 or HS512",
+			header.Algorithm))
+	}
+
+	if header.Nonce != "" {
+		return acme.MalformedProblem("externalAccountBinding must not contain a nonce")
+	}
+
+	expectedURL := wfe.relativeEndpoint(request, newAccountPath)
+	if eabURL, _ := header.ExtraHeaders[jose.HeaderKey("url")].(string); eabURL != expectedURL {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"externalAccountBinding header parameter 'url' incorrect. Expected %q, got %q",
+			expectedURL, eabURL))
 	}
 
+	macKey, present := wfe.db.GetExternalAccountKeyByID(header.KeyID)
+	if !present {
+		return acme.UnauthorizedProblem(fmt.Sprintf(
+			"externalAccountBinding key ID %q is not known", header.KeyID))
+	}
+
+	payload, err := eabJWS.Verify(macKey)
+	if err != nil {
+		return acme.UnauthorizedProblem("externalAccountBinding signature verification failed")
+	}
+
+	var boundKey jose.JSONWebKey
+	if err := json.Unmarshal(payload, &boundKey); err != nil {
+		return acme.MalformedProblem("externalAccountBinding payload is not a JWK")
+	}
+	boundKeyID, err := core.KeyToID(&boundKey)
+	if err != nil {
+		return acme.MalformedProblem("externalAccountBinding payload has an invalid JWK")
+	}
+	acctKeyID, err := core.KeyToID(acctKey)
+	if err != nil || boundKeyID != acctKeyID {
+		return acme.UnauthorizedProblem(
+			"externalAccountBinding payload does not match the account key")
+	}
+
+	wfe.log.WithContext(request.Context()).Printf("Verified external account binding for key ID %q\n", header.KeyID)
 	return nil
 }
 
@@ -509,6 +906,10 @@ func (wfe *WebFrontEndImpl) NewAccount(
 		wfe.sendError(prob, response)
 		return
 	}
+	if prob := wfe.checkKey(key); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
 
 	// newAcct is the ACME account information submitted by the client
 	var newAcct acme.Account
@@ -526,22 +927,30 @@ func (wfe *WebFrontEndImpl) NewAccount(
 		return
 	}
 
-	// createdAcct is the internal Pebble account object
+	// createdAcct is the internal Pebble account object. Its ID is random rather
+	// than derived from the key so that it remains stable across key rollovers.
 	createdAcct := core.Account{
 		Account: newAcct,
 		Key:     key,
+		ID:      newToken(),
 	}
-	keyID, err := keyToID(key)
+
+	// NOTE: We don't use wfe.getAccountByKey here because we want to treat a
+	//       "missing" account as a non-error
+	existingAcct, err := wfe.db.GetAccountByKey(key)
 	if err != nil {
 		wfe.sendError(acme.MalformedProblem(err.Error()), response)
 		return
 	}
-	createdAcct.ID = keyID
-
-	// NOTE: We don't use wfe.getAccountByKey here because we want to treat a
-	//       "missing" account as a non-error
-	existingAcct := wfe.db.GetAccountByID(createdAcct.ID)
 	if existingAcct != nil {
+		existingAcct.RLock()
+		existingStatus := existingAcct.Status
+		existingAcct.RUnlock()
+		if existingStatus == acme.StatusDeactivated {
+			wfe.sendError(acme.UnauthorizedProblem(
+				"The account for the provided key has been deactivated"), response)
+			return
+		}
 		// If there is an existing account then return a Location header pointing to
 		// the account and a 200 OK response
 		acctURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, existingAcct.ID))
@@ -557,21 +966,63 @@ func (wfe *WebFrontEndImpl) NewAccount(
 		return
 	}
 
-	if newAcct.ToSAgreed == false {
-		response.Header().Add("Link", link(ToSURL, "terms-of-service"))
+	tosURL, tosEnforced := wfe.tos.get()
+	if !newAcct.AgreesToTermsOfService() {
+		response.Header().Add("Link", link(tosURL, "terms-of-service"))
+		if tosEnforced {
+			wfe.sendError(
+				acme.UserActionRequiredProblem(fmt.Sprintf(
+					"Provided account did not agree to the terms of service at %s", tosURL)),
+				response)
+			return
+		}
 		wfe.sendError(
 			acme.AgreementRequiredProblem(
 				"Provided account did not agree to the terms of service"),
 			response)
 		return
 	}
+	createdAcct.AgreedTermsOfService = tosURL
+
+	if wfe.requireEAB {
+		if len(newAcct.ExternalAccountBinding) == 0 {
+			wfe.sendError(
+				acme.ExternalAccountRequiredProblem(
+					"No externalAccountBinding provided, one is required for new accounts"),
+				response)
+			return
+		}
+		prob = wfe.verifyEAB(newAcct.ExternalAccountBinding, key, request)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	}
+	// Only requests that would create an account count towards the limit
+	ip := clientIP(request)
+	if wfe.rateLimited(response, wfe.settings.get().newAcctLimiter, ip, "Too many new accounts from "+ip) {
+		return
+	}
+
+	// The binding is only meaningful at creation time, don't echo it back
+	createdAcct.ExternalAccountBinding = nil
+	newAcct.ExternalAccountBinding = nil
+
+	// New accounts are always valid, regardless of the status the client sent
+	createdAcct.Status = acme.StatusValid
+	newAcct.Status = acme.StatusValid
+
+	// The orders URL is always set by the server
+	ordersURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", ordersPath, createdAcct.ID))
+	createdAcct.Orders = ordersURL
+	newAcct.Orders = ordersURL
 
 	count, err := wfe.db.AddAccount(&createdAcct)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error saving account"), response)
 		return
 	}
-	wfe.log.Printf("There are now %d accounts in memory\n", count)
+	wfe.log.WithContext(ctx).Printf("There are now %d accounts in memory\n", count)
 
 	acctURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, createdAcct.ID))
 
@@ -583,119 +1034,563 @@ func (wfe *WebFrontEndImpl) NewAccount(
 	}
 }
 
-func (wfe *WebFrontEndImpl) verifyOrder(order *core.Order, reg *core.Account) *acme.ProblemDetails {
-	// Lock the order for reading
-	order.RLock()
-	defer order.RUnlock()
+// UpdateAccount handles POSTs to an existing account's URL. A payload with
+// "status": "deactivated" deactivates the account and one with a "contact"
+// list replaces the account's contacts, an empty list removes them. The
+// updated account is returned.
+func (wfe *WebFrontEndImpl) UpdateAccount(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
 
-	// Shouldn't happen - defensive check
-	if order == nil {
-		return acme.InternalErrorProblem("Order is nil")
-	}
-	if reg == nil {
-		return acme.InternalErrorProblem("Account is nil")
+	// Accounts that haven't agreed to the current terms of service can still
+	// be updated, that's how they agree to them
+	body, key, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWKIgnoringToS)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
 	}
-	csr := order.ParsedCSR
-	if csr == nil {
-		return acme.InternalErrorProblem("Parsed CSR is nil")
+
+	existingAcct, prob := wfe.getAcctByKey(key)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
 	}
-	if len(csr.DNSNames) == 0 {
-		return acme.MalformedProblem("CSR has no names in it")
+
+	// An account may only be updated by a JWS signed with its own key
+	acctID := strings.TrimPrefix(request.URL.Path, acctPath)
+	if acctID != existingAcct.ID {
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Request signing key does not match the account being updated"), response)
+		return
 	}
-	orderKeyID, err := keyToID(csr.PublicKey)
-	if err != nil {
-		return acme.MalformedProblem("CSR has an invalid PublicKey")
+
+	var update acme.Account
+	if err := json.Unmarshal(body, &update); err != nil {
+		wfe.sendError(acme.MalformedProblem("Error unmarshaling body JSON"), response)
+		return
 	}
-	if orderKeyID == reg.ID {
-		return acme.MalformedProblem("Certificate public key must be different than account key")
+	// A missing contact field keeps the contacts, an empty list is non-nil
+	if update.Contact != nil {
+		if prob := wfe.verifyContacts(update); prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
 	}
-	return nil
-}
-
-// makeAuthorizations populates an order with new authz's. The request parameter
-// is required to make the authz URL's absolute based on the request host
-func (wfe *WebFrontEndImpl) makeAuthorizations(order *core.Order, request *http.Request) error {
-	var auths []string
-	var authObs []*core.Authorization
 
-	// Lock the order for reading
-	order.RLock()
-	// Create one authz for each name in the order's parsed CSR
-	for _, name := range order.ParsedCSR.DNSNames {
-		ident := acme.Identifier{
-			Type:  acme.IdentifierDNS,
-			Value: name,
-		}
-		now := wfe.clk.Now().UTC()
-		expires := now.Add(pendingAuthzExpire)
-		authz := &core.Authorization{
-			ID:          newToken(),
-			ExpiresDate: expires,
-			Order:       order,
-			Authorization: acme.Authorization{
-				Status:     acme.StatusPending,
-				Identifier: ident,
-				Expires:    expires.UTC().Format(time.RFC3339),
-			},
-		}
-		authz.URL = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
-		// Create the challenges for this authz
-		err := wfe.makeChallenges(authz, request)
-		if err != nil {
-			return err
+	existingAcct.Lock()
+	switch update.Status {
+	case "":
+		// No status change requested
+	case acme.StatusDeactivated:
+		existingAcct.Status = acme.StatusDeactivated
+		wfe.log.WithContext(ctx).Printf("Deactivated account %s\n", existingAcct.ID)
+	default:
+		existingAcct.Unlock()
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Invalid account status %q, only %q is accepted",
+			update.Status, acme.StatusDeactivated)), response)
+		return
+	}
+	if update.AgreesToTermsOfService() {
+		tosURL, _ := wfe.tos.get()
+		if existingAcct.AgreedTermsOfService != tosURL {
+			wfe.log.WithContext(ctx).Printf("Account %s agreed to the terms of service at %s\n",
+				existingAcct.ID, tosURL)
 		}
-		// Save the authorization in memory
-		count, err := wfe.db.AddAuthorization(authz)
-		if err != nil {
-			return err
+		existingAcct.AgreedTermsOfService = tosURL
+		existingAcct.ToSAgreed = true
+	} else if update.Status != acme.StatusDeactivated {
+		// Deactivating an account never needs the current terms of service
+		if prob := wfe.termsOfServiceProblem(existingAcct); prob != nil {
+			existingAcct.Unlock()
+			wfe.sendError(prob, response)
+			return
 		}
-		wfe.log.Printf("There are now %d authorizations in the db\n", count)
-		authzURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
-		auths = append(auths, authzURL)
-		authObs = append(authObs, authz)
 	}
-	// Unlock the order from reading
-	order.RUnlock()
-
-	// Lock the order for writing & update the order's authorizations
-	order.Lock()
-	order.Authorizations = auths
-	order.AuthorizationObjects = authObs
-	order.Unlock()
-	return nil
-}
-
-func (wfe *WebFrontEndImpl) makeChallenge(
-	chalType string,
-	authz *core.Authorization,
-	request *http.Request) (*core.Challenge, error) {
-	// Create a new challenge of the requested type
-	id := newToken()
-	chal := &core.Challenge{
-		ID: id,
-		Challenge: acme.Challenge{
-			Type:   chalType,
-			Token:  newToken(),
-			URL:    wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", challengePath, id)),
-			Status: acme.StatusPending,
-		},
-		Authz: authz,
+	if update.Contact != nil {
+		existingAcct.Contact = update.Contact
+		wfe.log.WithContext(ctx).Printf("Updated the contacts of account %s to %s\n",
+			existingAcct.ID, strings.Join(update.Contact, ", "))
 	}
+	acct := existingAcct.Account
+	existingAcct.Unlock()
 
-	// Add it to the in-memory database
-	_, err := wfe.db.AddChallenge(chal)
+	err := wfe.writeJsonResponse(response, http.StatusOK, acct)
 	if err != nil {
-		return nil, err
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling account"), response)
+		return
 	}
-	return chal, nil
 }
 
-// makeChallenges populates an authz with new challenges. The request parameter
-// is required to make the challenge URL's absolute based on the request host
-func (wfe *WebFrontEndImpl) makeChallenges(authz *core.Authorization, request *http.Request) error {
-	var chals []*core.Challenge
-
-	enabledChallenges := []string{acme.ChallengeHTTP01, acme.ChallengeTLSSNI02, acme.ChallengeDNS01}
+// KeyRollover changes the key of an existing account per RFC 8555 Section
+// 7.3.5. The outer JWS is signed by the current account key and its payload is
+// an inner JWS signed by the new key.
+func (wfe *WebFrontEndImpl) KeyRollover(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	outerPayload, outerKey, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWK)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	existingAcct, prob := wfe.getAcctByKey(outerKey)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	innerJWS, err := wfe.parseJWS(string(outerPayload))
+	if err != nil {
+		wfe.sendError(acme.MalformedProblem("Inner JWS: "+err.Error()), response)
+		return
+	}
+
+	// The inner JWS must embed the new key as a JWK and be signed by it
+	newKey, prob := wfe.extractJWK(request, innerJWS)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	if prob := wfe.checkAlgorithm(newKey, innerJWS); prob != nil {
+		prob.Detail = "Inner JWS: " + prob.Detail
+		wfe.sendError(prob, response)
+		return
+	}
+	innerPayload, err := innerJWS.Verify(newKey)
+	if err != nil {
+		wfe.sendError(acme.MalformedProblem("Inner JWS verification error"), response)
+		return
+	}
+	if prob := wfe.checkKey(newKey); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	// The inner JWS must have the same "url" as the outer JWS
+	if prob := checkJWSURL(request, innerJWS); prob != nil {
+		prob.Detail = "Inner " + prob.Detail
+		wfe.sendError(prob, response)
+		return
+	}
+
+	var rolloverRequest struct {
+		Account string
+		OldKey  *jose.JSONWebKey
+	}
+	if err := json.Unmarshal(innerPayload, &rolloverRequest); err != nil {
+		wfe.sendError(acme.MalformedProblem("Error unmarshaling key roll-over inner JWS body"), response)
+		return
+	}
+
+	acctURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, existingAcct.ID))
+	if rolloverRequest.Account != acctURL {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Inner JWS 'account' %q does not match outer JWS 'kid' %q",
+			rolloverRequest.Account, acctURL)), response)
+		return
+	}
+
+	if rolloverRequest.OldKey == nil {
+		wfe.sendError(acme.MalformedProblem("Inner JWS is missing 'oldKey'"), response)
+		return
+	}
+	oldKeyID, err := core.KeyToID(rolloverRequest.OldKey)
+	if err != nil {
+		wfe.sendError(acme.MalformedProblem("Inner JWS 'oldKey' is invalid"), response)
+		return
+	}
+	outerKeyID, err := core.KeyToID(outerKey)
+	if err != nil || oldKeyID != outerKeyID {
+		wfe.sendError(acme.MalformedProblem(
+			"Inner JWS 'oldKey' does not match the key used to sign the outer JWS"), response)
+		return
+	}
+
+	conflictAcct, err := wfe.db.ChangeAccountKey(existingAcct, newKey)
+	if conflictAcct != nil {
+		response.Header().Set("Location",
+			wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", acctPath, conflictAcct.ID)))
+		wfe.sendError(acme.Conflict("New key is already in use for a different account"), response)
+		return
+	}
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error changing account key"), response)
+		return
+	}
+	wfe.log.WithContext(ctx).Printf("Rolled over key for account %s\n", existingAcct.ID)
+
+	existingAcct.RLock()
+	acct := existingAcct.Account
+	existingAcct.RUnlock()
+	err = wfe.writeJsonResponse(response, http.StatusOK, acct)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling account"), response)
+		return
+	}
+}
+
+// normalizeIdentifier returns ident in the form it is stored and compared in.
+// DNS names are case insensitive and are lowercased with any trailing dot
+// removed. IP addresses are written in their canonical form, invalid ones are
+// left for verifyOrderIdentifiers to reject.
+func normalizeIdentifier(ident acme.Identifier) acme.Identifier {
+	switch ident.Type {
+	case acme.IdentifierDNS:
+		ident.Value = strings.TrimSuffix(strings.ToLower(ident.Value), ".")
+	case acme.IdentifierIP:
+		if ip := net.ParseIP(ident.Value); ip != nil {
+			ident.Value = ip.String()
+		}
+	}
+	return ident
+}
+
+// normalizeIdentifiers returns a copy of idents with every identifier
+// normalized by normalizeIdentifier.
+func normalizeIdentifiers(idents []acme.Identifier) []acme.Identifier {
+	normalized := make([]acme.Identifier, len(idents))
+	for i, ident := range idents {
+		normalized[i] = normalizeIdentifier(ident)
+	}
+	return normalized
+}
+
+// verifyOrderIdentifiers checks the identifiers of a new order are of a
+// supported type, well formed, not duplicated and not forbidden by the
+// identifier policy. The identifiers must already be normalized so that
+// duplicates differing only in case are caught. When several identifiers are
+// rejected the problem has a subproblem for each.
+func (wfe *WebFrontEndImpl) verifyOrderIdentifiers(idents []acme.Identifier) *acme.ProblemDetails {
+	if len(idents) == 0 {
+		return acme.MalformedProblem("Order did not specify any identifiers")
+	}
+
+	var subproblems []acme.SubProblemDetails
+	seen := make(map[acme.Identifier]bool, len(idents))
+	for _, ident := range idents {
+		prob := verifyOrderIdentifier(ident)
+		if prob == nil {
+			prob = wfe.identPolicy.check(ident)
+		}
+		if prob == nil && seen[ident] {
+			prob = acme.MalformedProblem(fmt.Sprintf(
+				"Order included duplicate identifier %s:%s", ident.Type, ident.Value))
+		}
+		seen[ident] = true
+		if prob != nil {
+			subproblems = append(subproblems, acme.SubProblemDetails{
+				ProblemDetails: *prob,
+				Identifier:     ident,
+			})
+		}
+	}
+	return acme.IdentifiersProblem(fmt.Sprintf(
+		"Order included %d rejected identifiers", len(subproblems)), subproblems)
+}
+
+// verifyOrderIdentifier checks a single identifier of a new order is of a
+// supported type and well formed.
+func verifyOrderIdentifier(ident acme.Identifier) *acme.ProblemDetails {
+	switch ident.Type {
+	case acme.IdentifierDNS:
+		if ident.Value == "" {
+			return acme.MalformedProblem("Order included an empty DNS identifier")
+		}
+		return verifyWildcard(ident.Value)
+	case acme.IdentifierIP:
+		if net.ParseIP(ident.Value) == nil {
+			return acme.MalformedProblem(fmt.Sprintf(
+				"Order included an invalid IP address identifier %q", ident.Value))
+		}
+		return nil
+	}
+	return acme.UnsupportedIdentifierProblem(fmt.Sprintf(
+		"Order included identifier of unsupported type %q", ident.Type))
+}
+
+// verifyFinalizeCSR checks the CSR an order is being finalized with. It must
+// request exactly the order's identifiers and use a different key than the
+// account that the key policy accepts. The caller must hold the order lock.
+func (wfe *WebFrontEndImpl) verifyFinalizeCSR(
+	order *core.Order,
+	csr *x509.CertificateRequest,
+	reg *core.Account) *acme.ProblemDetails {
+	if err := csr.CheckSignature(); err != nil {
+		return acme.BadCSRProblem("CSR signature is invalid: " + err.Error())
+	}
+	if prob := wfe.checkKey(csr.PublicKey); prob != nil {
+		return prob
+	}
+	if prob := wfe.csrPolicy.checkSANTypes(csr); prob != nil {
+		return prob
+	}
+	if wfe.csrPolicy.RejectMustStaple && core.TLSFeatureExtension(csr) != nil {
+		return acme.BadCSRProblem("CSR requests the OCSP Must-Staple extension, which is not supported")
+	}
+	if prob := wfe.csrPolicy.checkStrictness(csr); prob != nil {
+		return prob
+	}
+	if len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 {
+		return acme.BadCSRProblem("CSR has no names or IP addresses in it")
+	}
+
+	orderIdents := make(map[acme.Identifier]bool, len(order.Identifiers))
+	for _, ident := range order.Identifiers {
+		orderIdents[normalizeIdentifier(ident)] = true
+	}
+	// CSR names are normalized the same way as the order's identifiers so
+	// that they match regardless of case, unless the CSR policy is pedantic
+	csrIdents := make(map[acme.Identifier]bool)
+	for _, name := range csr.DNSNames {
+		ident := acme.Identifier{Type: acme.IdentifierDNS, Value: name}
+		csrIdents[wfe.csrPolicy.csrIdentifier(ident)] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		csrIdents[acme.Identifier{Type: acme.IdentifierIP, Value: ip.String()}] = true
+	}
+	var subproblems []acme.SubProblemDetails
+	for ident := range csrIdents {
+		if !orderIdents[ident] {
+			subproblems = append(subproblems, acme.SubProblemDetails{
+				ProblemDetails: *acme.BadCSRProblem(fmt.Sprintf(
+					"CSR requests identifier %s:%s which is not in the order", ident.Type, ident.Value)),
+				Identifier: ident,
+			})
+		}
+	}
+	for ident := range orderIdents {
+		if !csrIdents[ident] {
+			subproblems = append(subproblems, acme.SubProblemDetails{
+				ProblemDetails: *acme.BadCSRProblem(fmt.Sprintf(
+					"CSR is missing order identifier %s:%s", ident.Type, ident.Value)),
+				Identifier: ident,
+			})
+		}
+	}
+	// The identifiers come from maps, sort them for a stable problem
+	sort.Slice(subproblems, func(i, j int) bool {
+		a, b := subproblems[i].Identifier, subproblems[j].Identifier
+		return a.Type < b.Type || (a.Type == b.Type && a.Value < b.Value)
+	})
+	if prob := acme.IdentifiersProblem(fmt.Sprintf(
+		"CSR and order differ in %d identifiers", len(subproblems)), subproblems); prob != nil {
+		return prob
+	}
+
+	csrKeyID, err := core.KeyToID(csr.PublicKey)
+	if err != nil {
+		return acme.BadCSRProblem("CSR has an invalid PublicKey")
+	}
+	reg.RLock()
+	acctKeyID, err := core.KeyToID(reg.Key)
+	reg.RUnlock()
+	if err != nil {
+		return acme.InternalErrorProblem("Account has an invalid key")
+	}
+	if csrKeyID == acctKeyID {
+		return acme.BadCSRProblem("Certificate public key must be different than account key")
+	}
+	return nil
+}
+
+// verifyWildcard checks that a name containing a "*" is a well formed
+// wildcard: the "*" must be the entire leftmost label and there must be at
+// least two labels following it (e.g. no "*.com").
+func verifyWildcard(name string) *acme.ProblemDetails {
+	if !strings.Contains(name, "*") {
+		return nil
+	}
+	if !strings.HasPrefix(name, "*.") {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Name %q has a wildcard that is not the leftmost label", name))
+	}
+	base := strings.TrimPrefix(name, "*.")
+	if strings.Contains(base, "*") {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Name %q has more than one wildcard label", name))
+	}
+	if len(strings.Split(base, ".")) < 2 {
+		return acme.MalformedProblem(fmt.Sprintf(
+			"Name %q is a wildcard for a bare top level domain", name))
+	}
+	return nil
+}
+
+// makeAuthorization creates a new pending authz for the given identifier,
+// populates its challenges and saves it in the db.
+func (wfe *WebFrontEndImpl) makeAuthorization(
+	ident acme.Identifier,
+	wildcard bool,
+	acctID string,
+	order *core.Order,
+	request *http.Request) (*core.Authorization, error) {
+	now := wfe.clk.Now().UTC()
+	expires := now.Add(wfe.lifetimes.PendingAuthorization.Duration)
+	authz := &core.Authorization{
+		ID:          newToken(),
+		AccountID:   acctID,
+		ExpiresDate: expires,
+		Order:       order,
+		Authorization: acme.Authorization{
+			Status:     acme.StatusPending,
+			Identifier: ident,
+			Expires:    expires.UTC().Format(time.RFC3339),
+			Wildcard:   wildcard,
+		},
+	}
+	authz.URL = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", authzPath, authz.ID))
+	// Create the challenges for this authz
+	err := wfe.makeChallenges(authz, request)
+	if err != nil {
+		return nil, err
+	}
+	// Save the authorization in memory
+	count, err := wfe.db.AddAuthorization(authz)
+	if err != nil {
+		return nil, err
+	}
+	wfe.log.WithContext(request.Context()).Printf("There are now %d authorizations in the db\n", count)
+	return authz, nil
+}
+
+// findPreAuthorization returns a valid, unexpired authorization created with
+// the newAuthz endpoint for the account and identifier, or nil if there is
+// none.
+func (wfe *WebFrontEndImpl) findPreAuthorization(
+	acctID string,
+	ident acme.Identifier,
+	wildcard bool) *core.Authorization {
+	if !wfe.preAuthz || wildcard {
+		return nil
+	}
+	now := wfe.clk.Now()
+	for _, authz := range wfe.db.GetAuthorizationsByAccountID(acctID) {
+		authz.RLock()
+		usable := authz.Order == nil &&
+			authz.Status == acme.StatusValid &&
+			authz.Identifier == ident &&
+			!authz.Wildcard &&
+			now.Before(authz.ExpiresDate)
+		authz.RUnlock()
+		if usable {
+			return authz
+		}
+	}
+	return nil
+}
+
+// makeAuthorizations populates an order with new authz's. The request parameter
+// is required to make the authz URL's absolute based on the request host
+func (wfe *WebFrontEndImpl) makeAuthorizations(order *core.Order, request *http.Request) error {
+	var auths []string
+	var authObs []*core.Authorization
+
+	// Lock the order for reading
+	order.RLock()
+	// Create one authz for each identifier in the order
+	for _, ident := range order.Identifiers {
+		// Wildcard names are authorized by validating the base domain. The authz
+		// identifier never includes the "*." prefix, it is marked as a wildcard
+		// instead.
+		wildcard := ident.Type == acme.IdentifierDNS && strings.HasPrefix(ident.Value, "*.")
+		if wildcard {
+			ident.Value = strings.TrimPrefix(ident.Value, "*.")
+		}
+		// Reuse a valid pre-authorization for the identifier if there is one
+		if authz := wfe.findPreAuthorization(order.AccountID, ident, wildcard); authz != nil {
+			wfe.log.WithContext(request.Context()).Printf("Reusing pre-authorization %s for order %s\n", authz.ID, order.ID)
+			auths = append(auths, authz.URL)
+			authObs = append(authObs, authz)
+			continue
+		}
+		// Reuse a valid authorization of an earlier order, if enabled
+		if authz := wfe.findReusableAuthorization(order.AccountID, ident, wildcard); authz != nil {
+			wfe.log.WithContext(request.Context()).Printf("Reusing valid authorization %s for order %s\n", authz.ID, order.ID)
+			auths = append(auths, authz.URL)
+			authObs = append(authObs, authz)
+			continue
+		}
+		authz, err := wfe.makeAuthorization(ident, wildcard, order.AccountID, order, request)
+		if err != nil {
+			order.RUnlock()
+			return err
+		}
+		auths = append(auths, authz.URL)
+		authObs = append(authObs, authz)
+	}
+	// Unlock the order from reading
+	order.RUnlock()
+
+	// An order whose authorizations are all reused is ready right away. An
+	// order never outlives a reused authorization.
+	ready := true
+	var earliestExpiry time.Time
+	for _, authz := range authObs {
+		authz.RLock()
+		if authz.Status != acme.StatusValid {
+			ready = false
+		} else if earliestExpiry.IsZero() || authz.ExpiresDate.Before(earliestExpiry) {
+			earliestExpiry = authz.ExpiresDate
+		}
+		authz.RUnlock()
+	}
+
+	// Lock the order for writing & update the order's authorizations
+	order.Lock()
+	order.Authorizations = auths
+	order.AuthorizationObjects = authObs
+	if !earliestExpiry.IsZero() && earliestExpiry.Before(order.ExpiresDate) {
+		order.ExpiresDate = earliestExpiry
+		order.Expires = earliestExpiry.UTC().Format(time.RFC3339)
+	}
+	if ready {
+		order.Status = acme.StatusReady
+	}
+	order.Unlock()
+	return nil
+}
+
+func (wfe *WebFrontEndImpl) makeChallenge(
+	chalType string,
+	authz *core.Authorization,
+	request *http.Request) (*core.Challenge, error) {
+	// Create a new challenge of the requested type
+	id := newToken()
+	chal := &core.Challenge{
+		ID: id,
+		Challenge: acme.Challenge{
+			Type:   chalType,
+			Token:  newToken(),
+			URL:    wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", challengePath, id)),
+			Status: acme.StatusPending,
+		},
+		Authz: authz,
+	}
+
+	// Add it to the in-memory database
+	_, err := wfe.db.AddChallenge(chal)
+	if err != nil {
+		return nil, err
+	}
+	return chal, nil
+}
+
+// makeChallenges populates an authz with new challenges. The request parameter
+// is required to make the challenge URL's absolute based on the request host
+func (wfe *WebFrontEndImpl) makeChallenges(authz *core.Authorization, request *http.Request) error {
+	var chals []*core.Challenge
+
+	// The VA's validation methods decide which challenges are offered
+	authz.RLock()
+	enabledChallenges := wfe.va.ChallengeTypes(authz.Identifier, authz.Wildcard)
+	authz.RUnlock()
+
 	for _, chalType := range enabledChallenges {
 		chal, err := wfe.makeChallenge(chalType, authz, request)
 		if err != nil {
@@ -742,40 +1637,54 @@ func (wfe *WebFrontEndImpl) NewOrder(
 		return
 	}
 
-	// Decode and parse the CSR bytes from the order
-	csrBytes, err := base64.RawURLEncoding.DecodeString(newOrder.CSR)
-	if err != nil {
-		wfe.sendError(
-			acme.MalformedProblem("Error decoding Base64url-encoded CSR: "+err.Error()), response)
+	// Verify the identifiers of the order before creating authorizations
+	newOrder.Identifiers = normalizeIdentifiers(newOrder.Identifiers)
+	if prob := wfe.verifyOrderIdentifiers(newOrder.Identifiers); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if newOrder.Profile != "" && !wfe.ca.HasProfile(newOrder.Profile) {
+		wfe.sendError(acme.InvalidProfileProblem(
+			fmt.Sprintf("Profile %q is not one of the profiles in the directory", newOrder.Profile)), response)
 		return
 	}
-	parsedCSR, err := x509.ParseCertificateRequest(csrBytes)
+
+	if prob := wfe.validityWindow.check(wfe.clk.Now(), newOrder.NotBefore, newOrder.NotAfter); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	annotation, err := wfe.orderAnnotation(body)
 	if err != nil {
 		wfe.sendError(
-			acme.MalformedProblem("Error parsing Base64url-encoded CSR: "+err.Error()), response)
+			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+		return
+	}
+
+	if wfe.rateLimited(response, wfe.settings.get().newOrderLimiter, existingReg.ID,
+		"Too many new orders for account "+existingReg.ID) {
 		return
 	}
-	expires := time.Now().AddDate(0, 0, 1)
+
+	expires := wfe.clk.Now().Add(wfe.lifetimes.Order.Duration)
 	order := &core.Order{
 		ID: newToken(),
 		Order: acme.Order{
 			Status:  acme.StatusPending,
 			Expires: expires.UTC().Format(time.RFC3339),
-			// Only the CSR, NotBefore and NotAfter fields of the client request are
-			// copied as-is
-			CSR:       newOrder.CSR,
-			NotBefore: newOrder.NotBefore,
-			NotAfter:  newOrder.NotAfter,
+			// Only the Identifiers, NotBefore, NotAfter and Profile fields of
+			// the client request are copied as-is
+			Identifiers: newOrder.Identifiers,
+			NotBefore:   newOrder.NotBefore,
+			NotAfter:    newOrder.NotAfter,
+			Profile:     newOrder.Profile,
 		},
+		AccountID:   existingReg.ID,
 		ExpiresDate: expires,
-		ParsedCSR:   parsedCSR,
-	}
-
-	// Verify the details of the order before creating authorizations
-	if err := wfe.verifyOrder(order, existingReg); err != nil {
-		wfe.sendError(err, response)
-		return
+		Annotation:  annotation,
 	}
+	order.Finalize = wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", finalizePath, order.ID))
 
 	// Create the authorizations for the order
 	err = wfe.makeAuthorizations(order, request)
@@ -792,18 +1701,357 @@ func (wfe *WebFrontEndImpl) NewOrder(
 			acme.InternalErrorProblem("Error saving order"), response)
 		return
 	}
-	wfe.log.Printf("Added order %q to the db\n", order.ID)
-	wfe.log.Printf("There are now %d orders in the db\n", count)
+	wfe.log.WithContext(ctx).Printf("Added order %q to the db\n", order.ID)
+	wfe.log.WithContext(ctx).Printf("There are now %d orders in the db\n", count)
+
+	orderURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", orderPath, order.ID))
+	response.Header().Add("Location", orderURL)
+	order.RLock()
+	orderResp := wfe.orderForDisplay(order, request)
+	order.RUnlock()
+	err = wfe.writeJsonResponse(response, http.StatusCreated, orderResp)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling order"), response)
+		return
+	}
+}
+
+// orderForDisplay returns the order to send to clients, with the certificate
+// URL set if a certificate has been issued and the order's annotation, if any.
+// The caller must hold the order lock.
+func (wfe *WebFrontEndImpl) orderForDisplay(order *core.Order, request *http.Request) interface{} {
+	result := order.Order
+	if order.CertificateObject != nil {
+		result.Certificate = wfe.relativeEndpoint(
+			request,
+			certPath+order.CertificateObject.ID)
+	}
+	if len(order.Annotation) > 0 && wfe.orderAnnotationKey != "" {
+		return annotatedOrder{
+			Order: result,
+			key:   wfe.orderAnnotationKey,
+			value: order.Annotation,
+		}
+	}
+	return result
+}
+
+// FinalizeOrder accepts the CSR for a ready order, whose authorizations are all
+// valid, and asks the CA to issue a certificate for it. Finalizing an order
+// again with the same CSR returns the existing order unless
+// PEBBLE_WFE_REJECT_REFINALIZE is set.
+func (wfe *WebFrontEndImpl) FinalizeOrder(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	body, key, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWK)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	existingAcct, prob := wfe.getAcctByKey(key)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	orderID := strings.TrimPrefix(request.URL.Path, finalizePath)
+	order := wfe.db.GetOrderByID(orderID)
+	if order == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No order for ID %q", orderID)), response)
+		return
+	}
+
+	var finalizeReq acme.FinalizeRequest
+	err := json.Unmarshal(body, &finalizeReq)
+	if err != nil {
+		wfe.sendError(
+			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+		return
+	}
+
+	// Decode and parse the CSR bytes from the finalize request
+	parsedCSR, prob := parseCSR(finalizeReq.CSR)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
 
 	orderURL := wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", orderPath, order.ID))
+
+	order.Lock()
+	if order.AccountID != existingAcct.ID {
+		order.Unlock()
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Account does not own the order"), response)
+		return
+	}
+
+	switch order.Status {
+	case acme.StatusProcessing, acme.StatusValid:
+		// The order was already finalized. Repeating the request with the same
+		// CSR returns the existing order unless re-finalizing is rejected.
+		sameCSR := order.ParsedCSR != nil && bytes.Equal(order.ParsedCSR.Raw, parsedCSR.Raw)
+		if wfe.rejectRefinalize || !sameCSR {
+			status := order.Status
+			order.Unlock()
+			wfe.sendError(acme.OrderNotReadyProblem(fmt.Sprintf(
+				"Order %s has status %q and was already finalized", orderID, status)), response)
+			return
+		}
+		wfe.log.WithContext(ctx).Printf("Order %s finalized again with the same CSR\n", orderID)
+		orderResp := wfe.orderForDisplay(order, request)
+		order.Unlock()
+		response.Header().Add("Location", orderURL)
+		err = wfe.writeJsonResponse(response, http.StatusOK, orderResp)
+		if err != nil {
+			wfe.sendError(acme.InternalErrorProblem("Error marshalling order"), response)
+		}
+		return
+	case acme.StatusReady:
+		// Finalized below
+	case acme.StatusPending:
+		order.Unlock()
+		wfe.sendError(acme.OrderNotReadyProblem(fmt.Sprintf(
+			"Order %s is not ready, not all of its authorizations are valid", orderID)), response)
+		return
+	default:
+		status := order.Status
+		order.Unlock()
+		wfe.sendError(acme.OrderNotReadyProblem(fmt.Sprintf(
+			"Order %s has status %q and can not be finalized", orderID, status)), response)
+		return
+	}
+
+	if wfe.clk.Now().After(order.ExpiresDate) {
+		expires := order.ExpiresDate
+		order.Unlock()
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Order expired %s", expires.Format(time.RFC3339))), response)
+		return
+	}
+
+	// Every authorization of the order must still be valid, one may have been
+	// deactivated since the order became ready
+	for _, authz := range order.AuthorizationObjects {
+		authz.RLock()
+		authzStatus := authz.Status
+		authz.RUnlock()
+		if authzStatus != acme.StatusValid {
+			order.Unlock()
+			wfe.sendError(acme.OrderNotReadyProblem(fmt.Sprintf(
+				"Order %s is not ready, authorization %s has status %q",
+				orderID, authz.ID, authzStatus)), response)
+			return
+		}
+	}
+
+	if prob := wfe.verifyFinalizeCSR(order, parsedCSR, existingAcct); prob != nil {
+		order.Unlock()
+		wfe.sendError(prob, response)
+		return
+	}
+
+	if wfe.overloaded(response, wfe.PendingFinalizations(),
+		wfe.backpressure.MaxPendingFinalizations, "finalizations") {
+		order.Unlock()
+		return
+	}
+
+	// Only finalizations that issue a certificate count towards the duplicate
+	// certificate limit
+	identifierSet := identifierSetKey(order.Identifiers)
+	if wfe.rateLimited(response, wfe.settings.get().duplicateCertLimiter, identifierSet,
+		"Too many certificates already issued for exact set of identifiers "+identifierSet) {
+		order.Unlock()
+		return
+	}
+
+	order.ParsedCSR = parsedCSR
+	order.Status = acme.StatusProcessing
+	orderResp := wfe.orderForDisplay(order, request)
+	event := webhook.Event{
+		Type:        webhook.OrderFinalized,
+		AccountID:   order.AccountID,
+		OrderID:     order.ID,
+		Identifiers: order.Identifiers,
+	}
+	order.Unlock()
+	wfe.addPollRetryAfter(response, true)
+	wfe.notifier.Notify(event)
+
+	// Ask the CA to complete the order in the background
+	atomic.AddInt64(wfe.pendingFinalizations, 1)
+	wfe.ca.IssueOrder(ctx, order, func() {
+		defer atomic.AddInt64(wfe.pendingFinalizations, -1)
+		order.RLock()
+		cert := order.CertificateObject
+		issued := order.Status == acme.StatusValid && cert != nil
+		order.RUnlock()
+		if issued {
+			event.Type = webhook.CertificateIssued
+			event.CertificateSerial = cert.ID
+			wfe.notifier.Notify(event)
+		}
+	})
+
 	response.Header().Add("Location", orderURL)
-	err = wfe.writeJsonResponse(response, http.StatusCreated, order.Order)
+	err = wfe.writeJsonResponse(response, http.StatusOK, orderResp)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshalling order"), response)
 		return
 	}
 }
 
+// NewAuthz creates a pre-authorization for a single identifier that is not
+// associated with an order (RFC 8555 Section 7.4.1). Once it is valid new
+// orders for the identifier reuse it instead of creating a new authorization.
+func (wfe *WebFrontEndImpl) NewAuthz(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	body, key, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWK)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	existingAcct, prob := wfe.getAcctByKey(key)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	var newAuthz struct {
+		Identifier acme.Identifier `json:"identifier"`
+	}
+	err := json.Unmarshal(body, &newAuthz)
+	if err != nil {
+		wfe.sendError(
+			acme.MalformedProblem("Error unmarshaling body JSON: "+err.Error()), response)
+		return
+	}
+
+	ident := normalizeIdentifier(newAuthz.Identifier)
+	if prob := wfe.verifyOrderIdentifiers([]acme.Identifier{ident}); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	// The identifier of a pre-authorization is used as-is, so it can't be used
+	// to authorize a wildcard name
+	if ident.Type == acme.IdentifierDNS && strings.Contains(ident.Value, "*") {
+		wfe.sendError(acme.MalformedProblem(
+			"Wildcard identifiers can not be pre-authorized"), response)
+		return
+	}
+
+	authz, err := wfe.makeAuthorization(ident, false, existingAcct.ID, nil, request)
+	if err != nil {
+		wfe.sendError(
+			acme.InternalErrorProblem("Error creating pre-authorization"), response)
+		return
+	}
+	wfe.log.WithContext(ctx).Printf("Added pre-authorization %q for account %q\n", authz.ID, existingAcct.ID)
+
+	authz.RLock()
+	defer authz.RUnlock()
+	response.Header().Add("Location", authz.URL)
+	err = wfe.writeJsonResponse(response, http.StatusCreated, authz.Authorization)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling authz"), response)
+		return
+	}
+}
+
+// ListOrders returns the URLs of an account's orders. Invalid orders are not
+// included. The list is paginated with the "cursor" query parameter and
+// a Link header with relation "next" when there are more orders.
+func (wfe *WebFrontEndImpl) ListOrders(
+	ctx context.Context,
+	logEvent *requestEvent,
+	response http.ResponseWriter,
+	request *http.Request) {
+
+	reqAcct, prob := wfe.verifyPOSTAsGET(ctx, logEvent, request)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	acctID := strings.TrimPrefix(request.URL.Path, ordersPath)
+	acct := wfe.db.GetAccountByID(acctID)
+	if acct == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No account for ID %q", acctID)), response)
+		return
+	}
+	if prob := ownedBy(reqAcct, acctID, "orders list"); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
+	cursor := 0
+	if c := request.URL.Query().Get("cursor"); c != "" {
+		var err error
+		cursor, err = strconv.Atoi(c)
+		if err != nil || cursor < 0 {
+			wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+				"Invalid orders list cursor %q", c)), response)
+			return
+		}
+	}
+
+	var orderURLs []string
+	for _, order := range wfe.db.GetOrdersByAccountID(acctID) {
+		order.RLock()
+		orderID := order.ID
+		orderStatus := order.Status
+		order.RUnlock()
+		if orderStatus == acme.StatusInvalid {
+			continue
+		}
+		orderURLs = append(orderURLs,
+			wfe.relativeEndpoint(request, fmt.Sprintf("%s%s", orderPath, orderID)))
+	}
+
+	// Select the page of order URLs starting at the cursor. Cursors past the
+	// last page are rejected, the first page is served even when it is empty.
+	// The cursor is only multiplied once it is known to be small enough not to
+	// overflow.
+	if cursor > len(orderURLs)/wfe.ordersPerPage ||
+		(cursor > 0 && cursor*wfe.ordersPerPage >= len(orderURLs)) {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Orders list cursor %d is past the last page", cursor)), response)
+		return
+	}
+	start := cursor * wfe.ordersPerPage
+	end := start + wfe.ordersPerPage
+	if end < len(orderURLs) {
+		// The query is added to the endpoint URL, it would be escaped as part
+		// of the path
+		nextURL := fmt.Sprintf("%s?cursor=%d",
+			wfe.relativeEndpoint(request, ordersPath+acctID), cursor+1)
+		response.Header().Add("Link", link(nextURL, "next"))
+	} else {
+		end = len(orderURLs)
+	}
+
+	ordersList := struct {
+		Orders []string `json:"orders"`
+	}{
+		Orders: append([]string{}, orderURLs[start:end]...),
+	}
+	err := wfe.writeJsonResponse(response, http.StatusOK, ordersList)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling orders list"), response)
+		return
+	}
+}
+
 // Order retrieves the details of an existing order
 func (wfe *WebFrontEndImpl) Order(
 	ctx context.Context,
@@ -811,10 +2059,16 @@ func (wfe *WebFrontEndImpl) Order(
 	response http.ResponseWriter,
 	request *http.Request) {
 
+	acct, prob := wfe.verifyPOSTAsGET(ctx, logEvent, request)
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	orderID := strings.TrimPrefix(request.URL.Path, orderPath)
 	order := wfe.db.GetOrderByID(orderID)
 	if order == nil {
-		response.WriteHeader(http.StatusNotFound)
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No order for ID %q", orderID)), response)
 		return
 	}
 
@@ -822,17 +2076,14 @@ func (wfe *WebFrontEndImpl) Order(
 	order.RLock()
 	defer order.RUnlock()
 
-	// If the order has a cert ID then set the certificate URL by constructing
-	// a relative path based on the HTTP request & the cert ID
-	if order.CertificateObject != nil {
-		order.Certificate = wfe.relativeEndpoint(
-			request,
-			certPath+order.CertificateObject.ID)
+	if prob := ownedBy(acct, order.AccountID, "order"); prob != nil {
+		wfe.sendError(prob, response)
+		return
 	}
 
-	// Return only the initial OrderRequest not the internal object with the
-	// parsedCSR
-	orderReq := order.Order
+	// Return only the acme.Order not the internal object with the parsedCSR
+	orderReq := wfe.orderForDisplay(order, request)
+	wfe.addPollRetryAfter(response, order.Status == acme.StatusProcessing)
 
 	err := wfe.writeJsonResponse(response, http.StatusOK, orderReq)
 	if err != nil {
@@ -847,13 +2098,95 @@ func (wfe *WebFrontEndImpl) Authz(
 	response http.ResponseWriter,
 	request *http.Request) {
 
+	// A POST with a payload updates the authorization, one without is a
+	// POST-as-GET
+	var acct *core.Account
+	if request.Method == "POST" {
+		body, postAcct, prob := wfe.verifyAccountPOST(ctx, logEvent, request)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		if len(body) != 0 {
+			wfe.updateAuthz(ctx, response, request, body, postAcct)
+			return
+		}
+		acct = postAcct
+	} else if prob := wfe.checkLegacyGET(); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	authzID := strings.TrimPrefix(request.URL.Path, authzPath)
 	authz := wfe.db.GetAuthorizationByID(authzID)
 	if authz == nil {
-		response.WriteHeader(http.StatusNotFound)
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No authorization for ID %q", authzID)), response)
+		return
+	}
+
+	authz.RLock()
+	defer authz.RUnlock()
+
+	if prob := ownedBy(acct, authz.AccountID, "authorization"); prob != nil {
+		wfe.sendError(prob, response)
 		return
 	}
 
+	wfe.addPollRetryAfter(response, authz.Status == acme.StatusPending)
+	err := wfe.writeJsonResponse(response, http.StatusOK, authz.Authorization)
+	if err != nil {
+		wfe.sendError(acme.InternalErrorProblem("Error marshalling authz"), response)
+		return
+	}
+}
+
+// updateAuthz handles POSTs to an authorization with the verified body of the
+// account. The only supported update is deactivation (RFC 8555 Section 7.5.2)
+// by the account that owns the authz.
+func (wfe *WebFrontEndImpl) updateAuthz(
+	ctx context.Context,
+	response http.ResponseWriter,
+	request *http.Request,
+	body []byte,
+	existingAcct *core.Account) {
+
+	authzID := strings.TrimPrefix(request.URL.Path, authzPath)
+	authz := wfe.db.GetAuthorizationByID(authzID)
+	if authz == nil {
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No authorization for ID %q", authzID)), response)
+		return
+	}
+
+	var update acme.Authorization
+	if err := json.Unmarshal(body, &update); err != nil {
+		wfe.sendError(acme.MalformedProblem("Error unmarshaling body JSON"), response)
+		return
+	}
+	if update.Status != acme.StatusDeactivated {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Invalid authorization status %q, only %q is accepted",
+			update.Status, acme.StatusDeactivated)), response)
+		return
+	}
+
+	authz.Lock()
+	defer authz.Unlock()
+
+	if authz.AccountID != existingAcct.ID {
+		wfe.sendError(acme.UnauthorizedProblem(
+			"Account does not own the authorization"), response)
+		return
+	}
+
+	// Only pending and valid authorizations can be deactivated
+	if authz.Status != acme.StatusPending && authz.Status != acme.StatusValid {
+		wfe.sendError(acme.MalformedProblem(fmt.Sprintf(
+			"Cannot deactivate an authorization with status %q", authz.Status)), response)
+		return
+	}
+	authz.Status = acme.StatusDeactivated
+	wfe.log.WithContext(ctx).Printf("authz %s DEACTIVATED by account %s\n", authz.ID, existingAcct.ID)
+
 	err := wfe.writeJsonResponse(response, http.StatusOK, authz.Authorization)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshalling authz"), response)
@@ -867,31 +2200,63 @@ func (wfe *WebFrontEndImpl) Challenge(
 	response http.ResponseWriter,
 	request *http.Request) {
 
+	// A POST with a payload responds to the challenge, one without is a
+	// POST-as-GET
+	var acct *core.Account
 	if request.Method == "POST" {
-		wfe.updateChallenge(ctx, logEvent, response, request)
+		body, postAcct, prob := wfe.verifyAccountPOST(ctx, logEvent, request)
+		if prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+		if len(body) != 0 {
+			wfe.updateChallenge(ctx, response, request, body, postAcct)
+			return
+		}
+		acct = postAcct
+	} else if prob := wfe.checkLegacyGET(); prob != nil {
+		wfe.sendError(prob, response)
 		return
 	}
 
-	wfe.getChallenge(ctx, logEvent, response, request)
+	wfe.getChallenge(ctx, response, request, acct)
 }
 
+// getChallenge returns a challenge to the account that owns it, or to
+// anyone for a legacy GET with a nil account.
 func (wfe *WebFrontEndImpl) getChallenge(
 	ctx context.Context,
-	logEvent *requestEvent,
 	response http.ResponseWriter,
-	request *http.Request) {
+	request *http.Request,
+	acct *core.Account) {
 
 	chalID := strings.TrimPrefix(request.URL.Path, challengePath)
 	chal := wfe.db.GetChallengeByID(chalID)
 	if chal == nil {
-		response.WriteHeader(http.StatusNotFound)
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No challenge for ID %q", chalID)), response)
 		return
 	}
 
+	// Lock the authorization of the challenge to check its owner
+	chal.RLock()
+	authz := chal.Authz
+	chal.RUnlock()
+	if authz != nil {
+		authz.RLock()
+		ownerID := authz.AccountID
+		authz.RUnlock()
+		if prob := ownedBy(acct, ownerID, "challenge"); prob != nil {
+			wfe.sendError(prob, response)
+			return
+		}
+	}
+
 	// Lock the challenge for reading in order to write the response
 	chal.RLock()
 	defer chal.RUnlock()
 
+	wfe.addPollRetryAfter(response,
+		chal.Status == acme.StatusPending || chal.Status == acme.StatusProcessing)
 	err := wfe.writeJsonResponse(response, http.StatusOK, chal.Challenge)
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshalling challenge"), response)
@@ -902,16 +2267,13 @@ func (wfe *WebFrontEndImpl) getChallenge(
 // getAcctByKey finds a account by key or returns a problem pointer if an
 // existing account can't be found or the key is invalid.
 func (wfe *WebFrontEndImpl) getAcctByKey(key crypto.PublicKey) (*core.Account, *acme.ProblemDetails) {
-	// Compute the account ID for the signer's key
-	regID, err := keyToID(key)
+	// Find the existing account object for the signer's key
+	existingAcct, err := wfe.db.GetAccountByKey(key)
 	if err != nil {
-		wfe.log.Printf("keyToID err: %s\n", err.Error())
+		wfe.log.Printf("KeyToID err: %s\n", err.Error())
 		return nil, acme.MalformedProblem("Error computing key digest")
 	}
-
-	// Find the existing account object for that key ID
-	var existingAcct *core.Account
-	if existingAcct = wfe.db.GetAccountByID(regID); existingAcct == nil {
+	if existingAcct == nil {
 		return nil, acme.AccountDoesNotExistProblem(
 			"URL in JWS 'kid' field does not correspond to an account")
 	}
@@ -957,9 +2319,9 @@ func (wfe *WebFrontEndImpl) validateChallengeUpdate(
 
 // validateAuthzForChallenge checks an authz is:
 // 1) for a supported identifier type
-// 2) not expired
-// 3) associated to an order
-// The associated order is returned when no problems are found to avoid needing
+// 2) pending
+// 3) not expired
+// The associated order, if any, is returned when no problems are found to avoid needing
 // another RLock() for the caller to get the order pointer later.
 func (wfe *WebFrontEndImpl) validateAuthzForChallenge(authz *core.Authorization) (*core.Order, *acme.ProblemDetails) {
 	// Lock the authz for reading
@@ -967,44 +2329,38 @@ func (wfe *WebFrontEndImpl) validateAuthzForChallenge(authz *core.Authorization)
 	defer authz.RUnlock()
 
 	ident := authz.Identifier
-	if ident.Type != acme.IdentifierDNS {
+	if ident.Type != acme.IdentifierDNS && ident.Type != acme.IdentifierIP {
 		return nil, acme.MalformedProblem(
-			fmt.Sprintf("Authorization identifier was type %s, only %s is supported",
-				ident.Type, acme.IdentifierDNS))
+			fmt.Sprintf("Authorization identifier was type %s, only %s and %s are supported",
+				ident.Type, acme.IdentifierDNS, acme.IdentifierIP))
+	}
+
+	if authz.Status != acme.StatusPending {
+		return nil, acme.MalformedProblem(
+			fmt.Sprintf("Authorization has status %q, only %q authorizations can be validated",
+				authz.Status, acme.StatusPending))
 	}
 
 	now := wfe.clk.Now()
 	if now.After(authz.ExpiresDate) {
 		return nil, acme.MalformedProblem(
-			fmt.Sprintf("Authorization expired %s %s",
+			fmt.Sprintf("Authorization expired %s",
 				authz.ExpiresDate.Format(time.RFC3339)))
 	}
 
-	existingOrder := authz.Order
-	if existingOrder == nil {
-		return nil, acme.InternalErrorProblem("authz missing associated order")
-	}
-
-	return existingOrder, nil
+	// Pre-authorizations created with newAuthz have no associated order, in
+	// which case a nil order is returned
+	return authz.Order, nil
 }
 
+// updateChallenge handles the response of the account to a challenge, with
+// the verified body of its POST.
 func (wfe *WebFrontEndImpl) updateChallenge(
 	ctx context.Context,
-	logEvent *requestEvent,
 	response http.ResponseWriter,
-	request *http.Request) {
-
-	body, key, prob := wfe.verifyPOST(ctx, logEvent, request, wfe.lookupJWK)
-	if prob != nil {
-		wfe.sendError(prob, response)
-		return
-	}
-
-	existingAcct, prob := wfe.getAcctByKey(key)
-	if prob != nil {
-		wfe.sendError(prob, response)
-		return
-	}
+	request *http.Request,
+	body []byte,
+	existingAcct *core.Account) {
 
 	var chalResp acme.Challenge
 	err := json.Unmarshal(body, &chalResp)
@@ -1017,7 +2373,12 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 	chalID := strings.TrimPrefix(request.URL.Path, challengePath)
 	existingChal := wfe.db.GetChallengeByID(chalID)
 	if existingChal == nil {
-		response.WriteHeader(http.StatusNotFound)
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No challenge for ID %q", chalID)), response)
+		return
+	}
+
+	if wfe.overloaded(response, wfe.va.PendingValidations(),
+		wfe.backpressure.MaxPendingValidations, "validations") {
 		return
 	}
 
@@ -1038,34 +2399,39 @@ func (wfe *WebFrontEndImpl) updateChallenge(
 		return
 	}
 
-	// Lock the order for reading to check the expiry date
-	existingOrder.RLock()
-	now := wfe.clk.Now()
-	if now.After(existingOrder.ExpiresDate) {
-		wfe.sendError(
-			acme.MalformedProblem(fmt.Sprintf("order expired %s %s",
-				existingOrder.ExpiresDate.Format(time.RFC3339))), response)
-		return
+	if existingOrder != nil {
+		// Lock the order for reading to check the expiry date
+		existingOrder.RLock()
+		orderExpires := existingOrder.ExpiresDate
+		existingOrder.RUnlock()
+		if wfe.clk.Now().After(orderExpires) {
+			wfe.sendError(
+				acme.MalformedProblem(fmt.Sprintf("order expired %s",
+					orderExpires.Format(time.RFC3339))), response)
+			return
+		}
 	}
-	existingOrder.RUnlock()
 
-	// Lock the authorization to get the identifier value
+	// Lock the authorization to get the identifier
 	authz.RLock()
-	ident := authz.Identifier.Value
+	ident := authz.Identifier
 	authz.RUnlock()
 
 	// Submit a validation job to the VA, this will be processed asynchronously
-	wfe.va.ValidateChallenge(ident, existingChal, existingAcct)
+	vaCtx, responded := wfe.validationContext(ctx, chalID)
+	wfe.va.ValidateChallenge(vaCtx, ident, existingChal, existingAcct, wfe.db.Changed)
 
 	// Lock the challenge for reading in order to write the response
 	existingChal.RLock()
-	defer existingChal.RUnlock()
 	response.Header().Add("Link", link(existingChal.Authz.URL, "up"))
+	wfe.addPollRetryAfter(response,
+		existingChal.Status == acme.StatusPending || existingChal.Status == acme.StatusProcessing)
 	err = wfe.writeJsonResponse(response, http.StatusOK, existingChal.Challenge)
+	existingChal.RUnlock()
 	if err != nil {
 		wfe.sendError(acme.InternalErrorProblem("Error marshalling challenge"), response)
-		return
 	}
+	responded(response)
 }
 
 func (wfe *WebFrontEndImpl) Certificate(
@@ -1074,16 +2440,45 @@ func (wfe *WebFrontEndImpl) Certificate(
 	response http.ResponseWriter,
 	request *http.Request) {
 
+	// Certificates are public, any account can read them
+	if _, prob := wfe.verifyPOSTAsGET(ctx, logEvent, request); prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+
 	serial := strings.TrimPrefix(request.URL.Path, certPath)
+	alternate := strings.HasSuffix(serial, alternateChainSuffix)
+	serial = strings.TrimSuffix(serial, alternateChainSuffix)
 	cert := wfe.db.GetCertificateByID(serial)
 	if cert == nil {
-		response.WriteHeader(http.StatusNotFound)
+		wfe.sendError(acme.NotFoundProblem(fmt.Sprintf("No certificate for serial %q", serial)), response)
 		return
 	}
 
-	response.Header().Set("Content-Type", "application/pem-certificate-chain")
+	// Each chain links to the other one (RFC 8555 Section 7.4.2)
+	certURL := wfe.relativeEndpoint(request, certPath+serial)
+	served := cert
+	if altCert := cert.AlternateChain(); alternate {
+		if altCert == nil {
+			wfe.sendError(acme.NotFoundProblem(fmt.Sprintf(
+				"No alternate chain for certificate with serial %q", serial)), response)
+			return
+		}
+		served = altCert
+		response.Header().Add("Link", link(certURL, "alternate"))
+	} else if altCert != nil {
+		response.Header().Add("Link", link(certURL+alternateChainSuffix, "alternate"))
+	}
+
+	response.Header().Set("Vary", "Accept")
+	contentType, body, prob := wfe.certificateBody(served, request.Header.Get("Accept"))
+	if prob != nil {
+		wfe.sendError(prob, response)
+		return
+	}
+	response.Header().Set("Content-Type", contentType)
 	response.WriteHeader(http.StatusOK)
-	_, _ = response.Write(cert.Chain())
+	_, _ = response.Write(body)
 }
 
 func (wfe *WebFrontEndImpl) writeJsonResponse(response http.ResponseWriter, status int, v interface{}) error {
@@ -1101,6 +2496,57 @@ func (wfe *WebFrontEndImpl) writeJsonResponse(response http.ResponseWriter, stat
 	return nil
 }
 
+// addPollRetryAfter adds the configured Retry-After header to the response if
+// polling is true, i.e. the resource is still waiting for a validation or
+// issuance (RFC 8555 Section 7.5.1 and 7.4).
+func (wfe *WebFrontEndImpl) addPollRetryAfter(response http.ResponseWriter, polling bool) {
+	if polling && wfe.pollRetryAfter > 0 {
+		response.Header().Set("Retry-After", ratelimit.RetryAfter(wfe.pollRetryAfter))
+	}
+}
+
+// rateLimited returns true if limiter is set and the event for key exceeds
+// its limit. A rateLimited problem with a Retry-After header is then sent.
+// detail describes the event, the limit is appended to it.
+func (wfe *WebFrontEndImpl) rateLimited(
+	response http.ResponseWriter,
+	limiter *ratelimit.Limiter,
+	key string,
+	detail string) bool {
+	if limiter == nil {
+		return false
+	}
+	ok, wait := limiter.Allow(key)
+	if ok {
+		return false
+	}
+	response.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+	wfe.sendError(acme.RateLimitedProblem(fmt.Sprintf(
+		"%s, the limit is %s", detail, limiter)), response)
+	return true
+}
+
+// identifierSetKey returns the key of the duplicate certificate limit for a
+// set of identifiers: their sorted values, so that the order of the
+// identifiers doesn't matter.
+func identifierSetKey(idents []acme.Identifier) string {
+	values := make([]string, 0, len(idents))
+	for _, ident := range idents {
+		values = append(values, ident.Value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// clientIP returns the IP address of the client that made the request.
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
 func addNoCacheHeader(response http.ResponseWriter) {
 	response.Header().Add("Cache-Control", "public, max-age=0, no-cache")
 }
@@ -0,0 +1,425 @@
+package wfe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/pebble/acme"
+	"github.com/letsencrypt/pebble/core"
+	"github.com/letsencrypt/pebble/db"
+	"github.com/letsencrypt/pebble/logging"
+	"github.com/letsencrypt/pebble/va"
+)
+
+// newTestWFE returns a WFE with the default configuration and no CA. Tests
+// change its fields to configure it further.
+func newTestWFE(t *testing.T) *WebFrontEndImpl {
+	t.Helper()
+	logger := logging.New(ioutil.Discard, logging.FormatText)
+	clk := clock.NewFake()
+	testVA := va.New(logger, clk, va.Config{NoSleep: true})
+	wfe := New(logger, clk, db.NewMemoryStore(), testVA, nil, Config{})
+	return &wfe
+}
+
+// addTestAccount adds a valid account with a new key to the WFE's database.
+func addTestAccount(t *testing.T, wfe *WebFrontEndImpl, id string) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating account key: %s", err)
+	}
+	acct := &core.Account{
+		ID:      id,
+		Key:     &jose.JSONWebKey{Key: &key.PublicKey},
+		Account: acme.Account{Status: acme.StatusValid},
+	}
+	if _, err := wfe.db.AddAccount(acct); err != nil {
+		t.Fatalf("adding account %q: %s", id, err)
+	}
+	return key
+}
+
+func TestListOrdersPages(t *testing.T) {
+	wfe := newTestWFE(t)
+	wfe.allowLegacyGET = true
+	wfe.ordersPerPage = 2
+	addTestAccount(t, wfe, "acct")
+	addOrder := func(i int) {
+		order := &core.Order{
+			ID:        fmt.Sprintf("order-%d", i),
+			AccountID: "acct",
+			Order:     acme.Order{Status: acme.StatusPending},
+		}
+		if _, err := wfe.db.AddOrder(order); err != nil {
+			t.Fatalf("adding order: %s", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		addOrder(i)
+	}
+	handler := wfe.Handler()
+	get := func(url string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+		return response
+	}
+
+	url := "http://example.com" + ordersPath + "acct"
+	var orders []string
+	for page := 0; url != ""; page++ {
+		if page > 2 {
+			t.Fatalf("more than 3 pages of 2 for 5 orders")
+		}
+		response := get(url)
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d, body %s", url, response.Code, response.Body.String())
+		}
+		var list struct {
+			Orders []string
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+			t.Fatalf("unmarshalling orders list: %s", err)
+		}
+		orders = append(orders, list.Orders...)
+
+		url = ""
+		for _, l := range response.Header()["Link"] {
+			if strings.HasSuffix(l, `;rel="next"`) {
+				url = strings.TrimSuffix(strings.TrimPrefix(l, "<"), `>;rel="next"`)
+			}
+		}
+		if want := fmt.Sprintf("?cursor=%d", page+1); url != "" && !strings.HasSuffix(url, want) {
+			t.Errorf("next URL %q of page %d doesn't end with the query %q", url, page, want)
+		}
+	}
+	if len(orders) != 5 {
+		t.Errorf("listed %d orders over all pages, want 5: %v", len(orders), orders)
+	}
+
+	for _, cursor := range []string{"-1", "four", "4", "4611686018427387904"} {
+		response := get("http://example.com" + ordersPath + "acct?cursor=" + cursor)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("GET with cursor %s: status %d, want %d", cursor, response.Code, http.StatusBadRequest)
+		}
+	}
+
+	// With 6 orders the third page is the last, there is no empty fourth page
+	addOrder(5)
+	for cursor, want := range map[string]int{"2": http.StatusOK, "3": http.StatusBadRequest} {
+		response := get("http://example.com" + ordersPath + "acct?cursor=" + cursor)
+		if response.Code != want {
+			t.Errorf("GET of 6 orders with cursor %s: status %d, want %d", cursor, response.Code, want)
+		}
+	}
+}
+
+func TestCloseStopsBackgroundGoroutines(t *testing.T) {
+	wfe := newTestWFE(t)
+	done := make(chan string, 2)
+	go func() {
+		wfe.sweepExpired()
+		done <- "sweepExpired"
+	}()
+	go func() {
+		wfe.authzExpiry.watch(wfe.log, wfe.clk, wfe.db, wfe.stop)
+		done <- "watch"
+	}()
+
+	wfe.Close()
+	// Closing again is a no-op
+	wfe.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of 2 background goroutines still running a second after Close", 2-i)
+		}
+	}
+}
+
+func TestMgmtInvalidateAuthorization(t *testing.T) {
+	wfe := newTestWFE(t)
+	authz := &core.Authorization{
+		ID:        "authz",
+		AccountID: "acct",
+		Authorization: acme.Authorization{
+			Status:     acme.StatusValid,
+			Identifier: acme.Identifier{Type: acme.IdentifierDNS, Value: "example.com"},
+		},
+	}
+	order := &core.Order{
+		ID:                   "order",
+		AccountID:            "acct",
+		Order:                acme.Order{Status: acme.StatusReady},
+		AuthorizationObjects: []*core.Authorization{authz},
+	}
+	authz.Order = order
+	if _, err := wfe.db.AddAuthorization(authz); err != nil {
+		t.Fatalf("adding authorization: %s", err)
+	}
+	if _, err := wfe.db.AddOrder(order); err != nil {
+		t.Fatalf("adding order: %s", err)
+	}
+
+	response := httptest.NewRecorder()
+	wfe.ManagementHandler().ServeHTTP(response,
+		httptest.NewRequest("POST", mgmtAuthzsPath+"authz/invalidate", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("POST invalidate: status %d, body %s", response.Code, response.Body.String())
+	}
+	if authz.Status != acme.StatusInvalid {
+		t.Errorf("authorization is %s after invalidate, want %s", authz.Status, acme.StatusInvalid)
+	}
+	if order.Status != acme.StatusInvalid || order.Error == nil {
+		t.Errorf("ready order is %s with error %v after invalidating its authorization, want %s with an error",
+			order.Status, order.Error, acme.StatusInvalid)
+	}
+}
+
+func TestCheckJWSURL(t *testing.T) {
+	testCases := []struct {
+		name      string
+		tls       bool
+		forwarded string
+		url       string
+		ok        bool
+	}{
+		{name: "HTTP", url: "http://example.com/new-acct", ok: true},
+		{name: "HTTP with an HTTPS URL", url: "https://example.com/new-acct"},
+		{name: "HTTPS", tls: true, url: "https://example.com/new-acct", ok: true},
+		{name: "HTTPS with an HTTP URL", tls: true, url: "http://example.com/new-acct"},
+		{name: "forwarded HTTPS", forwarded: "https", url: "https://example.com/new-acct", ok: true},
+		{name: "HTTPS forwarded as HTTP", tls: true, forwarded: "http", url: "http://example.com/new-acct", ok: true},
+		{name: "other path", url: "http://example.com/new-order"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/new-acct", nil)
+			if tc.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+			if tc.forwarded != "" {
+				request.Header.Set("X-Forwarded-Proto", tc.forwarded)
+			}
+			jws := &jose.JSONWebSignature{Signatures: []jose.Signature{{Header: jose.Header{
+				ExtraHeaders: map[jose.HeaderKey]interface{}{"url": tc.url},
+			}}}}
+			prob := checkJWSURL(request, jws)
+			if tc.ok && prob != nil {
+				t.Errorf("checkJWSURL() of %q = %s, want no problem", tc.url, prob.Detail)
+			}
+			if !tc.ok && prob == nil {
+				t.Errorf("checkJWSURL() of %q accepted the URL", tc.url)
+			}
+		})
+	}
+}
+
+func TestNewAccountOverTLS(t *testing.T) {
+	wfe := newTestWFE(t)
+	ts := httptest.NewTLSServer(wfe.Handler())
+	defer ts.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating account key: %s", err)
+	}
+
+	testCases := []struct {
+		url  string
+		want int
+	}{
+		{ts.URL + newAccountPath, http.StatusCreated},
+		// The scheme of the url header must be the one of the request
+		{"http" + strings.TrimPrefix(ts.URL, "https") + newAccountPath, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, &jose.SignerOptions{
+			EmbedJWK: true,
+			ExtraHeaders: map[jose.HeaderKey]interface{}{
+				"url":   tc.url,
+				"nonce": wfe.nonce.createNonce(),
+			},
+		})
+		if err != nil {
+			t.Fatalf("creating signer: %s", err)
+		}
+		jws, err := signer.Sign([]byte(`{"termsOfServiceAgreed":true}`))
+		if err != nil {
+			t.Fatalf("signing newAccount request: %s", err)
+		}
+		response, err := ts.Client().Post(ts.URL+newAccountPath, "application/jose+json",
+			strings.NewReader(jws.FullSerialize()))
+		if err != nil {
+			t.Fatalf("POST %s: %s", newAccountPath, err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tc.want {
+			t.Errorf("POST over TLS with url %q: status %d, want %d, body %s",
+				tc.url, response.StatusCode, tc.want, body)
+		}
+	}
+}
+
+func TestNormalizeIdentifier(t *testing.T) {
+	dns := func(value string) acme.Identifier {
+		return acme.Identifier{Type: acme.IdentifierDNS, Value: value}
+	}
+	ip := func(value string) acme.Identifier {
+		return acme.Identifier{Type: acme.IdentifierIP, Value: value}
+	}
+	testCases := []struct {
+		ident acme.Identifier
+		want  acme.Identifier
+	}{
+		{dns("example.com"), dns("example.com")},
+		{dns("WWW.Example.COM"), dns("www.example.com")},
+		{dns("example.com."), dns("example.com")},
+		{dns("Example.Com."), dns("example.com")},
+		{dns("*.example.com"), dns("*.example.com")},
+		{dns("*.EXAMPLE.com."), dns("*.example.com")},
+		// Only a single trailing dot is removed, the rest is left to be rejected
+		{dns("example.com.."), dns("example.com.")},
+		{ip("2001:DB8:0:0::1"), ip("2001:db8::1")},
+		{ip("::ffff:192.0.2.1"), ip("192.0.2.1")},
+		{ip("not an IP"), ip("not an IP")},
+		{acme.Identifier{Type: "email", Value: "Me@Example.com"}, acme.Identifier{Type: "email", Value: "Me@Example.com"}},
+	}
+	for _, tc := range testCases {
+		if got := normalizeIdentifier(tc.ident); got != tc.want {
+			t.Errorf("normalizeIdentifier(%v) = %v, want %v", tc.ident, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyOrderIdentifiers(t *testing.T) {
+	wfe := newTestWFE(t)
+	testCases := []struct {
+		name   string
+		values []string
+		// rejected are the normalized values that get a subproblem
+		rejected []string
+	}{
+		{
+			name:   "distinct names",
+			values: []string{"example.com", "www.example.com"},
+		},
+		{
+			name:   "wildcard and its base domain",
+			values: []string{"*.example.com", "example.com"},
+		},
+		{
+			name:     "duplicates differing in case",
+			values:   []string{"example.com", "EXAMPLE.com"},
+			rejected: []string{"example.com"},
+		},
+		{
+			name:     "duplicates differing in a trailing dot",
+			values:   []string{"www.example.com.", "www.example.com"},
+			rejected: []string{"www.example.com"},
+		},
+		{
+			name:     "duplicate wildcards",
+			values:   []string{"*.Example.com", "*.example.com.", "example.com"},
+			rejected: []string{"*.example.com"},
+		},
+		{
+			name:     "malformed wildcards",
+			values:   []string{"*.com", "www.*.example.com", "*example.com", "example.com"},
+			rejected: []string{"*.com", "www.*.example.com", "*example.com"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var idents []acme.Identifier
+			for _, value := range tc.values {
+				idents = append(idents, acme.Identifier{Type: acme.IdentifierDNS, Value: value})
+			}
+			prob := wfe.verifyOrderIdentifiers(normalizeIdentifiers(idents))
+			if prob == nil {
+				if len(tc.rejected) > 0 {
+					t.Errorf("no identifier rejected, want %v", tc.rejected)
+				}
+				return
+			}
+			// A single rejected identifier is the problem itself, without
+			// subproblems
+			if len(prob.Subproblems) == 0 {
+				if len(tc.rejected) != 1 || !strings.Contains(prob.Detail, tc.rejected[0]) {
+					t.Errorf("rejected with %q, want %v", prob.Detail, tc.rejected)
+				}
+				return
+			}
+			var rejected []string
+			for _, sub := range prob.Subproblems {
+				rejected = append(rejected, sub.Identifier.Value)
+			}
+			if strings.Join(rejected, ",") != strings.Join(tc.rejected, ",") {
+				t.Errorf("rejected %v, want %v", rejected, tc.rejected)
+			}
+		})
+	}
+}
+
+func TestMakeAuthorizationsWildcard(t *testing.T) {
+	wfe := newTestWFE(t)
+	addTestAccount(t, wfe, "acct")
+	var idents []acme.Identifier
+	for _, value := range []string{"*.Example.com.", "www.example.com", "example.net"} {
+		idents = append(idents, acme.Identifier{Type: acme.IdentifierDNS, Value: value})
+	}
+	order := &core.Order{
+		ID:        "order",
+		AccountID: "acct",
+		Order: acme.Order{
+			Status:      acme.StatusPending,
+			Identifiers: normalizeIdentifiers(idents),
+		},
+	}
+	request := httptest.NewRequest("POST", "/new-order", nil)
+	if err := wfe.makeAuthorizations(order, request); err != nil {
+		t.Fatalf("makeAuthorizations() = %s", err)
+	}
+
+	want := map[string]bool{"example.com": true, "www.example.com": false, "example.net": false}
+	if len(order.AuthorizationObjects) != len(want) {
+		t.Fatalf("made %d authorizations, want %d", len(order.AuthorizationObjects), len(want))
+	}
+	for _, authz := range order.AuthorizationObjects {
+		value := authz.Identifier.Value
+		wildcard, ok := want[value]
+		if !ok {
+			t.Errorf("authorization for unexpected identifier %q", value)
+			continue
+		}
+		body, err := json.Marshal(authz.Authorization)
+		if err != nil {
+			t.Fatalf("marshalling authorization: %s", err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			t.Fatalf("unmarshalling authorization: %s", err)
+		}
+		got, present := fields["wildcard"]
+		if wildcard && got != true {
+			t.Errorf("authorization for %q has wildcard %v, want true", value, got)
+		}
+		if !wildcard && present {
+			t.Errorf("authorization for %q has a wildcard field, want none", value)
+		}
+	}
+}